@@ -2,7 +2,7 @@ import React, { useEffect, useState } from 'react';
 import { useNavigate, useParams } from 'react-router-dom';
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query';
 import { api } from '../../lib/api';
-import type { Offer } from '../../types';
+import type { Offer, OfferRules } from '../../types';
 
 interface Product {
   uuid_id: string;
@@ -30,11 +30,28 @@ interface ProductVariant {
   price_cents: number;
 }
 
+type DiscountType = 'percentage' | 'fixed' | 'bogo' | 'tiered' | 'bundle';
+
+// Starting points for the rules of the offer types that have them
+const RULES_EXAMPLES: Partial<Record<DiscountType, OfferRules>> = {
+  bogo: { buy: 2, get: 1 },
+  tiered: { tiers: [{ min_order: 5000, percent: 15 }, { min_order: 10000, percent: 20 }] },
+  bundle: {
+    items: [
+      { applies_to: 'categories', ids: ['<saree category id>'] },
+      { applies_to: 'categories', ids: ['<blouse piece category id>'] },
+    ],
+    bundle_price: 4999,
+  },
+};
+
 interface FormData {
   title: string;
   description: string;
-  discount_type: 'percentage' | 'fixed';
+  discount_type: DiscountType;
   discount_value: number;
+  // rules is edited as JSON text and parsed on submit
+  rules: string;
   applies_to: 'all' | 'products' | 'categories' | 'collections';
   applies_to_ids: string[];
   min_order_amount: string;
@@ -127,6 +144,7 @@ export function OfferEditPage() {
     description: '',
     discount_type: 'percentage',
     discount_value: 0,
+    rules: '',
     applies_to: 'all',
     applies_to_ids: [],
     min_order_amount: '',
@@ -141,8 +159,9 @@ export function OfferEditPage() {
       setFormData({
         title: offer.title,
         description: offer.description || '',
-        discount_type: offer.discount_type as 'percentage' | 'fixed',
+        discount_type: offer.discount_type,
         discount_value: offer.discount_value,
+        rules: offer.rules ? JSON.stringify(offer.rules, null, 2) : '',
         applies_to: offer.applies_to as 'all' | 'products' | 'categories' | 'collections',
         applies_to_ids: offer.applies_to_ids,
         min_order_amount: offer.min_order_amount?.toString() || '',
@@ -166,13 +185,35 @@ export function OfferEditPage() {
     }));
   };
 
+  const hasRules = formData.discount_type in RULES_EXAMPLES;
+
+  const handleDiscountTypeChange = (e: React.ChangeEvent<HTMLSelectElement>) => {
+    const discountType = e.target.value as DiscountType;
+    const example = RULES_EXAMPLES[discountType];
+    setFormData(prev => ({
+      ...prev,
+      discount_type: discountType,
+      rules: example && !prev.rules ? JSON.stringify(example, null, 2) : prev.rules,
+    }));
+  };
+
   const handleSubmit = (e: React.FormEvent) => {
     e.preventDefault();
+    let rules: OfferRules | undefined;
+    if (hasRules) {
+      try {
+        rules = JSON.parse(formData.rules);
+      } catch {
+        alert('Rules must be valid JSON');
+        return;
+      }
+    }
     const payload = {
       title: formData.title,
       description: formData.description || undefined,
       discount_type: formData.discount_type,
-      discount_value: formData.discount_value,
+      discount_value: hasRules ? 0 : formData.discount_value,
+      rules,
       applies_to: formData.applies_to,
       applies_to_ids: formData.applies_to_ids,
       min_order_amount: formData.min_order_amount ? parseFloat(formData.min_order_amount) || undefined : undefined,
@@ -239,15 +280,18 @@ export function OfferEditPage() {
             <select
               name="discount_type"
               value={formData.discount_type}
-              onChange={handleChange}
+              onChange={handleDiscountTypeChange}
               className="w-full border rounded px-3 py-2"
             >
               <option value="percentage">Percentage</option>
               <option value="fixed">Fixed Amount</option>
+              <option value="bogo">Buy X Get Y</option>
+              <option value="tiered">Tiered (spend more, save more)</option>
+              <option value="bundle">Bundle Price</option>
             </select>
           </div>
 
-          <div>
+          {!hasRules && <div>
             <label className="block text-sm font-medium mb-1">
               {formData.discount_type === 'percentage' ? 'Percentage (%)' : 'Amount ($)'}
             </label>
@@ -261,9 +305,27 @@ export function OfferEditPage() {
               required
               className="w-full border rounded px-3 py-2"
             />
-          </div>
+          </div>}
         </div>
 
+        {hasRules && (
+          <div>
+            <label className="block text-sm font-medium mb-1">Rules (JSON)</label>
+            <textarea
+              name="rules"
+              value={formData.rules}
+              onChange={handleChange}
+              rows={8}
+              className="w-full border rounded px-3 py-2 font-mono text-sm"
+            />
+            <p className="text-xs text-gray-500 mt-1">
+              {formData.discount_type === 'bogo' && 'For every "buy" items, "get" more are free, or "get_percent" off. The cheapest items are discounted.'}
+              {formData.discount_type === 'tiered' && 'The highest tier the cart reaches sets the percentage off. Amounts in rupees.'}
+              {formData.discount_type === 'bundle' && 'One of each item, or "quantity" of it, costs "bundle_price" together. Each item lists products (SKUs), categories or collections.'}
+            </p>
+          </div>
+        )}
+
         <div>
           <label className="block text-sm font-medium mb-1">Applies To</label>
           <select
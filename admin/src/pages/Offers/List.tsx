@@ -9,6 +9,22 @@ interface ListResponse {
   next_cursor?: string;
 }
 
+function discountLabel(offer: Offer): string {
+  const rules = offer.rules || {};
+  switch (offer.discount_type) {
+    case 'percentage':
+      return `${offer.discount_value}%`;
+    case 'bogo':
+      return `Buy ${rules.buy} get ${rules.get} ${rules.get_percent && rules.get_percent < 100 ? `${rules.get_percent}% off` : 'free'}`;
+    case 'tiered':
+      return (rules.tiers || []).map((t) => `${t.percent}% over ₹${t.min_order}`).join(', ');
+    case 'bundle':
+      return `Bundle for ₹${rules.bundle_price}`;
+    default:
+      return `$${offer.discount_value}`;
+  }
+}
+
 export function OffersListPage() {
   const [cursor, setCursor] = useState<string | undefined>();
   const [limit] = useState(50);
@@ -96,7 +112,7 @@ export function OffersListPage() {
                     {offer.description && <p className="text-gray-600 text-sm mt-1">{offer.description}</p>}
                     <div className="mt-2 flex items-center gap-4 text-sm text-gray-600">
                       <span>
-                        Discount: {offer.discount_type === 'percentage' ? `${offer.discount_value}%` : `$${offer.discount_value}`}
+                        Discount: {discountLabel(offer)}
                       </span>
                       <span>Applies to: {offer.applies_to}</span>
                       <span className={offer.is_active ? 'text-green-600' : 'text-red-600'}>
@@ -197,6 +197,11 @@ export function OrderDetailPage() {
                       <p className="font-semibold text-gray-900">
                         {order.currency} {item.price ? item.price.toFixed(2) : '0.00'}
                       </p>
+                      {item.discount_amount ? (
+                        <p className="text-sm text-green-600">
+                          Offer discount: -{order.currency} {item.discount_amount.toFixed(2)}
+                        </p>
+                      ) : null}
                       <p className="text-sm text-gray-600">
                         Total: {order.currency} {item.total ? item.total.toFixed(2) : '0.00'}
                       </p>
@@ -17,6 +17,8 @@ export function PreviewPage() {
     image_url: '',
   });
   const [previewHtml, setPreviewHtml] = useState('');
+  // Offer rules are typed as JSON and sent once they parse
+  const [rulesText, setRulesText] = useState('');
 
   const previewMutation = useMutation({
     mutationFn: (payload: PreviewRequest) =>
@@ -34,6 +36,17 @@ export function PreviewPage() {
     setContent(prev => ({ ...prev, [field]: value }));
   };
 
+  const handleRulesChange = (text: string) => {
+    setRulesText(text);
+    try {
+      handleFieldChange('rules', JSON.parse(text));
+    } catch {
+      // keep the last rules that parsed until the JSON is complete again
+    }
+  };
+
+  const offerHasRules = ['bogo', 'tiered', 'bundle'].includes(content.discount_type);
+
   const renderForm = () => {
     switch (type) {
       case 'product':
@@ -200,18 +213,34 @@ export function PreviewPage() {
                 >
                   <option value="percentage">Percentage</option>
                   <option value="fixed">Fixed Amount</option>
+                  <option value="bogo">Buy X Get Y</option>
+                  <option value="tiered">Tiered</option>
+                  <option value="bundle">Bundle Price</option>
                 </select>
               </div>
-              <div>
-                <label className="block text-sm font-medium mb-1">Discount Value</label>
-                <input
-                  type="number"
-                  step="any"
-                  value={content.discount_value || ''}
-                  onChange={(e) => handleFieldChange('discount_value', parseFloat(e.target.value) || 0)}
-                  className="w-full border rounded px-3 py-2"
-                />
-              </div>
+              {offerHasRules ? (
+                <div className="col-span-2">
+                  <label className="block text-sm font-medium mb-1">Rules (JSON)</label>
+                  <textarea
+                    value={rulesText}
+                    onChange={(e) => handleRulesChange(e.target.value)}
+                    rows={5}
+                    placeholder='{"buy": 2, "get": 1}'
+                    className="w-full border rounded px-3 py-2 font-mono text-sm"
+                  />
+                </div>
+              ) : (
+                <div>
+                  <label className="block text-sm font-medium mb-1">Discount Value</label>
+                  <input
+                    type="number"
+                    step="any"
+                    value={content.discount_value || ''}
+                    onChange={(e) => handleFieldChange('discount_value', parseFloat(e.target.value) || 0)}
+                    className="w-full border rounded px-3 py-2"
+                  />
+                </div>
+              )}
             </div>
             <div>
               <label className="block text-sm font-medium mb-1">Image URL</label>
@@ -87,12 +87,23 @@ export interface Banner {
   updated_at: string;
 }
 
+// Settings of the offer types a single discount value cannot describe; amounts in rupees
+export interface OfferRules {
+  buy?: number;
+  get?: number;
+  get_percent?: number;
+  tiers?: { min_order: number; percent: number }[];
+  items?: { applies_to: 'products' | 'categories' | 'collections'; ids: string[]; quantity?: number }[];
+  bundle_price?: number;
+}
+
 export interface Offer {
   id: string;
   title: string;
   description?: string;
-  discount_type: 'percentage' | 'fixed';
+  discount_type: 'percentage' | 'fixed' | 'bogo' | 'tiered' | 'bundle';
   discount_value: number;
+  rules?: OfferRules;
   applies_to: 'all' | 'products' | 'categories' | 'collections';
   applies_to_ids: string[];
   min_order_amount?: number;
@@ -165,6 +176,8 @@ export interface OrderLineItem {
   quantity: number;
   price: number;
   total: number;
+  // The part of the order's offer discount that fell on this line
+  discount_amount?: number;
 }
 
 export interface CreateOrderLineItem {
@@ -7,14 +7,17 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 
+	"github.com/etreasure/backend/internal/auth"
 	"github.com/etreasure/backend/internal/config"
 	"github.com/etreasure/backend/internal/db"
 	"github.com/etreasure/backend/internal/email"
 	"github.com/etreasure/backend/internal/handlers"
+	"github.com/etreasure/backend/internal/kv"
 	"github.com/etreasure/backend/internal/middleware"
 	"github.com/etreasure/backend/internal/storage"
 	"github.com/gin-contrib/cors"
@@ -32,6 +35,11 @@ func main() {
 	}
 	defer pool.Close()
 
+	// API keys are verified against the database by middleware.AuthRequired
+	apiKeysHandler := &handlers.APIKeysHandler{DB: pool}
+	cfg.APIKeys = apiKeysHandler
+	cfg.Impersonation = &handlers.ImpersonationTracker{DB: pool}
+
 	// Initialize Redis client
 	redisAddr := os.Getenv("REDIS_ADDR")
 	if redisAddr == "" {
@@ -47,11 +55,22 @@ func main() {
 	_, err = redisClient.Ping(ctx).Result()
 	if err != nil {
 		log.Printf("Warning: Redis connection failed: %v", err)
-		log.Printf("Forgot password functionality will not work without Redis")
+		log.Printf("OTPs and rate limits will use the Postgres fallback store until Redis is reachable")
 	} else {
 		log.Println("Connected to Redis successfully")
 	}
 
+	// OTPs, sign-in challenges and rate limits: Redis, falling back to Postgres while it is down
+	pgStore := kv.NewPostgresStore(pool)
+	go pgStore.RunSweeper(ctx, 5*time.Minute)
+
+	// Failed-login history is only kept as long as it is useful
+	go handlers.RunLoginAttemptSweeper(ctx, pool, time.Hour)
+
+	// Give back stock held by checkouts that were abandoned on the payment screen
+	go handlers.RunReservationSweeper(ctx, pool, time.Minute)
+	kvStore := kv.NewFallbackStore(kv.NewRedisStore(redisClient), pgStore)
+
 	// Initialize email service
 	smtpConfig := email.SMTPConfig{
 		Host:     cfg.SMTPHost,
@@ -86,7 +105,7 @@ func main() {
 		r.Use(cors.New(cors.Config{
 			AllowOrigins:     []string{"http://localhost:4321", "http://127.0.0.1:4321", "http://localhost:3000", "http://127.0.0.1:3000", "http://localhost:5174", "http://127.0.0.1:5174", "https://ethnictreasures.co.in"},
 			AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Requested-With"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Requested-With", "X-Refresh-Token", "X-API-Key"},
 			ExposeHeaders:    []string{"Content-Length", "Set-Cookie"},
 			AllowCredentials: true,
 		}))
@@ -108,7 +127,7 @@ func main() {
 		r.Use(cors.New(cors.Config{
 			AllowOrigins:     allowedOrigins,
 			AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Requested-With"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Requested-With", "X-Refresh-Token", "X-API-Key"},
 			ExposeHeaders:    []string{"Content-Length", "Set-Cookie"},
 			AllowCredentials: true,
 		}))
@@ -121,10 +140,15 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
-	authHandler := &handlers.AuthHandler{DB: pool, Cfg: cfg, Rd: redisClient, Email: emailService}
+	privacy := &handlers.PrivacyHandler{DB: pool}
+	authHandler := &handlers.AuthHandler{DB: pool, Cfg: cfg, KV: kvStore, Email: emailService}
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+	if cfg.OIDCClientID != "" {
+		authHandler.OIDC = auth.NewOIDCProvider(cfg.OIDCIssuerURL, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL)
+	}
 
 	// Stock notifications handler
-	stockNotificationsHandler := &handlers.StockNotificationsHandler{DB: pool, Rd: redisClient, Email: emailService}
+	stockNotificationsHandler := &handlers.StockNotificationsHandler{DB: pool, Rd: redisClient, Email: emailService, Cfg: cfg}
 
 	// Admin auth routes
 	authGroup := r.Group("/api/admin/auth")
@@ -132,6 +156,8 @@ func main() {
 		authGroup.POST("/login", authHandler.Login)
 		authGroup.POST("/refresh", authHandler.Refresh)
 		authGroup.POST("/logout", authHandler.Logout)
+		authGroup.POST("/2fa/verify", authHandler.VerifyTwoFactor)
+		authGroup.POST("/2fa/enroll", authHandler.EnrollTwoFactor)
 		authGroup.POST("/forgot-password", authHandler.ForgotPassword)
 		authGroup.POST("/verify-otp", authHandler.VerifyOTP)
 		authGroup.POST("/reset-password", authHandler.ResetPassword)
@@ -148,7 +174,8 @@ func main() {
 	}
 
 	// Stock notifications (public)
-	r.POST("/api/stock-notifications", stockNotificationsHandler.CreateStockNotification)
+	r.POST("/api/stock-notifications", middleware.OptionalAuth(cfg), stockNotificationsHandler.CreateStockNotification)
+	r.POST("/api/stock-notifications/confirm", stockNotificationsHandler.ConfirmStockNotification)
 
 	// Initialize R2 client for global use
 	r2Client, err := storage.NewR2Client(cfg)
@@ -160,112 +187,154 @@ func main() {
 	// Initialize ImageURLHelper for consistent image URL formatting
 	imageHelper := storage.NewImageURLHelper(r2Client)
 
+	// Remind customers about carts and checkouts they left
+	cartRecovery := &handlers.CartRecoveryHandler{DB: pool, Email: emailService, Cfg: cfg, ImageHelper: imageHelper}
+	go cartRecovery.Run(ctx, 5*time.Minute)
+
+	// Email the daily digest of price drops and restocks on customers' wishlists
+	wishlistAlerts := &handlers.WishlistAlertsHandler{DB: pool, Email: emailService, Cfg: cfg, ImageHelper: imageHelper}
+	go wishlistAlerts.Run(ctx, 15*time.Minute)
+
 	protected := r.Group("/api/admin")
-	// protected.Use(middleware.AuthRequired(cfg)) // Re-enabled for production
+	protected.Use(middleware.AuthRequired(cfg))
 	{
 		protected.GET("/me", authHandler.Me)
 
 		// Products (admin)
 		products := &handlers.ProductsHandler{DB: pool, R2Client: r2Client, ImageHelper: imageHelper, StockNotifications: stockNotificationsHandler}
-		protected.GET("/products", products.List)
-		protected.POST("/products", products.Create)
-		protected.GET("/products/:id", products.Get)
-		protected.PUT("/products/:id", products.Update)
-		protected.DELETE("/products/:id", products.Delete)
-		protected.POST("/products/import", products.Import)
-		protected.GET("/products/out-of-stock", products.GetOutOfStockProducts)
-		protected.PATCH("/products/:productId/variants/:variantId/stock", products.UpdateVariantStock)
+		protected.GET("/products", middleware.RequirePermission("products:read"), products.List)
+		protected.POST("/products", middleware.RequirePermission("products:write"), products.Create)
+		protected.GET("/products/:id", middleware.RequirePermission("products:read"), products.Get)
+		protected.PUT("/products/:id", middleware.RequirePermission("products:write"), products.Update)
+		protected.DELETE("/products/:id", middleware.RequirePermission("products:write"), products.Delete)
+		protected.POST("/products/import", middleware.RequirePermission("products:write"), products.Import)
+		protected.GET("/products/out-of-stock", middleware.RequirePermission("products:read"), products.GetOutOfStockProducts)
+		protected.PATCH("/products/:productId/variants/:variantId/stock", middleware.RequirePermission("inventory:write"), products.UpdateVariantStock)
 
 		// Media
 		media := &handlers.MediaHandler{DB: pool, UploadDir: cfg.UploadDir, HMACSecret: cfg.UploadHMACSecret, R2Client: r2Client, Config: cfg}
-		protected.POST("/media/presign", media.Presign)
-		protected.PUT("/media/upload/:id", media.Upload)
-		protected.POST("/media/upload", media.UploadR2)
-		protected.GET("/media", media.List)
-		protected.DELETE("/media/:id", media.Delete)
+		protected.POST("/media/presign", middleware.RequirePermission("media:write"), media.Presign)
+		protected.PUT("/media/upload/:id", middleware.RequirePermission("media:write"), media.Upload)
+		protected.POST("/media/upload", middleware.RequirePermission("media:write"), media.UploadR2)
+		protected.GET("/media", middleware.RequirePermission("media:read"), media.List)
+		protected.DELETE("/media/:id", middleware.RequirePermission("media:write"), media.Delete)
 
 		// Categories
 		categories := &handlers.CategoriesHandler{DB: pool, R2Client: r2Client, ImageHelper: imageHelper}
-		protected.GET("/categories", categories.List)
-		protected.POST("/categories", categories.Create)
-		protected.PUT("/categories/:id", categories.Update)
-		protected.DELETE("/categories/:id", categories.Delete)
+		protected.GET("/categories", middleware.RequirePermission("categories:read"), categories.List)
+		protected.POST("/categories", middleware.RequirePermission("categories:write"), categories.Create)
+		protected.PUT("/categories/:id", middleware.RequirePermission("categories:write"), categories.Update)
+		protected.DELETE("/categories/:id", middleware.RequirePermission("categories:write"), categories.Delete)
 
 		// Banners
 		banners := &handlers.Handler{DB: pool, R2Client: r2Client, Config: cfg, ImageHelper: imageHelper}
-		protected.GET("/banners", banners.ListBanners)
-		protected.POST("/banners", banners.CreateBanner)
-		protected.GET("/banners/:id", banners.GetBanner)
-		protected.PUT("/banners/:id", banners.UpdateBanner)
-		protected.DELETE("/banners/:id", banners.DeleteBanner)
+		protected.GET("/banners", middleware.RequirePermission("banners:read"), banners.ListBanners)
+		protected.POST("/banners", middleware.RequirePermission("banners:write"), banners.CreateBanner)
+		protected.GET("/banners/:id", middleware.RequirePermission("banners:read"), banners.GetBanner)
+		protected.PUT("/banners/:id", middleware.RequirePermission("banners:write"), banners.UpdateBanner)
+		protected.DELETE("/banners/:id", middleware.RequirePermission("banners:write"), banners.DeleteBanner)
 
 		// Offers
 		offers := &handlers.Handler{DB: pool}
-		protected.GET("/offers", offers.ListOffers)
-		protected.POST("/offers", offers.CreateOffer)
-		protected.GET("/offers/:id", offers.GetOffer)
-		protected.PUT("/offers/:id", offers.UpdateOffer)
-		protected.DELETE("/offers/:id", offers.DeleteOffer)
+		protected.GET("/offers", middleware.RequirePermission("offers:read"), offers.ListOffers)
+		protected.POST("/offers", middleware.RequirePermission("offers:write"), offers.CreateOffer)
+		protected.GET("/offers/:id", middleware.RequirePermission("offers:read"), offers.GetOffer)
+		protected.PUT("/offers/:id", middleware.RequirePermission("offers:write"), offers.UpdateOffer)
+		protected.DELETE("/offers/:id", middleware.RequirePermission("offers:write"), offers.DeleteOffer)
+		protected.GET("/offers/:id/codes", middleware.RequirePermission("offers:read"), offers.ListOfferCodes)
+		protected.POST("/offers/:id/codes", middleware.RequirePermission("offers:write"), offers.CreateOfferCodes)
+		protected.GET("/offers/:id/codes/export", middleware.RequirePermission("offers:read"), offers.ExportOfferCodes)
+		protected.DELETE("/offers/:id/codes/:codeId", middleware.RequirePermission("offers:write"), offers.DeleteOfferCode)
 
 		// Orders
 		orders := &handlers.Handler{DB: pool}
-		protected.GET("/orders", orders.ListOrders)
-		protected.POST("/orders", orders.CreateOrder)
-		protected.GET("/orders/:id", orders.GetOrder)
-		protected.PUT("/orders/:id", orders.UpdateOrder)
-		protected.DELETE("/orders/:id", orders.DeleteOrder)
-		protected.GET("/orders/debug/schema", orders.DebugOrdersSchema)
-		protected.GET("/orders/debug/line-items", orders.DebugLineItems)
-		protected.POST("/orders/fix-prices", orders.FixOrderLineItemsPrices)
-		protected.POST("/orders/fix-null-prices", orders.FixNullPrices)
+		protected.GET("/orders", middleware.RequirePermission("orders:read"), orders.ListOrders)
+		protected.POST("/orders", middleware.RequirePermission("orders:write"), orders.CreateOrder)
+		protected.GET("/orders/:id", middleware.RequirePermission("orders:read"), orders.GetOrder)
+		protected.PUT("/orders/:id", middleware.RequirePermission("orders:write"), orders.UpdateOrder)
+		protected.PATCH("/orders/:id/shipping", middleware.RequirePermission("orders:fulfil"), orders.UpdateOrderShipping)
+		protected.DELETE("/orders/:id", middleware.RequirePermission("orders:write"), orders.DeleteOrder)
+		protected.GET("/orders/debug/schema", middleware.RequirePermission("system:maintenance"), orders.DebugOrdersSchema)
+		protected.GET("/orders/debug/line-items", middleware.RequirePermission("system:maintenance"), orders.DebugLineItems)
+		protected.POST("/orders/fix-prices", middleware.RequirePermission("system:maintenance"), orders.FixOrderLineItemsPrices)
+		protected.POST("/orders/fix-null-prices", middleware.RequirePermission("system:maintenance"), orders.FixNullPrices)
+
+		protected.GET("/cart-recovery", middleware.RequirePermission("orders:read"), cartRecovery.Report)
 
 		// Customers (from users table, excluding admin roles)
 		customers := &handlers.Handler{DB: pool}
-		protected.GET("/customers", customers.ListUserCustomers)
-		protected.GET("/customers/:id/orders", customers.GetCustomerOrders)
+		protected.GET("/customers", middleware.RequirePermission("customers:read"), customers.ListUserCustomers)
+		protected.GET("/customers/:id/orders", middleware.RequirePermission("customers:read"), customers.GetCustomerOrders)
+		protected.POST("/customers/:id/impersonate", middleware.RequirePermission("customers:impersonate"), authHandler.StartImpersonation)
+		protected.DELETE("/impersonations/:id", middleware.RequirePermission("customers:impersonate"), authHandler.EndImpersonation)
+
+		// Data subject requests (DPDP / GDPR)
+		protected.POST("/customers/:id/export", middleware.RequirePermission("customers:privacy"), privacy.ExportCustomerData)
+		protected.POST("/customers/:id/erase", middleware.RequirePermission("customers:privacy"), privacy.EraseCustomer)
+		protected.GET("/data-requests", middleware.RequirePermission("customers:privacy"), privacy.ListDataRequests)
 
 		// Inventory
 		inventory := &handlers.Handler{DB: pool}
-		protected.GET("/inventory", inventory.ListInventory)
-		protected.POST("/inventory", inventory.CreateInventoryItem)
-		protected.GET("/inventory/:id", inventory.GetInventoryItem)
-		protected.PUT("/inventory/:id", inventory.UpdateInventoryItem)
-		protected.DELETE("/inventory/:id", inventory.DeleteInventoryItem)
-		protected.POST("/inventory/:id/adjust", inventory.AdjustInventory)
+		protected.GET("/inventory", middleware.RequirePermission("inventory:read"), inventory.ListInventory)
+		protected.POST("/inventory", middleware.RequirePermission("inventory:write"), inventory.CreateInventoryItem)
+		protected.GET("/inventory/reservations", middleware.RequirePermission("inventory:read"), inventory.ListReservations)
+		protected.GET("/inventory/:id", middleware.RequirePermission("inventory:read"), inventory.GetInventoryItem)
+		protected.PUT("/inventory/:id", middleware.RequirePermission("inventory:write"), inventory.UpdateInventoryItem)
+		protected.DELETE("/inventory/:id", middleware.RequirePermission("inventory:write"), inventory.DeleteInventoryItem)
+		protected.POST("/inventory/:id/adjust", middleware.RequirePermission("inventory:write"), inventory.AdjustInventory)
 
 		// Settings
 		settings := &handlers.Handler{DB: pool}
-		protected.GET("/settings", settings.ListSettings)
-		protected.POST("/settings", settings.CreateSetting)
-		protected.GET("/settings/:key", settings.GetSetting)
-		protected.PUT("/settings/:key", settings.UpdateSetting)
-		protected.DELETE("/settings/:key", settings.DeleteSetting)
+		protected.GET("/settings", middleware.RequirePermission("settings:read"), settings.ListSettings)
+		protected.POST("/settings", middleware.RequirePermission("settings:write"), settings.CreateSetting)
+		protected.GET("/settings/:key", middleware.RequirePermission("settings:read"), settings.GetSetting)
+		protected.PUT("/settings/:key", middleware.RequirePermission("settings:write"), settings.UpdateSetting)
+		protected.DELETE("/settings/:key", middleware.RequirePermission("settings:write"), settings.DeleteSetting)
 
 		// Users & Roles
 		users := &handlers.Handler{DB: pool}
-		protected.GET("/users", users.ListUsers)
-		protected.POST("/users", users.CreateUser)
-		protected.GET("/users/:id", users.GetUser)
-		protected.PUT("/users/:id", users.UpdateUser)
-		protected.DELETE("/users/:id", users.DeleteUser)
-		protected.GET("/roles", users.ListRoles)
+		protected.GET("/users", middleware.RequirePermission("users:read"), users.ListUsers)
+		protected.POST("/users", middleware.RequirePermission("users:write"), users.CreateUser)
+		protected.GET("/users/:id", middleware.RequirePermission("users:read"), users.GetUser)
+		protected.PUT("/users/:id", middleware.RequirePermission("users:write"), users.UpdateUser)
+		protected.DELETE("/users/:id", middleware.RequirePermission("users:write"), users.DeleteUser)
+		protected.GET("/roles", middleware.RequirePermission("users:read"), users.ListRoles)
+		protected.PUT("/roles/:id/permissions", middleware.RequirePermission("users:write"), users.UpdateRolePermissions)
+		protected.GET("/permissions", middleware.RequirePermission("users:read"), users.ListPermissions)
+
+		// User sessions (support force-logout)
+		protected.GET("/users/:id/sessions", middleware.RequirePermission("users:read"), authHandler.ListUserSessions)
+		protected.DELETE("/users/:id/sessions", middleware.RequirePermission("users:write"), authHandler.RevokeAllUserSessions)
+		protected.DELETE("/users/:id/sessions/:sessionId", middleware.RequirePermission("users:write"), authHandler.RevokeUserSession)
+		protected.DELETE("/users/:id/two-factor", middleware.RequirePermission("users:write"), authHandler.ResetUserTwoFactor)
+		protected.POST("/users/:id/unlock", middleware.RequirePermission("users:write"), authHandler.UnlockUser)
+
+		// Integration API keys (ERP sync, fulfilment partners)
+		protected.GET("/api-keys", middleware.RequirePermission("api_keys:read"), apiKeysHandler.ListAPIKeys)
+		protected.POST("/api-keys", middleware.RequirePermission("api_keys:write"), apiKeysHandler.CreateAPIKey)
+		protected.PATCH("/api-keys/:id", middleware.RequirePermission("api_keys:write"), apiKeysHandler.UpdateAPIKey)
+		protected.DELETE("/api-keys/:id", middleware.RequirePermission("api_keys:write"), apiKeysHandler.RevokeAPIKey)
+
+		// Audit log
+		audit := &handlers.AuditHandler{DB: pool}
+		protected.GET("/audit", middleware.RequirePermission("audit:read"), audit.List)
 
 		// Preview
 		preview := &handlers.Handler{DB: pool}
-		protected.POST("/preview", preview.Preview)
+		protected.POST("/preview", middleware.RequirePermission("products:read"), preview.Preview)
 
 		// Content Management
 		content := &handlers.Handler{DB: pool}
-		protected.GET("/content/pages", content.ListContentPages)
-		protected.POST("/content/pages", content.CreateContentPage)
-		protected.GET("/content/pages/:slug", content.GetContentPageAdmin) // Use admin handler
-		protected.PUT("/content/pages/:id", content.CreateContentPage)     // Update uses same handler
-		protected.DELETE("/content/pages/:id", content.DeleteContentPage)
-
-		protected.GET("/content/faqs", content.ListFAQs)
-		protected.POST("/content/faqs", content.CreateFAQ)
-		protected.PUT("/content/faqs/:id", content.CreateFAQ) // Update uses same handler
-		protected.DELETE("/content/faqs/:id", content.DeleteFAQ)
+		protected.GET("/content/pages", middleware.RequirePermission("content:read"), content.ListContentPages)
+		protected.POST("/content/pages", middleware.RequirePermission("content:write"), content.CreateContentPage)
+		protected.GET("/content/pages/:slug", middleware.RequirePermission("content:read"), content.GetContentPageAdmin) // Use admin handler
+		protected.PUT("/content/pages/:id", middleware.RequirePermission("content:write"), content.CreateContentPage)    // Update uses same handler
+		protected.DELETE("/content/pages/:id", middleware.RequirePermission("content:write"), content.DeleteContentPage)
+
+		protected.GET("/content/faqs", middleware.RequirePermission("content:read"), content.ListFAQs)
+		protected.POST("/content/faqs", middleware.RequirePermission("content:write"), content.CreateFAQ)
+		protected.PUT("/content/faqs/:id", middleware.RequirePermission("content:write"), content.CreateFAQ) // Update uses same handler
+		protected.DELETE("/content/faqs/:id", middleware.RequirePermission("content:write"), content.DeleteFAQ)
 	}
 
 	// Public settings (no auth)
@@ -309,8 +378,55 @@ func main() {
 	r.POST("/api/auth/verify-otp", authHandler.VerifyOTP)
 	r.POST("/api/auth/reset-password", authHandler.ResetPassword)
 
+	// Passwordless login (emailed code or one-click link)
+	r.POST("/api/auth/passwordless/request", authHandler.RequestLoginCode)
+	r.POST("/api/auth/passwordless/verify", authHandler.VerifyLoginCode)
+	r.POST("/api/auth/passwordless/verify-link", authHandler.VerifyLoginLink)
+
+	// Email verification and email change
+	r.POST("/api/auth/email/verify-link", authHandler.VerifyEmailLink)
+	r.POST("/api/auth/email/change/confirm-link", authHandler.ConfirmEmailChangeLink)
+	emailRoutes := r.Group("/api/auth/email")
+	emailRoutes.Use(middleware.AuthRequired(cfg))
+	{
+		emailRoutes.POST("/resend", authHandler.ResendEmailVerification)
+		emailRoutes.POST("/verify", authHandler.VerifyEmail)
+		emailRoutes.POST("/change", authHandler.RequestEmailChange)
+		emailRoutes.POST("/change/confirm", authHandler.ConfirmEmailChange)
+	}
+
+	// Sign in with Google (OpenID Connect)
+	r.GET("/api/auth/oidc/start", authHandler.StartOIDCLogin)
+	r.POST("/api/auth/oidc/callback", authHandler.OIDCCallback)
+
+	// Ending a "view as customer" session with the impersonation token itself
+	middleware.AllowDuringImpersonation(http.MethodPost, "/api/auth/impersonation/stop")
+	r.POST("/api/auth/impersonation/stop", middleware.AuthRequired(cfg), authHandler.StopImpersonation)
+
+	// Two-factor authentication
+	r.POST("/api/auth/2fa/verify", authHandler.VerifyTwoFactor)
+	r.POST("/api/auth/2fa/enroll", authHandler.EnrollTwoFactor)
+	twoFactorRoutes := r.Group("/api/auth/2fa")
+	twoFactorRoutes.Use(middleware.AuthRequired(cfg))
+	{
+		twoFactorRoutes.GET("", authHandler.TwoFactorStatus)
+		twoFactorRoutes.POST("/setup", authHandler.SetupTwoFactor)
+		twoFactorRoutes.POST("/enable", authHandler.EnableTwoFactor)
+		twoFactorRoutes.POST("/disable", authHandler.DisableTwoFactor)
+		twoFactorRoutes.POST("/recovery-codes", authHandler.RegenerateRecoveryCodes)
+	}
+
+	// Session and device management for the signed-in user
+	sessionRoutes := r.Group("/api/auth/sessions")
+	sessionRoutes.Use(middleware.AuthRequired(cfg))
+	{
+		sessionRoutes.GET("", authHandler.ListMySessions)
+		sessionRoutes.DELETE("/:id", authHandler.RevokeMySession)
+		sessionRoutes.POST("/revoke-others", authHandler.RevokeOtherSessions)
+	}
+
 	// Temporary endpoint to fix orders table schema
-	r.POST("/admin/fix-orders-schema", func(c *gin.Context) {
+	r.POST("/admin/fix-orders-schema", middleware.AuthRequired(cfg), middleware.RequirePermission("system:maintenance"), func(c *gin.Context) {
 		ctx := context.Background()
 
 		sqlScript := `
@@ -378,7 +494,7 @@ WHERE customer_name IS NULL OR customer_email IS NULL OR customer_phone IS NULL
 	})
 
 	// Temporary endpoint to set some orders as just arrived for testing
-	r.POST("/admin/set-just-arrived", func(c *gin.Context) {
+	r.POST("/admin/set-just-arrived", middleware.AuthRequired(cfg), middleware.RequirePermission("system:maintenance"), func(c *gin.Context) {
 		ctx := context.Background()
 
 		// Update orders to have shipping_status = 'just arrived'
@@ -397,7 +513,7 @@ WHERE customer_name IS NULL OR customer_email IS NULL OR customer_phone IS NULL
 	})
 
 	// Temporary endpoint to create missing tables
-	r.POST("/admin/create-tables", func(c *gin.Context) {
+	r.POST("/admin/create-tables", middleware.AuthRequired(cfg), middleware.RequirePermission("system:maintenance"), func(c *gin.Context) {
 		ctx := context.Background()
 
 		sqlScript := `
@@ -448,17 +564,37 @@ CREATE INDEX IF NOT EXISTS idx_wishlist_product ON wishlist(product_id);
 		c.JSON(http.StatusOK, gin.H{"message": "Cart and wishlist tables created successfully!"})
 	})
 
-	// Public Cart and Wishlist endpoints (no authentication required)
+	// Cart endpoints: guests use the session cookie, signed-in customers get their own cart
 	cartHandler := &handlers.CartHandler{DB: pool, ImageHelper: imageHelper}
-	r.POST("/api/cart/add", cartHandler.AddToCart)
-	r.GET("/api/cart", cartHandler.GetCart)
-	r.DELETE("/api/cart/:id", cartHandler.RemoveFromCart)
-	r.POST("/api/cart/clear", cartHandler.ClearCart)
-
+	r.POST("/api/cart/add", middleware.OptionalAuth(cfg), cartHandler.AddToCart)
+	r.GET("/api/cart", middleware.OptionalAuth(cfg), cartHandler.GetCart)
+	r.PATCH("/api/cart/:id", middleware.OptionalAuth(cfg), cartHandler.UpdateCartItem)
+	r.DELETE("/api/cart/:id", middleware.OptionalAuth(cfg), cartHandler.RemoveFromCart)
+	r.POST("/api/cart/clear", middleware.OptionalAuth(cfg), cartHandler.ClearCart)
+	// Single-use codes are short enough to guess, so applying one is rate limited per IP
+	r.POST("/api/cart/coupon", middleware.RateLimitByIP(kvStore, "coupon", 10, time.Minute), middleware.OptionalAuth(cfg), cartHandler.ApplyCoupon)
+	r.DELETE("/api/cart/coupon", middleware.OptionalAuth(cfg), cartHandler.RemoveCoupon)
+
+	r.POST("/api/cart-reminders/click", cartRecovery.TrackClick)
+	r.POST("/api/email/unsubscribe", cartRecovery.Unsubscribe)
+
+	// Wishlist endpoints: like the cart, keyed by the session cookie for guests and by account once signed in
 	wishlistHandler := &handlers.WishlistHandler{DB: pool, ImageHelper: imageHelper}
-	r.POST("/api/wishlist/toggle", wishlistHandler.ToggleWishlist)
-	r.GET("/api/wishlist", wishlistHandler.GetWishlist)
-	r.DELETE("/api/wishlist/:id", wishlistHandler.RemoveFromWishlist)
+	r.POST("/api/wishlist/toggle", middleware.OptionalAuth(cfg), wishlistHandler.ToggleWishlist)
+	r.GET("/api/wishlist", middleware.OptionalAuth(cfg), wishlistHandler.GetWishlist)
+	r.DELETE("/api/wishlist/:id", middleware.OptionalAuth(cfg), wishlistHandler.RemoveFromWishlist)
+	r.PUT("/api/wishlist/:id/alerts", middleware.OptionalAuth(cfg), wishlistAlerts.UpdateItemAlerts)
+
+	// Moves between the cart, saved for later and the wishlist keep the variant
+	r.POST("/api/cart/:id/save-for-later", middleware.OptionalAuth(cfg), cartHandler.SaveForLater)
+	r.POST("/api/cart/:id/move-to-cart", middleware.OptionalAuth(cfg), cartHandler.MoveToCart)
+	r.POST("/api/cart/:id/move-to-wishlist", middleware.OptionalAuth(cfg), wishlistHandler.MoveFromCart)
+	r.POST("/api/wishlist/:id/move-to-cart", middleware.OptionalAuth(cfg), wishlistHandler.MoveToCart)
+
+	// Gift registries: anyone with the share link can view one and buy from it
+	registryHandler := &handlers.RegistryHandler{DB: pool, ImageHelper: imageHelper, Cfg: cfg}
+	r.GET("/api/registries/:token", registryHandler.ViewRegistry)
+	r.POST("/api/registries/:token/items/:itemId/cart", middleware.OptionalAuth(cfg), registryHandler.AddGiftToCart)
 
 	// Public search endpoints (fast, cached, rate-limited)
 	searchHandler := handlers.NewSearchHandler(pool)
@@ -468,7 +604,7 @@ CREATE INDEX IF NOT EXISTS idx_wishlist_product ON wishlist(product_id);
 	r.GET("/api/search/health", searchHandler.Health)
 
 	// Admin search endpoints
-	protected.POST("/search/reindex", searchHandler.Reindex)
+	protected.POST("/search/reindex", middleware.RequirePermission("system:maintenance"), searchHandler.Reindex)
 
 	r.GET("/api/search/fix", func(c *gin.Context) {
 		ctx := c.Request.Context()
@@ -538,6 +674,28 @@ CREATE INDEX IF NOT EXISTS idx_wishlist_product ON wishlist(product_id);
 		paymentRoutes.POST("/verify-payment", razorpay.VerifyPayment)
 	}
 
+	// Signed-in customer account: personal data export, erasure and gift registries
+	accountRoutes := r.Group("/api/account")
+	accountRoutes.Use(middleware.AuthRequired(cfg))
+	{
+		accountRoutes.POST("/export", privacy.ExportMyData)
+		accountRoutes.POST("/erase", privacy.EraseMyAccount)
+
+		// Price-drop and back-in-stock alerts for the whole wishlist
+		accountRoutes.GET("/wishlist-alerts", wishlistAlerts.GetAlertSettings)
+		accountRoutes.PUT("/wishlist-alerts", wishlistAlerts.UpdateAlertSettings)
+
+		// The customer's own gift registries
+		accountRoutes.GET("/registries", registryHandler.ListMyRegistries)
+		accountRoutes.POST("/registries", registryHandler.CreateRegistry)
+		accountRoutes.GET("/registries/:id", registryHandler.GetMyRegistry)
+		accountRoutes.PUT("/registries/:id", registryHandler.UpdateRegistry)
+		accountRoutes.DELETE("/registries/:id", registryHandler.DeleteRegistry)
+		accountRoutes.POST("/registries/:id/items", registryHandler.AddRegistryItem)
+		accountRoutes.PATCH("/registries/:id/items/:itemId", registryHandler.UpdateRegistryItem)
+		accountRoutes.DELETE("/registries/:id/items/:itemId", registryHandler.RemoveRegistryItem)
+	}
+
 	// Authenticated user orders
 	userOrders := r.Group("/api/orders")
 	userOrders.Use(middleware.AuthRequired(cfg))
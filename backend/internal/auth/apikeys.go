@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+)
+
+// APIKeyPrefix marks integration keys so they are easy to spot in logs and secret scanners
+const APIKeyPrefix = "etk_"
+
+var (
+	ErrAPIKeyInvalid      = errors.New("invalid api key")
+	ErrAPIKeyIPNotAllowed = errors.New("api key not allowed from this address")
+)
+
+// APIKey is the identity behind a verified X-API-Key header
+type APIKey struct {
+	ID     int
+	Name   string
+	Scopes []string
+}
+
+// APIKeyVerifier resolves a raw key sent by a client; it is implemented by the API key store
+type APIKeyVerifier interface {
+	VerifyAPIKey(ctx context.Context, rawKey, clientIP string) (*APIKey, error)
+}
+
+// GenerateAPIKey returns a new key and the short prefix shown in listings
+func GenerateAPIKey() (key, displayPrefix string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(b)
+	return key, key[:len(APIKeyPrefix)+8], nil
+}
+
+// HashAPIKey is what gets stored and looked up; keys have enough entropy that a plain hash suffices
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IPAllowed reports whether ip matches the allow-list of addresses and CIDR ranges.
+// An empty list allows every address.
+func IPAllowed(allowList []string, ip string) bool {
+	if len(allowList) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowList {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidIPAllowEntry reports whether entry is an IP address or CIDR range
+func ValidIPAllowEntry(entry string) bool {
+	if strings.Contains(entry, "/") {
+		_, _, err := net.ParseCIDR(entry)
+		return err == nil
+	}
+	return net.ParseIP(entry) != nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || !strings.HasPrefix(key, prefix) || len(prefix) != len(APIKeyPrefix)+8 {
+		t.Fatalf("unexpected key %q with prefix %q", key, prefix)
+	}
+	other, _, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key || HashAPIKey(other) == HashAPIKey(key) {
+		t.Fatal("keys should be unique")
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	tests := []struct {
+		allow []string
+		ip    string
+		want  bool
+	}{
+		{nil, "203.0.113.7", true},
+		{[]string{"203.0.113.7"}, "203.0.113.7", true},
+		{[]string{"203.0.113.7"}, "203.0.113.8", false},
+		{[]string{"10.0.0.0/8", "198.51.100.1"}, "10.20.30.40", true},
+		{[]string{"10.0.0.0/8"}, "11.0.0.1", false},
+		{[]string{"2001:db8::/32"}, "2001:db8::1", true},
+		{[]string{"10.0.0.0/8"}, "not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := IPAllowed(tt.allow, tt.ip); got != tt.want {
+			t.Errorf("IPAllowed(%v, %q) = %v, want %v", tt.allow, tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package auth
+
+import "context"
+
+// ImpersonationTracker backs tokens minted for support staff to view the storefront as a
+// customer. Sessions can be ended before the token expires, and every request made with
+// such a token is recorded.
+type ImpersonationTracker interface {
+	ImpersonationActive(ctx context.Context, sessionID string) (bool, error)
+	RecordImpersonatedRequest(ctx context.Context, claims *Claims, method, path string, status int, clientIP string)
+}
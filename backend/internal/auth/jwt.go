@@ -4,31 +4,45 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type Claims struct {
-	UserID int      `json:"user_id"`
-	Roles  []string `json:"roles"`
+	UserID      int      `json:"user_id"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions,omitempty"`
+	// ImpersonatorID is the staff user acting as UserID ("view as customer"); zero otherwise
+	ImpersonatorID int `json:"impersonator_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateAccessToken(secret string, userID int, roles []string, ttl time.Duration) (string, error) {
-	claims := Claims{
-		UserID: userID,
-		Roles:  roles,
+func newClaims(userID int, roles, permissions []string, ttl time.Duration) Claims {
+	return Claims{
+		UserID:      userID,
+		Roles:       roles,
+		Permissions: permissions,
 		RegisteredClaims: jwt.RegisteredClaims{
+			// A unique ID keeps tokens issued in the same second distinct,
+			// which matters for refresh tokens stored by hash.
+			ID:        uuid.New().String(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+}
+
+// GenerateAccessToken signs an HS256 token with a shared secret. Access tokens are signed
+// through KeySet; this is used for refresh tokens, which only this service verifies.
+func GenerateAccessToken(secret string, userID int, roles, permissions []string, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims(userID, roles, permissions, ttl))
 	return token.SignedString([]byte(secret))
 }
 
+// ParseToken verifies an HS256 token signed by GenerateAccessToken
 func ParseToken(secret, tokenStr string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(secret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return nil, err
 	}
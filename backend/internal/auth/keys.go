@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet signs access tokens with the active key and verifies them with any key it knows,
+// looked up by the kid header. Rotating means adding a new key, making it active, and
+// removing the old one once the tokens it signed have expired.
+type KeySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	// legacy verifies kid-less HS256 tokens issued before asymmetric keys were configured
+	legacy []byte
+}
+
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer // nil for verification-only keys
+	public  crypto.PublicKey
+	secret  []byte // HS256 development keys only
+}
+
+// JWK is a public key as published in the JWKS document (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACKeySet signs and verifies with a shared secret. It is meant for local
+// development; the JWKS document of such a set is empty.
+func NewHMACKeySet(secret string) *KeySet {
+	k := &signingKey{id: "hs256", method: jwt.SigningMethodHS256, secret: []byte(secret)}
+	return &KeySet{active: k, keys: map[string]*signingKey{k.id: k}}
+}
+
+// LoadKeySet reads every <kid>.pem file in dir. Files holding a private key (RSA or
+// Ed25519) can sign and verify; files holding only a public key verify tokens signed
+// by a retired key. activeKID names the key used to sign new tokens.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{keys: map[string]*signingKey{}}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", kid, err)
+		}
+		ks.keys[kid] = key
+	}
+
+	active, ok := ks.keys[activeKID]
+	if !ok {
+		return nil, fmt.Errorf("active jwt key %q not found in %s", activeKID, dir)
+	}
+	if active.private == nil {
+		return nil, fmt.Errorf("active jwt key %q has no private key", activeKID)
+	}
+	ks.active = active
+	return ks, nil
+}
+
+// AcceptLegacyHS256 keeps kid-less HS256 tokens signed with secret valid, so switching
+// to asymmetric keys does not log everyone out. Remove it once those tokens expired.
+func (ks *KeySet) AcceptLegacyHS256(secret string) {
+	ks.legacy = []byte(secret)
+}
+
+// GenerateAccessToken signs an access token with the active key
+func (ks *KeySet) GenerateAccessToken(userID int, roles, permissions []string, ttl time.Duration) (string, error) {
+	return ks.sign(newClaims(userID, roles, permissions, ttl))
+}
+
+// GenerateImpersonationToken signs a token for userID on behalf of the staff user
+// impersonatorID. The token ID doubles as the impersonation session ID.
+func (ks *KeySet) GenerateImpersonationToken(userID int, roles []string, impersonatorID int, ttl time.Duration) (token, sessionID string, err error) {
+	claims := newClaims(userID, roles, nil, ttl)
+	claims.ImpersonatorID = impersonatorID
+	token, err = ks.sign(claims)
+	return token, claims.ID, err
+}
+
+func (ks *KeySet) sign(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	if ks.active.secret != nil {
+		return token.SignedString(ks.active.secret)
+	}
+	token.Header["kid"] = ks.active.id
+	return token.SignedString(ks.active.private)
+}
+
+// ParseToken verifies a token against the key named by its kid. The alg header must
+// match the algorithm of that key, so a public key can never be used as an HMAC secret.
+func (ks *KeySet) ParseToken(tokenStr string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if ks.active.secret != nil {
+				kid = ks.active.id
+			} else if ks.legacy != nil && token.Method == jwt.SigningMethodHS256 {
+				return ks.legacy, nil
+			} else {
+				return nil, errors.New("missing kid header")
+			}
+		}
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for kid %q", token.Method.Alg(), kid)
+		}
+		if key.secret != nil {
+			return key.secret, nil
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{"RS256", "EdDSA", "HS256"}))
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
+	}
+	return nil, jwt.ErrTokenInvalidClaims
+}
+
+// JWKS returns the public keys other services need to verify access tokens
+func (ks *KeySet) JWKS() JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+				N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP", Kid: kid, Use: "sig", Alg: "EdDSA", Crv: "Ed25519",
+				X: base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
+
+func parseKey(kid string, data []byte) (*signingKey, error) {
+	if priv, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return &signingKey{id: kid, method: jwt.SigningMethodRS256, private: priv, public: &priv.PublicKey}, nil
+	}
+	if priv, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		signer := priv.(ed25519.PrivateKey)
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, private: signer, public: signer.Public()}, nil
+	}
+	if pub, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return &signingKey{id: kid, method: jwt.SigningMethodRS256, public: pub}, nil
+	}
+	if pub, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, public: pub}, nil
+	}
+	return nil, errors.New("unsupported key, expected an RSA or Ed25519 key in PEM format")
+}
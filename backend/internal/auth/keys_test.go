@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeKey(t *testing.T, dir, kid, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "2024-01", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "2024-06", "PRIVATE KEY", der)
+
+	old, err := LoadKeySet(dir, "2024-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := old.GenerateAccessToken(7, []string{"Admin"}, []string{"orders:read"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: the new key signs, the old one still verifies
+	current, err := LoadKeySet(dir, "2024-06")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := current.GenerateAccessToken(7, nil, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range []string{oldToken, newToken} {
+		claims, err := current.ParseToken(tok)
+		if err != nil {
+			t.Fatalf("ParseToken: %v", err)
+		}
+		if claims.UserID != 7 {
+			t.Errorf("user id = %d, want 7", claims.UserID)
+		}
+	}
+
+	jwks := current.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kty != "RSA" || jwks.Keys[1].Crv != "Ed25519" {
+		t.Errorf("unexpected JWKS: %+v", jwks)
+	}
+}
+
+func TestKeySetRejectsAlgorithmConfusion(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "main", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	ks, err := LoadKeySet(dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HS256 signed with the public key bytes, claiming the RSA kid
+	pubDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims(1, []string{"SuperAdmin"}, nil, time.Minute))
+	forged.Header["kid"] = "main"
+	tok, err := forged.SignedString(pubDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.ParseToken(tok); err == nil {
+		t.Fatal("HS256 token accepted for an RS256 key")
+	}
+
+	// Kid-less HS256 tokens only pass while legacy verification is on
+	legacy, _ := GenerateAccessToken("old-secret", 1, nil, nil, time.Minute)
+	if _, err := ks.ParseToken(legacy); err == nil {
+		t.Fatal("legacy token accepted without AcceptLegacyHS256")
+	}
+	ks.AcceptLegacyHS256("old-secret")
+	if _, err := ks.ParseToken(legacy); err != nil {
+		t.Fatalf("legacy token rejected: %v", err)
+	}
+}
+
+func TestImpersonationToken(t *testing.T) {
+	ks := NewHMACKeySet("secret")
+	tok, sessionID, err := ks.GenerateImpersonationToken(42, []string{"customer"}, 7, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ks.ParseToken(tok)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims.UserID != 42 || claims.ImpersonatorID != 7 {
+		t.Errorf("user id = %d, impersonator id = %d, want 42 and 7", claims.UserID, claims.ImpersonatorID)
+	}
+	if claims.ID != sessionID || len(claims.Permissions) != 0 {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	plain, _ := ks.GenerateAccessToken(42, nil, nil, time.Minute)
+	if claims, _ := ks.ParseToken(plain); claims.ImpersonatorID != 0 {
+		t.Error("regular token carries an impersonator")
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// stubIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint that
+// checks the PKCE verifier against the challenge of the issued code
+type stubIdP struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	audience  string
+	challenge string
+	nonce     string
+}
+
+func newStubIdP(t *testing.T, audience string) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdP{key: key, audience: audience}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "idp-1",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, idp.audience, idp.nonce)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *stubIdP) sign(t *testing.T, audience, nonce string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, IDTokenClaims{
+		Email:         "priya@example.com",
+		EmailVerified: true,
+		Name:          "Priya",
+		Nonce:         nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.URL,
+			Subject:   "1234567890",
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	token.Header["kid"] = "idp-1"
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	idp := newStubIdP(t, "client-1")
+	p := NewOIDCProvider(idp.URL, "client-1", "secret", "https://shop.example/login")
+
+	verifier, challenge, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge") != challenge || q.Get("code_challenge_method") != "S256" || q.Get("state") != "state-1" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	idp.challenge = q.Get("code_challenge")
+	idp.nonce = q.Get("nonce")
+
+	if _, err := p.Exchange(ctx, "good-code", "wrong-verifier"); err == nil {
+		t.Fatal("exchange with the wrong PKCE verifier should fail")
+	}
+	rawIDToken, err := p.Exchange(ctx, "good-code", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "1234567890" || claims.Email != "priya@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := p.VerifyIDToken(ctx, rawIDToken, "other-nonce"); err == nil {
+		t.Fatal("token with a different nonce should be rejected")
+	}
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, "someone-else", "nonce-1"), "nonce-1"); err == nil {
+		t.Fatal("token for another client should be rejected")
+	}
+
+	// Once keys are cached, a token signed by a key missing from the JWKS fails
+	published := idp.sign(t, "client-1", "nonce-1")
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.key = other
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, "client-1", "nonce-1"), "nonce-1"); err == nil {
+		t.Fatal("token signed with an unpublished key should be rejected")
+	}
+	if _, err := p.VerifyIDToken(ctx, published, "nonce-1"); err != nil {
+		t.Fatalf("token signed with the published key should still verify: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors for SHA1, truncated to six digits
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", tc.unix, err)
+		}
+		if got != tc.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	prev, _ := TOTPCode(secret, TOTPStep(now)-1)
+	if step, ok := ValidateTOTP(secret, prev, now); !ok || step != TOTPStep(now)-1 {
+		t.Errorf("previous step code rejected")
+	}
+	old, _ := TOTPCode(secret, TOTPStep(now)-3)
+	if _, ok := ValidateTOTP(secret, old, now); ok {
+		t.Errorf("code three steps old accepted")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Errorf("short code accepted")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Ethnic Treasures", "admin@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Ethnic%20Treasures:admin@example.com?") {
+		t.Errorf("unexpected label in %s", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Ethnic+Treasures") {
+		t.Errorf("missing parameters in %s", uri)
+	}
+}
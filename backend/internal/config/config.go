@@ -3,6 +3,11 @@ package config
 import (
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/auth"
 )
 
 type Config struct {
@@ -14,6 +19,25 @@ type Config struct {
 	UploadHMACSecret string
 	RazorpayKeyID    string
 	RazorpaySecret   string
+	// Storefront base URL used in links sent by email
+	WebBaseURL string
+	// How long a cart or unpaid checkout sits idle before each reminder email
+	CartReminderCadence []time.Duration
+	// Issuer shown in authenticator apps for TOTP 2FA
+	TOTPIssuer string
+	// Access token keys: <kid>.pem files in JWTKeysDir, JWTSigningKeyID signs new tokens
+	JWTKeysDir      string
+	JWTSigningKeyID string
+	JWTKeys         *auth.KeySet
+	// Verifies X-API-Key headers; set in main once the database is connected
+	APIKeys auth.APIKeyVerifier
+	// Checks and audits "view as customer" tokens; set in main once the database is connected
+	Impersonation auth.ImpersonationTracker
+	// OpenID Connect sign-in ("Sign in with Google"); the issuer can point at a local stand-in IdP
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
 	// SMTP Configuration
 	SMTPHost     string
 	SMTPPort     string
@@ -39,11 +63,19 @@ func Load() Config {
 		DBURL:            os.Getenv("DATABASE_URL"),
 		JWTSecret:        os.Getenv("JWT_SECRET"),
 		RefreshSecret:    os.Getenv("REFRESH_SECRET"),
+		JWTKeysDir:       os.Getenv("JWT_KEYS_DIR"),
+		JWTSigningKeyID:  os.Getenv("JWT_SIGNING_KEY_ID"),
 		MediaBucketURL:   os.Getenv("MEDIA_BUCKET_URL"),
 		UploadDir:        os.Getenv("UPLOAD_DIR"),
 		UploadHMACSecret: os.Getenv("UPLOAD_HMAC_SECRET"),
 		RazorpayKeyID:    os.Getenv("RAZORPAY_KEY_ID"),
 		RazorpaySecret:   os.Getenv("RAZORPAY_KEY_SECRET"),
+		WebBaseURL:       os.Getenv("WEB_FRONTEND_URL"),
+		TOTPIssuer:       os.Getenv("TOTP_ISSUER"),
+		OIDCIssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
 		// SMTP Configuration
 		SMTPHost:     os.Getenv("SMTP_HOST"),
 		SMTPPort:     os.Getenv("SMTP_PORT"),
@@ -68,6 +100,21 @@ func Load() Config {
 		log.Println("WARNING: DATABASE_URL is not set")
 	}
 
+	if cfg.JWTKeysDir != "" {
+		keys, err := auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTSigningKeyID)
+		if err != nil {
+			log.Fatalf("failed to load JWT keys: %v", err)
+		}
+		// Set during the switch from HS256 so tokens issued before it stay valid
+		if os.Getenv("JWT_ACCEPT_LEGACY_HS256") == "true" && cfg.JWTSecret != "" {
+			keys.AcceptLegacyHS256(cfg.JWTSecret)
+		}
+		cfg.JWTKeys = keys
+	} else {
+		log.Println("WARNING: JWT_KEYS_DIR is not set - access tokens are signed with HS256 and the JWKS is empty")
+		cfg.JWTKeys = auth.NewHMACKeySet(cfg.JWTSecret)
+	}
+
 	if cfg.UploadDir == "" {
 		cfg.UploadDir = "uploads"
 	}
@@ -75,6 +122,21 @@ func Load() Config {
 		cfg.UploadHMACSecret = "dev-upload-secret"
 	}
 
+	if cfg.WebBaseURL == "" {
+		cfg.WebBaseURL = "https://ethnictreasures.co.in"
+	}
+	cfg.CartReminderCadence = parseDurations("CART_REMINDER_CADENCE", os.Getenv("CART_REMINDER_CADENCE"),
+		[]time.Duration{time.Hour, 24 * time.Hour})
+	if cfg.TOTPIssuer == "" {
+		cfg.TOTPIssuer = "Ethnic Treasures"
+	}
+	if cfg.OIDCIssuerURL == "" {
+		cfg.OIDCIssuerURL = "https://accounts.google.com"
+	}
+	if cfg.OIDCRedirectURL == "" {
+		cfg.OIDCRedirectURL = strings.TrimRight(cfg.WebBaseURL, "/") + "/login"
+	}
+
 	// Set SMTP defaults
 	if cfg.SMTPHost == "" {
 		cfg.SMTPHost = "smtp.gmail.com"
@@ -88,3 +150,22 @@ func Load() Config {
 
 	return cfg
 }
+
+// parseDurations reads a comma-separated list such as "1h,24h", sorted ascending. An
+// empty or invalid value falls back to def.
+func parseDurations(name, value string, def []time.Duration) []time.Duration {
+	if strings.TrimSpace(value) == "" {
+		return def
+	}
+	var out []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			log.Printf("WARNING: invalid %s %q, using the default", name, value)
+			return def
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
@@ -2,8 +2,10 @@ package email
 
 import (
 	"fmt"
+	"html"
 	"net/smtp"
 	"strings"
+	"time"
 )
 
 type SMTPConfig struct {
@@ -24,10 +26,6 @@ func NewEmailService(cfg SMTPConfig) *EmailService {
 }
 
 func (e *EmailService) SendOTPEmail(toEmail, otp string) error {
-	if e.config.Email == "" || e.config.Password == "" {
-		return nil
-	}
-
 	subject := "Password Reset OTP - Ethnic treasures Admin"
 	body := fmt.Sprintf(`
 		<html>
@@ -47,26 +45,10 @@ func (e *EmailService) SendOTPEmail(toEmail, otp string) error {
 		</html>
 	`, otp)
 
-	// Create message
-	message := fmt.Sprintf("To: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s",
-		toEmail, subject, body)
-
-	// Send email using SMTP
-	addr := fmt.Sprintf("%s:%s", e.config.Host, e.config.Port)
-	auth := smtp.PlainAuth("", e.config.Email, e.config.Password, e.config.Host)
-
-	err := smtp.SendMail(addr, auth, e.config.Email, []string{toEmail}, []byte(message))
-	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
-	}
-	return nil
+	return e.send(toEmail, subject, body)
 }
 
 func (e *EmailService) SendSignupOTPEmail(toEmail, otp string) error {
-	if e.config.Email == "" || e.config.Password == "" {
-		return nil
-	}
-
 	subject := "Verify Your Email - Ethnic Treasures Signup"
 	body := fmt.Sprintf(`
 		<html>
@@ -86,26 +68,149 @@ func (e *EmailService) SendSignupOTPEmail(toEmail, otp string) error {
 		</html>
 	`, otp)
 
-	// Create message
-	message := fmt.Sprintf("To: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s",
-		toEmail, subject, body)
+	return e.send(toEmail, subject, body)
+}
 
-	// Send email using SMTP
-	addr := fmt.Sprintf("%s:%s", e.config.Host, e.config.Port)
-	auth := smtp.PlainAuth("", e.config.Email, e.config.Password, e.config.Host)
+func (e *EmailService) SendLoginCodeEmail(toEmail, code, link string) error {
+	subject := "Your Ethnic Treasures sign-in code"
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Sign in to Ethnic Treasures</h2>
+			<p>Hello,</p>
+			<p>Use this code to sign in to your Ethnic Treasures account:</p>
+			<div style="background-color: #f0f0f0; padding: 20px; text-align: center; margin: 20px 0;">
+				<h1 style="color: #333; font-size: 32px; letter-spacing: 5px;">%s</h1>
+			</div>
+			<p>Or sign in with one click:</p>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #800020; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; font-weight: bold;">Sign In</a>
+			</div>
+			<p>The code and link expire in 10 minutes and can be used only once.</p>
+			<p>If you didn't try to sign in, you can safely ignore this email.</p>
+			<br>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+		</body>
+		</html>
+	`, code, link)
 
-	err := smtp.SendMail(addr, auth, e.config.Email, []string{toEmail}, []byte(message))
-	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
-	}
-	return nil
+	return e.send(toEmail, subject, body)
 }
 
-func (e *EmailService) SendStockNotificationEmail(toEmail, productSlug, productTitle string, productImage *string, minPriceCents int) error {
-	if e.config.Email == "" || e.config.Password == "" {
-		return nil
-	}
+// SendTwoFactorSetupCodeEmail sends the code that unlocks 2FA enrollment during sign-in
+func (e *EmailService) SendTwoFactorSetupCodeEmail(toEmail, code string) error {
+	subject := "Set up two-factor authentication"
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Set up two-factor authentication</h2>
+			<p>Hello,</p>
+			<p>Your account needs two-factor authentication. Enter this code to continue setting it up:</p>
+			<div style="background-color: #f0f0f0; padding: 20px; text-align: center; margin: 20px 0;">
+				<h1 style="color: #333; font-size: 32px; letter-spacing: 5px;">%s</h1>
+			</div>
+			<p>The code expires in 5 minutes.</p>
+			<p>If you didn't try to sign in, someone may know your password. Please change it.</p>
+			<br>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+		</body>
+		</html>
+	`, code)
+
+	return e.send(toEmail, subject, body)
+}
+
+func (e *EmailService) SendAccountLockedEmail(toEmail string, lockedUntil time.Time, ipAddress string) error {
+	subject := "Your Ethnic Treasures account has been temporarily locked"
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Account Temporarily Locked</h2>
+			<p>Hello,</p>
+			<p>We noticed several failed sign-in attempts on your Ethnic Treasures account, the most recent from IP address <strong>%s</strong>.</p>
+			<p>To protect your account, sign-in has been locked until <strong>%s</strong>.</p>
+			<p>If this was you, you can sign in again after that time or reset your password. If it wasn't, we recommend resetting your password as soon as the lock expires.</p>
+			<br>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+		</body>
+		</html>
+	`, ipAddress, lockedUntil.UTC().Format("02 Jan 2006 15:04 MST"))
+
+	return e.send(toEmail, subject, body)
+}
+
+func (e *EmailService) SendEmailVerificationEmail(toEmail, code, link string) error {
+	subject := "Verify your Ethnic Treasures email address"
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Verify Your Email Address</h2>
+			<p>Hello,</p>
+			<p>Please confirm that this is your email address. Enter this code on the Ethnic Treasures website:</p>
+			<div style="background-color: #f0f0f0; padding: 20px; text-align: center; margin: 20px 0;">
+				<h1 style="color: #333; font-size: 32px; letter-spacing: 5px;">%s</h1>
+			</div>
+			<p>Or verify with one click:</p>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #800020; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; font-weight: bold;">Verify Email</a>
+			</div>
+			<p>The code and link expire in 24 hours.</p>
+			<p>If you didn't create an account, you can safely ignore this email.</p>
+			<br>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+		</body>
+		</html>
+	`, code, link)
+
+	return e.send(toEmail, subject, body)
+}
+
+func (e *EmailService) SendEmailChangeConfirmationEmail(toEmail, code, link string) error {
+	subject := "Confirm your new Ethnic Treasures email address"
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Confirm Your New Email Address</h2>
+			<p>Hello,</p>
+			<p>You asked to use this address for your Ethnic Treasures account. Enter this code to confirm the change:</p>
+			<div style="background-color: #f0f0f0; padding: 20px; text-align: center; margin: 20px 0;">
+				<h1 style="color: #333; font-size: 32px; letter-spacing: 5px;">%s</h1>
+			</div>
+			<p>Or confirm with one click:</p>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #800020; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; font-weight: bold;">Confirm Email Change</a>
+			</div>
+			<p>The code and link expire in 1 hour. Your email address will not change until you confirm.</p>
+			<p>If you didn't request this, you can safely ignore this email.</p>
+			<br>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+		</body>
+		</html>
+	`, code, link)
+
+	return e.send(toEmail, subject, body)
+}
+
+// SendEmailChangedAlertEmail tells the previous address that the account email was changed
+func (e *EmailService) SendEmailChangedAlertEmail(oldEmail, newEmail string) error {
+	subject := "Your Ethnic Treasures email address was changed"
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Email Address Changed</h2>
+			<p>Hello,</p>
+			<p>The email address on your Ethnic Treasures account was changed to <strong>%s</strong>. This address will no longer receive sign-in codes or order updates.</p>
+			<p>If you made this change, no action is needed. If you didn't, please contact us immediately by replying to this email.</p>
+			<br>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+		</body>
+		</html>
+	`, newEmail)
+
+	return e.send(oldEmail, subject, body)
+}
 
+func (e *EmailService) SendStockNotificationEmail(toEmail, productSlug, productTitle string, productImage *string, minPriceCents int) error {
 	subject := "Good News! Product is Back in Stock - Ethnic Treasures"
 	productURL := fmt.Sprintf("http://localhost:4321/product/%s", productSlug)
 
@@ -153,17 +258,196 @@ func (e *EmailService) SendStockNotificationEmail(toEmail, productSlug, productT
 		</html>
 	`, imageHTML, productTitle, priceRupees, productURL)
 
-	// Create message
-	message := fmt.Sprintf("To: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s",
-		toEmail, subject, body)
+	return e.send(toEmail, subject, body)
+}
+
+// SendStockNotificationConfirmEmail asks the address to confirm a back-in-stock sign-up
+// made for it
+func (e *EmailService) SendStockNotificationConfirmEmail(toEmail, productTitle, link string) error {
+	subject := "Confirm your back-in-stock alert - Ethnic Treasures"
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #800020;">Confirm your alert</h2>
+			<p>Hello,</p>
+			<p>Someone asked us to email this address when <strong>%s</strong> is back in stock at Ethnic Treasures.</p>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #800020; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; font-weight: bold;">
+					Yes, notify me
+				</a>
+			</div>
+			<p>If this wasn't you, ignore this email and you won't hear from us about it.</p>
+			<br>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+		</body>
+		</html>
+	`, productTitle, link)
+
+	return e.send(toEmail, subject, body)
+}
+
+// CartReminderItem is one line shown in a cart reminder
+type CartReminderItem struct {
+	Title    string
+	Quantity int
+	Price    float64
+	ImageURL string
+}
+
+// CartReminder is the content of an abandoned cart or unpaid checkout reminder
+type CartReminder struct {
+	Items          []CartReminderItem
+	Total          float64
+	CheckoutURL    string
+	UnsubscribeURL string
+	// PendingPayment is set when the customer reached the payment step but did not pay
+	PendingPayment bool
+}
+
+func (e *EmailService) SendCartReminderEmail(toEmail string, r CartReminder) error {
+	subject := "You left something in your cart - Ethnic Treasures"
+	heading := "Your treasures are waiting"
+	intro := "You left these handcrafted pieces in your cart. Many of them are one of a kind, so we can't hold them for long."
+	button := "Return to Your Cart"
+	if r.PendingPayment {
+		subject = "Complete your order - Ethnic Treasures"
+		heading = "Your order is almost complete"
+		intro = "It looks like your payment didn't go through. Your items are still in your cart, ready when you are."
+		button = "Complete Payment"
+	}
+
+	var rows strings.Builder
+	for _, item := range r.Items {
+		image := ""
+		if item.ImageURL != "" {
+			image = fmt.Sprintf(`<img src="%s" alt="" style="width: 64px; height: 64px; object-fit: cover; border-radius: 4px;">`,
+				html.EscapeString(absoluteImageURL(item.ImageURL)))
+		}
+		fmt.Fprintf(&rows, `
+				<tr>
+					<td style="padding: 8px 0; width: 72px;">%s</td>
+					<td style="padding: 8px;">%s<br><span style="color: #666;">Qty %d</span></td>
+					<td style="padding: 8px 0; text-align: right; white-space: nowrap;">₹%.2f</td>
+				</tr>`, image, html.EscapeString(item.Title), item.Quantity, item.Price*float64(item.Quantity))
+	}
+
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #800020;">%s</h2>
+			<p>Hello,</p>
+			<p>%s</p>
+			<table style="width: 100%%; border-collapse: collapse; margin: 20px 0;">%s
+				<tr>
+					<td></td>
+					<td style="padding: 8px; border-top: 1px solid #eee; font-weight: bold;">Total</td>
+					<td style="padding: 8px 0; border-top: 1px solid #eee; text-align: right; font-weight: bold;">₹%.2f</td>
+				</tr>
+			</table>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #800020; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; font-weight: bold;">%s</a>
+			</div>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+			<p style="color: #999; font-size: 12px;">Don't want these reminders? <a href="%s" style="color: #999;">Unsubscribe</a>.</p>
+		</body>
+		</html>
+	`, heading, intro, rows.String(), r.Total, r.CheckoutURL, button, r.UnsubscribeURL)
+
+	return e.send(toEmail, subject, body, "List-Unsubscribe: <"+r.UnsubscribeURL+">")
+}
+
+// WishlistDigestItem is one wishlisted product whose price dropped or that is back in stock
+type WishlistDigestItem struct {
+	Title      string
+	ImageURL   string
+	ProductURL string
+	Price      float64
+	// OldPrice is set for price drops
+	OldPrice    float64
+	BackInStock bool
+}
+
+// WishlistDigest is the daily summary of changes to a customer's wishlist
+type WishlistDigest struct {
+	Items          []WishlistDigestItem
+	WishlistURL    string
+	UnsubscribeURL string
+}
+
+func (e *EmailService) SendWishlistDigestEmail(toEmail string, d WishlistDigest) error {
+	subject := "Good news about your wishlist - Ethnic Treasures"
+
+	var rows strings.Builder
+	for _, item := range d.Items {
+		image := ""
+		if item.ImageURL != "" {
+			image = fmt.Sprintf(`<img src="%s" alt="" style="width: 64px; height: 64px; object-fit: cover; border-radius: 4px;">`,
+				html.EscapeString(absoluteImageURL(item.ImageURL)))
+		}
+		note := "Price dropped"
+		if item.BackInStock {
+			note = "Back in stock"
+		}
+		price := fmt.Sprintf("₹%.2f", item.Price)
+		if item.OldPrice > item.Price {
+			price = fmt.Sprintf(`<span style="color: #999; text-decoration: line-through;">₹%.2f</span> ₹%.2f`, item.OldPrice, item.Price)
+		}
+		fmt.Fprintf(&rows, `
+				<tr>
+					<td style="padding: 8px 0; width: 72px;">%s</td>
+					<td style="padding: 8px;"><a href="%s" style="color: #333;">%s</a><br><span style="color: #800020;">%s</span></td>
+					<td style="padding: 8px 0; text-align: right; white-space: nowrap;">%s</td>
+				</tr>`, image, html.EscapeString(item.ProductURL), html.EscapeString(item.Title), note, price)
+	}
+
+	body := fmt.Sprintf(`
+		<html>
+		<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #800020;">Something on your wishlist just changed</h2>
+			<p>Hello,</p>
+			<p>A few of the handcrafted pieces you saved are now easier to take home:</p>
+			<table style="width: 100%%; border-collapse: collapse; margin: 20px 0;">%s
+			</table>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #800020; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; font-weight: bold;">View Your Wishlist</a>
+			</div>
+			<p>Best regards,<br>Ethnic Treasures Team</p>
+			<p style="color: #999; font-size: 12px;">Don't want wishlist alerts? <a href="%s" style="color: #999;">Unsubscribe</a>.</p>
+		</body>
+		</html>
+	`, rows.String(), d.WishlistURL, d.UnsubscribeURL)
+
+	return e.send(toEmail, subject, body, "List-Unsubscribe: <"+d.UnsubscribeURL+">")
+}
+
+// absoluteImageURL turns a stored media path into a URL an email client can load
+func absoluteImageURL(path string) string {
+	if strings.HasPrefix(path, "product/") {
+		return fmt.Sprintf("https://etreasure-1.onrender.com/%s", path)
+	} else if strings.HasPrefix(path, "/uploads/") {
+		return fmt.Sprintf("https://etreasure-1.onrender.com%s", path)
+	}
+	return path
+}
+
+// send delivers an HTML email, with any extra header lines, and does nothing when SMTP
+// is not configured
+func (e *EmailService) send(to, subject, body string, headers ...string) error {
+	if e.config.Email == "" || e.config.Password == "" {
+		return nil
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "To: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n", to, subject)
+	for _, h := range headers {
+		message.WriteString(h + "\r\n")
+	}
+	message.WriteString("\r\n" + body)
 
-	// Send email using SMTP
 	addr := fmt.Sprintf("%s:%s", e.config.Host, e.config.Port)
 	auth := smtp.PlainAuth("", e.config.Email, e.config.Password, e.config.Host)
-
-	err := smtp.SendMail(addr, auth, e.config.Email, []string{toEmail}, []byte(message))
-	if err != nil {
-		return fmt.Errorf("failed to send stock notification email: %w", err)
+	if err := smtp.SendMail(addr, auth, e.config.Email, []string{to}, []byte(message.String())); err != nil {
+		return fmt.Errorf("failed to send email %q: %w", subject, err)
 	}
 	return nil
 }
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// APIKeysHandler manages integration keys and verifies them for middleware.AuthRequired
+type APIKeysHandler struct {
+	DB *pgxpool.Pool
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP *string    `json:"last_used_ip,omitempty"`
+	CreatedBy  *int       `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" binding:"required"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+type UpdateAPIKeyRequest struct {
+	Name       *string    `json:"name,omitempty"`
+	Scopes     *[]string  `json:"scopes,omitempty"`
+	AllowedIPs *[]string  `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+const apiKeyColumns = `id, name, key_prefix, scopes, allowed_ips, expires_at, last_used_at, last_used_ip, created_by, created_at, revoked_at`
+
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.Name, &k.KeyPrefix, &k.Scopes, &k.AllowedIPs, &k.ExpiresAt,
+		&k.LastUsedAt, &k.LastUsedIP, &k.CreatedBy, &k.CreatedAt, &k.RevokedAt)
+	return k, err
+}
+
+// ListAPIKeys - GET /api/admin/api-keys
+func (h *APIKeysHandler) ListAPIKeys(c *gin.Context) {
+	rows, err := h.DB.Query(c, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY revoked_at IS NOT NULL, created_at DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list api keys"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list api keys"})
+			return
+		}
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// CreateAPIKey - POST /api/admin/api-keys
+// The key is only ever returned in this response.
+func (h *APIKeysHandler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scopes, ok := h.validateScopes(c, req.Scopes)
+	if !ok {
+		return
+	}
+	allowedIPs, ok := validateAllowedIPs(c, req.AllowedIPs)
+	if !ok {
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	rawKey, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate api key"})
+		return
+	}
+	var createdBy *int
+	if userID, ok := contextUserID(c); ok {
+		createdBy = &userID
+	}
+
+	k, err := scanAPIKey(h.DB.QueryRow(c, `
+		INSERT INTO api_keys (name, key_prefix, key_hash, scopes, allowed_ips, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+apiKeyColumns,
+		strings.TrimSpace(req.Name), prefix, auth.HashAPIKey(rawKey), scopes, allowedIPs, req.ExpiresAt, createdBy))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create api key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"api_key": k, "key": rawKey})
+}
+
+// UpdateAPIKey - PATCH /api/admin/api-keys/:id
+func (h *APIKeysHandler) UpdateAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid api key id"})
+		return
+	}
+	var req UpdateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sets := []string{}
+	args := []any{}
+	argIdx := 1
+
+	if req.Name != nil {
+		sets = append(sets, "name = $"+strconv.Itoa(argIdx))
+		args = append(args, strings.TrimSpace(*req.Name))
+		argIdx++
+	}
+	if req.Scopes != nil {
+		scopes, ok := h.validateScopes(c, *req.Scopes)
+		if !ok {
+			return
+		}
+		if len(scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one scope is required"})
+			return
+		}
+		sets = append(sets, "scopes = $"+strconv.Itoa(argIdx))
+		args = append(args, scopes)
+		argIdx++
+	}
+	if req.AllowedIPs != nil {
+		allowedIPs, ok := validateAllowedIPs(c, *req.AllowedIPs)
+		if !ok {
+			return
+		}
+		sets = append(sets, "allowed_ips = $"+strconv.Itoa(argIdx))
+		args = append(args, allowedIPs)
+		argIdx++
+	}
+	if req.ExpiresAt != nil {
+		sets = append(sets, "expires_at = $"+strconv.Itoa(argIdx))
+		args = append(args, *req.ExpiresAt)
+		argIdx++
+	}
+	if len(sets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no fields to update"})
+		return
+	}
+
+	query := "UPDATE api_keys SET " + joinString(sets, ", ") + " WHERE id = $" + strconv.Itoa(argIdx) + " AND revoked_at IS NULL RETURNING " + apiKeyColumns
+	args = append(args, id)
+	k, err := scanAPIKey(h.DB.QueryRow(c, query, args...))
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found or revoked"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update api key"})
+		return
+	}
+	c.JSON(http.StatusOK, k)
+}
+
+// RevokeAPIKey - DELETE /api/admin/api-keys/:id
+// Revoked keys stay listed so their usage history is kept.
+func (h *APIKeysHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid api key id"})
+		return
+	}
+	tag, err := h.DB.Exec(c, `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke api key"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found or already revoked"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// VerifyAPIKey implements auth.APIKeyVerifier
+func (h *APIKeysHandler) VerifyAPIKey(ctx context.Context, rawKey, clientIP string) (*auth.APIKey, error) {
+	if !strings.HasPrefix(rawKey, auth.APIKeyPrefix) {
+		return nil, auth.ErrAPIKeyInvalid
+	}
+
+	var (
+		key        auth.APIKey
+		allowedIPs []string
+	)
+	err := h.DB.QueryRow(ctx, `
+		SELECT id, name, scopes, allowed_ips FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, auth.HashAPIKey(rawKey)).Scan(&key.ID, &key.Name, &key.Scopes, &allowedIPs)
+	if err == pgx.ErrNoRows {
+		return nil, auth.ErrAPIKeyInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	if !auth.IPAllowed(allowedIPs, clientIP) {
+		log.Printf("API key %d (%s) used from disallowed address %s", key.ID, key.Name, clientIP)
+		return nil, auth.ErrAPIKeyIPNotAllowed
+	}
+
+	// Usage is recorded at most once a minute per key to keep busy integrations from writing on every call
+	_, err = h.DB.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip IS DISTINCT FROM $2)
+	`, key.ID, clientIP)
+	if err != nil {
+		log.Printf("API key %d: failed to record usage: %v", key.ID, err)
+	}
+	return &key, nil
+}
+
+// validateScopes checks scopes are known permissions the caller holds. Keys cannot
+// manage keys, so a leaked key cannot mint new ones.
+func (h *APIKeysHandler) validateScopes(c *gin.Context, requested []string) ([]string, bool) {
+	scopes := uniqueStrings(requested)
+
+	held := map[string]bool{}
+	if val, ok := c.Get("permissions"); ok {
+		perms, _ := val.([]string)
+		for _, p := range perms {
+			held[p] = true
+		}
+	}
+
+	var known []string
+	err := h.DB.QueryRow(c, `SELECT COALESCE(array_agg(name), '{}') FROM permissions WHERE name = ANY($1)`, scopes).Scan(&known)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate scopes"})
+		return nil, false
+	}
+	knownSet := map[string]bool{}
+	for _, k := range known {
+		knownSet[k] = true
+	}
+
+	for _, s := range scopes {
+		switch {
+		case !knownSet[s]:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope", "scope": s})
+			return nil, false
+		case strings.HasPrefix(s, "api_keys:"):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "api keys cannot be granted api key management", "scope": s})
+			return nil, false
+		case !held[s]:
+			c.JSON(http.StatusForbidden, gin.H{"error": "cannot grant a scope you do not have", "scope": s})
+			return nil, false
+		}
+	}
+	return scopes, true
+}
+
+func validateAllowedIPs(c *gin.Context, entries []string) ([]string, bool) {
+	allowed := []string{}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !auth.ValidIPAllowEntry(e) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid IP address or CIDR range", "value": e})
+			return nil, false
+		}
+		allowed = append(allowed, e)
+	}
+	return allowed, true
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -17,12 +19,15 @@ type AuditHandler struct {
 }
 
 type AuditEntry struct {
-	ID          int64     `json:"id"`
-	ActorUserID *int      `json:"actor_user_id"`
-	Action      string    `json:"action"`
-	ObjectType  string    `json:"object_type"`
-	ObjectID    string    `json:"object_id"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                 int64           `json:"id"`
+	ActorUserID        *int            `json:"actor_user_id"`
+	ImpersonatedUserID *int            `json:"impersonated_user_id,omitempty"`
+	Action             string          `json:"action"`
+	ObjectType         string          `json:"object_type"`
+	ObjectID           string          `json:"object_id"`
+	Details            json.RawMessage `json:"details"`
+	IPAddress          *string         `json:"ip_address,omitempty"`
+	CreatedAt          time.Time       `json:"created_at"`
 }
 
 // GET /api/admin/audit?object_type=&object_id=&limit=50
@@ -43,11 +48,11 @@ func (h *AuditHandler) List(c *gin.Context) {
 	)
 
 	if objType != "" && objID != "" {
-		rows, err = h.DB.Query(ctx, `SELECT id, actor_user_id, action, object_type, object_id, created_at 
+		rows, err = h.DB.Query(ctx, `SELECT id, actor_user_id, impersonated_user_id, action, object_type, object_id, details, ip_address, created_at
             FROM audit_logs WHERE object_type=$1 AND object_id=$2 
             ORDER BY created_at DESC LIMIT $3`, objType, objID, limit)
 	} else {
-		rows, err = h.DB.Query(ctx, `SELECT id, actor_user_id, action, object_type, object_id, created_at 
+		rows, err = h.DB.Query(ctx, `SELECT id, actor_user_id, impersonated_user_id, action, object_type, object_id, details, ip_address, created_at
             FROM audit_logs ORDER BY created_at DESC LIMIT $1`, limit)
 	}
 	if err != nil {
@@ -59,20 +64,53 @@ func (h *AuditHandler) List(c *gin.Context) {
 	var items []AuditEntry
 	for rows.Next() {
 		var (
-			id                 int64
-			actor              sql.NullInt32
-			action, otype, oid string
-			created            time.Time
+			id                  int64
+			actor, impersonated sql.NullInt32
+			action, otype, oid  string
+			details             []byte
+			ip                  *string
+			created             time.Time
 		)
-		if err := rows.Scan(&id, &actor, &action, &otype, &oid, &created); err == nil {
-			var actorPtr *int
-			if actor.Valid {
-				v := int(actor.Int32)
-				actorPtr = &v
-			}
-			items = append(items, AuditEntry{ID: id, ActorUserID: actorPtr, Action: action, ObjectType: otype, ObjectID: oid, CreatedAt: created})
+		if err := rows.Scan(&id, &actor, &impersonated, &action, &otype, &oid, &details, &ip, &created); err == nil {
+			items = append(items, AuditEntry{ID: id, ActorUserID: nullIntPtr(actor), ImpersonatedUserID: nullIntPtr(impersonated),
+				Action: action, ObjectType: otype, ObjectID: oid, Details: details, IPAddress: ip, CreatedAt: created})
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"items": items})
 }
+
+func nullIntPtr(v sql.NullInt32) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int32)
+	return &n
+}
+
+// auditRecord is one row of audit_logs; zero IDs are stored as NULL
+type auditRecord struct {
+	ActorUserID        int
+	ImpersonatedUserID int
+	Action             string
+	ObjectType         string
+	ObjectID           string
+	Details            gin.H
+	IPAddress          string
+}
+
+// recordAudit writes an audit row. Failures are logged rather than failing the request
+// that is being audited.
+func recordAudit(ctx context.Context, db *pgxpool.Pool, r auditRecord) {
+	details := r.Details
+	if details == nil {
+		details = gin.H{}
+	}
+	_, err := db.Exec(ctx, `
+		INSERT INTO audit_logs (actor_user_id, impersonated_user_id, action, object_type, object_id, details, ip_address)
+		VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6, NULLIF($7, ''))
+	`, r.ActorUserID, r.ImpersonatedUserID, r.Action, r.ObjectType, r.ObjectID, details, r.IPAddress)
+	if err != nil {
+		log.Printf("audit: failed to record %s %s/%s: %v", r.Action, r.ObjectType, r.ObjectID, err)
+	}
+}
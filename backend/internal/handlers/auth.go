@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -14,17 +15,21 @@ import (
 	"github.com/etreasure/backend/internal/auth"
 	"github.com/etreasure/backend/internal/config"
 	"github.com/etreasure/backend/internal/email"
+	"github.com/etreasure/backend/internal/kv"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
 	DB    *pgxpool.Pool
 	Cfg   config.Config
-	Rd    *redis.Client
+	KV    kv.Store
 	Email *email.EmailService
+	// OIDC is nil when "Sign in with Google" is not configured
+	OIDC *auth.OIDCProvider
 }
 
 type loginRequest struct {
@@ -69,14 +74,20 @@ type tokenResponse struct {
 	AccessToken  string        `json:"accessToken"`
 	RefreshToken string        `json:"refreshToken"`
 	User         authUserModel `json:"user"`
+	// CartMerge is set when a guest cart was folded into the account on this sign-in
+	CartMerge *cartMergeResult `json:"cartMerge,omitempty"`
 }
 
 type authUserModel struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	Roles     []string  `json:"roles"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            int       `json:"id"`
+	Email         string    `json:"email"`
+	Name          string    `json:"name"`
+	Roles         []string  `json:"roles"`
+	Permissions   []string  `json:"permissions,omitempty"`
+	EmailVerified bool      `json:"emailVerified"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Set when support staff are viewing the storefront as this customer
+	ImpersonatorID int `json:"impersonatorId,omitempty"`
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -88,81 +99,110 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	// Basic rate limiting: 5 attempts per minute per IP
 	ip := c.ClientIP()
-	if h.Rd != nil {
+	if h.KV != nil {
 		ctx := context.Background()
 		key := fmt.Sprintf("login:ip:%s", ip)
-		count, err := h.Rd.Incr(ctx, key).Result()
-		if err == nil {
-			if count == 1 {
-				_ = h.Rd.Expire(ctx, key, time.Minute).Err()
-			}
-			if count > 5 {
-				c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many login attempts, please try again later"})
-				return
-			}
+		count, err := h.KV.Incr(ctx, key, time.Minute)
+		if err == nil && count > 5 {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many login attempts, please try again later"})
+			return
 		}
 	}
 
 	ctx := context.Background()
+	if blocked, err := h.loginBlockedByAnomaly(ctx, ip); err != nil {
+		log.Printf("Login: failed to check attempt rate for %s: %v", ip, err)
+	} else if blocked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many login attempts, please try again later"})
+		return
+	}
+
 	var (
 		id           int
 		email        string
-		fullName     sql.NullString
 		passwordHash string
+		failures     int
+		lastFailedAt *time.Time
+		lockedUntil  *time.Time
 	)
-	err := h.DB.QueryRow(ctx, `SELECT id, email, full_name, password_hash FROM users WHERE email = $1 AND is_active = TRUE`, req.Email).
-		Scan(&id, &email, &fullName, &passwordHash)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
-		return
+	err := h.DB.QueryRow(ctx, `
+		SELECT id, email, password_hash, failed_login_count, last_failed_login_at, locked_until
+		FROM users WHERE email = $1 AND is_active = TRUE
+	`, req.Email).Scan(&id, &email, &passwordHash, &failures, &lastFailedAt, &lockedUntil)
+	// An address without an account is delayed and locked by its own counters, so the
+	// responses below cannot tell anyone which addresses have accounts
+	known := err == nil
+	var userID *int
+	if known {
+		userID = &id
+	} else {
+		email = strings.ToLower(strings.TrimSpace(req.Email))
+		failures, lastFailedAt, lockedUntil, err = h.unknownLoginFailures(ctx, email)
+		if err != nil {
+			log.Printf("Login: failed to load failure counter for %s: %v", email, err)
+		}
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	now := time.Now()
+	if lockedUntil != nil && now.Before(*lockedUntil) {
+		h.recordLoginAttempt(ctx, email, userID, ip, false)
+		c.Header("Retry-After", retryAfterSeconds(lockedUntil.Sub(now)))
+		c.JSON(http.StatusLocked, gin.H{"error": "account temporarily locked after too many failed attempts", "lockedUntil": lockedUntil})
 		return
 	}
+	if lastFailedAt != nil {
+		if wait := lastFailedAt.Add(loginDelay(failures)).Sub(now); wait > 0 {
+			c.Header("Retry-After", retryAfterSeconds(wait))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts, please wait before trying again"})
+			return
+		}
+	}
 
-	rows, err := h.DB.Query(ctx, `SELECT r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = $1`, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load roles"})
-		return
+	// An unknown address is checked against a dummy hash of the same cost, so it takes
+	// as long to refuse as a wrong password
+	hash := dummyPasswordHash
+	if known {
+		hash = passwordHash
 	}
-	defer rows.Close()
-	var roles []string
-	for rows.Next() {
-		var r string
-		if err := rows.Scan(&r); err == nil {
-			roles = append(roles, r)
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil || !known {
+		h.recordLoginAttempt(ctx, email, userID, ip, false)
+		var lockedUntil *time.Time
+		if known {
+			lockedUntil, err = h.recordLoginFailure(ctx, id, email, ip)
+		} else {
+			lockedUntil, err = h.recordUnknownLoginFailure(ctx, email)
+		}
+		if err != nil {
+			log.Printf("Login: failed to record failure for %s: %v", email, err)
 		}
+		if lockedUntil != nil {
+			c.Header("Retry-After", retryAfterSeconds(time.Until(*lockedUntil)))
+			c.JSON(http.StatusLocked, gin.H{"error": "account temporarily locked after too many failed attempts", "lockedUntil": lockedUntil})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
 	}
 
-	access, err := auth.GenerateAccessToken(h.Cfg.JWTSecret, id, roles, 15*time.Minute)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+	h.recordLoginAttempt(ctx, email, &id, ip, true)
+
+	// Admins with 2FA get a challenge instead of tokens. The failure counter stays until
+	// the second factor passes, so wrong codes keep counting towards the lock.
+	if challenge, err := h.startTwoFactorChallenge(ctx, id, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor challenge"})
+		return
+	} else if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
 		return
 	}
-	refreshTTL := 30 * 24 * time.Hour
-	refresh, err := auth.GenerateAccessToken(h.Cfg.RefreshSecret, id, roles, refreshTTL)
+	h.resetLoginFailures(ctx, id)
+
+	resp, err := h.issueTokens(ctx, c, id, false, 30*24*time.Hour)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
 		return
 	}
-
-	// Persist hashed refresh token for potential revocation/rotation
-	if err := h.storeRefreshToken(ctx, id, refresh, false, ip, c.GetHeader("User-Agent"), time.Now().Add(refreshTTL)); err != nil {
-		// Log but do not fail login if persistence issues occur
-	}
-
-	c.JSON(http.StatusOK, tokenResponse{
-		AccessToken:  access,
-		RefreshToken: refresh,
-		User: authUserModel{
-			ID:    id,
-			Email: email,
-			Name:  fullName.String,
-			Roles: roles,
-		},
-	})
+	c.JSON(http.StatusOK, resp)
 }
 
 // Signup registers a new user and returns tokens
@@ -206,58 +246,120 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 	}
 
 	// Default role: customer (if role exists)
-	var roles []string
-	if _, err := h.DB.Exec(ctx, `
+	_, _ = h.DB.Exec(ctx, `
 		INSERT INTO user_roles (user_id, role_id)
 		SELECT $1, id FROM roles WHERE name = 'customer'
 		ON CONFLICT DO NOTHING
-	`, userID); err == nil {
-		roles = append(roles, "customer")
+	`, userID)
+
+	// The account works right away but stays unverified until the emailed code or link is used
+	if err := h.sendEmailVerification(ctx, userID, req.Email); err != nil {
+		log.Printf("Signup: failed to send verification email to %s: %v", req.Email, err)
 	}
 
 	// Issue tokens
-	access, err := auth.GenerateAccessToken(h.Cfg.JWTSecret, userID, roles, 15*time.Minute)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
-		return
-	}
 	refreshTTL := 30 * 24 * time.Hour
 	if !req.RememberMe {
 		refreshTTL = 7 * 24 * time.Hour
 	}
-	refresh, err := auth.GenerateAccessToken(h.Cfg.RefreshSecret, userID, roles, refreshTTL)
+	resp, err := h.issueTokens(ctx, c, userID, req.RememberMe, refreshTTL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
 		return
 	}
+	c.JSON(http.StatusCreated, resp)
+}
 
-	ip := c.ClientIP()
-	if err := h.storeRefreshToken(ctx, userID, refresh, req.RememberMe, ip, c.GetHeader("User-Agent"), time.Now().Add(refreshTTL)); err != nil {
+// issueTokens signs an access/refresh pair for the user, persists the refresh token
+// and builds the response shared by every sign-in flow
+func (h *AuthHandler) issueTokens(ctx context.Context, c *gin.Context, userID int, rememberMe bool, refreshTTL time.Duration) (tokenResponse, error) {
+	var (
+		email           string
+		fullName        sql.NullString
+		createdAt       time.Time
+		emailVerifiedAt *time.Time
+	)
+	err := h.DB.QueryRow(ctx, `SELECT email, full_name, created_at, email_verified_at FROM users WHERE id = $1`, userID).
+		Scan(&email, &fullName, &createdAt, &emailVerifiedAt)
+	if err != nil {
+		return tokenResponse{}, err
 	}
 
-	c.JSON(http.StatusCreated, tokenResponse{
+	roles, permissions, err := loadAuthorization(ctx, h.DB, userID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	access, err := h.Cfg.JWTKeys.GenerateAccessToken(userID, roles, permissions, 15*time.Minute)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refresh, err := auth.GenerateAccessToken(h.Cfg.RefreshSecret, userID, roles, nil, refreshTTL)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	// Persist hashed refresh token for revocation/rotation; log but do not fail sign-in
+	if err := h.storeRefreshToken(ctx, userID, refresh, rememberMe, c.ClientIP(), c.GetHeader("User-Agent"), time.Now().Add(refreshTTL)); err != nil {
+		log.Printf("issueTokens: failed to store refresh token for user %d: %v", userID, err)
+	}
+
+	// Fold the guest cart of this browser into the account; a failed merge leaves the
+	// guest cart in place and must not block sign-in
+	var cartMerge *cartMergeResult
+	if sessionID, err := c.Cookie("session_id"); err == nil && sessionID != "" {
+		cartMerge, err = mergeGuestCart(ctx, h.DB, sessionID, userID)
+		if err != nil {
+			log.Printf("issueTokens: failed to merge guest cart for user %d: %v", userID, err)
+		}
+		if _, err := mergeGuestWishlist(ctx, h.DB, sessionID, userID); err != nil {
+			log.Printf("issueTokens: failed to merge guest wishlist for user %d: %v", userID, err)
+		}
+	}
+
+	return tokenResponse{
 		AccessToken:  access,
 		RefreshToken: refresh,
+		CartMerge:    cartMerge,
 		User: authUserModel{
-			ID:    userID,
-			Email: req.Email,
-			Name:  req.FullName,
-			Roles: roles,
+			ID:            userID,
+			Email:         email,
+			Name:          fullName.String,
+			Roles:         roles,
+			Permissions:   permissions,
+			EmailVerified: emailVerifiedAt != nil,
+			CreatedAt:     createdAt,
 		},
-	})
+	}, nil
 }
 
 // storeRefreshToken hashes a refresh token and stores it in the refresh_tokens table
+// as the first token of a new rotation family
 func (h *AuthHandler) storeRefreshToken(ctx context.Context, userID int, token string, rememberMe bool, ip, userAgent string, expiresAt time.Time) error {
+	_, err := h.DB.Exec(ctx, `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, user_agent, ip_address, remember_me, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, userID, hashToken(token), uuid.New(), userAgent, ip, rememberMe, expiresAt)
+	return err
+}
+
+// hashToken returns the hex encoded SHA-256 of a token, as stored in the database
+func hashToken(token string) string {
 	hashBytes := sha256.Sum256([]byte(token))
-	th := hex.EncodeToString(hashBytes[:])
+	return hex.EncodeToString(hashBytes[:])
+}
+
+// revokeRefreshFamily revokes every still-active token that descends from the same login
+func (h *AuthHandler) revokeRefreshFamily(ctx context.Context, familyID uuid.UUID) error {
 	_, err := h.DB.Exec(ctx, `
-		INSERT INTO refresh_tokens (user_id, token_hash, user_agent, ip_address, remember_me, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, userID, th, userAgent, ip, rememberMe, expiresAt)
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID)
 	return err
 }
 
+// Refresh exchanges a refresh token for a new access/refresh token pair.
+// The presented token is revoked; presenting it again revokes the whole family.
 func (h *AuthHandler) Refresh(c *gin.Context) {
 	var body struct {
 		RefreshToken string `json:"refreshToken" binding:"required"`
@@ -272,17 +374,128 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	access, err := auth.GenerateAccessToken(h.Cfg.JWTSecret, claims.UserID, claims.Roles, 15*time.Minute)
+	ctx := context.Background()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		tokenID    int64
+		userID     int
+		familyID   uuid.UUID
+		rememberMe bool
+		createdAt  time.Time
+		expiresAt  time.Time
+		revokedAt  *time.Time
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, family_id, remember_me, created_at, expires_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, hashToken(body.RefreshToken)).Scan(&tokenID, &userID, &familyID, &rememberMe, &createdAt, &expiresAt, &revokedAt)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+
+	if revokedAt != nil {
+		// A revoked token is being replayed: assume it was stolen and kill the whole family
+		_ = tx.Rollback(ctx)
+		if err := h.revokeRefreshFamily(ctx, familyID); err != nil {
+			log.Printf("Refresh: failed to revoke token family %s: %v", familyID, err)
+		}
+		log.Printf("Refresh: reuse of revoked refresh token detected for user %d, family %s revoked", userID, familyID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has been revoked"})
+		return
+	}
+	if userID != claims.UserID || time.Now().After(expiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+
+	// Roles and permissions are reloaded so that grants and revocations apply on the next refresh
+	roles, permissions, err := loadAuthorization(ctx, h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load roles"})
+		return
+	}
+
+	access, err := h.Cfg.JWTKeys.GenerateAccessToken(userID, roles, permissions, 15*time.Minute)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+
+	// The new token keeps the lifetime the family was created with
+	refreshTTL := expiresAt.Sub(createdAt)
+	refresh, err := auth.GenerateAccessToken(h.Cfg.RefreshSecret, userID, roles, nil, refreshTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+
+	var newTokenID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, user_agent, ip_address, remember_me, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, userID, hashToken(refresh), familyID, c.GetHeader("User-Agent"), c.ClientIP(), rememberMe, time.Now().Add(refreshTTL)).Scan(&newTokenID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"accessToken": access})
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW(), last_used_at = NOW(), replaced_by = $2
+		WHERE id = $1
+	`, tokenID, newTokenID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate token"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accessToken": access, "refreshToken": refresh})
 }
 
+// JWKS - GET /.well-known/jwks.json
+// Public keys for services that verify access tokens on their own.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.Cfg.JWTKeys.JWKS())
+}
+
+// Logout revokes the presented refresh token so it can no longer be exchanged
 func (h *AuthHandler) Logout(c *gin.Context) {
-	// Stateless JWT: on client we simply drop tokens. In production you may add token blacklist / rotation via DB.
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	// The body is optional: clients without a refresh token simply drop their access token
+	_ = c.ShouldBindJSON(&body)
+
+	if body.RefreshToken != "" {
+		ctx := context.Background()
+		_, err := h.DB.Exec(ctx, `
+			UPDATE refresh_tokens SET revoked_at = NOW(), last_used_at = NOW()
+			WHERE token_hash = $1 AND revoked_at IS NULL
+		`, hashToken(body.RefreshToken))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -304,7 +517,7 @@ func (h *AuthHandler) Me(c *gin.Context) {
 	}
 
 	// Parse token to get user info
-	claims, err := auth.ParseToken(h.Cfg.JWTSecret, tokenString)
+	claims, err := h.Cfg.JWTKeys.ParseToken(tokenString)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 		return
@@ -312,24 +525,28 @@ func (h *AuthHandler) Me(c *gin.Context) {
 
 	ctx := context.Background()
 	var (
-		id        int
-		email     string
-		fullName  sql.NullString
-		createdAt time.Time
+		id              int
+		email           string
+		fullName        sql.NullString
+		createdAt       time.Time
+		emailVerifiedAt *time.Time
 	)
-	err = h.DB.QueryRow(ctx, `SELECT id, email, full_name, created_at FROM users WHERE id = $1`, claims.UserID).
-		Scan(&id, &email, &fullName, &createdAt)
+	err = h.DB.QueryRow(ctx, `SELECT id, email, full_name, created_at, email_verified_at FROM users WHERE id = $1`, claims.UserID).
+		Scan(&id, &email, &fullName, &createdAt, &emailVerifiedAt)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
 
 	c.JSON(http.StatusOK, authUserModel{
-		ID:        id,
-		Email:     email,
-		Name:      fullName.String,
-		Roles:     claims.Roles,
-		CreatedAt: createdAt,
+		ID:             id,
+		Email:          email,
+		Name:           fullName.String,
+		Roles:          claims.Roles,
+		Permissions:    claims.Permissions,
+		EmailVerified:  emailVerifiedAt != nil,
+		CreatedAt:      createdAt,
+		ImpersonatorID: claims.ImpersonatorID,
 	})
 }
 
@@ -357,12 +574,12 @@ func (h *AuthHandler) SendSignupOTP(c *gin.Context) {
 	// Generate 6-digit OTP
 	otp := fmt.Sprintf("%06d", rand.Intn(1000000))
 
-	// Store signup data and OTP in Redis with 10-minute expiry
+	// Store signup data and OTP with 10-minute expiry
 	signupKey := fmt.Sprintf("signup:%s", req.Email)
 	signupData := fmt.Sprintf("%s|%s|%s", req.Email, req.Password, req.FullName)
 
 	// Store signup data
-	err = h.Rd.Set(ctx, signupKey, signupData, 10*time.Minute).Err()
+	err = h.KV.Set(ctx, signupKey, signupData, 10*time.Minute)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store signup data"})
 		return
@@ -370,7 +587,7 @@ func (h *AuthHandler) SendSignupOTP(c *gin.Context) {
 
 	// Store OTP
 	otpKey := fmt.Sprintf("signup_otp:%s", req.Email)
-	err = h.Rd.Set(ctx, otpKey, otp, 10*time.Minute).Err()
+	err = h.KV.Set(ctx, otpKey, otp, 10*time.Minute)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store OTP"})
 		return
@@ -398,9 +615,9 @@ func (h *AuthHandler) VerifySignupOTP(c *gin.Context) {
 	otpKey := fmt.Sprintf("signup_otp:%s", req.Email)
 	signupKey := fmt.Sprintf("signup:%s", req.Email)
 
-	// Get OTP from Redis
-	storedOTP, err := h.Rd.Get(ctx, otpKey).Result()
-	if err == redis.Nil {
+	// Get stored OTP
+	storedOTP, err := h.KV.Get(ctx, otpKey)
+	if err == kv.ErrNotFound {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
 		return
 	} else if err != nil {
@@ -414,9 +631,9 @@ func (h *AuthHandler) VerifySignupOTP(c *gin.Context) {
 		return
 	}
 
-	// Get signup data from Redis
-	signupData, err := h.Rd.Get(ctx, signupKey).Result()
-	if err == redis.Nil {
+	// Get stored signup data
+	signupData, err := h.KV.Get(ctx, signupKey)
+	if err == kv.ErrNotFound {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "signup data not found or expired"})
 		return
 	} else if err != nil {
@@ -444,9 +661,10 @@ func (h *AuthHandler) VerifySignupOTP(c *gin.Context) {
 
 	// Insert user
 	var userID int
+	// The signup OTP already proved the address
 	err = h.DB.QueryRow(ctx, `
-		INSERT INTO users (email, password_hash, full_name, is_active, created_at)
-		VALUES ($1, $2, $3, TRUE, NOW())
+		INSERT INTO users (email, password_hash, full_name, is_active, created_at, email_verified_at)
+		VALUES ($1, $2, $3, TRUE, NOW(), NOW())
 		RETURNING id
 	`, email, string(hashedPassword), fullName).Scan(&userID)
 	if err != nil {
@@ -464,8 +682,8 @@ func (h *AuthHandler) VerifySignupOTP(c *gin.Context) {
 		roles = append(roles, "customer")
 	}
 
-	// Clean up Redis
-	h.Rd.Del(ctx, otpKey, signupKey)
+	// Clean up stored OTP
+	h.KV.Del(ctx, otpKey, signupKey)
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Account created successfully", "userID": userID})
 }
@@ -495,9 +713,9 @@ func (h *AuthHandler) ForgotPassword(c *gin.Context) {
 	// Generate 6-digit OTP
 	otp := fmt.Sprintf("%06d", rand.Intn(1000000))
 
-	// Store OTP in Redis with 10-minute expiry
+	// Store OTP with 10-minute expiry
 	redisKey := fmt.Sprintf("otp:%s", req.Email)
-	err = h.Rd.Set(ctx, redisKey, otp, 10*time.Minute).Err()
+	err = h.KV.Set(ctx, redisKey, otp, 10*time.Minute)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store OTP"})
 		return
@@ -523,9 +741,9 @@ func (h *AuthHandler) VerifyOTP(c *gin.Context) {
 	ctx := context.Background()
 	redisKey := fmt.Sprintf("otp:%s", req.Email)
 
-	// Get OTP from Redis
-	storedOTP, err := h.Rd.Get(ctx, redisKey).Result()
-	if err == redis.Nil {
+	// Get stored OTP
+	storedOTP, err := h.KV.Get(ctx, redisKey)
+	if err == kv.ErrNotFound {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
 		return
 	} else if err != nil {
@@ -541,14 +759,14 @@ func (h *AuthHandler) VerifyOTP(c *gin.Context) {
 
 	// Mark OTP as verified by setting a verification flag
 	verifyKey := fmt.Sprintf("verified:%s", req.Email)
-	err = h.Rd.Set(ctx, verifyKey, "true", 5*time.Minute).Err()
+	err = h.KV.Set(ctx, verifyKey, "true", 5*time.Minute)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mark verification"})
 		return
 	}
 
 	// Delete the OTP after successful verification
-	h.Rd.Del(ctx, redisKey)
+	h.KV.Del(ctx, redisKey)
 
 	c.JSON(http.StatusOK, gin.H{"message": "OTP verified successfully"})
 }
@@ -565,8 +783,8 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 
 	// Verify OTP first
 	redisKey := fmt.Sprintf("otp:%s", req.Email)
-	storedOTP, err := h.Rd.Get(ctx, redisKey).Result()
-	if err == redis.Nil {
+	storedOTP, err := h.KV.Get(ctx, redisKey)
+	if err == kv.ErrNotFound {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
 		return
 	} else if err != nil {
@@ -588,15 +806,18 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 	}
 
 	// Update password in database
-	_, err = h.DB.Exec(ctx, "UPDATE users SET password_hash = $1, updated_at = NOW() WHERE email = $2 AND is_active = TRUE",
-		string(hashedPassword), req.Email)
+	// Receiving the OTP also proves the address
+	_, err = h.DB.Exec(ctx, `
+		UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE email = $2 AND is_active = TRUE
+	`, string(hashedPassword), req.Email)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update password"})
 		return
 	}
 
-	// Clean up Redis
-	h.Rd.Del(ctx, redisKey)
+	// Clean up stored OTP
+	h.KV.Del(ctx, redisKey)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
 }
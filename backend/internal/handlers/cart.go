@@ -10,6 +10,7 @@ import (
 	"github.com/etreasure/backend/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -19,26 +20,164 @@ type CartHandler struct {
 }
 
 type AddToCartRequest struct {
-	ProductID string  `json:"product_id" binding:"required"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price,omitempty"` // Allow price override for discounts
+	ProductID string `json:"product_id" binding:"required"`
+	// VariantID picks the size/colour; without it the product's first variant is used
+	VariantID int `json:"variant_id,omitempty"`
+	Quantity  int `json:"quantity"`
+}
+
+type UpdateCartItemRequest struct {
+	Quantity int `json:"quantity" binding:"required,min=1"`
 }
 
 type CartItem struct {
-	ID              string  `json:"id"`
-	ProductID       string  `json:"product_id"`
-	Title           string  `json:"title"`
-	Price           float64 `json:"price"`
-	Quantity        int     `json:"quantity"`
-	ImageURL        string  `json:"image_url"`
-	SKU             string  `json:"sku"`
-	DiscountedPrice *string `json:"discounted_price,omitempty"`
+	ID             string            `json:"id"`
+	ProductID      string            `json:"product_id"`
+	VariantID      int               `json:"variant_id"`
+	Title          string            `json:"title"`
+	VariantTitle   string            `json:"variant_title"`
+	Options        map[string]string `json:"options"`
+	Price          float64           `json:"price"`      // unit price charged
+	ListPrice      float64           `json:"list_price"` // unit price before discounts
+	Discount       float64           `json:"discount"`   // per unit
+	DiscountReason string            `json:"discount_reason,omitempty"`
+	Quantity       int               `json:"quantity"`
+	LineTotal      float64           `json:"line_total"`
+	ImageURL       string            `json:"image_url"`
+	SKU            string            `json:"sku"`
+	GiftRegistry   string            `json:"gift_registry,omitempty"` // registry the line is a gift for
+}
+
+// SavedCartItem is a line set aside for later: shown at its current price, not charged
+type SavedCartItem struct {
+	ID           string            `json:"id"`
+	ProductID    string            `json:"product_id"`
+	VariantID    int               `json:"variant_id"`
+	Title        string            `json:"title"`
+	VariantTitle string            `json:"variant_title"`
+	Options      map[string]string `json:"options"`
+	Price        float64           `json:"price"`
+	Quantity     int               `json:"quantity"`
+	InStock      bool              `json:"in_stock"`
+	Stock        int               `json:"stock"`
+	ImageURL     string            `json:"image_url"`
+	SKU          string            `json:"sku"`
+}
+
+// CartOffer is the offer the cart is priced with
+type CartOffer struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Discount float64 `json:"discount"` // what the offer takes off the whole cart
 }
 
 type CartResponse struct {
-	Items []CartItem `json:"items"`
-	Total float64    `json:"total"`
-	Count int        `json:"count"`
+	Items         []CartItem      `json:"items"`
+	SavedForLater []SavedCartItem `json:"saved_for_later"`
+	Subtotal      float64         `json:"subtotal"` // at list prices
+	Discount      float64         `json:"discount"`
+	Offer         *CartOffer      `json:"offer,omitempty"`
+	Coupon        *CartCoupon     `json:"coupon,omitempty"`
+	Total         float64         `json:"total"`
+	Count         int             `json:"count"` // cart only, not saved lines
+}
+
+// cartOwner is whose cart a request acts on: the signed-in user when OptionalAuth
+// verified an access token, otherwise the guest session cookie
+type cartOwner struct {
+	UserID    int
+	SessionID string
+}
+
+// key returns the cart column and value that select the owner's lines
+func (o cartOwner) key() (string, any) {
+	if o.UserID != 0 {
+		return "user_id", o.UserID
+	}
+	return "session_id", o.SessionID
+}
+
+func (o cartOwner) empty() bool {
+	return o.UserID == 0 && o.SessionID == ""
+}
+
+// cartOwnerFromRequest resolves the cart owner. A Bearer token that could not be verified
+// (usually expired) gets a 401 so the client refreshes it rather than silently
+// switching to the guest cart; false means that response was sent.
+func cartOwnerFromRequest(c *gin.Context) (cartOwner, bool) {
+	if userID, ok := contextUserID(c); ok {
+		return cartOwner{UserID: userID}, true
+	}
+	if strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		return cartOwner{}, false
+	}
+	sessionID, _ := c.Cookie("session_id")
+	return cartOwner{SessionID: sessionID}, true
+}
+
+// newSessionCookie starts a guest session, used to key guest carts and wishlists
+func newSessionCookie(c *gin.Context) string {
+	// Generate new session ID using UUID
+	sessionID := fmt.Sprintf("session_%s", uuid.New().String())
+	// Set secure flag based on whether request is HTTPS
+	// Check both TLS and X-Forwarded-Proto header (for proxy setups)
+	isSecure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+
+	// For production, set cookie domain to work across ethnictreasures.co.in
+	cookieDomain := ""
+	// Only set domain for production/remote environments, not localhost
+	requestHost := c.Request.Host
+	headerHost := c.GetHeader("Host")
+	origin := c.GetHeader("Origin")
+	referer := c.GetHeader("Referer")
+
+	// Check if this is a production request from ethnictreasures.co.in
+	isProduction := (origin == "https://ethnictreasures.co.in") ||
+		(referer != "" && strings.Contains(referer, "ethnictreasures.co.in")) ||
+		(headerHost != "" && strings.Contains(headerHost, "ethnictreasures.co.in")) ||
+		(requestHost != "" && strings.Contains(requestHost, "ethnictreasures.co.in"))
+
+	// Check if this is localhost
+	isLocalhost := requestHost == "localhost:8080" ||
+		headerHost == "localhost:8080" ||
+		requestHost == "127.0.0.1:8080" ||
+		headerHost == "127.0.0.1:8080" ||
+		requestHost == "localhost:4321" ||
+		headerHost == "localhost:4321" ||
+		origin == "http://localhost:4321" ||
+		origin == "http://localhost:3000"
+
+	if isProduction && !isLocalhost {
+		// For cross-origin requests, don't set domain to let browser handle cookies naturally
+		cookieDomain = os.Getenv("COOKIE_DOMAIN")
+		if cookieDomain == "" {
+			// Don't set domain for cross-origin - let browser handle it
+			cookieDomain = ""
+		}
+	}
+
+	// Industry standard: Set single cookie with proper attributes for cross-domain
+	// For cross-domain scenarios, we need Domain=ethnictreasures.co.in with SameSite=None; Secure
+	// For localhost scenarios, we need no domain with SameSite=Lax
+
+	var cookieString string
+	if cookieDomain != "" && isSecure {
+		// Production: Cross-domain cookie with explicit domain
+		cookieString = fmt.Sprintf("session_id=%s; Path=/; Domain=%s; Max-Age=%d; HttpOnly=false; SameSite=None; Secure",
+			sessionID, cookieDomain, 86400*30)
+	} else if isSecure {
+		// Cross-origin or localhost: Secure cookie without domain
+		cookieString = fmt.Sprintf("session_id=%s; Path=/; Max-Age=%d; HttpOnly=false; SameSite=None; Secure",
+			sessionID, 86400*30)
+	} else {
+		// Localhost: Simple cookie
+		cookieString = fmt.Sprintf("session_id=%s; Path=/; Max-Age=%d; HttpOnly=false; SameSite=Lax",
+			sessionID, 86400*30)
+	}
+
+	c.Header("Set-Cookie", cookieString)
+	return sessionID
 }
 
 // AddToCart adds a product to the cart
@@ -49,29 +188,44 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 		return
 	}
 
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if req.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be positive; use PATCH /api/cart/:id to change a line"})
+		return
+	}
+
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+
 	ctx := context.Background()
 
 	// Check if product exists and get variant info
 	var title string
 	var priceCents int
 	var currency string
-	var imageURL string
 	var variantID int
 	var currentStock int
 
 	err := h.DB.QueryRow(ctx, `
-		SELECT p.title, pv.price_cents, pv.currency, 
-		       (SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1) as image_url,
+		SELECT p.title, pv.price_cents, pv.currency,
 		       pv.id as variant_id,
 		       COALESCE(pv.stock_quantity, 0) as stock_quantity
 		FROM products p
-		LEFT JOIN product_variants pv ON p.uuid_id = pv.product_id
-		WHERE p.uuid_id = $1::uuid
+		JOIN product_variants pv ON p.uuid_id = pv.product_id
+		WHERE p.uuid_id = $1::uuid AND ($2 = 0 OR pv.id = $2)
 		ORDER BY pv.id
 		LIMIT 1
-	`, req.ProductID).Scan(&title, &priceCents, &currency, &imageURL, &variantID, &currentStock)
+	`, req.ProductID, req.VariantID).Scan(&title, &priceCents, &currency, &variantID, &currentStock)
 
 	if err != nil {
+		if req.VariantID != 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found for this product"})
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
@@ -87,248 +241,242 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 		return
 	}
 
-	// Get session ID from cookie or create new one
-	sessionID, err := c.Cookie("session_id")
-
-	if err != nil || sessionID == "" {
-		// Generate new session ID using UUID
-		sessionID = fmt.Sprintf("session_%s", uuid.New().String())
-		// Set secure flag based on whether request is HTTPS
-		// Check both TLS and X-Forwarded-Proto header (for proxy setups)
-		isSecure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
-
-		// For production, set cookie domain to work across ethnictreasures.co.in
-		cookieDomain := ""
-		// Only set domain for production/remote environments, not localhost
-		requestHost := c.Request.Host
-		headerHost := c.GetHeader("Host")
-		origin := c.GetHeader("Origin")
-		referer := c.GetHeader("Referer")
-
-		// Check if this is a production request from ethnictreasures.co.in
-		isProduction := (origin == "https://ethnictreasures.co.in") ||
-			(referer != "" && strings.Contains(referer, "ethnictreasures.co.in")) ||
-			(headerHost != "" && strings.Contains(headerHost, "ethnictreasures.co.in")) ||
-			(requestHost != "" && strings.Contains(requestHost, "ethnictreasures.co.in"))
-
-		// Check if this is localhost
-		isLocalhost := requestHost == "localhost:8080" ||
-			headerHost == "localhost:8080" ||
-			requestHost == "127.0.0.1:8080" ||
-			headerHost == "127.0.0.1:8080" ||
-			requestHost == "localhost:4321" ||
-			headerHost == "localhost:4321" ||
-			origin == "http://localhost:4321" ||
-			origin == "http://localhost:3000"
-
-		if isProduction && !isLocalhost {
-			// For cross-origin requests, don't set domain to let browser handle cookies naturally
-			cookieDomain = os.Getenv("COOKIE_DOMAIN")
-			if cookieDomain == "" {
-				// Don't set domain for cross-origin - let browser handle it
-				cookieDomain = ""
-			}
-		}
-
-		// Industry standard: Set single cookie with proper attributes for cross-domain
-		// For cross-domain scenarios, we need Domain=ethnictreasures.co.in with SameSite=None; Secure
-		// For localhost scenarios, we need no domain with SameSite=Lax
-
-		var cookieString string
-		if cookieDomain != "" && isSecure {
-			// Production: Cross-domain cookie with explicit domain
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Domain=%s; Max-Age=%d; HttpOnly=false; SameSite=None; Secure",
-				sessionID, cookieDomain, 86400*30)
-		} else if isSecure {
-			// Cross-origin or localhost: Secure cookie without domain
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Max-Age=%d; HttpOnly=false; SameSite=None; Secure",
-				sessionID, 86400*30)
-		} else {
-			// Localhost: Simple cookie
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Max-Age=%d; HttpOnly=false; SameSite=Lax",
-				sessionID, 86400*30)
-		}
-
-		c.Header("Set-Cookie", cookieString)
-	} else {
-		// Use existing session
-	}
-
-	// Use provided price or get from database
-	var finalPrice float64
-	if req.Price > 0 {
-		// Use the provided price (discounted price)
-		finalPrice = req.Price
-	} else {
-		// Use regular price from database
-		finalPrice = float64(priceCents) / 100.0
+	// Guests without a session cookie get a new one
+	if owner.empty() {
+		owner.SessionID = newSessionCookie(c)
 	}
+	ownerColumn, ownerValue := owner.key()
 
-	// Check if item already exists in cart
+	// Lines are keyed by variant: adding the same variant again adds to its line, and
+	// brings it back into the cart if it was saved for later
 	var existingQuantity int
+	var registryItemID *int64
 	err = h.DB.QueryRow(ctx, `
-		SELECT quantity FROM cart 
-		WHERE session_id = $1 AND product_id = $2::uuid AND variant_id = $3
-	`, sessionID, req.ProductID, variantID).Scan(&existingQuantity)
+		SELECT quantity, registry_item_id FROM cart 
+		WHERE `+ownerColumn+` = $1 AND variant_id = $2
+	`, ownerValue, variantID).Scan(&existingQuantity, &registryItemID)
+
+	if err == nil && registryItemID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "this item is already in your cart as a registry gift; check it out or remove it first"})
+		return
+	}
 
 	newTotalQuantity := existingQuantity + req.Quantity
 
-	if err == nil {
-		// Check if new total quantity exceeds available stock
-		if newTotalQuantity > currentStock {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":           "insufficient stock",
-				"current_stock":   currentStock,
-				"existing_cart":   existingQuantity,
-				"requested":       req.Quantity,
-				"total_requested": newTotalQuantity,
-				"variant_id":      variantID,
-			})
-			return
-		}
-		// Update existing item with discounted price
-		_, err = h.DB.Exec(ctx, `
-			UPDATE cart 
-			SET quantity = quantity + $1, discounted_price = $2, updated_at = NOW()
-			WHERE session_id = $3 AND product_id = $4::uuid AND variant_id = $5
-		`, req.Quantity, fmt.Sprintf("%.2f", finalPrice), sessionID, req.ProductID, variantID)
-	} else {
-		// Insert new item with discounted price
-		_, err = h.DB.Exec(ctx, `
-			INSERT INTO cart (session_id, product_id, variant_id, quantity, discounted_price, updated_at)
-			VALUES ($1, $2::uuid, $3, $4, $5, NOW())
-		`, sessionID, req.ProductID, variantID, req.Quantity, fmt.Sprintf("%.2f", finalPrice))
+	if err == nil && newTotalQuantity > currentStock {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":           "insufficient stock",
+			"current_stock":   currentStock,
+			"existing_cart":   existingQuantity,
+			"requested":       req.Quantity,
+			"total_requested": newTotalQuantity,
+			"variant_id":      variantID,
+		})
+		return
+	}
+
+	var itemID, quantity int
+	err = h.DB.QueryRow(ctx, `
+		INSERT INTO cart (`+ownerColumn+`, product_id, variant_id, quantity, updated_at)
+		VALUES ($1, $2::uuid, $3, $4, NOW())
+		ON CONFLICT (`+ownerColumn+`, variant_id) WHERE `+ownerColumn+` IS NOT NULL
+		DO UPDATE SET quantity = cart.quantity + EXCLUDED.quantity,
+		              saved_for_later = FALSE,
+		              updated_at = NOW()
+		RETURNING id, quantity
+	`, ownerValue, req.ProductID, variantID, req.Quantity).Scan(&itemID, &quantity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Product added to cart successfully",
+		"item_id":    itemID,
 		"product_id": req.ProductID,
-		"quantity":   req.Quantity + existingQuantity,
-		"price":      finalPrice,
+		"variant_id": variantID,
+		"quantity":   quantity,
+		"price":      float64(priceCents) / 100.0,
 		"currency":   currency,
 	})
 }
 
+// UpdateCartItem - PATCH /api/cart/:id
+// Sets the quantity of a cart line, checked against the variant's stock.
+func (h *CartHandler) UpdateCartItem(c *gin.Context) {
+	itemID := c.Param("id")
+	var req UpdateCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be at least 1; use DELETE to remove the item"})
+		return
+	}
+
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		return
+	}
+	ownerColumn, ownerValue := owner.key()
+
+	ctx := context.Background()
+	var variantID, currentStock int
+	err := h.DB.QueryRow(ctx, `
+		SELECT pv.id, COALESCE(pv.stock_quantity, 0)
+		FROM cart c
+		JOIN product_variants pv ON pv.id = c.variant_id
+		WHERE c.id = $1 AND c.`+ownerColumn+` = $2
+	`, itemID, ownerValue).Scan(&variantID, &currentStock)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
+		return
+	}
+
+	if req.Quantity > currentStock {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "insufficient stock",
+			"current_stock": currentStock,
+			"requested":     req.Quantity,
+			"variant_id":    variantID,
+		})
+		return
+	}
+
+	if _, err := h.DB.Exec(ctx, `
+		UPDATE cart SET quantity = $1, updated_at = NOW()
+		WHERE id = $2 AND `+ownerColumn+` = $3
+	`, req.Quantity, itemID, ownerValue); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Cart updated successfully",
+		"item_id":    itemID,
+		"variant_id": variantID,
+		"quantity":   req.Quantity,
+	})
+}
+
 // GetCart retrieves the current user's cart
 func (h *CartHandler) GetCart(c *gin.Context) {
 	ctx := context.Background()
 
-	// Get session ID from cookie
-	sessionID, err := c.Cookie("session_id")
-
-	if err != nil || sessionID == "" {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
 		// Return empty cart for new users
 		c.JSON(http.StatusOK, CartResponse{
-			Items: []CartItem{},
-			Total: 0.0,
-			Count: 0,
+			Items:         []CartItem{},
+			SavedForLater: []SavedCartItem{},
+			Total:         0.0,
+			Count:         0,
 		})
 		return
 	}
 
-	query := `
-			SELECT 
-			c.id,
-			c.product_id::text,
-			p.title,
-			pv.sku,
-			CASE 
-				WHEN c.discounted_price IS NOT NULL THEN c.discounted_price::numeric * 100
-				ELSE pv.price_cents
-			END as price_cents,
-			pv.currency,
-			c.quantity,
-			c.discounted_price,
-			(SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1) as image_url
-		FROM cart c
-		JOIN products p ON c.product_id = p.uuid_id
-		JOIN product_variants pv ON c.variant_id = pv.id
-		WHERE c.session_id = $1
-	`
-
-	rows, err := h.DB.Query(ctx, query, sessionID)
+	cart, err := priceCart(ctx, h.DB, owner)
 	if err != nil {
 		// Check if it's a table doesn't exist error
 		if strings.Contains(err.Error(), "does not exist") || strings.Contains(err.Error(), "relation") {
 			c.JSON(http.StatusOK, CartResponse{
-				Items: []CartItem{},
-				Total: 0.0,
-				Count: 0,
+				Items:         []CartItem{},
+				SavedForLater: []SavedCartItem{},
+				Total:         0.0,
+				Count:         0,
 			})
 			return
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart", "details": err.Error()})
 		return
 	}
-	defer rows.Close()
-
-	items := make([]CartItem, 0)
-	total := 0.0
-	count := 0
-	for rows.Next() {
-		var item CartItem
-		var id any
-		var priceCents int
-		var currency string
-		var quantity int
-		var imagePath *string
-		var productID string
-		var sku *string
-		var discountedPrice *string
-
-		err := rows.Scan(&id, &productID, &item.Title, &sku, &priceCents, &currency, &quantity, &discountedPrice, &imagePath)
-		if err != nil {
-			continue
-		}
-
-		item.ID = fmt.Sprint(id)
-		item.ProductID = productID
-		item.Price = float64(priceCents) / 100.0
-		item.Quantity = quantity
 
-		// Set SKU if available
-		if sku != nil {
-			item.SKU = *sku
-		}
-
-		// Set discounted price if available
-		if discountedPrice != nil {
-			item.DiscountedPrice = discountedPrice
-		}
+	savedLines, err := loadSavedForLater(ctx, h.DB, owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart", "details": err.Error()})
+		return
+	}
+	saved := make([]SavedCartItem, 0, len(savedLines))
+	for _, l := range savedLines {
+		saved = append(saved, SavedCartItem{
+			ID:           fmt.Sprint(l.ID),
+			ProductID:    l.ProductID,
+			VariantID:    l.VariantID,
+			Title:        l.Title,
+			VariantTitle: l.VariantTitle,
+			Options:      l.Options,
+			Price:        float64(l.PriceCents) / 100.0,
+			Quantity:     l.Quantity,
+			InStock:      l.Available && l.Stock > 0,
+			Stock:        l.Stock,
+			ImageURL:     h.imageURL(l.ImagePath),
+			SKU:          l.SKU,
+		})
+	}
 
-		// Construct proper image URL using ImageHelper
-		if h.ImageHelper != nil {
-			_, imageURL := h.ImageHelper.GetImageKeyAndURL(imagePath)
-
-			// If no image URL is available, use fallback
-			if imageURL == nil || *imageURL == "" {
-				fallbackURL := h.ImageHelper.GetFallbackImageURL("product")
-				item.ImageURL = fallbackURL
-			} else {
-				item.ImageURL = *imageURL
-			}
-
-		} else {
-			// Fallback if ImageHelper is not available
-			if imagePath != nil {
-				item.ImageURL = *imagePath
-			} else {
-				item.ImageURL = "/product-placeholder.webp"
-			}
+	items := make([]CartItem, 0, len(cart.Lines))
+	for _, l := range cart.Lines {
+		item := CartItem{
+			ID:             fmt.Sprint(l.ID),
+			ProductID:      l.ProductID,
+			VariantID:      l.VariantID,
+			Title:          l.Title,
+			VariantTitle:   l.VariantTitle,
+			Options:        l.Options,
+			Price:          float64(l.UnitPriceCents) / 100.0,
+			ListPrice:      float64(l.ListPriceCents) / 100.0,
+			Discount:       float64(l.DiscountCents) / 100.0,
+			DiscountReason: l.Reason,
+			Quantity:       l.Quantity,
+			LineTotal:      float64(l.TotalCents) / 100.0,
+			ImageURL:       h.imageURL(l.ImagePath),
+			SKU:            l.SKU,
+			GiftRegistry:   l.RegistryName,
 		}
-
 		items = append(items, item)
-		total += item.Price * float64(item.Quantity)
-		count += item.Quantity
 	}
 
-	cart := CartResponse{
-		Items: items,
-		Total: total,
-		Count: count,
+	var offer *CartOffer
+	if cart.Offer != nil {
+		offer = &CartOffer{ID: cart.Offer.ID, Title: cart.Offer.Title, Discount: float64(cart.OfferDiscountCents) / 100.0}
 	}
 
-	c.JSON(http.StatusOK, cart)
+	c.JSON(http.StatusOK, CartResponse{
+		Items:         items,
+		SavedForLater: saved,
+		Subtotal:      float64(cart.SubtotalCents) / 100.0,
+		Discount:      float64(cart.DiscountCents) / 100.0,
+		Offer:         offer,
+		Coupon:        cart.Coupon.response(),
+		Total:         float64(cart.TotalCents) / 100.0,
+		Count:         cart.Count,
+	})
+}
+
+// imageURL turns a stored media path into the URL shown for a cart line
+func (h *CartHandler) imageURL(path *string) string {
+	// Construct proper image URL using ImageHelper
+	if h.ImageHelper != nil {
+		_, imageURL := h.ImageHelper.GetImageKeyAndURL(path)
+
+		// If no image URL is available, use fallback
+		if imageURL == nil || *imageURL == "" {
+			return h.ImageHelper.GetFallbackImageURL("product")
+		}
+		return *imageURL
+	}
+	// Fallback if ImageHelper is not available
+	if path != nil {
+		return *path
+	}
+	return "/product-placeholder.webp"
 }
 
 // RemoveFromCart removes an item from the cart
@@ -341,18 +489,21 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 
 	ctx := context.Background()
 
-	// Get session ID from cookie
-	sessionID, err := c.Cookie("session_id")
-	if err != nil || sessionID == "" {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
 		return
 	}
+	ownerColumn, ownerValue := owner.key()
 
 	// Delete item from cart
-	_, err = h.DB.Exec(ctx, `
+	_, err := h.DB.Exec(ctx, `
 		DELETE FROM cart 
-		WHERE id = $1 AND session_id = $2
-	`, itemID, sessionID)
+		WHERE id = $1 AND `+ownerColumn+` = $2
+	`, itemID, ownerValue)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item from cart"})
@@ -365,22 +516,25 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 	})
 }
 
-// ClearCart clears all items from the cart
+// ClearCart clears all items from the cart. Lines saved for later are kept.
 func (h *CartHandler) ClearCart(c *gin.Context) {
 	ctx := context.Background()
 
-	// Get session ID from cookie
-	sessionID, err := c.Cookie("session_id")
-	if err != nil || sessionID == "" {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
 		return
 	}
+	ownerColumn, ownerValue := owner.key()
 
 	// Delete all items from cart
-	_, err = h.DB.Exec(ctx, `
+	_, err := h.DB.Exec(ctx, `
 		DELETE FROM cart 
-		WHERE session_id = $1
-	`, sessionID)
+		WHERE `+ownerColumn+` = $1 AND saved_for_later = FALSE
+	`, ownerValue)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// cartMergeResult reports what happened to the guest cart when it was folded into the
+// signed-in user's cart. It is part of the sign-in response, hence the camelCase.
+type cartMergeResult struct {
+	Merged  int             `json:"merged"`
+	Capped  []cartMergeLine `json:"capped"`
+	Dropped []cartMergeLine `json:"dropped"`
+}
+
+// cartMergeLine is a guest line that could not be merged as-is
+type cartMergeLine struct {
+	VariantID int    `json:"variantId"`
+	Title     string `json:"title"`
+	Requested int    `json:"requested"`
+	Quantity  int    `json:"quantity"`
+}
+
+const (
+	cartMergeAdded   = "merged"
+	cartMergeCapped  = "capped"
+	cartMergeDropped = "dropped"
+)
+
+// mergeCartLine applies the merge rules to one variant: quantities already in the user's
+// cart and in the guest cart are summed and capped at stock, and lines for variants that
+// are gone, unpublished or out of stock are dropped.
+func mergeCartLine(existing, guest, stock int, available bool) (int, string) {
+	if !available || stock <= 0 {
+		return 0, cartMergeDropped
+	}
+	want := existing + guest
+	if want > stock {
+		return stock, cartMergeCapped
+	}
+	return want, cartMergeAdded
+}
+
+type guestCartLine struct {
+	id         int
+	variantID  *int
+	quantity   int
+	title      string
+	stock      int
+	available  bool
+	userLineID *int
+	userQty    int
+	saved      bool
+	userSaved  bool
+}
+
+// mergeGuestCart moves the lines of the guest session cart, and the code entered on it,
+// into the user's cart. It returns nil when the session had nothing in its cart.
+func mergeGuestCart(ctx context.Context, db *pgxpool.Pool, sessionID string, userID int) (*cartMergeResult, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT c.id, c.variant_id, c.quantity, COALESCE(p.title, ''),
+		       COALESCE(pv.stock_quantity, 0), pv.id IS NOT NULL AND COALESCE(p.published, FALSE),
+		       u.id, COALESCE(u.quantity, 0), c.saved_for_later, COALESCE(u.saved_for_later, FALSE)
+		FROM cart c
+		LEFT JOIN product_variants pv ON pv.id = c.variant_id
+		LEFT JOIN products p ON p.uuid_id = c.product_id
+		LEFT JOIN cart u ON u.user_id = $2 AND u.variant_id = c.variant_id
+		WHERE c.session_id = $1
+		ORDER BY c.id
+		FOR UPDATE OF c
+	`, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var lines []guestCartLine
+	for rows.Next() {
+		var l guestCartLine
+		if err := rows.Scan(&l.id, &l.variantID, &l.quantity, &l.title, &l.stock, &l.available, &l.userLineID, &l.userQty, &l.saved, &l.userSaved); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A code entered as a guest comes along unless the user already has one on their cart
+	if _, err := tx.Exec(ctx, `
+		UPDATE cart_coupons SET user_id = $2, session_id = NULL
+		WHERE session_id = $1 AND NOT EXISTS (SELECT 1 FROM cart_coupons WHERE user_id = $2)
+	`, sessionID, userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM cart_coupons WHERE session_id = $1`, sessionID); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, tx.Commit(ctx)
+	}
+
+	result := &cartMergeResult{Capped: []cartMergeLine{}, Dropped: []cartMergeLine{}}
+	for _, l := range lines {
+		// Lines saved for later come along as they are; stock is checked when they are
+		// moved back into the cart
+		if l.saved {
+			switch {
+			case l.variantID == nil:
+				_, err = tx.Exec(ctx, `DELETE FROM cart WHERE id = $1`, l.id)
+			case l.userLineID != nil:
+				_, err = tx.Exec(ctx, `DELETE FROM cart WHERE id = $1`, l.id)
+			default:
+				_, err = tx.Exec(ctx, `UPDATE cart SET user_id = $1, session_id = NULL WHERE id = $2`, userID, l.id)
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// A line the user saved for later is replaced rather than added to
+		existing := l.userQty
+		if l.userSaved {
+			existing = 0
+		}
+		qty, outcome := mergeCartLine(existing, l.quantity, l.stock, l.available && l.variantID != nil)
+		line := cartMergeLine{Title: l.title, Requested: existing + l.quantity, Quantity: qty}
+		if l.variantID != nil {
+			line.VariantID = *l.variantID
+		}
+
+		switch {
+		case outcome == cartMergeDropped:
+			result.Dropped = append(result.Dropped, line)
+			_, err = tx.Exec(ctx, `DELETE FROM cart WHERE id = $1`, l.id)
+		case l.userLineID != nil:
+			// The user already has this variant: keep their line and fold the guest one into
+			// it, back in the cart if they had saved it for later
+			if _, err = tx.Exec(ctx, `UPDATE cart SET quantity = $1, saved_for_later = FALSE, updated_at = NOW() WHERE id = $2`, qty, *l.userLineID); err == nil {
+				_, err = tx.Exec(ctx, `DELETE FROM cart WHERE id = $1`, l.id)
+			}
+		default:
+			// Hand the guest line over
+			_, err = tx.Exec(ctx, `
+				UPDATE cart SET user_id = $1, session_id = NULL, quantity = $2, updated_at = NOW()
+				WHERE id = $3
+			`, userID, qty, l.id)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch outcome {
+		case cartMergeCapped:
+			result.Capped = append(result.Capped, line)
+			result.Merged++
+		case cartMergeAdded:
+			result.Merged++
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
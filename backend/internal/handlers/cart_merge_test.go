@@ -0,0 +1,27 @@
+package handlers
+
+import "testing"
+
+func TestMergeCartLine(t *testing.T) {
+	cases := []struct {
+		existing, guest, stock int
+		available              bool
+		qty                    int
+		outcome                string
+	}{
+		{0, 2, 10, true, 2, cartMergeAdded},
+		{3, 2, 10, true, 5, cartMergeAdded},
+		{3, 2, 5, true, 5, cartMergeAdded},
+		{3, 4, 5, true, 5, cartMergeCapped},
+		{0, 6, 5, true, 5, cartMergeCapped},
+		{1, 1, 0, true, 0, cartMergeDropped},
+		{1, 1, 10, false, 0, cartMergeDropped},
+	}
+	for _, tc := range cases {
+		qty, outcome := mergeCartLine(tc.existing, tc.guest, tc.stock, tc.available)
+		if qty != tc.qty || outcome != tc.outcome {
+			t.Errorf("mergeCartLine(%d, %d, %d, %v) = %d, %q, want %d, %q",
+				tc.existing, tc.guest, tc.stock, tc.available, qty, outcome, tc.qty, tc.outcome)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/config"
+	"github.com/etreasure/backend/internal/email"
+	"github.com/etreasure/backend/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Customers who leave a cart, or stop on the payment screen, get reminder emails at
+// each step of the configured cadence. A reminder is sent for a cart only while its
+// owner has not ordered since the last change to it, and for a checkout only while the
+// order is still pending payment, so paying stops the series. Payments that follow a
+// reminder are attributed to it as recovered revenue.
+
+const cartReminderList = "cart_reminders"
+
+// CartRecoveryHandler sends the reminders and serves their links and reporting
+type CartRecoveryHandler struct {
+	DB          *pgxpool.Pool
+	Email       *email.EmailService
+	Cfg         config.Config
+	ImageHelper *storage.ImageURLHelper
+}
+
+// dueReminderStep returns the cadence step to send for something idle that long, given
+// the last step already sent (-1 for none). Only the latest due step is sent, so a
+// reminder that was missed while the worker was down is not followed by a burst.
+func dueReminderStep(cadence []time.Duration, lastStep int, idle time.Duration) (int, bool) {
+	due := 0
+	for _, d := range cadence {
+		if idle >= d {
+			due++
+		}
+	}
+	step := due - 1
+	if step < 0 || step <= lastStep {
+		return 0, false
+	}
+	return step, true
+}
+
+type cartReminderCandidate struct {
+	kind     string // cart | order
+	userID   int
+	orderID  *string
+	email    string
+	anchorAt time.Time
+	lastStep int
+}
+
+// Run sends due reminders every interval until ctx is cancelled
+func (h *CartRecoveryHandler) Run(ctx context.Context, interval time.Duration) {
+	if len(h.Cfg.CartReminderCadence) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := h.sendDueReminders(ctx)
+			if err != nil {
+				log.Printf("cart recovery: run failed: %v", err)
+			} else if n > 0 {
+				log.Printf("cart recovery: sent %d reminders", n)
+			}
+		}
+	}
+}
+
+func (h *CartRecoveryHandler) sendDueReminders(ctx context.Context) (int, error) {
+	cadence := h.Cfg.CartReminderCadence
+	now := time.Now()
+	idleSince := now.Add(-cadence[0])
+	lookback := now.Add(-(cadence[len(cadence)-1] + 24*time.Hour))
+
+	// Unpaid checkouts: the customer's latest pending order, unless they have placed another since
+	rows, err := h.DB.Query(ctx, `
+		SELECT DISTINCT ON (o.user_id) 'order', o.user_id, o.id::text, u.email, o.created_at,
+		       COALESCE((SELECT MAX(r.step) FROM cart_recovery_emails r WHERE r.order_id = o.id), -1)
+		FROM orders o
+		JOIN users u ON u.id = o.user_id
+		WHERE o.status = 'pending_payment'
+		  AND o.created_at <= $1 AND o.created_at > $2
+		  AND u.is_active = TRUE AND u.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM orders n WHERE n.user_id = o.user_id AND n.created_at > o.created_at)
+		  AND NOT EXISTS (SELECT 1 FROM email_opt_outs x WHERE x.email = LOWER(u.email) AND x.list = $3)
+		ORDER BY o.user_id, o.created_at DESC
+	`, idleSince, lookback, cartReminderList)
+	if err != nil {
+		return 0, err
+	}
+	candidates, err := scanCartReminderCandidates(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	// Carts: idle since their last change, with no order placed after it
+	rows, err = h.DB.Query(ctx, `
+		WITH idle AS (
+			SELECT user_id, MAX(updated_at) AS last_activity
+			FROM cart
+			WHERE user_id IS NOT NULL AND saved_for_later = FALSE
+			GROUP BY user_id
+		)
+		SELECT 'cart', i.user_id, NULL::text, u.email, i.last_activity,
+		       COALESCE((SELECT MAX(r.step) FROM cart_recovery_emails r
+		                 WHERE r.user_id = i.user_id AND r.kind = 'cart' AND r.anchor_at = i.last_activity), -1)
+		FROM idle i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.last_activity <= $1 AND i.last_activity > $2
+		  AND u.is_active = TRUE AND u.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.user_id = i.user_id AND o.created_at >= i.last_activity)
+		  AND NOT EXISTS (SELECT 1 FROM email_opt_outs x WHERE x.email = LOWER(u.email) AND x.list = $3)
+	`, idleSince, lookback, cartReminderList)
+	if err != nil {
+		return 0, err
+	}
+	carts, err := scanCartReminderCandidates(rows)
+	if err != nil {
+		return 0, err
+	}
+	candidates = append(candidates, carts...)
+
+	sent := 0
+	for _, cand := range candidates {
+		step, ok := dueReminderStep(cadence, cand.lastStep, now.Sub(cand.anchorAt))
+		if !ok {
+			continue
+		}
+		ok, err = h.sendReminder(ctx, cand, step)
+		if err != nil {
+			log.Printf("cart recovery: reminder to user %d failed: %v", cand.userID, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+func scanCartReminderCandidates(rows pgx.Rows) ([]cartReminderCandidate, error) {
+	defer rows.Close()
+	var out []cartReminderCandidate
+	for rows.Next() {
+		var cand cartReminderCandidate
+		if err := rows.Scan(&cand.kind, &cand.userID, &cand.orderID, &cand.email, &cand.anchorAt, &cand.lastStep); err != nil {
+			return nil, err
+		}
+		out = append(out, cand)
+	}
+	return out, rows.Err()
+}
+
+// sendReminder records the reminder and emails it. The record is removed again when the
+// email cannot be sent so the next run retries. Nothing is sent when every line is gone.
+func (h *CartRecoveryHandler) sendReminder(ctx context.Context, cand cartReminderCandidate, step int) (bool, error) {
+	reminder, err := h.reminderContent(ctx, cand)
+	if err != nil || len(reminder.Items) == 0 {
+		return false, err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return false, err
+	}
+	// The row is claimed before sending: every API process runs the job, and the unique
+	// index on the reminder lets only one of them send it
+	var id int64
+	err = h.DB.QueryRow(ctx, `
+		INSERT INTO cart_recovery_emails (kind, user_id, order_id, email, anchor_at, step, token_hash)
+		VALUES ($1, $2, $3::uuid, $4, $5, $6, $7)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`, cand.kind, cand.userID, cand.orderID, cand.email, cand.anchorAt, step, hashToken(token)).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	base := strings.TrimRight(h.Cfg.WebBaseURL, "/")
+	reminder.CheckoutURL = base + "/cart?recover=" + url.QueryEscape(token)
+	reminder.UnsubscribeURL = base + "/unsubscribe?token=" + url.QueryEscape(token)
+	if err := h.Email.SendCartReminderEmail(cand.email, reminder); err != nil {
+		_, _ = h.DB.Exec(ctx, `DELETE FROM cart_recovery_emails WHERE id = $1`, id)
+		return false, err
+	}
+	return true, nil
+}
+
+// reminderContent lists the order's lines for an unpaid checkout and the priced cart otherwise
+func (h *CartRecoveryHandler) reminderContent(ctx context.Context, cand cartReminderCandidate) (email.CartReminder, error) {
+	var r email.CartReminder
+	if cand.kind == "cart" {
+		cart, err := priceCart(ctx, h.DB, cartOwner{UserID: cand.userID})
+		if err != nil {
+			return r, err
+		}
+		for _, l := range cart.Lines {
+			r.Items = append(r.Items, email.CartReminderItem{
+				Title: l.Title, Quantity: l.Quantity, Price: float64(l.UnitPriceCents) / 100,
+				ImageURL: h.imageURL(l.ImagePath),
+			})
+		}
+		r.Total = float64(cart.TotalCents) / 100
+		return r, nil
+	}
+
+	r.PendingPayment = true
+	if err := h.DB.QueryRow(ctx, `SELECT COALESCE(total_price, 0)::float8 FROM orders WHERE id = $1::uuid`, cand.orderID).Scan(&r.Total); err != nil {
+		return r, err
+	}
+	rows, err := h.DB.Query(ctx, `
+		SELECT product_title, quantity, price::float8, NULLIF(product_image_url, '')
+		FROM order_line_items
+		WHERE order_id = $1::uuid
+		ORDER BY id
+	`, cand.orderID)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item email.CartReminderItem
+		var imagePath *string
+		if err := rows.Scan(&item.Title, &item.Quantity, &item.Price, &imagePath); err != nil {
+			return r, err
+		}
+		item.ImageURL = h.imageURL(imagePath)
+		r.Items = append(r.Items, item)
+	}
+	return r, rows.Err()
+}
+
+// imageURL turns a stored media path into the public URL the cart shows
+func (h *CartRecoveryHandler) imageURL(path *string) string {
+	return (&CartHandler{ImageHelper: h.ImageHelper}).imageURL(path)
+}
+
+// attributeCartRecovery credits a paid order to the latest reminder its customer was sent
+// in the seven days before paying. It runs in the payment transaction.
+func attributeCartRecovery(ctx context.Context, tx pgx.Tx, orderID string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE cart_recovery_emails r
+		SET recovered_order_id = o.id, recovered_amount = o.total_price, recovered_at = NOW()
+		FROM orders o
+		WHERE o.id = $1::uuid
+		  AND NOT EXISTS (SELECT 1 FROM cart_recovery_emails d WHERE d.recovered_order_id = o.id)
+		  AND r.id = (
+			SELECT l.id FROM cart_recovery_emails l
+			WHERE l.recovered_order_id IS NULL
+			  AND l.sent_at > NOW() - INTERVAL '7 days'
+			  AND (l.order_id = o.id OR l.user_id = o.user_id)
+			ORDER BY l.sent_at DESC
+			LIMIT 1
+		  )
+	`, orderID)
+	return err
+}
+
+type cartReminderTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// TrackClick - POST /api/cart-reminders/click
+func (h *CartRecoveryHandler) TrackClick(c *gin.Context) {
+	var req cartReminderTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	_, err := h.DB.Exec(c.Request.Context(), `
+		UPDATE cart_recovery_emails SET clicked_at = COALESCE(clicked_at, NOW()) WHERE token_hash = $1
+	`, hashToken(req.Token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+// Unsubscribe - POST /api/email/unsubscribe
+// Stops cart reminders, or wishlist alerts, for the address the email with this token
+// was sent to.
+func (h *CartRecoveryHandler) Unsubscribe(c *gin.Context) {
+	var req cartReminderTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	list, message := cartReminderList, "You will no longer receive cart reminder emails."
+	var address string
+	err := h.DB.QueryRow(ctx, `SELECT email FROM cart_recovery_emails WHERE token_hash = $1`, hashToken(req.Token)).Scan(&address)
+	if err == pgx.ErrNoRows {
+		list, message = wishlistAlertList, "You will no longer receive wishlist alert emails."
+		address, err = wishlistDigestAddress(ctx, h.DB, req.Token)
+		if err == nil && address == "" {
+			err = pgx.ErrNoRows
+		}
+	}
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	_, err = h.DB.Exec(ctx, `
+		INSERT INTO email_opt_outs (email, list) VALUES (LOWER($1), $2)
+		ON CONFLICT (email, list) DO NOTHING
+	`, address, list)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// Report - GET /api/admin/cart-recovery
+// Reminders sent in the last ?days= (default 30) and the revenue they brought back.
+func (h *CartRecoveryHandler) Report(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return
+	}
+
+	rows, err := h.DB.Query(c.Request.Context(), `
+		SELECT kind, COUNT(*), COUNT(clicked_at), COUNT(recovered_order_id),
+		       COALESCE(SUM(recovered_amount), 0)::float8
+		FROM cart_recovery_emails
+		WHERE sent_at > NOW() - make_interval(days => $1)
+		GROUP BY kind
+		ORDER BY kind
+	`, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer rows.Close()
+
+	type kindReport struct {
+		Kind             string  `json:"kind"`
+		Sent             int     `json:"sent"`
+		Clicked          int     `json:"clicked"`
+		Recovered        int     `json:"recovered"`
+		RecoveredRevenue float64 `json:"recovered_revenue"`
+	}
+	data := []kindReport{}
+	var total kindReport
+	total.Kind = "all"
+	for rows.Next() {
+		var k kindReport
+		if err := rows.Scan(&k.Kind, &k.Sent, &k.Clicked, &k.Recovered, &k.RecoveredRevenue); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		total.Sent += k.Sent
+		total.Clicked += k.Clicked
+		total.Recovered += k.Recovered
+		total.RecoveredRevenue += k.RecoveredRevenue
+		data = append(data, k)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data, "total": total, "days": days})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDueReminderStep(t *testing.T) {
+	cadence := []time.Duration{time.Hour, 24 * time.Hour}
+	cases := []struct {
+		name     string
+		lastStep int
+		idle     time.Duration
+		wantStep int
+		wantOK   bool
+	}{
+		{"not idle long enough", -1, 30 * time.Minute, 0, false},
+		{"first reminder", -1, 2 * time.Hour, 0, true},
+		{"first already sent", 0, 5 * time.Hour, 0, false},
+		{"second reminder", 0, 25 * time.Hour, 1, true},
+		{"missed first sends only the latest", -1, 30 * time.Hour, 1, true},
+		{"cadence finished", 1, 72 * time.Hour, 0, false},
+	}
+	for _, tc := range cases {
+		step, ok := dueReminderStep(cadence, tc.lastStep, tc.idle)
+		if step != tc.wantStep || ok != tc.wantOK {
+			t.Errorf("%s: dueReminderStep = %d, %v, want %d, %v", tc.name, step, ok, tc.wantStep, tc.wantOK)
+		}
+	}
+}
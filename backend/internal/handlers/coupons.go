@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/etreasure/backend/internal/pricing"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Customers enter an offer's code on their cart. The code stays on the cart and is checked
+// again every time the cart is priced, so the reason it stopped applying (it expired, the
+// cart went under the minimum order) is shown where the customer sees the total.
+
+// Reasons a code does not apply besides the ones the pricing package reports
+const (
+	couponNotFound       = "not_found"
+	couponInactive       = "inactive"
+	couponAlreadyUsed    = "already_used"
+	couponFirstOrderOnly = "first_order_only"
+	couponEmptyCart      = "empty_cart"
+	couponBetterOffer    = "better_offer"
+)
+
+// cartCoupon is the code entered on a cart. Reason is empty while the code can apply.
+type cartCoupon struct {
+	CodeID  int64
+	Code    string
+	Offer   pricing.Offer
+	Applied bool
+	Reason  string
+}
+
+// CartCoupon is the code on a cart as shown to the customer
+type CartCoupon struct {
+	Code    string `json:"code"`
+	Applied bool   `json:"applied"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (cc *cartCoupon) response() *CartCoupon {
+	if cc == nil {
+		return nil
+	}
+	return &CartCoupon{Code: cc.Code, Applied: cc.Applied, Reason: cc.Reason, Message: couponMessage(cc.Reason, cc.Offer)}
+}
+
+// couponMessage explains a rejection reason to the customer
+func couponMessage(reason string, o pricing.Offer) string {
+	switch reason {
+	case "":
+		return ""
+	case couponNotFound:
+		return "This code does not exist."
+	case couponInactive:
+		return "This code is no longer active."
+	case pricing.ReasonNotStarted:
+		return "This code is not active yet."
+	case pricing.ReasonExpired:
+		return "This code has expired."
+	case pricing.ReasonUsageLimitReached:
+		return "This code has reached its usage limit."
+	case pricing.ReasonMinOrderNotMet:
+		return fmt.Sprintf("This code needs an order of at least ₹%.2f.", float64(o.MinimumOrderCents())/100)
+	case pricing.ReasonNotApplicable:
+		return "This code does not apply to the items in your cart."
+	case couponAlreadyUsed:
+		return "You have already used this code."
+	case couponFirstOrderOnly:
+		return "This code is only valid on your first order."
+	case couponEmptyCart:
+		return "Add something to your cart before entering a code."
+	case couponBetterOffer:
+		return "A better offer is already applied to your cart."
+	}
+	return "This code cannot be used."
+}
+
+// loadCartCoupon returns the code on the owner's cart, or nil. Reason is set when the code
+// cannot apply whatever is in the cart: it was switched off, a single-use code was used,
+// or the signed-in customer is past the per-customer limit or has ordered before. Guests
+// are checked against the customer rules when they sign in to check out.
+func loadCartCoupon(ctx context.Context, db *pgxpool.Pool, owner cartOwner) (*cartCoupon, error) {
+	ownerColumn, ownerValue := owner.key()
+	var cc cartCoupon
+	var singleUse, redeemed, active, firstOrderOnly bool
+	var perCustomerLimit *int
+	err := scanOffer(db.QueryRow(ctx, `
+		SELECT `+offerColumns+`, oc.id, oc.code, oc.single_use, oc.redeemed_at IS NOT NULL,
+		       o.is_active, o.per_customer_limit, o.first_order_only
+		FROM cart_coupons cc
+		JOIN offer_codes oc ON oc.id = cc.code_id
+		JOIN offers o ON o.id = oc.offer_id
+		WHERE cc.`+ownerColumn+` = $1
+	`, ownerValue), &cc.Offer, &cc.CodeID, &cc.Code, &singleUse, &redeemed, &active, &perCustomerLimit, &firstOrderOnly)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !active:
+		cc.Reason = couponInactive
+	case singleUse && redeemed:
+		cc.Reason = couponAlreadyUsed
+	case owner.UserID != 0:
+		cc.Reason, err = couponCustomerReason(ctx, db, cc.Offer.ID, owner.UserID, perCustomerLimit, firstOrderOnly)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cc, nil
+}
+
+// couponCustomerReason applies the offer's per-customer rules to a signed-in customer
+func couponCustomerReason(ctx context.Context, db *pgxpool.Pool, offerID string, userID int, perCustomerLimit *int, firstOrderOnly bool) (string, error) {
+	var used int
+	var ordered bool
+	err := db.QueryRow(ctx, `
+		SELECT (SELECT COUNT(*) FROM offer_redemptions WHERE offer_id = $1::uuid AND user_id = $2),
+		       EXISTS (SELECT 1 FROM orders WHERE user_id = $2 AND status IN ('paid', 'processing', 'shipped', 'delivered'))
+	`, offerID, userID).Scan(&used, &ordered)
+	if err != nil {
+		return "", err
+	}
+	if perCustomerLimit != nil && used >= *perCustomerLimit {
+		return couponAlreadyUsed, nil
+	}
+	if firstOrderOnly && ordered {
+		return couponFirstOrderOnly, nil
+	}
+	return "", nil
+}
+
+type applyCouponRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// ApplyCoupon - POST /api/cart/coupon
+// Puts a code on the cart, replacing any code already there. A code that cannot apply
+// to the cart as it is now is refused with its reason and not kept.
+func (h *CartHandler) ApplyCoupon(c *gin.Context) {
+	var req applyCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": couponMessage(couponEmptyCart, pricing.Offer{}), "reason": couponEmptyCart})
+		return
+	}
+	ctx := c.Request.Context()
+
+	var codeID int64
+	err := h.DB.QueryRow(ctx, `SELECT id FROM offer_codes WHERE UPPER(code) = UPPER($1)`, strings.TrimSpace(req.Code)).Scan(&codeID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": couponMessage(couponNotFound, pricing.Offer{}), "reason": couponNotFound})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+
+	ownerColumn, ownerValue := owner.key()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM cart_coupons WHERE `+ownerColumn+` = $1`, ownerValue); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO cart_coupons (`+ownerColumn+`, code_id) VALUES ($1, $2)`, ownerValue, codeID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+
+	cart, err := priceCart(ctx, h.DB, owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+	coupon := cart.Coupon
+	if len(cart.Lines) == 0 {
+		coupon = &cartCoupon{Reason: couponEmptyCart}
+	}
+	if coupon == nil || (coupon.Reason != "" && coupon.Reason != couponBetterOffer) {
+		_, _ = h.DB.Exec(ctx, `DELETE FROM cart_coupons WHERE `+ownerColumn+` = $1 AND code_id = $2`, ownerValue, codeID)
+		reason, offer := couponNotFound, pricing.Offer{}
+		if coupon != nil {
+			reason, offer = coupon.Reason, coupon.Offer
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": couponMessage(reason, offer), "reason": reason})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"coupon":   coupon.response(),
+		"discount": float64(cart.DiscountCents) / 100.0,
+		"total":    float64(cart.TotalCents) / 100.0,
+	})
+}
+
+// RemoveCoupon - DELETE /api/cart/coupon
+func (h *CartHandler) RemoveCoupon(c *gin.Context) {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if !owner.empty() {
+		ownerColumn, ownerValue := owner.key()
+		if _, err := h.DB.Exec(c.Request.Context(), `DELETE FROM cart_coupons WHERE `+ownerColumn+` = $1`, ownerValue); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Code removed"})
+}
@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/kv"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	emailVerifyTTL       = 24 * time.Hour
+	emailChangeTTL       = time.Hour
+	emailCodeMaxAttempts = 5
+	emailCodeResendAfter = time.Minute
+	// Storefront page handling both links
+	emailVerifyLinkPath = "/verify-email?token="
+	emailChangeLinkPath = "/verify-email?change="
+)
+
+type verifyEmailRequest struct {
+	OTP string `json:"otp" binding:"required"`
+}
+
+type verifyEmailLinkRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type changeEmailRequest struct {
+	NewEmail string `json:"newEmail" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// sendEmailVerification emails a code and link that mark the address as verified.
+// A new call replaces the previous code and link.
+func (h *AuthHandler) sendEmailVerification(ctx context.Context, userID int, email string) error {
+	otp, err := randomDigits(6)
+	if err != nil {
+		return err
+	}
+	linkToken, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	linkHash := hashToken(linkToken)
+
+	codeKey := emailVerifyKey(userID)
+	if previous, err := h.KV.Get(ctx, codeKey); err == nil {
+		if _, oldLink, ok := strings.Cut(previous, "|"); ok {
+			h.KV.Del(ctx, emailVerifyLinkKey(oldLink))
+		}
+	}
+
+	if err := h.KV.Set(ctx, codeKey, otp+"|"+linkHash, emailVerifyTTL); err != nil {
+		return err
+	}
+	if err := h.KV.Set(ctx, emailVerifyLinkKey(linkHash), fmt.Sprintf("%d|%s", userID, strings.ToLower(email)), emailVerifyTTL); err != nil {
+		return err
+	}
+	h.KV.Del(ctx, emailVerifyAttemptsKey(userID))
+
+	link := strings.TrimRight(h.Cfg.WebBaseURL, "/") + emailVerifyLinkPath + url.QueryEscape(linkToken)
+	return h.Email.SendEmailVerificationEmail(email, otp, link)
+}
+
+// ResendEmailVerification - POST /api/auth/email/resend
+func (h *AuthHandler) ResendEmailVerification(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx := context.Background()
+	email, verified, err := userEmailStatus(ctx, h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if verified {
+		c.JSON(http.StatusOK, gin.H{"message": "Email address is already verified", "emailVerified": true})
+		return
+	}
+
+	ok, err = h.KV.SetNX(ctx, fmt.Sprintf("email_verify_cooldown:%d", userID), "1", emailCodeResendAfter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "please wait a minute before requesting another email"})
+		return
+	}
+
+	if err := h.sendEmailVerification(ctx, userID, email); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", email, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// VerifyEmail - POST /api/auth/email/verify
+// The signed-in customer enters the emailed code.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req verifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	stored, ok := h.checkEmailCode(c, ctx, emailVerifyKey(userID), emailVerifyAttemptsKey(userID), req.OTP, emailVerifyTTL)
+	if !ok {
+		return
+	}
+	_, linkHash, _ := strings.Cut(stored, "|")
+	h.KV.Del(ctx, emailVerifyLinkKey(linkHash))
+
+	email, _, err := userEmailStatus(ctx, h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	h.markEmailVerified(ctx, userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified", "email": email, "emailVerified": true})
+}
+
+// VerifyEmailLink - POST /api/auth/email/verify-link
+// Works without signing in so the link can be opened on any device.
+func (h *AuthHandler) VerifyEmailLink(c *gin.Context) {
+	var req verifyEmailLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	stored, err := h.KV.GetDel(ctx, emailVerifyLinkKey(hashToken(req.Token)))
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link is invalid, expired or already used"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify link"})
+		return
+	}
+	userID, email, ok := parseUserEmail(stored)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link is invalid, expired or already used"})
+		return
+	}
+
+	// The link only verifies the address it was sent to
+	current, _, err := userEmailStatus(ctx, h.DB, userID)
+	if err != nil || !strings.EqualFold(current, email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link is invalid, expired or already used"})
+		return
+	}
+	h.KV.Del(ctx, emailVerifyKey(userID), emailVerifyAttemptsKey(userID))
+	h.markEmailVerified(ctx, userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified", "email": current, "emailVerified": true})
+}
+
+// RequestEmailChange - POST /api/auth/email/change
+// Sends a confirmation to the new address; the account keeps its current email until
+// the new one is confirmed. Accounts created through Google sign-in have no usable
+// password and must set one through forgot-password first.
+func (h *AuthHandler) RequestEmailChange(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req changeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	newEmail := strings.ToLower(strings.TrimSpace(req.NewEmail))
+
+	var currentEmail, passwordHash string
+	err := h.DB.QueryRow(ctx, `SELECT email, password_hash FROM users WHERE id = $1 AND is_active = TRUE`, userID).
+		Scan(&currentEmail, &passwordHash)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "incorrect password"})
+		return
+	}
+	if strings.EqualFold(currentEmail, newEmail) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "this is already your email address"})
+		return
+	}
+
+	var taken bool
+	if err := h.DB.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = $1)`, newEmail).Scan(&taken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "email address is already in use"})
+		return
+	}
+
+	ok, err = h.KV.SetNX(ctx, fmt.Sprintf("email_change_cooldown:%d", userID), "1", emailCodeResendAfter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start email change"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "please wait a minute before requesting another email"})
+		return
+	}
+
+	otp, err := randomDigits(6)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start email change"})
+		return
+	}
+	linkToken, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start email change"})
+		return
+	}
+	linkHash := hashToken(linkToken)
+
+	codeKey := emailChangeKey(userID)
+	if previous, err := h.KV.Get(ctx, codeKey); err == nil {
+		if parts := strings.SplitN(previous, "|", 3); len(parts) == 3 {
+			h.KV.Del(ctx, emailChangeLinkKey(parts[2]))
+		}
+	}
+
+	if err := h.KV.Set(ctx, codeKey, newEmail+"|"+otp+"|"+linkHash, emailChangeTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start email change"})
+		return
+	}
+	if err := h.KV.Set(ctx, emailChangeLinkKey(linkHash), fmt.Sprintf("%d|%s", userID, newEmail), emailChangeTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start email change"})
+		return
+	}
+	h.KV.Del(ctx, emailChangeAttemptsKey(userID))
+
+	link := strings.TrimRight(h.Cfg.WebBaseURL, "/") + emailChangeLinkPath + url.QueryEscape(linkToken)
+	if err := h.Email.SendEmailChangeConfirmationEmail(newEmail, otp, link); err != nil {
+		log.Printf("Failed to send email change confirmation to %s: %v", newEmail, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send confirmation email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "We sent a confirmation code to your new email address"})
+}
+
+// ConfirmEmailChange - POST /api/auth/email/change/confirm
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req verifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	codeKey := emailChangeKey(userID)
+	stored, err := h.KV.Get(ctx, codeKey)
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify OTP"})
+		return
+	}
+	parts := strings.SplitN(stored, "|", 3)
+	if len(parts) != 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
+		return
+	}
+	newEmail, linkHash := parts[0], parts[2]
+
+	if !h.countEmailCodeAttempt(c, ctx, codeKey, emailChangeAttemptsKey(userID), emailChangeTTL, emailChangeLinkKey(linkHash)) {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(parts[1]), []byte(strings.TrimSpace(req.OTP))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OTP"})
+		return
+	}
+	if n, err := h.KV.Del(ctx, codeKey); err != nil || n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
+		return
+	}
+	h.KV.Del(ctx, emailChangeLinkKey(linkHash), emailChangeAttemptsKey(userID))
+
+	h.applyEmailChange(c, ctx, userID, newEmail)
+}
+
+// ConfirmEmailChangeLink - POST /api/auth/email/change/confirm-link
+func (h *AuthHandler) ConfirmEmailChangeLink(c *gin.Context) {
+	var req verifyEmailLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	stored, err := h.KV.GetDel(ctx, emailChangeLinkKey(hashToken(req.Token)))
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link is invalid, expired or already used"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify link"})
+		return
+	}
+	userID, newEmail, ok := parseUserEmail(stored)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link is invalid, expired or already used"})
+		return
+	}
+	h.KV.Del(ctx, emailChangeKey(userID), emailChangeAttemptsKey(userID))
+
+	h.applyEmailChange(c, ctx, userID, newEmail)
+}
+
+// applyEmailChange switches the account to the confirmed address, signs out other
+// sessions and alerts the previous address
+func (h *AuthHandler) applyEmailChange(c *gin.Context, ctx context.Context, userID int, newEmail string) {
+	var oldEmail string
+	err := h.DB.QueryRow(ctx, `SELECT email FROM users WHERE id = $1 AND is_active = TRUE`, userID).Scan(&oldEmail)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	// Someone may have registered the address since the change was requested
+	var updatedID int
+	err = h.DB.QueryRow(ctx, `
+		UPDATE users SET email = $2, email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = $2 AND id <> $1)
+		RETURNING id
+	`, userID, newEmail).Scan(&updatedID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "email address is already in use"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change email"})
+		return
+	}
+
+	// Old verification codes were for the old address
+	h.KV.Del(ctx, emailVerifyKey(userID), emailVerifyAttemptsKey(userID))
+
+	if _, err := h.DB.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID); err != nil {
+		log.Printf("Email change: failed to revoke sessions for user %d: %v", userID, err)
+	}
+
+	go func() {
+		if err := h.Email.SendEmailChangedAlertEmail(oldEmail, newEmail); err != nil {
+			log.Printf("Failed to send email changed alert to %s: %v", oldEmail, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address changed, please sign in again", "email": newEmail, "emailVerified": true})
+}
+
+// checkEmailCode validates an "otp|linkHash" code stored under codeKey and consumes it
+func (h *AuthHandler) checkEmailCode(c *gin.Context, ctx context.Context, codeKey, attemptsKey, otp string, ttl time.Duration) (string, bool) {
+	stored, err := h.KV.Get(ctx, codeKey)
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
+		return "", false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify OTP"})
+		return "", false
+	}
+	code, linkHash, _ := strings.Cut(stored, "|")
+
+	if !h.countEmailCodeAttempt(c, ctx, codeKey, attemptsKey, ttl, emailVerifyLinkKey(linkHash)) {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(strings.TrimSpace(otp))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OTP"})
+		return "", false
+	}
+	if n, err := h.KV.Del(ctx, codeKey); err != nil || n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
+		return "", false
+	}
+	h.KV.Del(ctx, attemptsKey)
+	return stored, true
+}
+
+// countEmailCodeAttempt limits guesses; too many wrong codes discard the code and its link.
+// A guess that cannot be counted is refused.
+func (h *AuthHandler) countEmailCodeAttempt(c *gin.Context, ctx context.Context, codeKey, attemptsKey string, ttl time.Duration, linkKey string) bool {
+	attempts, err := h.KV.Incr(ctx, attemptsKey, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify OTP"})
+		return false
+	}
+	if attempts > emailCodeMaxAttempts {
+		h.KV.Del(ctx, codeKey, linkKey, attemptsKey)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, please request a new code"})
+		return false
+	}
+	return true
+}
+
+// markEmailVerified records that the user proved control of their current address
+func (h *AuthHandler) markEmailVerified(ctx context.Context, userID int) {
+	_, err := h.DB.Exec(ctx, `UPDATE users SET email_verified_at = NOW() WHERE id = $1 AND email_verified_at IS NULL`, userID)
+	if err != nil {
+		log.Printf("Failed to mark email verified for user %d: %v", userID, err)
+	}
+}
+
+// userEmailStatus returns the user's email and whether it is verified
+func userEmailStatus(ctx context.Context, db *pgxpool.Pool, userID int) (string, bool, error) {
+	var (
+		email      string
+		verifiedAt *time.Time
+	)
+	err := db.QueryRow(ctx, `SELECT email, email_verified_at FROM users WHERE id = $1`, userID).Scan(&email, &verifiedAt)
+	if err == pgx.ErrNoRows {
+		return "", false, err
+	}
+	return email, verifiedAt != nil, err
+}
+
+func parseUserEmail(value string) (int, string, bool) {
+	idPart, email, ok := strings.Cut(value, "|")
+	if !ok {
+		return 0, "", false
+	}
+	userID, err := strconv.Atoi(idPart)
+	if err != nil {
+		return 0, "", false
+	}
+	return userID, email, true
+}
+
+func emailVerifyKey(userID int) string {
+	return fmt.Sprintf("email_verify:%d", userID)
+}
+
+func emailVerifyAttemptsKey(userID int) string {
+	return fmt.Sprintf("email_verify_attempts:%d", userID)
+}
+
+func emailVerifyLinkKey(linkHash string) string {
+	return fmt.Sprintf("email_verify_link:%s", linkHash)
+}
+
+func emailChangeKey(userID int) string {
+	return fmt.Sprintf("email_change:%d", userID)
+}
+
+func emailChangeAttemptsKey(userID int) string {
+	return fmt.Sprintf("email_change_attempts:%d", userID)
+}
+
+func emailChangeLinkKey(linkHash string) string {
+	return fmt.Sprintf("email_change_link:%s", linkHash)
+}
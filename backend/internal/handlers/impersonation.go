@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// impersonationTTL keeps "view as customer" tokens short; they are never refreshed
+const impersonationTTL = 15 * time.Minute
+
+type startImpersonationRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// ImpersonationTracker implements auth.ImpersonationTracker for middleware.AuthRequired
+type ImpersonationTracker struct {
+	DB *pgxpool.Pool
+}
+
+func (t *ImpersonationTracker) ImpersonationActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	err := t.DB.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM impersonation_sessions
+			WHERE id = $1 AND ended_at IS NULL AND expires_at > NOW()
+		)
+	`, sessionID).Scan(&active)
+	return active, err
+}
+
+func (t *ImpersonationTracker) RecordImpersonatedRequest(ctx context.Context, claims *auth.Claims, method, path string, status int, clientIP string) {
+	recordAudit(ctx, t.DB, auditRecord{
+		ActorUserID:        claims.ImpersonatorID,
+		ImpersonatedUserID: claims.UserID,
+		Action:             "impersonation.request",
+		ObjectType:         "impersonation_session",
+		ObjectID:           claims.ID,
+		Details:            gin.H{"method": method, "path": path, "status": status},
+		IPAddress:          clientIP,
+	})
+}
+
+// StartImpersonation - POST /api/admin/customers/:id/impersonate
+// Mints a short-lived token that acts as the customer on the storefront. It carries
+// impersonator_id, has no permissions and is read-only outside opted-in routes.
+func (h *AuthHandler) StartImpersonation(c *gin.Context) {
+	staffID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "impersonation requires a staff user"})
+		return
+	}
+	customerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return
+	}
+	var req startImpersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required"})
+		return
+	}
+	if customerID == staffID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot impersonate yourself"})
+		return
+	}
+
+	ctx := context.Background()
+	var (
+		email    string
+		fullName *string
+		active   bool
+	)
+	err = h.DB.QueryRow(ctx, `SELECT email, full_name, is_active FROM users WHERE id = $1`, customerID).
+		Scan(&email, &fullName, &active)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load customer"})
+		return
+	}
+	if !active {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account is disabled"})
+		return
+	}
+	roles, permissions, err := loadAuthorization(ctx, h.DB, customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load customer"})
+		return
+	}
+	// Staff accounts hold permissions; acting as them would be privilege escalation
+	if len(permissions) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only customer accounts can be impersonated"})
+		return
+	}
+
+	token, sessionID, err := h.Cfg.JWTKeys.GenerateImpersonationToken(customerID, roles, staffID, impersonationTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	expiresAt := time.Now().Add(impersonationTTL)
+	reason := strings.TrimSpace(req.Reason)
+	if _, err := h.DB.Exec(ctx, `
+		INSERT INTO impersonation_sessions (id, impersonator_id, user_id, reason, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, sessionID, staffID, customerID, reason, c.ClientIP(), expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start impersonation"})
+		return
+	}
+	recordAudit(ctx, h.DB, auditRecord{
+		ActorUserID:        staffID,
+		ImpersonatedUserID: customerID,
+		Action:             "impersonation.start",
+		ObjectType:         "impersonation_session",
+		ObjectID:           sessionID,
+		Details:            gin.H{"reason": reason},
+		IPAddress:          c.ClientIP(),
+	})
+
+	name := ""
+	if fullName != nil {
+		name = *fullName
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"access_token": token,
+		"expires_in":   int(impersonationTTL.Seconds()),
+		"session_id":   sessionID,
+		"expires_at":   expiresAt,
+		"customer":     gin.H{"id": customerID, "email": email, "name": name},
+	})
+}
+
+// StopImpersonation - POST /api/auth/impersonation/stop
+// Called with the impersonation token itself, e.g. from the storefront banner.
+func (h *AuthHandler) StopImpersonation(c *gin.Context) {
+	sessionID := c.GetString("impersonation_session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not an impersonation session"})
+		return
+	}
+	staffID := c.GetInt("impersonator_id")
+	if err := h.endImpersonation(context.Background(), sessionID, staffID, c.ClientIP()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stop impersonation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+}
+
+// EndImpersonation - DELETE /api/admin/impersonations/:id
+// Lets staff end a session from the admin panel before the token expires.
+func (h *AuthHandler) EndImpersonation(c *gin.Context) {
+	staffID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "impersonation requires a staff user"})
+		return
+	}
+	err := h.endImpersonation(context.Background(), c.Param("id"), staffID, c.ClientIP())
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "impersonation session not found or already ended"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stop impersonation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+}
+
+// endImpersonation closes an open session; it returns pgx.ErrNoRows when there is none
+func (h *AuthHandler) endImpersonation(ctx context.Context, sessionID string, actorID int, clientIP string) error {
+	var customerID int
+	err := h.DB.QueryRow(ctx, `
+		UPDATE impersonation_sessions SET ended_at = NOW()
+		WHERE id = $1 AND ended_at IS NULL
+		RETURNING user_id
+	`, sessionID).Scan(&customerID)
+	if err != nil {
+		return err
+	}
+	recordAudit(ctx, h.DB, auditRecord{
+		ActorUserID:        actorID,
+		ImpersonatedUserID: customerID,
+		Action:             "impersonation.stop",
+		ObjectType:         "impersonation_session",
+		ObjectID:           sessionID,
+		IPAddress:          clientIP,
+	})
+	return nil
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Failed-login policy. Counters live in Postgres so they survive restarts and
+// keep working when Redis is unavailable.
+const (
+	// After loginDelayAfter consecutive failures each retry must wait 1s, 2s, 4s... up to loginMaxDelay
+	loginDelayAfter = 3
+	loginMaxDelay   = time.Minute
+	// From loginLockAfter failures on, the account is locked for a period doubling from loginLockBase up to loginLockMax
+	loginLockAfter = 10
+	loginLockBase  = 15 * time.Minute
+	loginLockMax   = 24 * time.Hour
+
+	// An IP with this many failures in ipFailureWindow is refused outright,
+	// whichever login route (customer or admin) it uses
+	ipFailureWindow = 15 * time.Minute
+	ipFailureLimit  = 30
+	// When failures across all IPs exceed globalFailureLimit in globalFailureWindow
+	// (credential stuffing from many addresses) the per-IP limit drops to ipFailureLimitUnderAttack
+	globalFailureWindow       = 5 * time.Minute
+	globalFailureLimit        = 300
+	ipFailureLimitUnderAttack = 10
+
+	// Attempts older than loginAttemptRetention are deleted; the rate limits above only
+	// look back minutes, the rest is kept for investigating incidents
+	loginAttemptRetention = 90 * 24 * time.Hour
+)
+
+// dummyPasswordHash is a bcrypt hash at bcrypt.DefaultCost, the cost every password is
+// stored with, of a password nobody uses. Login compares against it for addresses
+// without an account.
+const dummyPasswordHash = "$2a$10$N0m0Z3N6zTlZT5NRN07MaeuMTsjN/LQa5CicgOP2UuY22N9h/Twp."
+
+// loginDelay returns how long after the last failure the next attempt is allowed
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	return capDuration(time.Second, failures-loginDelayAfter, loginMaxDelay)
+}
+
+// lockDuration returns how long the account is locked after the given number of failures
+func lockDuration(failures int) time.Duration {
+	if failures < loginLockAfter {
+		return 0
+	}
+	return capDuration(loginLockBase, failures-loginLockAfter, loginLockMax)
+}
+
+// capDuration returns base * 2^exp, limited to max
+func capDuration(base time.Duration, exp int, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < exp && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// loginBlockedByAnomaly reports whether the IP has failed too often recently
+func (h *AuthHandler) loginBlockedByAnomaly(ctx context.Context, ip string) (bool, error) {
+	var ipFailures, globalFailures int
+	err := h.DB.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE ip_address = $1),
+		       COUNT(*) FILTER (WHERE created_at > NOW() - $3 * INTERVAL '1 second')
+		FROM login_attempts
+		WHERE success = FALSE AND created_at > NOW() - $2 * INTERVAL '1 second'
+	`, ip, int(ipFailureWindow.Seconds()), int(globalFailureWindow.Seconds())).Scan(&ipFailures, &globalFailures)
+	if err != nil {
+		return false, err
+	}
+
+	limit := ipFailureLimit
+	if globalFailures >= globalFailureLimit {
+		limit = ipFailureLimitUnderAttack
+	}
+	if ipFailures >= limit {
+		log.Printf("Login: blocked IP %s after %d failures (%d failures globally)", ip, ipFailures, globalFailures)
+		return true, nil
+	}
+	return false, nil
+}
+
+// recordLoginAttempt logs an attempt for the anomaly counters; failures to log are not fatal
+func (h *AuthHandler) recordLoginAttempt(ctx context.Context, email string, userID *int, ip string, success bool) {
+	_, err := h.DB.Exec(ctx, `
+		INSERT INTO login_attempts (email, user_id, ip_address, success)
+		VALUES ($1, $2, $3, $4)
+	`, email, userID, ip, success)
+	if err != nil {
+		log.Printf("Login: failed to record attempt for %s: %v", email, err)
+	}
+}
+
+// recordLoginFailure bumps the account's failure counter and locks it once the threshold is
+// reached. It returns the lock expiry when the account is now locked.
+func (h *AuthHandler) recordLoginFailure(ctx context.Context, userID int, email, ip string) (*time.Time, error) {
+	var failures int
+	err := h.DB.QueryRow(ctx, `
+		UPDATE users SET failed_login_count = failed_login_count + 1, last_failed_login_at = NOW()
+		WHERE id = $1
+		RETURNING failed_login_count
+	`, userID).Scan(&failures)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := lockDuration(failures)
+	if lock == 0 {
+		return nil, nil
+	}
+	lockedUntil := time.Now().Add(lock)
+	if _, err := h.DB.Exec(ctx, `UPDATE users SET locked_until = $2 WHERE id = $1`, userID, lockedUntil); err != nil {
+		return nil, err
+	}
+
+	// Notify on the first lock only; further locks before a successful login stay quiet
+	if failures == loginLockAfter && h.Email != nil {
+		go func() {
+			if err := h.Email.SendAccountLockedEmail(email, lockedUntil, ip); err != nil {
+				log.Printf("Failed to send account locked email to %s: %v", email, err)
+			}
+		}()
+	}
+	return &lockedUntil, nil
+}
+
+// unknownLoginFailures returns the failure counters of an address that has no account
+func (h *AuthHandler) unknownLoginFailures(ctx context.Context, email string) (int, *time.Time, *time.Time, error) {
+	var (
+		failures     int
+		lastFailedAt *time.Time
+		lockedUntil  *time.Time
+	)
+	err := h.DB.QueryRow(ctx, `
+		SELECT failed_login_count, last_failed_login_at, locked_until FROM unknown_login_failures WHERE email = $1
+	`, email).Scan(&failures, &lastFailedAt, &lockedUntil)
+	if err == pgx.ErrNoRows {
+		return 0, nil, nil, nil
+	}
+	return failures, lastFailedAt, lockedUntil, err
+}
+
+// recordUnknownLoginFailure is recordLoginFailure for an address that has no account.
+// Nobody is told about the lock.
+func (h *AuthHandler) recordUnknownLoginFailure(ctx context.Context, email string) (*time.Time, error) {
+	var failures int
+	err := h.DB.QueryRow(ctx, `
+		INSERT INTO unknown_login_failures (email, failed_login_count, last_failed_login_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (email) DO UPDATE SET
+			failed_login_count = unknown_login_failures.failed_login_count + 1, last_failed_login_at = NOW()
+		RETURNING failed_login_count
+	`, email).Scan(&failures)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := lockDuration(failures)
+	if lock == 0 {
+		return nil, nil
+	}
+	lockedUntil := time.Now().Add(lock)
+	if _, err := h.DB.Exec(ctx, `UPDATE unknown_login_failures SET locked_until = $2 WHERE email = $1`, email, lockedUntil); err != nil {
+		return nil, err
+	}
+	return &lockedUntil, nil
+}
+
+// PruneLoginAttempts deletes attempts past loginAttemptRetention, and the counters of
+// addresses without an account once they could no longer delay or lock anything
+func PruneLoginAttempts(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	tag, err := db.Exec(ctx, `
+		DELETE FROM login_attempts WHERE created_at < NOW() - $1 * INTERVAL '1 second'
+	`, int(loginAttemptRetention.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec(ctx, `
+		DELETE FROM unknown_login_failures
+		WHERE last_failed_login_at < NOW() - $1 * INTERVAL '1 second'
+		  AND (locked_until IS NULL OR locked_until < NOW())
+	`, int(loginLockMax.Seconds())); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RunLoginAttemptSweeper calls PruneLoginAttempts every interval until ctx is cancelled
+func RunLoginAttemptSweeper(ctx context.Context, db *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := PruneLoginAttempts(ctx, db)
+			if err != nil {
+				log.Printf("login attempts: sweep failed: %v", err)
+			} else if n > 0 {
+				log.Printf("login attempts: pruned %d old attempts", n)
+			}
+		}
+	}
+}
+
+// resetLoginFailures clears the counters after a successful login
+func (h *AuthHandler) resetLoginFailures(ctx context.Context, userID int) {
+	_, err := h.DB.Exec(ctx, `
+		UPDATE users SET failed_login_count = 0, last_failed_login_at = NULL, locked_until = NULL
+		WHERE id = $1 AND (failed_login_count > 0 OR locked_until IS NOT NULL)
+	`, userID)
+	if err != nil {
+		log.Printf("Login: failed to reset failure counter for user %d: %v", userID, err)
+	}
+}
+
+// UnlockUser - POST /api/admin/users/:id/unlock
+func (h *AuthHandler) UnlockUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	var email string
+	err = h.DB.QueryRow(context.Background(), `
+		UPDATE users SET failed_login_count = 0, last_failed_login_at = NULL, locked_until = NULL
+		WHERE id = $1
+		RETURNING email
+	`, userID).Scan(&email)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked", "id": userID, "email": email})
+}
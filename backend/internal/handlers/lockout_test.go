@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginDelayAndLockDuration(t *testing.T) {
+	cases := []struct {
+		failures int
+		delay    time.Duration
+		lock     time.Duration
+	}{
+		{0, 0, 0},
+		{2, 0, 0},
+		{3, time.Second, 0},
+		{5, 4 * time.Second, 0},
+		{9, time.Minute, 0},
+		{10, time.Minute, 15 * time.Minute},
+		{11, time.Minute, 30 * time.Minute},
+		{40, time.Minute, 24 * time.Hour},
+	}
+	for _, tc := range cases {
+		if got := loginDelay(tc.failures); got != tc.delay {
+			t.Errorf("loginDelay(%d) = %v, want %v", tc.failures, got, tc.delay)
+		}
+		if got := lockDuration(tc.failures); got != tc.lock {
+			t.Errorf("lockDuration(%d) = %v, want %v", tc.failures, got, tc.lock)
+		}
+	}
+}
+
+// Refusing an unknown address must cost as much as checking a real password
+func TestDummyPasswordHashCost(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost = %d, want %d", cost, bcrypt.DefaultCost)
+	}
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Once an offer is given codes it only applies to carts one of them was entered on, even
+// if the codes are deleted again. A shared code ("DIWALI20") can be used by anyone within
+// the offer's limits; generated codes are single use and come in named batches, e.g. one
+// per influencer campaign.
+
+const (
+	maxCodeBatch = 10000
+	// codeAlphabet leaves out characters that are easy to misread: 0/O and 1/I/L
+	codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	codeLength   = 8
+)
+
+var (
+	offerCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{2,39}$`)
+	unsafeFilename   = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+type OfferCode struct {
+	ID         int64      `json:"id"`
+	Code       string     `json:"code"`
+	SingleUse  bool       `json:"single_use"`
+	Batch      *string    `json:"batch,omitempty"`
+	RedeemedAt *time.Time `json:"redeemed_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// OfferCodeBatch summarises a batch of generated codes
+type OfferCodeBatch struct {
+	Batch     string    `json:"batch"`
+	Total     int       `json:"total"`
+	Redeemed  int       `json:"redeemed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// createOfferCodesRequest adds either one shared code or Count generated single-use codes
+type createOfferCodesRequest struct {
+	Code   string `json:"code"`
+	Count  int    `json:"count"`
+	Prefix string `json:"prefix"`
+	Batch  string `json:"batch"`
+}
+
+// generateOfferCode returns prefix followed by codeLength random characters
+func generateOfferCode(prefix string) (string, error) {
+	var b strings.Builder
+	b.WriteString(prefix)
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := 0; i < codeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+func normalizeOfferCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CreateOfferCodes - POST /api/admin/offers/:id/codes
+func (h *Handler) CreateOfferCodes(c *gin.Context) {
+	offerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req createOfferCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	code := normalizeOfferCode(req.Code)
+	prefix := normalizeOfferCode(req.Prefix)
+	batch := strings.TrimSpace(req.Batch)
+	switch {
+	case code != "":
+		if !offerCodePattern.MatchString(code) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code must be 3-40 letters, digits, - or _"})
+			return
+		}
+	case req.Count < 1 || req.Count > maxCodeBatch:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("give a code, or a count between 1 and %d", maxCodeBatch)})
+		return
+	case prefix != "" && !offerCodePattern.MatchString(prefix+"XXX"):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prefix must be letters, digits, - or _"})
+		return
+	}
+
+	tag, err := h.DB.Exec(ctx, `UPDATE offers SET requires_code = TRUE, updated_at = NOW() WHERE id = $1`, offerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "offer not found"})
+		return
+	}
+
+	if code != "" {
+		var oc OfferCode
+		err := h.DB.QueryRow(ctx, `
+			INSERT INTO offer_codes (offer_id, code) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+			RETURNING id, code, single_use, batch, redeemed_at, created_at
+		`, offerID, code).Scan(&oc.ID, &oc.Code, &oc.SingleUse, &oc.Batch, &oc.RedeemedAt, &oc.CreatedAt)
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "this code is already in use"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		c.JSON(http.StatusCreated, oc)
+		return
+	}
+
+	if batch == "" {
+		batch = time.Now().UTC().Format("batch-20060102-150405")
+	}
+
+	// Codes that collide with existing ones are skipped and drawn again
+	created := 0
+	for attempt := 0; created < req.Count && attempt < 5; attempt++ {
+		codes := make([]string, 0, req.Count-created)
+		for len(codes) < req.Count-created {
+			code, err := generateOfferCode(prefix)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate codes"})
+				return
+			}
+			codes = append(codes, code)
+		}
+		tag, err := h.DB.Exec(ctx, `
+			INSERT INTO offer_codes (offer_id, code, single_use, batch)
+			SELECT $1, code, TRUE, $2 FROM unnest($3::text[]) AS code
+			ON CONFLICT DO NOTHING
+		`, offerID, batch, codes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		created += int(tag.RowsAffected())
+	}
+	c.JSON(http.StatusCreated, gin.H{"batch": batch, "created": created})
+}
+
+// ListOfferCodes - GET /api/admin/offers/:id/codes
+// Shared codes are listed one by one, generated codes per batch.
+func (h *Handler) ListOfferCodes(c *gin.Context) {
+	offerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	rows, err := h.DB.Query(ctx, `
+		SELECT id, code, single_use, batch, redeemed_at, created_at
+		FROM offer_codes WHERE offer_id = $1 AND batch IS NULL
+		ORDER BY created_at
+	`, offerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	codes := []OfferCode{}
+	for rows.Next() {
+		var oc OfferCode
+		if err := rows.Scan(&oc.ID, &oc.Code, &oc.SingleUse, &oc.Batch, &oc.RedeemedAt, &oc.CreatedAt); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		codes = append(codes, oc)
+	}
+	rows.Close()
+
+	rows, err = h.DB.Query(ctx, `
+		SELECT batch, COUNT(*), COUNT(redeemed_at), MIN(created_at)
+		FROM offer_codes WHERE offer_id = $1 AND batch IS NOT NULL
+		GROUP BY batch
+		ORDER BY MIN(created_at)
+	`, offerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer rows.Close()
+	batches := []OfferCodeBatch{}
+	for rows.Next() {
+		var b OfferCodeBatch
+		if err := rows.Scan(&b.Batch, &b.Total, &b.Redeemed, &b.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		batches = append(batches, b)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"codes": codes, "batches": batches})
+}
+
+// ExportOfferCodes - GET /api/admin/offers/:id/codes/export?batch=
+// Downloads the offer's codes, or one batch of them, as CSV with the order each was
+// redeemed on.
+func (h *Handler) ExportOfferCodes(c *gin.Context) {
+	offerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	batch := c.Query("batch")
+
+	rows, err := h.DB.Query(c.Request.Context(), `
+		SELECT oc.code, COALESCE(oc.batch, ''), oc.single_use, oc.redeemed_at,
+		       COALESCE((SELECT o.order_number FROM offer_redemptions r JOIN orders o ON o.id = r.order_id
+		                 WHERE r.code_id = oc.id ORDER BY r.redeemed_at DESC LIMIT 1), ''),
+		       (SELECT COUNT(*) FROM offer_redemptions r WHERE r.code_id = oc.id)
+		FROM offer_codes oc
+		WHERE oc.offer_id = $1 AND ($2 = '' OR oc.batch = $2)
+		ORDER BY oc.id
+	`, offerID, batch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer rows.Close()
+
+	filename := "offer-codes-" + offerID.String()
+	if batch != "" {
+		filename += "-" + unsafeFilename.ReplaceAllString(batch, "_")
+	}
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"code", "batch", "single_use", "redeemed_at", "last_order_number", "redemptions"})
+	for rows.Next() {
+		var code, batchName, orderNumber string
+		var singleUse bool
+		var redeemedAt *time.Time
+		var redemptions int
+		if err := rows.Scan(&code, &batchName, &singleUse, &redeemedAt, &orderNumber, &redemptions); err != nil {
+			break
+		}
+		redeemed := ""
+		if redeemedAt != nil {
+			redeemed = redeemedAt.UTC().Format(time.RFC3339)
+		}
+		_ = w.Write([]string{code, batchName, strconv.FormatBool(singleUse), redeemed, orderNumber, strconv.Itoa(redemptions)})
+	}
+	w.Flush()
+}
+
+// DeleteOfferCode - DELETE /api/admin/offers/:id/codes/:codeId
+func (h *Handler) DeleteOfferCode(c *gin.Context) {
+	codeID, err := strconv.ParseInt(c.Param("codeId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code id"})
+		return
+	}
+	tag, err := h.DB.Exec(c.Request.Context(), `DELETE FROM offer_codes WHERE id = $1 AND offer_id::text = $2`, codeID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "code not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
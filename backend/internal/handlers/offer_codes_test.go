@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateOfferCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		code, err := generateOfferCode("RIYA-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(code, "RIYA-") || len(code) != len("RIYA-")+codeLength {
+			t.Fatalf("generateOfferCode = %q, want RIYA- and %d characters", code, codeLength)
+		}
+		for _, r := range strings.TrimPrefix(code, "RIYA-") {
+			if !strings.ContainsRune(codeAlphabet, r) {
+				t.Fatalf("generateOfferCode = %q: %q is not in the code alphabet", code, r)
+			}
+		}
+		if !offerCodePattern.MatchString(code) {
+			t.Fatalf("generateOfferCode = %q, which codes entered by hand would not match", code)
+		}
+		if seen[code] {
+			t.Fatalf("generateOfferCode returned %q twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestCouponMessage(t *testing.T) {
+	if got := couponMessage("", cartCoupon{}.Offer); got != "" {
+		t.Errorf("couponMessage for an applied code = %q, want none", got)
+	}
+	var cc *cartCoupon
+	if cc.response() != nil {
+		t.Error("response of no coupon should be nil")
+	}
+	cc = &cartCoupon{Code: "DIWALI20", Reason: "min_order_not_met"}
+	cc.Offer.MinOrderCents = 500000
+	if got := cc.response().Message; got != "This code needs an order of at least ₹5000.00." {
+		t.Errorf("min order message = %q", got)
+	}
+	if got := couponMessage("something_new", cc.Offer); got == "" {
+		t.Error("an unknown reason should still get a message")
+	}
+}
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/etreasure/backend/internal/pricing"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -15,46 +17,59 @@ type Offer struct {
 	ID            uuid.UUID `json:"id"`
 	Title         string    `json:"title"`
 	Description   *string   `json:"description,omitempty"`
-	DiscountType  string    `json:"discount_type"` // percentage | fixed
+	DiscountType  string    `json:"discount_type"` // percentage | fixed | bogo | tiered | bundle
 	DiscountValue float64   `json:"discount_value"`
-	AppliesTo     string    `json:"applies_to"` // all | products | categories | collections
-	AppliesToIds  []string  `json:"applies_to_ids"`
-	MinOrderAmt   *float64  `json:"min_order_amount,omitempty"`
-	UsageLimit    *int      `json:"usage_limit,omitempty"`
-	UsageCount    int       `json:"usage_count"`
-	IsActive      bool      `json:"is_active"`
-	StartsAt      time.Time `json:"starts_at"`
-	EndsAt        time.Time `json:"ends_at"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	// Rules are the settings of bogo, tiered and bundle offers
+	Rules        *pricing.Rules `json:"rules,omitempty"`
+	AppliesTo    string         `json:"applies_to"` // all | products | categories | collections
+	AppliesToIds []string       `json:"applies_to_ids"`
+	MinOrderAmt  *float64       `json:"min_order_amount,omitempty"`
+	UsageLimit   *int           `json:"usage_limit,omitempty"`
+	UsageCount   int            `json:"usage_count"`
+	// PerCustomerLimit caps how many orders one customer can use the offer on
+	PerCustomerLimit *int `json:"per_customer_limit,omitempty"`
+	FirstOrderOnly   bool `json:"first_order_only"`
+	// RequiresCode is set once the offer has codes; it then only applies when one is entered
+	RequiresCode bool      `json:"requires_code"`
+	IsActive     bool      `json:"is_active"`
+	StartsAt     time.Time `json:"starts_at"`
+	EndsAt       time.Time `json:"ends_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 type CreateOfferRequest struct {
-	Title         string   `json:"title" binding:"required"`
-	Description   *string  `json:"description,omitempty"`
-	DiscountType  string   `json:"discount_type" binding:"required,oneof=percentage fixed"`
-	DiscountValue float64  `json:"discount_value" binding:"required,min=0"`
-	AppliesTo     string   `json:"applies_to" binding:"required,oneof=all products categories collections"`
-	AppliesToIds  []string `json:"applies_to_ids"`
-	MinOrderAmt   *float64 `json:"min_order_amount,omitempty"`
-	UsageLimit    *int     `json:"usage_limit,omitempty"`
-	IsActive      bool     `json:"is_active"`
-	StartsAt      *string  `json:"starts_at" binding:"required"`
-	EndsAt        *string  `json:"ends_at" binding:"required"`
+	Title            string         `json:"title" binding:"required"`
+	Description      *string        `json:"description,omitempty"`
+	DiscountType     string         `json:"discount_type" binding:"required,oneof=percentage fixed bogo tiered bundle"`
+	DiscountValue    float64        `json:"discount_value" binding:"min=0"`
+	Rules            *pricing.Rules `json:"rules,omitempty"`
+	AppliesTo        string         `json:"applies_to" binding:"required,oneof=all products categories collections"`
+	AppliesToIds     []string       `json:"applies_to_ids"`
+	MinOrderAmt      *float64       `json:"min_order_amount,omitempty"`
+	UsageLimit       *int           `json:"usage_limit,omitempty"`
+	PerCustomerLimit *int           `json:"per_customer_limit,omitempty" binding:"omitempty,min=1"`
+	FirstOrderOnly   bool           `json:"first_order_only"`
+	IsActive         bool           `json:"is_active"`
+	StartsAt         *string        `json:"starts_at" binding:"required"`
+	EndsAt           *string        `json:"ends_at" binding:"required"`
 }
 
 type UpdateOfferRequest struct {
-	Title         *string    `json:"title,omitempty"`
-	Description   *string    `json:"description,omitempty"`
-	DiscountType  *string    `json:"discount_type,omitempty"`
-	DiscountValue *float64   `json:"discount_value,omitempty"`
-	AppliesTo     *string    `json:"applies_to,omitempty"`
-	AppliesToIds  *[]string  `json:"applies_to_ids,omitempty"`
-	MinOrderAmt   *float64   `json:"min_order_amount,omitempty"`
-	UsageLimit    *int       `json:"usage_limit,omitempty"`
-	IsActive      *bool      `json:"is_active,omitempty"`
-	StartsAt      *time.Time `json:"starts_at,omitempty"`
-	EndsAt        *time.Time `json:"ends_at,omitempty"`
+	Title            *string        `json:"title,omitempty"`
+	Description      *string        `json:"description,omitempty"`
+	DiscountType     *string        `json:"discount_type,omitempty"`
+	DiscountValue    *float64       `json:"discount_value,omitempty"`
+	Rules            *pricing.Rules `json:"rules,omitempty"`
+	AppliesTo        *string        `json:"applies_to,omitempty"`
+	AppliesToIds     *[]string      `json:"applies_to_ids,omitempty"`
+	MinOrderAmt      *float64       `json:"min_order_amount,omitempty"`
+	UsageLimit       *int           `json:"usage_limit,omitempty"`
+	PerCustomerLimit *int           `json:"per_customer_limit,omitempty" binding:"omitempty,min=1"`
+	FirstOrderOnly   *bool          `json:"first_order_only,omitempty"`
+	IsActive         *bool          `json:"is_active,omitempty"`
+	StartsAt         *time.Time     `json:"starts_at,omitempty"`
+	EndsAt           *time.Time     `json:"ends_at,omitempty"`
 }
 
 func (h *Handler) ListOffers(c *gin.Context) {
@@ -63,7 +78,7 @@ func (h *Handler) ListOffers(c *gin.Context) {
 	// Simple query without prepared statement conflicts
 	query := `
 		SELECT id, title, description, discount_type, discount_value, applies_to, applies_to_ids,
-		       min_order_amount, usage_limit, usage_count, is_active, starts_at, ends_at, created_at, updated_at
+		       min_order_amount, usage_limit, usage_count, per_customer_limit, first_order_only, requires_code, is_active, starts_at, ends_at, created_at, updated_at, rules
 		FROM offers
 		WHERE is_active = true AND starts_at <= NOW() AND ends_at >= NOW()
 		ORDER BY updated_at DESC
@@ -81,11 +96,12 @@ func (h *Handler) ListOffers(c *gin.Context) {
 	for rows.Next() {
 		var o Offer
 		var appliesToIdsStr *string
+		var rules []byte
 		err := rows.Scan(
 			&o.ID, &o.Title, &o.Description, &o.DiscountType, &o.DiscountValue,
 			&o.AppliesTo, &appliesToIdsStr, &o.MinOrderAmt, &o.UsageLimit,
-			&o.UsageCount, &o.IsActive, &o.StartsAt, &o.EndsAt,
-			&o.CreatedAt, &o.UpdatedAt,
+			&o.UsageCount, &o.PerCustomerLimit, &o.FirstOrderOnly, &o.RequiresCode, &o.IsActive, &o.StartsAt, &o.EndsAt,
+			&o.CreatedAt, &o.UpdatedAt, &rules,
 		)
 		if err != nil {
 			continue
@@ -93,6 +109,7 @@ func (h *Handler) ListOffers(c *gin.Context) {
 		if appliesToIdsStr != nil && *appliesToIdsStr != "" {
 			o.AppliesToIds = strings.Split(*appliesToIdsStr, ",")
 		}
+		o.Rules = offerRules(o.DiscountType, rules)
 		offers = append(offers, o)
 	}
 
@@ -114,9 +131,14 @@ func (h *Handler) CreateOffer(c *gin.Context) {
 		return
 	}
 
+	rulesJSON, err := validateOfferDiscount(req.DiscountType, req.DiscountValue, req.Rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Parse time strings
 	var startsAt, endsAt time.Time
-	var err error
 
 	if req.StartsAt != nil {
 		startsAt, err = time.Parse(time.RFC3339, *req.StartsAt)
@@ -145,32 +167,36 @@ func (h *Handler) CreateOffer(c *gin.Context) {
 
 	_, err = h.DB.Exec(c, `
 		INSERT INTO offers (id, title, description, discount_type, discount_value, applies_to, applies_to_ids,
-						   min_order_amount, usage_limit, usage_count, is_active, starts_at, ends_at, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14)
+						   min_order_amount, usage_limit, usage_count, is_active, starts_at, ends_at, created_at, updated_at,
+						   per_customer_limit, first_order_only, rules)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14, $15, $16, $17::jsonb)
 	`, id, req.Title, req.Description, req.DiscountType, req.DiscountValue,
 		req.AppliesTo, appliesToIdsStr, req.MinOrderAmt, req.UsageLimit, 0,
-		req.IsActive, startsAt, endsAt, now)
+		req.IsActive, startsAt, endsAt, now, req.PerCustomerLimit, req.FirstOrderOnly, rulesJSON)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	offer := Offer{
-		ID:            id,
-		Title:         req.Title,
-		Description:   req.Description,
-		DiscountType:  req.DiscountType,
-		DiscountValue: req.DiscountValue,
-		AppliesTo:     req.AppliesTo,
-		AppliesToIds:  req.AppliesToIds,
-		MinOrderAmt:   req.MinOrderAmt,
-		UsageLimit:    req.UsageLimit,
-		UsageCount:    0,
-		IsActive:      req.IsActive,
-		StartsAt:      startsAt,
-		EndsAt:        endsAt,
-		CreatedAt:     now,
-		UpdatedAt:     now,
+		ID:               id,
+		Title:            req.Title,
+		Description:      req.Description,
+		DiscountType:     req.DiscountType,
+		DiscountValue:    req.DiscountValue,
+		Rules:            offerRules(req.DiscountType, []byte(rulesJSON)),
+		AppliesTo:        req.AppliesTo,
+		AppliesToIds:     req.AppliesToIds,
+		MinOrderAmt:      req.MinOrderAmt,
+		UsageLimit:       req.UsageLimit,
+		UsageCount:       0,
+		PerCustomerLimit: req.PerCustomerLimit,
+		FirstOrderOnly:   req.FirstOrderOnly,
+		IsActive:         req.IsActive,
+		StartsAt:         startsAt,
+		EndsAt:           endsAt,
+		CreatedAt:        now,
+		UpdatedAt:        now,
 	}
 
 	c.JSON(http.StatusCreated, offer)
@@ -186,15 +212,16 @@ func (h *Handler) GetOffer(c *gin.Context) {
 
 	var o Offer
 	var appliesToIdsStr string
+	var rules []byte
 	err = h.DB.QueryRow(c, `
 		SELECT id, title, description, discount_type, discount_value, applies_to, applies_to_ids,
-			   min_order_amount, usage_limit, usage_count, is_active, starts_at, ends_at, created_at, updated_at
+			   min_order_amount, usage_limit, usage_count, per_customer_limit, first_order_only, requires_code, is_active, starts_at, ends_at, created_at, updated_at, rules
 		FROM offers
 		WHERE id = $1
 	`, id).Scan(
 		&o.ID, &o.Title, &o.Description, &o.DiscountType, &o.DiscountValue,
 		&o.AppliesTo, &appliesToIdsStr, &o.MinOrderAmt, &o.UsageLimit,
-		&o.UsageCount, &o.IsActive, &o.StartsAt, &o.EndsAt, &o.CreatedAt, &o.UpdatedAt,
+		&o.UsageCount, &o.PerCustomerLimit, &o.FirstOrderOnly, &o.RequiresCode, &o.IsActive, &o.StartsAt, &o.EndsAt, &o.CreatedAt, &o.UpdatedAt, &rules,
 	)
 	if err == pgx.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "offer not found"})
@@ -206,6 +233,7 @@ func (h *Handler) GetOffer(c *gin.Context) {
 	if appliesToIdsStr != "" {
 		o.AppliesToIds = strings.Split(appliesToIdsStr, ",")
 	}
+	o.Rules = offerRules(o.DiscountType, rules)
 
 	c.JSON(http.StatusOK, o)
 }
@@ -224,6 +252,36 @@ func (h *Handler) UpdateOffer(c *gin.Context) {
 		return
 	}
 
+	// The discount is checked as it will be after the update, so changing only the type
+	// or only the rules cannot leave the offer half configured
+	if req.DiscountType != nil || req.DiscountValue != nil || req.Rules != nil {
+		var discountType string
+		var discountValue float64
+		var rules []byte
+		err := h.DB.QueryRow(c, `SELECT discount_type, discount_value, rules FROM offers WHERE id = $1`, id).Scan(&discountType, &discountValue, &rules)
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "offer not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if req.DiscountType != nil {
+			discountType = *req.DiscountType
+		}
+		if req.DiscountValue != nil {
+			discountValue = *req.DiscountValue
+		}
+		if req.Rules == nil {
+			req.Rules = &pricing.Rules{}
+			_ = json.Unmarshal(rules, req.Rules)
+		}
+		if _, err := validateOfferDiscount(discountType, discountValue, req.Rules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	sets := []string{}
 	args := []any{1, id}
 	argIdx := 2
@@ -248,6 +306,12 @@ func (h *Handler) UpdateOffer(c *gin.Context) {
 		args = append(args, *req.DiscountValue)
 		argIdx++
 	}
+	if req.Rules != nil {
+		rulesJSON, _ := json.Marshal(req.Rules)
+		sets = append(sets, "rules = $"+strconv.Itoa(argIdx)+"::jsonb")
+		args = append(args, string(rulesJSON))
+		argIdx++
+	}
 	if req.AppliesTo != nil {
 		sets = append(sets, "applies_to = $"+strconv.Itoa(argIdx))
 		args = append(args, *req.AppliesTo)
@@ -272,6 +336,16 @@ func (h *Handler) UpdateOffer(c *gin.Context) {
 		args = append(args, *req.UsageLimit)
 		argIdx++
 	}
+	if req.PerCustomerLimit != nil {
+		sets = append(sets, "per_customer_limit = $"+strconv.Itoa(argIdx))
+		args = append(args, *req.PerCustomerLimit)
+		argIdx++
+	}
+	if req.FirstOrderOnly != nil {
+		sets = append(sets, "first_order_only = $"+strconv.Itoa(argIdx))
+		args = append(args, *req.FirstOrderOnly)
+		argIdx++
+	}
 	if req.IsActive != nil {
 		sets = append(sets, "is_active = $"+strconv.Itoa(argIdx))
 		args = append(args, *req.IsActive)
@@ -306,15 +380,16 @@ func (h *Handler) UpdateOffer(c *gin.Context) {
 
 	var o Offer
 	var appliesToIdsStr string
+	var rules []byte
 	err = h.DB.QueryRow(c, `
 		SELECT id, title, description, discount_type, discount_value, applies_to, applies_to_ids,
-			   min_order_amount, usage_limit, usage_count, is_active, starts_at, ends_at, created_at, updated_at
+			   min_order_amount, usage_limit, usage_count, per_customer_limit, first_order_only, requires_code, is_active, starts_at, ends_at, created_at, updated_at, rules
 		FROM offers
 		WHERE id = $1
 	`, id).Scan(
 		&o.ID, &o.Title, &o.Description, &o.DiscountType, &o.DiscountValue,
 		&o.AppliesTo, &appliesToIdsStr, &o.MinOrderAmt, &o.UsageLimit,
-		&o.UsageCount, &o.IsActive, &o.StartsAt, &o.EndsAt, &o.CreatedAt, &o.UpdatedAt,
+		&o.UsageCount, &o.PerCustomerLimit, &o.FirstOrderOnly, &o.RequiresCode, &o.IsActive, &o.StartsAt, &o.EndsAt, &o.CreatedAt, &o.UpdatedAt, &rules,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -323,10 +398,43 @@ func (h *Handler) UpdateOffer(c *gin.Context) {
 	if appliesToIdsStr != "" {
 		o.AppliesToIds = strings.Split(appliesToIdsStr, ",")
 	}
+	o.Rules = offerRules(o.DiscountType, rules)
 
 	c.JSON(http.StatusOK, o)
 }
 
+// validateOfferDiscount checks the discount settings of an offer and returns the rules
+// to store for it as JSON
+func validateOfferDiscount(discountType string, discountValue float64, rules *pricing.Rules) (string, error) {
+	o := pricing.Offer{DiscountType: discountType, DiscountValue: discountValue}
+	if rules != nil {
+		o.Rules = *rules
+	}
+	if err := o.Validate(); err != nil {
+		return "", err
+	}
+	rulesJSON, err := json.Marshal(o.Rules)
+	if err != nil {
+		return "", err
+	}
+	return string(rulesJSON), nil
+}
+
+// offerRules returns the stored rules of an offer of discountType, or nil for the types
+// that have none
+func offerRules(discountType string, raw []byte) *pricing.Rules {
+	switch discountType {
+	case pricing.TypeBOGO, pricing.TypeTiered, pricing.TypeBundle:
+	default:
+		return nil
+	}
+	var r pricing.Rules
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil
+	}
+	return &r
+}
+
 func (h *Handler) DeleteOffer(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
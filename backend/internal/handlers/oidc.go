@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/auth"
+	"github.com/etreasure/backend/internal/kv"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// oidcStateTTL bounds how long the customer can spend on the provider's consent screen
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie ties a sign-in's state to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+type oidcCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// StartOIDCLogin - GET /api/auth/oidc/start
+// Returns the provider URL to send the browser to. The PKCE verifier and nonce stay
+// server-side, keyed by the state the provider echoes back; the state's hash goes in an
+// HttpOnly cookie.
+func (h *AuthHandler) StartOIDCLogin(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "sign in with Google is not configured"})
+		return
+	}
+
+	ctx := context.Background()
+	state, err := randomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	verifier, challenge, err := auth.NewPKCEVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+
+	authURL, err := h.OIDC.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+		return
+	}
+	if err := h.KV.Set(ctx, oidcStateKey(state), verifier+"|"+nonce, oidcStateTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start sign-in"})
+		return
+	}
+	setOIDCStateCookie(c, hashToken(state), int(oidcStateTTL.Seconds()))
+
+	c.JSON(http.StatusOK, gin.H{"authorizationUrl": authURL, "state": state})
+}
+
+// OIDCCallback - POST /api/auth/oidc/callback
+// The storefront posts the code and state it received on the redirect URL.
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if h.OIDC == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "sign in with Google is not configured"})
+		return
+	}
+	var req oidcCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	// The state must come back to the browser that started the sign-in, so a code from
+	// someone else's sign-in cannot be planted on this one
+	cookie, err := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(hashToken(req.State))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sign-in session expired, please try again"})
+		return
+	}
+
+	ctx := context.Background()
+	// GetDel makes the state single-use
+	stored, err := h.KV.GetDel(ctx, oidcStateKey(req.State))
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sign-in session expired, please try again"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify sign-in"})
+		return
+	}
+	verifier, nonce, _ := strings.Cut(stored, "|")
+
+	rawIDToken, err := h.OIDC.Exchange(ctx, req.Code, verifier)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign-in was not accepted by the identity provider"})
+		return
+	}
+	claims, err := h.OIDC.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		log.Printf("OIDC: invalid id token: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid identity token"})
+		return
+	}
+	// Linking by email is only safe when the provider vouches for the address
+	if !claims.EmailVerified || claims.Email == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "your Google account email is not verified"})
+		return
+	}
+
+	userID, created, err := h.linkOIDCIdentity(ctx, claims)
+	if err != nil {
+		log.Printf("OIDC: failed to link %s: %v", claims.Email, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+		return
+	}
+	if userID == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is disabled"})
+		return
+	}
+
+	email := strings.ToLower(claims.Email)
+	if challenge, err := h.startTwoFactorChallenge(ctx, userID, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor challenge"})
+		return
+	} else if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	h.recordLoginAttempt(ctx, email, &userID, c.ClientIP(), true)
+	h.resetLoginFailures(ctx, userID)
+	resp, err := h.issueTokens(ctx, c, userID, false, 30*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, resp)
+}
+
+// linkOIDCIdentity resolves the provider identity to a user: an already linked
+// identity wins, then an existing account with the same email, otherwise a new
+// customer is created. It returns 0 when the matched account is disabled. An existing
+// account whose address was never verified may have been registered by someone else,
+// so it loses its password and sessions when the provider proves who owns the address.
+func (h *AuthHandler) linkOIDCIdentity(ctx context.Context, claims *auth.IDTokenClaims) (userID int, created bool, err error) {
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, created, err = linkIdentity(ctx, tx, h.OIDC.Issuer, claims)
+	if err != nil || userID == 0 {
+		return 0, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, false, err
+	}
+	return userID, created, nil
+}
+
+// linkIdentity does the work of linkOIDCIdentity inside the caller's transaction
+func linkIdentity(ctx context.Context, tx pgx.Tx, issuer string, claims *auth.IDTokenClaims) (userID int, created bool, err error) {
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+
+	var active bool
+	err = tx.QueryRow(ctx, `
+		SELECT u.id, u.is_active FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2
+	`, issuer, claims.Subject).Scan(&userID, &active)
+	switch {
+	case err == nil:
+		if _, err := tx.Exec(ctx, `
+			UPDATE user_identities SET email = $3, last_login_at = NOW()
+			WHERE issuer = $1 AND subject = $2
+		`, issuer, claims.Subject, email); err != nil {
+			return 0, false, err
+		}
+	case err == pgx.ErrNoRows:
+		var verified bool
+		err = tx.QueryRow(ctx, `
+			SELECT id, is_active, email_verified_at IS NOT NULL FROM users WHERE LOWER(email) = $1
+		`, email).Scan(&userID, &active, &verified)
+		if err == pgx.ErrNoRows {
+			userID, err = createOIDCUser(ctx, tx, email, claims.Name)
+			if err != nil {
+				return 0, false, err
+			}
+			active, created = true, true
+		} else if err != nil {
+			return 0, false, err
+		} else if !verified {
+			if err := resetUnverifiedAccount(ctx, tx, userID); err != nil {
+				return 0, false, err
+			}
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+			VALUES ($1, $2, $3, $4, NOW())
+		`, userID, issuer, claims.Subject, email); err != nil {
+			return 0, false, err
+		}
+		// The provider vouched for the address the account was matched or created with
+		if _, err := tx.Exec(ctx, `
+			UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1
+		`, userID); err != nil {
+			return 0, false, err
+		}
+	default:
+		return 0, false, err
+	}
+
+	if !active {
+		return 0, false, nil
+	}
+	return userID, created, nil
+}
+
+// resetUnverifiedAccount locks whoever registered an unverified address out of the
+// account: the password is replaced with a random one, 2FA is removed and every session
+// is revoked. The owner can set a password through forgot-password.
+func resetUnverifiedAccount(ctx context.Context, tx pgx.Tx, userID int) error {
+	password, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE users SET password_hash = $2, totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL,
+			updated_at = NOW()
+		WHERE id = $1
+	`, userID, string(hashedPassword)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	return err
+}
+
+// createOIDCUser creates a customer who signed up through the provider. The password
+// is random and never shown; they can set one through forgot-password.
+func createOIDCUser(ctx context.Context, tx pgx.Tx, email, fullName string) (int, error) {
+	password, err := randomToken(32)
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (email, password_hash, full_name, is_active, created_at)
+		VALUES ($1, $2, $3, TRUE, NOW())
+		RETURNING id
+	`, email, string(hashedPassword), fullName).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = 'customer'
+		ON CONFLICT DO NOTHING
+	`, userID)
+	return userID, err
+}
+
+// setOIDCStateCookie stores the hash of the sign-in state in the browser for the
+// callback to check. The storefront calls the API cross-site over HTTPS, which needs
+// SameSite=None; plain HTTP is local development.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	if secure {
+		c.SetSameSite(http.SameSiteNoneMode)
+	} else {
+		c.SetSameSite(http.SameSiteLaxMode)
+	}
+	c.SetCookie(oidcStateCookie, value, maxAge, "/api/auth/oidc", "", secure, true)
+}
+
+func oidcStateKey(state string) string {
+	return fmt.Sprintf("oidc_state:%s", state)
+}
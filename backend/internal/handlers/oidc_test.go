@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/etreasure/backend/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Someone who registered the address first, without verifying it, must not keep a way
+// into the account once its owner signs in with the provider
+func TestLinkIdentityResetsUnverifiedAccount(t *testing.T) {
+	ctx, tx := testDBTx(t)
+	email := fmt.Sprintf("oidc-%d@example.com", time.Now().UnixNano())
+	squatterHash, err := bcrypt.GenerateFromPassword([]byte("squatter-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID int
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id
+	`, email, string(squatterHash)).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, NOW() + INTERVAL '1 day')
+	`, userID, hashToken(email)); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &auth.IDTokenClaims{Email: email, EmailVerified: true, RegisteredClaims: jwt.RegisteredClaims{Subject: "sub-" + email}}
+	linked, created, err := linkIdentity(ctx, tx, "https://accounts.google.com", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked != userID || created {
+		t.Fatalf("linked user %d (created %v), want existing user %d", linked, created, userID)
+	}
+
+	var passwordHash string
+	var verified bool
+	var liveSessions int
+	tx.QueryRow(ctx, `SELECT password_hash, email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&passwordHash, &verified)
+	tx.QueryRow(ctx, `SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1 AND revoked_at IS NULL`, userID).Scan(&liveSessions)
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte("squatter-password")) == nil {
+		t.Error("the earlier registrant's password still works")
+	}
+	if liveSessions != 0 {
+		t.Errorf("%d sessions of the earlier registrant are still live", liveSessions)
+	}
+	if !verified {
+		t.Error("the address was not marked verified")
+	}
+}
@@ -69,6 +69,8 @@ type Order struct {
 	TrackingNumber    *string    `json:"tracking_number,omitempty"`
 	TrackingProvider  *string    `json:"tracking_provider,omitempty"`
 	EstimatedDelivery *time.Time `json:"estimated_delivery,omitempty"`
+	// Set when the order was paid after its stock hold lapsed and the stock had gone
+	OversoldAt *time.Time `json:"oversold_at,omitempty"`
 	// Metadata
 	Notes     *string         `json:"notes,omitempty"`
 	CreatedAt time.Time       `json:"created_at"`
@@ -86,6 +88,8 @@ type OrderLineItem struct {
 	Quantity  int      `json:"quantity"`
 	Price     *float64 `json:"price"`
 	Total     *float64 `json:"total"`
+	// DiscountAmount is the part of the order's offer discount that fell on this line
+	DiscountAmount float64 `json:"discount_amount"`
 }
 
 type CreateOrderRequest struct {
@@ -112,6 +116,14 @@ type UpdateOrderRequest struct {
 	Notes          *string  `json:"notes,omitempty"`
 }
 
+// UpdateOrderShippingRequest only touches fulfilment fields, so it can be granted
+// to warehouse staff who must not change prices, status or customer data.
+type UpdateOrderShippingRequest struct {
+	ShippingStatus   string  `json:"shipping_status" binding:"required"`
+	TrackingNumber   *string `json:"tracking_number,omitempty"`
+	TrackingProvider *string `json:"tracking_provider,omitempty"`
+}
+
 func (h *Handler) ListOrders(c *gin.Context) {
 	limit := 50
 	if l := c.Query("limit"); l != "" {
@@ -122,6 +134,7 @@ func (h *Handler) ListOrders(c *gin.Context) {
 
 	cursor := c.Query("cursor")
 	shippingStatus := c.Query("shipping_status")
+	oversold := c.Query("oversold") == "true"
 
 	var cursorTime time.Time
 	if cursor != "" {
@@ -149,7 +162,7 @@ func (h *Handler) ListOrders(c *gin.Context) {
 			COALESCE(customer_phone, '0000000000') as customer_phone,
 			COALESCE(payment_method, 'cod') as payment_method,
 			payment_id, razorpay_order_id, razorpay_payment_id, razorpay_signature,
-			notes, created_at, updated_at
+			notes, oversold_at, created_at, updated_at
 		FROM orders
 	`
 
@@ -168,6 +181,10 @@ func (h *Handler) ListOrders(c *gin.Context) {
 		argIdx++
 	}
 
+	if oversold {
+		whereConditions = append(whereConditions, "oversold_at IS NOT NULL")
+	}
+
 	// Build the complete query
 	query = baseSelect
 	if len(whereConditions) > 0 {
@@ -194,7 +211,7 @@ func (h *Handler) ListOrders(c *gin.Context) {
 			&o.DiscountAmount,
 			&o.CustomerName, &o.CustomerEmail, &o.CustomerPhone,
 			&o.PaymentMethod, &o.PaymentID, &o.RazorpayOrderID, &o.RazorpayPaymentID, &o.RazorpaySignature,
-			&o.Notes, &o.CreatedAt, &o.UpdatedAt,
+			&o.Notes, &o.OversoldAt, &o.CreatedAt, &o.UpdatedAt,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -571,6 +588,59 @@ func (h *Handler) UpdateOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, o)
 }
 
+// UpdateOrderShipping - PATCH /api/admin/orders/:id/shipping
+func (h *Handler) UpdateOrderShipping(c *gin.Context) {
+	id := c.Param("id")
+	var req UpdateOrderShippingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sets := []string{"shipping_status = $1"}
+	args := []any{req.ShippingStatus}
+	argIdx := 2
+
+	if req.TrackingNumber != nil {
+		sets = append(sets, "tracking_number = $"+strconv.Itoa(argIdx))
+		args = append(args, *req.TrackingNumber)
+		argIdx++
+	}
+	if req.TrackingProvider != nil {
+		sets = append(sets, "tracking_provider = $"+strconv.Itoa(argIdx))
+		args = append(args, *req.TrackingProvider)
+		argIdx++
+	}
+
+	sets = append(sets, "updated_at = NOW()")
+	query := "UPDATE orders SET " + joinString(sets, ", ") + " WHERE id = $" + strconv.Itoa(argIdx) +
+		" RETURNING shipping_status, tracking_number, tracking_provider, updated_at"
+	args = append(args, id)
+
+	var (
+		shippingStatus   *string
+		trackingNumber   *string
+		trackingProvider *string
+		updatedAt        time.Time
+	)
+	err := h.DB.QueryRow(c, query, args...).Scan(&shippingStatus, &trackingNumber, &trackingProvider, &updatedAt)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":                id,
+		"shipping_status":   shippingStatus,
+		"tracking_number":   trackingNumber,
+		"tracking_provider": trackingProvider,
+		"updated_at":        updatedAt,
+	})
+}
+
 func (h *Handler) DeleteOrder(c *gin.Context) {
 	id := c.Param("id")
 	_, err := h.DB.Exec(c, "DELETE FROM orders WHERE id = $1", id)
@@ -603,7 +673,8 @@ func (h *Handler) loadOrderLineItems(c *gin.Context, orderID string) ([]OrderLin
 
 	// Try with correct column names (price, total)
 	rows, err := h.DB.Query(c, `
-		SELECT id, order_id, product_id, variant_id, product_title, product_sku, quantity, price, total
+		SELECT id, order_id, product_id, variant_id, product_title, product_sku, quantity, price, total,
+		       COALESCE(discount_amount, 0)::float8
 		FROM order_line_items
 		WHERE order_id = $1
 	`, orderID)
@@ -621,7 +692,7 @@ func (h *Handler) loadOrderLineItems(c *gin.Context, orderID string) ([]OrderLin
 
 		// Scan with the correct column mapping (numeric columns)
 		err = rows.Scan(&li.ID, &li.OrderID, &li.ProductID, &variantID, &title, &sku,
-			&li.Quantity, &price, &total)
+			&li.Quantity, &price, &total, &li.DiscountAmount)
 		if err != nil {
 			return nil, err
 		}
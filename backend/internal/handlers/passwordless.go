@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/kv"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	loginCodeTTL         = 10 * time.Minute
+	loginCodeMaxAttempts = 5
+	loginCodeResendAfter = time.Minute
+)
+
+type requestLoginCodeRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type verifyLoginCodeRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	OTP   string `json:"otp" binding:"required"`
+}
+
+type verifyLoginLinkRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestLoginCode - POST /api/auth/passwordless/request
+// Emails a one-time code and a one-click link. The response is the same whether or
+// not the address has an account, so it cannot be used to probe for customers. Staff
+// accounts, which hold any permission, sign in with their password and second factor
+// only: inbox access alone must not be enough to reach the admin.
+func (h *AuthHandler) RequestLoginCode(c *gin.Context) {
+	var req requestLoginCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	sent := gin.H{"message": "If an account exists for this email, a sign-in code has been sent"}
+
+	// One request per minute per address, counted before looking the address up so the
+	// wait is the same for every address
+	cooldownKey := fmt.Sprintf("login_otp_cooldown:%s", email)
+	ok, err := h.KV.SetNX(ctx, cooldownKey, "1", loginCodeResendAfter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store OTP"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "please wait a minute before requesting another code"})
+		return
+	}
+
+	var userID int
+	err = h.DB.QueryRow(ctx, "SELECT id FROM users WHERE LOWER(email) = $1 AND is_active = TRUE", email).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusOK, sent)
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	_, permissions, err := loadAuthorization(ctx, h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if len(permissions) > 0 {
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+
+	otp, err := randomDigits(6)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate OTP"})
+		return
+	}
+	linkToken, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate link"})
+		return
+	}
+	linkHash := hashToken(linkToken)
+
+	// A new request replaces the previous code and link
+	if previous, err := h.KV.Get(ctx, loginCodeKey(email)); err == nil {
+		if _, oldLink, ok := strings.Cut(previous, "|"); ok {
+			h.KV.Del(ctx, loginLinkKey(oldLink))
+		}
+	}
+
+	if err := h.KV.Set(ctx, loginCodeKey(email), otp+"|"+linkHash, loginCodeTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store OTP"})
+		return
+	}
+	if err := h.KV.Set(ctx, loginLinkKey(linkHash), email, loginCodeTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store OTP"})
+		return
+	}
+	h.KV.Del(ctx, loginCodeAttemptsKey(email))
+
+	link := strings.TrimRight(h.Cfg.WebBaseURL, "/") + "/login?magic=" + url.QueryEscape(linkToken)
+	if err := h.Email.SendLoginCodeEmail(email, otp, link); err != nil {
+		// Log error but don't fail the request
+		log.Printf("Failed to send login code email: %v", err)
+	}
+
+	c.JSON(http.StatusOK, sent)
+}
+
+// VerifyLoginCode - POST /api/auth/passwordless/verify
+func (h *AuthHandler) VerifyLoginCode(c *gin.Context) {
+	var req verifyLoginCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	codeKey := loginCodeKey(email)
+	attemptsKey := loginCodeAttemptsKey(email)
+
+	stored, err := h.KV.Get(ctx, codeKey)
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify OTP"})
+		return
+	}
+	otp, linkHash, _ := strings.Cut(stored, "|")
+
+	attempts, err := h.KV.Incr(ctx, attemptsKey, loginCodeTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify OTP"})
+		return
+	}
+	if attempts > loginCodeMaxAttempts {
+		h.KV.Del(ctx, codeKey, loginLinkKey(linkHash), attemptsKey)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, please request a new code"})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(otp), []byte(strings.TrimSpace(req.OTP))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OTP"})
+		return
+	}
+
+	// Deleting the code is what makes it single-use: only one concurrent request wins
+	if n, err := h.KV.Del(ctx, codeKey); err != nil || n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP not found or expired"})
+		return
+	}
+	h.KV.Del(ctx, loginLinkKey(linkHash), attemptsKey)
+
+	h.completePasswordlessLogin(c, ctx, email)
+}
+
+// VerifyLoginLink - POST /api/auth/passwordless/verify-link
+// The storefront posts the token from the emailed link.
+func (h *AuthHandler) VerifyLoginLink(c *gin.Context) {
+	var req verifyLoginLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	linkHash := hashToken(req.Token)
+	email, err := h.KV.GetDel(ctx, loginLinkKey(linkHash))
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link is invalid, expired or already used"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify link"})
+		return
+	}
+	h.KV.Del(ctx, loginCodeKey(email), loginCodeAttemptsKey(email))
+
+	h.completePasswordlessLogin(c, ctx, email)
+}
+
+// completePasswordlessLogin issues tokens like Login does. It refuses staff accounts,
+// in case one was granted a permission after its code was sent.
+func (h *AuthHandler) completePasswordlessLogin(c *gin.Context, ctx context.Context, email string) {
+	var userID int
+	err := h.DB.QueryRow(ctx, `SELECT id FROM users WHERE LOWER(email) = $1 AND is_active = TRUE`, email).Scan(&userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	_, permissions, err := loadAuthorization(ctx, h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign in"})
+		return
+	}
+	if len(permissions) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "staff accounts must sign in with their password"})
+		return
+	}
+
+	if challenge, err := h.startTwoFactorChallenge(ctx, userID, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor challenge"})
+		return
+	} else if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	h.resetLoginFailures(ctx, userID)
+	h.markEmailVerified(ctx, userID)
+	resp, err := h.issueTokens(ctx, c, userID, false, 30*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func loginCodeKey(email string) string {
+	return fmt.Sprintf("login_otp:%s", email)
+}
+
+func loginCodeAttemptsKey(email string) string {
+	return fmt.Sprintf("login_otp_attempts:%s", email)
+}
+
+func loginLinkKey(linkHash string) string {
+	return fmt.Sprintf("login_link:%s", linkHash)
+}
+
+// randomDigits returns a numeric code of n digits from crypto/rand
+func randomDigits(n int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < n; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Permission struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+}
+
+type UpdateRolePermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+// ListPermissions - GET /api/admin/permissions
+func (h *Handler) ListPermissions(c *gin.Context) {
+	rows, err := h.DB.Query(c, `SELECT id, name, description FROM permissions ORDER BY name ASC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		permissions = append(permissions, p)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": permissions})
+}
+
+// UpdateRolePermissions - PUT /api/admin/roles/:id/permissions
+// Replaces the permissions granted to the role. Users pick up the change on their next token refresh.
+func (h *Handler) UpdateRolePermissions(c *gin.Context) {
+	id := c.Param("id")
+	var req UpdateRolePermissionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow(c, "SELECT EXISTS(SELECT 1 FROM roles WHERE id = $1)", id).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+		return
+	}
+
+	var known int
+	if err := h.DB.QueryRow(c, "SELECT COUNT(*) FROM permissions WHERE name = ANY($1)", req.Permissions).Scan(&known); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if known != len(uniqueStrings(req.Permissions)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown permission in list"})
+		return
+	}
+
+	tx, err := h.DB.Begin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start transaction"})
+		return
+	}
+	defer tx.Rollback(c)
+
+	if _, err := tx.Exec(c, "DELETE FROM role_permissions WHERE role_id = $1", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(c, `
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT $1, id FROM permissions WHERE name = ANY($2)
+		ON CONFLICT DO NOTHING
+	`, id, req.Permissions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(c); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to commit transaction"})
+		return
+	}
+
+	permissions, err := h.loadRolePermissions(c, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "permissions": permissions})
+}
+
+func (h *Handler) loadRolePermissions(c *gin.Context, roleID string) ([]string, error) {
+	rows, err := h.DB.Query(c, `
+		SELECT p.name
+		FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.id
+		WHERE rp.role_id = $1
+		ORDER BY p.name
+	`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, name)
+	}
+	return permissions, rows.Err()
+}
+
+// loadAuthorization returns the roles of a user and the permissions granted through them
+func loadAuthorization(ctx context.Context, db *pgxpool.Pool, userID int) ([]string, []string, error) {
+	var roles, permissions []string
+	err := db.QueryRow(ctx, `
+		SELECT
+			COALESCE((SELECT array_agg(r.name ORDER BY r.name)
+			          FROM roles r JOIN user_roles ur ON ur.role_id = r.id
+			          WHERE ur.user_id = $1), '{}'),
+			COALESCE((SELECT array_agg(DISTINCT p.name)
+			          FROM permissions p
+			          JOIN role_permissions rp ON rp.permission_id = p.id
+			          JOIN user_roles ur ON ur.role_id = rp.role_id
+			          WHERE ur.user_id = $1), '{}')
+	`, userID).Scan(&roles, &permissions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return roles, permissions, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return out
+}
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/etreasure/backend/internal/pricing"
 	"github.com/gin-gonic/gin"
 )
 
@@ -125,12 +128,11 @@ func renderOfferPreview(data map[string]interface{}) string {
 	discountValue := asFloat(data["discount_value"])
 	image := asString(data["image_url"])
 
-	discountText := ""
-	if discountType == "percentage" {
-		discountText = strconv.FormatFloat(discountValue, 'f', 0, 64) + "% OFF"
-	} else {
-		discountText = "$" + strconv.FormatFloat(discountValue, 'f', 2, 64) + " OFF"
+	var rules pricing.Rules
+	if raw, err := json.Marshal(data["rules"]); err == nil {
+		_ = json.Unmarshal(raw, &rules)
 	}
+	discountText := offerBadge(discountType, discountValue, rules)
 
 	var imageHtml string
 	if image != "" {
@@ -151,6 +153,33 @@ func renderOfferPreview(data map[string]interface{}) string {
 	</div>`
 }
 
+// offerBadge sums up the offer's discount in a few words, e.g. "BUY 2 GET 1 FREE"
+func offerBadge(discountType string, discountValue float64, rules pricing.Rules) string {
+	switch discountType {
+	case pricing.TypePercentage:
+		return strconv.FormatFloat(discountValue, 'f', 0, 64) + "% OFF"
+	case pricing.TypeBOGO:
+		text := "BUY " + strconv.Itoa(rules.Buy) + " GET " + strconv.Itoa(rules.Get)
+		if rules.GetPercent == 0 || rules.GetPercent == 100 {
+			return text + " FREE"
+		}
+		return text + " AT " + strconv.FormatFloat(rules.GetPercent, 'f', 0, 64) + "% OFF"
+	case pricing.TypeTiered:
+		tiers := make([]string, 0, len(rules.Tiers))
+		for _, t := range rules.Tiers {
+			tiers = append(tiers, "SPEND ₹"+strconv.FormatFloat(t.MinOrder, 'f', 0, 64)+" GET "+strconv.FormatFloat(t.Percent, 'f', 0, 64)+"% OFF")
+		}
+		return strings.Join(tiers, " · ")
+	case pricing.TypeBundle:
+		units := 0
+		for _, it := range rules.Items {
+			units += max(it.Quantity, 1)
+		}
+		return "BUNDLE OF " + strconv.Itoa(units) + " FOR ₹" + strconv.FormatFloat(rules.BundlePrice, 'f', 0, 64)
+	}
+	return "₹" + strconv.FormatFloat(discountValue, 'f', -1, 64) + " OFF"
+}
+
 func asString(v interface{}) string {
 	if s, ok := v.(string); ok {
 		return s
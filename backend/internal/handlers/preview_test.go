@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/etreasure/backend/internal/pricing"
+)
+
+func TestOfferBadge(t *testing.T) {
+	cases := []struct {
+		discountType string
+		value        float64
+		rules        pricing.Rules
+		want         string
+	}{
+		{pricing.TypePercentage, 20, pricing.Rules{}, "20% OFF"},
+		{pricing.TypeFixed, 500, pricing.Rules{}, "₹500 OFF"},
+		{pricing.TypeFixed, 49.5, pricing.Rules{}, "₹49.5 OFF"},
+		{pricing.TypeBOGO, 0, pricing.Rules{Buy: 2, Get: 1}, "BUY 2 GET 1 FREE"},
+		{pricing.TypeTiered, 0, pricing.Rules{Tiers: []pricing.Tier{{MinOrder: 2000, Percent: 10}}}, "SPEND ₹2000 GET 10% OFF"},
+	}
+	for _, tc := range cases {
+		if got := offerBadge(tc.discountType, tc.value, tc.rules); got != tc.want {
+			t.Errorf("offerBadge(%s, %v) = %q, want %q", tc.discountType, tc.value, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/pricing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Cart prices are always computed from the variant price and the active offers, never
+// taken from the client. The offer rules live in the pricing package; this file loads
+// the cart and the offers for it. GetCart and CreatePayment both use priceCart so the
+// total shown in the cart is the amount charged.
+
+// pricedCartLine is a cart line with everything needed to show it or turn it into an order line
+type pricedCartLine struct {
+	ID           int
+	ProductID    string
+	VariantID    int
+	Title        string
+	VariantTitle string
+	Options      map[string]string
+	SKU          string
+	Currency     string
+	Quantity     int
+	ImagePath    *string
+	// RegistryItemID is set on gifts bought from a gift registry
+	RegistryItemID *int64
+	RegistryName   string
+	pricing.LinePrice
+}
+
+// pricedCart totals a cart. SubtotalCents is at list price; TotalCents is what is charged.
+type pricedCart struct {
+	Lines         []pricedCartLine
+	SubtotalCents int
+	DiscountCents int
+	TotalCents    int
+	Count         int
+	// Offer is the offer applied to the cart and OfferDiscountCents what it takes off
+	Offer              *pricing.Offer
+	OfferDiscountCents int
+	// Coupon is the code entered on the cart, whether or not it applied
+	Coupon *cartCoupon
+}
+
+// offerColumns selects an offers row o for scanOffer
+const offerColumns = `
+	o.id::text, o.title, o.discount_type, o.discount_value::float8, o.applies_to,
+	COALESCE(o.applies_to_ids, ''), ROUND(COALESCE(o.min_order_amount, 0) * 100)::int,
+	o.starts_at, o.ends_at, o.usage_limit, o.usage_count, o.rules`
+
+// scanOffer reads offerColumns, followed by any extra columns into extra
+func scanOffer(row pgx.Row, o *pricing.Offer, extra ...any) error {
+	var ids string
+	var rules []byte
+	dest := []any{&o.ID, &o.Title, &o.DiscountType, &o.DiscountValue, &o.AppliesTo, &ids,
+		&o.MinOrderCents, &o.StartsAt, &o.EndsAt, &o.UsageLimit, &o.UsageCount, &rules}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	o.Rules = pricing.Rules{}
+	if err := json.Unmarshal(rules, &o.Rules); err != nil {
+		return err
+	}
+	o.AppliesToIDs = nil
+	if ids != "" {
+		o.AppliesToIDs = strings.Split(ids, ",")
+	}
+	return nil
+}
+
+// loadActiveOffers returns the offers running now that apply without a code, most
+// recently updated first
+func loadActiveOffers(ctx context.Context, db *pgxpool.Pool) ([]pricing.Offer, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+offerColumns+`
+		FROM offers o
+		WHERE o.is_active = TRUE
+		  AND (o.starts_at IS NULL OR o.starts_at <= NOW())
+		  AND (o.ends_at IS NULL OR o.ends_at > NOW())
+		  AND (o.usage_limit IS NULL OR o.usage_count < o.usage_limit)
+		  AND NOT o.requires_code
+		ORDER BY o.updated_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []pricing.Offer
+	for rows.Next() {
+		var o pricing.Offer
+		if err := scanOffer(rows, &o); err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// priceCart loads the owner's cart and prices every line. Lines saved for later are left out.
+func priceCart(ctx context.Context, db *pgxpool.Pool, owner cartOwner) (*pricedCart, error) {
+	ownerColumn, ownerValue := owner.key()
+	rows, err := db.Query(ctx, `
+		SELECT c.id, c.product_id::text, pv.id, p.title, COALESCE(pv.title, ''), pv.options,
+		       COALESCE(pv.sku, ''), COALESCE(pv.currency, 'INR'), c.quantity,
+		       (SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1),
+		       COALESCE(p.category_id::text, ''), COALESCE(p.tags, '{}'), pv.price_cents, COALESCE(pv.compare_at_price_cents, 0),
+		       c.registry_item_id, COALESCE(r.name, '')
+		FROM cart c
+		JOIN products p ON c.product_id = p.uuid_id
+		JOIN product_variants pv ON c.variant_id = pv.id
+		LEFT JOIN gift_registry_items gi ON gi.id = c.registry_item_id
+		LEFT JOIN gift_registries r ON r.id = gi.registry_id
+		WHERE c.`+ownerColumn+` = $1 AND c.saved_for_later = FALSE
+		ORDER BY c.id
+	`, ownerValue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cart := &pricedCart{Lines: []pricedCartLine{}}
+	var inputs []pricing.Line
+	for rows.Next() {
+		var l pricedCartLine
+		var in pricing.Line
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.VariantID, &l.Title, &l.VariantTitle, &l.Options,
+			&l.SKU, &l.Currency, &l.Quantity, &l.ImagePath,
+			&in.CategoryID, &in.Collections, &in.PriceCents, &in.CompareAtPriceCents, &l.RegistryItemID, &l.RegistryName); err != nil {
+			return nil, err
+		}
+		in.ProductID, in.SKU, in.Quantity = l.ProductID, l.SKU, l.Quantity
+		cart.Lines = append(cart.Lines, l)
+		inputs = append(inputs, in)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(cart.Lines) == 0 {
+		return cart, nil
+	}
+
+	offers, err := loadActiveOffers(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// A code the customer entered competes with the automatic offers, and wins a tie
+	now := time.Now()
+	coupon, err := loadCartCoupon(ctx, db, owner)
+	if err != nil {
+		return nil, err
+	}
+	if coupon != nil {
+		if coupon.Reason == "" {
+			coupon.Reason = coupon.Offer.Check(inputs, now)
+		}
+		if coupon.Reason == "" {
+			offers = append([]pricing.Offer{coupon.Offer}, offers...)
+		}
+	}
+
+	res := pricing.Evaluate(inputs, offers, now)
+	for i := range cart.Lines {
+		cart.Lines[i].LinePrice = res.Lines[i]
+		cart.Count += cart.Lines[i].Quantity
+	}
+	cart.SubtotalCents, cart.DiscountCents, cart.TotalCents = res.SubtotalCents, res.DiscountCents, res.TotalCents
+	cart.Offer, cart.OfferDiscountCents = res.Offer, res.OfferDiscountCents
+	if coupon != nil {
+		coupon.Applied = coupon.Reason == "" && res.Offer != nil && res.Offer.ID == coupon.Offer.ID
+		if coupon.Reason == "" && !coupon.Applied {
+			coupon.Reason = couponBetterOffer
+		}
+		cart.Coupon = coupon
+	}
+	return cart, nil
+}
+
+// errOfferClaimed is returned by claimOrderOffer when the order's offer or code has no
+// uses left for it. Reason is the coupon reason to show the customer.
+type errOfferClaimed struct {
+	Reason string
+}
+
+func (e *errOfferClaimed) Error() string {
+	return "offer cannot be claimed: " + e.Reason
+}
+
+// claimOrderOffer takes one use of the order's offer for it when checkout starts: the
+// usage count goes up, a single-use code is marked used and the redemption is stored, so
+// the usage limit, single-use codes and the per-customer limit hold however many
+// checkouts run at once. Updating the offer row first queues concurrent claims of the
+// same offer. It fails with errOfferClaimed when any of those is used up.
+func claimOrderOffer(ctx context.Context, tx pgx.Tx, orderID string) error {
+	return claimOffer(ctx, tx, orderID, true)
+}
+
+// redeemOrderOffer makes sure a paid order holds its claim. The claim is normally taken
+// at checkout; if it was given back because the customer paid after their hold lapsed,
+// it is taken again, and when the uses ran out meanwhile the order keeps the price it
+// was charged and only the counts are left alone.
+func redeemOrderOffer(ctx context.Context, tx pgx.Tx, orderID string) error {
+	return claimOffer(ctx, tx, orderID, false)
+}
+
+func claimOffer(ctx context.Context, tx pgx.Tx, orderID string, strict bool) error {
+	var offerID string
+	var codeID *int64
+	var userID *int
+	var discount float64
+	err := tx.QueryRow(ctx, `
+		UPDATE orders SET offer_redeemed_at = NOW()
+		WHERE id = $1::uuid AND offer_id IS NOT NULL AND offer_redeemed_at IS NULL
+		RETURNING offer_id::text, offer_code_id, user_id, COALESCE(offer_discount_amount, 0)::float8
+	`, orderID).Scan(&offerID, &codeID, &userID, &discount)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	used := func(reason, msg string) error {
+		if strict {
+			return &errOfferClaimed{Reason: reason}
+		}
+		log.Printf("offers: order %s paid after %s", orderID, msg)
+		return nil
+	}
+
+	var perCustomerLimit *int
+	err = tx.QueryRow(ctx, `
+		UPDATE offers SET usage_count = usage_count + 1
+		WHERE id = $1::uuid AND (usage_limit IS NULL OR usage_count < usage_limit)
+		RETURNING per_customer_limit
+	`, offerID).Scan(&perCustomerLimit)
+	if err == pgx.ErrNoRows {
+		if err := used(pricing.ReasonUsageLimitReached, "offer "+offerID+" reached its usage limit"); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if codeID != nil {
+		tag, err := tx.Exec(ctx, `
+			UPDATE offer_codes SET redeemed_at = NOW() WHERE id = $1 AND (NOT single_use OR redeemed_at IS NULL)
+		`, *codeID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			if err := used(couponAlreadyUsed, fmt.Sprintf("single-use code %d was already redeemed", *codeID)); err != nil {
+				return err
+			}
+		}
+	}
+	if perCustomerLimit != nil && userID != nil {
+		var n int
+		if err := tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM offer_redemptions WHERE offer_id = $1::uuid AND user_id = $2
+		`, offerID, *userID).Scan(&n); err != nil {
+			return err
+		}
+		if n >= *perCustomerLimit {
+			if err := used(couponAlreadyUsed, fmt.Sprintf("user %d reached the per-customer limit of offer %s", *userID, offerID)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO offer_redemptions (offer_id, code_id, order_id, user_id, discount_amount)
+		VALUES ($1::uuid, $2, $3::uuid, $4, $5)
+		ON CONFLICT (order_id) DO NOTHING
+	`, offerID, codeID, orderID, userID, discount)
+	return err
+}
+
+// releaseOfferClaims gives back the claims of unpaid orders, so an abandoned checkout
+// does not use up a code or a limit. filter narrows the orders, o, to look at; args are
+// its parameters. An order paid after losing its claim takes it again through
+// redeemOrderOffer.
+func releaseOfferClaims(ctx context.Context, tx pgx.Tx, filter string, args ...any) error {
+	rows, err := tx.Query(ctx, `
+		UPDATE orders o SET offer_redeemed_at = NULL
+		WHERE o.status = 'pending_payment' AND o.offer_redeemed_at IS NOT NULL
+		  AND `+filter+`
+		RETURNING o.id::text
+	`, args...)
+	if err != nil {
+		return err
+	}
+	var orderIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range orderIDs {
+		var offerID string
+		var codeID *int64
+		err := tx.QueryRow(ctx, `
+			DELETE FROM offer_redemptions WHERE order_id = $1::uuid RETURNING offer_id::text, code_id
+		`, id).Scan(&offerID, &codeID)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE offers SET usage_count = GREATEST(usage_count - 1, 0) WHERE id = $1::uuid
+		`, offerID); err != nil {
+			return err
+		}
+		if codeID != nil {
+			if _, err := tx.Exec(ctx, `
+				UPDATE offer_codes SET redeemed_at = NULL WHERE id = $1 AND single_use
+			`, *codeID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
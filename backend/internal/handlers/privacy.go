@@ -0,0 +1,548 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PrivacyHandler serves data subject requests under the DPDP Act / GDPR: a machine
+// readable export of everything we hold on a user, and erasure by anonymization.
+// Orders and their line items stay for tax and accounting; only the PII on them goes.
+type PrivacyHandler struct {
+	DB *pgxpool.Pool
+}
+
+type eraseAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+var errStaffAccount = errors.New("staff accounts cannot be erased here")
+
+// exportSection is one table of the export: export.json holds every section and
+// each one is also written as <name>.csv
+type exportSection struct {
+	Name    string
+	Columns []string
+	Rows    [][]any
+}
+
+// ExportMyData - POST /api/account/export
+// The storefront session cookie identifies the guest cart and wishlist. Staff viewing
+// as the customer cannot take the export; they have ExportCustomerData, which is audited
+// as theirs.
+func (h *PrivacyHandler) ExportMyData(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if c.GetInt("impersonator_id") != 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not allowed while impersonating a customer"})
+		return
+	}
+	sessionID, _ := c.Cookie("session_id")
+	h.export(c, userID, userID, sessionID)
+}
+
+// ExportCustomerData - POST /api/admin/customers/:id/export
+func (h *PrivacyHandler) ExportCustomerData(c *gin.Context) {
+	customerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return
+	}
+	staffID, _ := contextUserID(c)
+	h.export(c, customerID, staffID, "")
+}
+
+func (h *PrivacyHandler) export(c *gin.Context, userID, requestedBy int, sessionID string) {
+	ctx := context.Background()
+	requestID, err := h.startDataRequest(ctx, userID, requestedBy, "export")
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record request"})
+		return
+	}
+
+	sections, err := h.collectUserData(ctx, userID, sessionID)
+	if err != nil {
+		log.Printf("privacy: export for user %d failed: %v", userID, err)
+		h.finishDataRequest(ctx, requestID, "failed", nil, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export data"})
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeExportZip(&buf, sections, time.Now()); err != nil {
+		h.finishDataRequest(ctx, requestID, "failed", nil, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export data"})
+		return
+	}
+
+	counts := gin.H{}
+	for _, s := range sections {
+		counts[s.Name] = len(s.Rows)
+	}
+	h.finishDataRequest(ctx, requestID, "completed", gin.H{"rows": counts}, nil)
+
+	filename := fmt.Sprintf("etreasure-data-%d-%s.zip", userID, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// EraseMyAccount - POST /api/account/erase
+// The password is asked again so a stolen access token cannot wipe an account.
+func (h *PrivacyHandler) EraseMyAccount(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req eraseAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
+		return
+	}
+
+	ctx := context.Background()
+	var passwordHash string
+	if err := h.DB.QueryRow(ctx, `SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&passwordHash); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "incorrect password"})
+		return
+	}
+
+	sessionID, _ := c.Cookie("session_id")
+	h.erase(c, userID, userID, sessionID)
+}
+
+// EraseCustomer - POST /api/admin/customers/:id/erase
+func (h *PrivacyHandler) EraseCustomer(c *gin.Context) {
+	customerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+		return
+	}
+	staffID, _ := contextUserID(c)
+	h.erase(c, customerID, staffID, "")
+}
+
+func (h *PrivacyHandler) erase(c *gin.Context, userID, requestedBy int, sessionID string) {
+	ctx := context.Background()
+	requestID, err := h.startDataRequest(ctx, userID, requestedBy, "erasure")
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record request"})
+		return
+	}
+
+	counts, err := h.anonymizeUser(ctx, userID, sessionID)
+	if errors.Is(err, errStaffAccount) {
+		h.finishDataRequest(ctx, requestID, "rejected", nil, err)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		log.Printf("privacy: erasure for user %d failed: %v", userID, err)
+		h.finishDataRequest(ctx, requestID, "failed", nil, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to erase account"})
+		return
+	}
+	h.finishDataRequest(ctx, requestID, "completed", gin.H{"rows": counts}, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Personal data erased", "request_id": requestID})
+}
+
+// ListDataRequests - GET /api/admin/data-requests?user_id=
+func (h *PrivacyHandler) ListDataRequests(c *gin.Context) {
+	query := `
+		SELECT id, user_id, request_type, requested_by, status, details, error, created_at, completed_at
+		FROM data_requests`
+	args := []interface{}{}
+	if v := c.Query("user_id"); v != "" {
+		userID, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		query += " WHERE user_id = $1"
+		args = append(args, userID)
+	}
+	query += " ORDER BY created_at DESC LIMIT 200"
+
+	rows, err := h.DB.Query(c, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list data requests"})
+		return
+	}
+	defer rows.Close()
+
+	type dataRequest struct {
+		ID          int             `json:"id"`
+		UserID      *int            `json:"user_id"`
+		RequestType string          `json:"request_type"`
+		RequestedBy *int            `json:"requested_by"`
+		Status      string          `json:"status"`
+		Details     json.RawMessage `json:"details"`
+		Error       *string         `json:"error,omitempty"`
+		CreatedAt   time.Time       `json:"created_at"`
+		CompletedAt *time.Time      `json:"completed_at"`
+	}
+	items := []dataRequest{}
+	for rows.Next() {
+		var r dataRequest
+		if err := rows.Scan(&r.ID, &r.UserID, &r.RequestType, &r.RequestedBy, &r.Status, &r.Details, &r.Error, &r.CreatedAt, &r.CompletedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list data requests"})
+			return
+		}
+		items = append(items, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": items})
+}
+
+// startDataRequest records a pending request; it returns pgx.ErrNoRows for an unknown user
+func (h *PrivacyHandler) startDataRequest(ctx context.Context, userID, requestedBy int, requestType string) (int, error) {
+	var id int
+	err := h.DB.QueryRow(ctx, `
+		INSERT INTO data_requests (user_id, request_type, requested_by)
+		SELECT id, $2, NULLIF($3, 0) FROM users WHERE id = $1
+		RETURNING id
+	`, userID, requestType, requestedBy).Scan(&id)
+	return id, err
+}
+
+func (h *PrivacyHandler) finishDataRequest(ctx context.Context, id int, status string, details gin.H, cause error) {
+	if details == nil {
+		details = gin.H{}
+	}
+	var errText *string
+	if cause != nil {
+		s := cause.Error()
+		errText = &s
+	}
+	if _, err := h.DB.Exec(ctx, `
+		UPDATE data_requests SET status = $2, details = $3, error = $4, completed_at = NOW()
+		WHERE id = $1
+	`, id, status, details, errText); err != nil {
+		log.Printf("privacy: failed to record outcome of data request %d: %v", id, err)
+	}
+}
+
+// collectUserData gathers every table that holds the user's personal data. Guest
+// carts and wishlists are keyed by the storefront session, so those lines are only
+// included when sessionID is known.
+func (h *PrivacyHandler) collectUserData(ctx context.Context, userID int, sessionID string) ([]exportSection, error) {
+	// Guest orders, customer profiles and sign-ups are matched by email only once the
+	// account has verified it; sign-up alone does not prove the address is theirs. A nil
+	// email matches nothing.
+	address, verified, err := userEmailStatus(ctx, h.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	var email *string
+	if verified {
+		lower := strings.ToLower(address)
+		email = &lower
+	}
+
+	queries := []struct {
+		name  string
+		query string
+		args  []any
+	}{
+		{"profile", `
+			SELECT id, email, full_name, is_active, created_at, email_verified_at,
+			       totp_enabled_at IS NOT NULL AS two_factor_enabled
+			FROM users WHERE id = $1`, []any{userID}},
+		{"linked_accounts", `
+			SELECT issuer, email, created_at, last_login_at FROM user_identities WHERE user_id = $1`, []any{userID}},
+		{"customer_profile", `SELECT * FROM customers WHERE LOWER(email) = $1`, []any{email}},
+		{"addresses", `
+			SELECT a.* FROM addresses a JOIN customers cu ON cu.id = a.customer_id
+			WHERE LOWER(cu.email) = $1 ORDER BY a.created_at`, []any{email}},
+		{"orders", `
+			SELECT * FROM orders WHERE user_id = $1 OR LOWER(customer_email) = $2
+			ORDER BY created_at`, []any{userID, email}},
+		{"order_line_items", `
+			SELECT oli.* FROM order_line_items oli JOIN orders o ON o.id = oli.order_id
+			WHERE o.user_id = $1 OR LOWER(o.customer_email) = $2
+			ORDER BY o.created_at`, []any{userID, email}},
+		{"cart", `
+			SELECT c.*, p.title AS product_title FROM cart c LEFT JOIN products p ON p.uuid_id = c.product_id
+			WHERE c.user_id = $1 OR (c.session_id = $2 AND $2 <> '')
+			ORDER BY c.id`, []any{userID, sessionID}},
+		{"wishlist", `
+			SELECT w.*, p.title AS product_title FROM wishlist w LEFT JOIN products p ON p.uuid_id = w.product_id
+			WHERE w.user_id = $1 OR (w.session_id = $2 AND $2 <> '')
+			ORDER BY w.id`, []any{userID, sessionID}},
+		{"gift_registries", `
+			SELECT * FROM gift_registries WHERE user_id = $1 ORDER BY created_at`, []any{userID}},
+		{"gift_registry_items", `
+			SELECT gi.*, p.title AS product_title FROM gift_registry_items gi
+			JOIN gift_registries r ON r.id = gi.registry_id
+			LEFT JOIN products p ON p.uuid_id = gi.product_id
+			WHERE r.user_id = $1 ORDER BY gi.id`, []any{userID}},
+		{"offer_redemptions", `
+			SELECT r.order_id, o.title AS offer_title, oc.code, r.discount_amount, r.redeemed_at
+			FROM offer_redemptions r
+			JOIN offers o ON o.id = r.offer_id
+			LEFT JOIN offer_codes oc ON oc.id = r.code_id
+			WHERE r.user_id = $1 ORDER BY r.redeemed_at`, []any{userID}},
+		{"stock_notifications", `
+			SELECT * FROM stock_notifications WHERE LOWER(email) = $1 ORDER BY created_at`, []any{email}},
+		{"newsletter_subscription", `
+			SELECT email, subscribed_at, is_active FROM newsletter_subscribers WHERE LOWER(email) = $1`, []any{email}},
+	}
+
+	sections := make([]exportSection, 0, len(queries))
+	for _, q := range queries {
+		section, err := querySection(ctx, h.DB, q.name, q.query, q.args...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.name, err)
+		}
+		if q.name == "orders" {
+			hideGiftShipping(section)
+		}
+		sections = append(sections, section)
+	}
+	return sections, nil
+}
+
+// hideGiftShipping blanks the delivery details of orders bought from a gift registry:
+// they are the registrant's address, which the buyer was never shown
+func hideGiftShipping(section exportSection) {
+	gift := -1
+	var shipping []int
+	for i, col := range section.Columns {
+		switch {
+		case col == "gift_registry_id":
+			gift = i
+		case strings.HasPrefix(col, "shipping_") && col != "shipping_email" && col != "shipping_amount":
+			shipping = append(shipping, i)
+		}
+	}
+	if gift < 0 {
+		return
+	}
+	for _, row := range section.Rows {
+		if row[gift] == nil {
+			continue
+		}
+		for _, i := range shipping {
+			row[i] = nil
+		}
+	}
+}
+
+func querySection(ctx context.Context, db *pgxpool.Pool, name, query string, args ...any) (exportSection, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return exportSection{}, err
+	}
+	defer rows.Close()
+
+	section := exportSection{Name: name}
+	for _, fd := range rows.FieldDescriptions() {
+		section.Columns = append(section.Columns, fd.Name)
+	}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return exportSection{}, err
+		}
+		for i, v := range values {
+			values[i] = exportValue(v)
+		}
+		section.Rows = append(section.Rows, values)
+	}
+	return section, rows.Err()
+}
+
+// exportValue turns pgx's native types into values that read well in JSON and CSV
+func exportValue(v any) any {
+	switch v := v.(type) {
+	case [16]byte:
+		return uuid.UUID(v).String()
+	case pgtype.Numeric:
+		f, err := v.Float64Value()
+		if err != nil || !f.Valid {
+			return nil
+		}
+		return f.Float64
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+// writeExportZip writes export.json with every section plus one CSV per section
+func writeExportZip(w io.Writer, sections []exportSection, generatedAt time.Time) error {
+	zw := zip.NewWriter(w)
+
+	doc := map[string]any{"generated_at": generatedAt.UTC().Format(time.RFC3339)}
+	for _, s := range sections {
+		records := make([]map[string]any, 0, len(s.Rows))
+		for _, row := range s.Rows {
+			record := make(map[string]any, len(s.Columns))
+			for i, col := range s.Columns {
+				record[col] = row[i]
+			}
+			records = append(records, record)
+		}
+		doc[s.Name] = records
+	}
+	f, err := zw.Create("export.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	for _, s := range sections {
+		f, err := zw.Create(s.Name + ".csv")
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		if err := cw.Write(s.Columns); err != nil {
+			return err
+		}
+		for _, row := range s.Rows {
+			record := make([]string, len(row))
+			for i, v := range row {
+				record[i] = csvValue(v)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func csvValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]any, []any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// anonymizeUser erases a customer's personal data in one transaction. Orders keep
+// their amounts, payment references, items and tax region (state and country); the
+// name, contact details and street address on them are removed. The user row stays
+// so foreign keys hold, with a placeholder email and no way to sign in.
+func (h *PrivacyHandler) anonymizeUser(ctx context.Context, userID int, sessionID string) (map[string]int64, error) {
+	_, permissions, err := loadAuthorization(ctx, h.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(permissions) > 0 {
+		return nil, errStaffAccount
+	}
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// As in collectUserData, data keyed by email alone is only erased for a verified
+	// address, so an account cannot wipe the guest orders of an address it does not own
+	var email *string
+	if err := tx.QueryRow(ctx, `
+		SELECT CASE WHEN email_verified_at IS NOT NULL THEN LOWER(email) END FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&email); err != nil {
+		return nil, err
+	}
+	placeholder := fmt.Sprintf("erased-%d@erased.invalid", userID)
+
+	steps := []struct {
+		name  string
+		query string
+		args  []any
+	}{
+		{"orders", `
+			UPDATE orders SET
+				customer_name = 'Erased customer', customer_email = $3, customer_phone = NULL,
+				shipping_name = NULL, shipping_email = NULL, shipping_phone = NULL,
+				shipping_address_line1 = NULL, shipping_address_line2 = NULL,
+				shipping_city = NULL, shipping_pin_code = NULL,
+				billing_name = NULL, billing_email = NULL, billing_phone = NULL,
+				billing_address_line1 = NULL, billing_address_line2 = NULL,
+				billing_city = NULL, billing_pin_code = NULL,
+				shipping_address = NULL, billing_address = NULL, notes = NULL,
+				updated_at = NOW()
+			WHERE user_id = $1 OR LOWER(customer_email) = $2`, []any{userID, email, placeholder}},
+		// Addresses, and carts and wishlists keyed by customer, cascade with the customer row
+		{"customer_profile", `DELETE FROM customers WHERE LOWER(email) = $1`, []any{email}},
+		{"cart", `DELETE FROM cart WHERE user_id = $1 OR (session_id = $2 AND $2 <> '')`, []any{userID, sessionID}},
+		{"cart_coupons", `DELETE FROM cart_coupons WHERE user_id = $1 OR (session_id = $2 AND $2 <> '')`, []any{userID, sessionID}},
+		{"wishlist", `DELETE FROM wishlist WHERE user_id = $1 OR (session_id = $2 AND $2 <> '')`, []any{userID, sessionID}},
+		{"gift_registries", `DELETE FROM gift_registries WHERE user_id = $1`, []any{userID}},
+		{"wishlist_alerts", `DELETE FROM wishlist_alerts WHERE user_id = $1`, []any{userID}},
+		{"wishlist_alert_digests", `DELETE FROM wishlist_alert_digests WHERE user_id = $1`, []any{userID}},
+		{"stock_notifications", `DELETE FROM stock_notifications WHERE LOWER(email) = $1`, []any{email}},
+		{"newsletter_subscription", `DELETE FROM newsletter_subscribers WHERE LOWER(email) = $1`, []any{email}},
+		{"cart_recovery_emails", `UPDATE cart_recovery_emails SET email = $3 WHERE user_id = $1 OR LOWER(email) = $2`, []any{userID, email, placeholder}},
+		{"email_opt_outs", `DELETE FROM email_opt_outs WHERE LOWER(email) = $1`, []any{email}},
+		{"linked_accounts", `DELETE FROM user_identities WHERE user_id = $1`, []any{userID}},
+		{"sessions", `DELETE FROM refresh_tokens WHERE user_id = $1`, []any{userID}},
+		{"recovery_codes", `DELETE FROM user_recovery_codes WHERE user_id = $1`, []any{userID}},
+		{"login_attempts", `DELETE FROM login_attempts WHERE user_id = $1 OR LOWER(email) = $2`, []any{userID, email}},
+		{"profile", `
+			UPDATE users SET
+				email = $2, full_name = NULL, password_hash = '!', is_active = FALSE,
+				totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL,
+				email_verified_at = NULL, deleted_at = NOW(), updated_at = NOW()
+			WHERE id = $1`, []any{userID, placeholder}},
+	}
+
+	counts := make(map[string]int64, len(steps))
+	for _, s := range steps {
+		tag, err := tx.Exec(ctx, s.query, s.args...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		counts[s.name] = tag.RowsAffected()
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteExportZip(t *testing.T) {
+	sections := []exportSection{
+		{Name: "profile", Columns: []string{"id", "email"}, Rows: [][]any{{1, "a@example.com"}}},
+		{Name: "orders", Columns: []string{"order_number", "shipping_address"}, Rows: [][]any{
+			{"ET-1", map[string]any{"city": "Jaipur"}},
+			{"ET-2", nil},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := writeExportZip(&buf, sections, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		b.ReadFrom(rc)
+		rc.Close()
+		files[f.Name] = b.Bytes()
+	}
+	if len(files) != 3 {
+		t.Fatalf("files = %d, want export.json and two CSVs", len(files))
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(files["export.json"], &doc); err != nil {
+		t.Fatal(err)
+	}
+	var orders []map[string]any
+	json.Unmarshal(doc["orders"], &orders)
+	if len(orders) != 2 || orders[0]["order_number"] != "ET-1" {
+		t.Errorf("unexpected orders in export.json: %s", doc["orders"])
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(files["orders.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"order_number", "shipping_address"}, {"ET-1", `{"city":"Jaipur"}`}, {"ET-2", ""}}
+	for i := range want {
+		for j := range want[i] {
+			if records[i][j] != want[i][j] {
+				t.Errorf("orders.csv[%d][%d] = %q, want %q", i, j, records[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestHideGiftShipping(t *testing.T) {
+	var registry int64 = 4
+	section := exportSection{
+		Name:    "orders",
+		Columns: []string{"order_number", "shipping_name", "shipping_email", "shipping_city", "shipping_amount", "gift_registry_id"},
+		Rows: [][]any{
+			{"ET-1", "Riya", "buyer@example.com", "Jaipur", 99.0, nil},
+			{"ET-2", "Registrant", "buyer@example.com", "Pune", 99.0, registry},
+		},
+	}
+	hideGiftShipping(section)
+	if section.Rows[0][1] != "Riya" || section.Rows[0][3] != "Jaipur" {
+		t.Errorf("own order lost its address: %v", section.Rows[0])
+	}
+	if section.Rows[1][1] != nil || section.Rows[1][3] != nil {
+		t.Errorf("gift order still shows the registrant's address: %v", section.Rows[1])
+	}
+	if section.Rows[1][2] != "buyer@example.com" || section.Rows[1][4] != 99.0 {
+		t.Errorf("gift order lost the buyer's own details: %v", section.Rows[1])
+	}
+}
@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -81,6 +84,8 @@ type ProductVariant struct {
 	CompareAtPriceCents *int      `json:"compare_at_price_cents"`
 	Currency            string    `json:"currency"`
 	StockQuantity       int       `json:"stock_quantity"`
+	// Options are what the customer picks, e.g. {"size": "M", "colour": "Maroon"}
+	Options map[string]string `json:"options,omitempty"`
 }
 
 type ProductImage struct {
@@ -111,6 +116,37 @@ type UpsertProductRequest struct {
 	CareInstructions *string `json:"care_instructions,omitempty"`
 }
 
+// bindProductRequest reads an UpsertProductRequest and answers 400 itself when it is
+// invalid. Variant options must be non-empty text names and values, as carts and the
+// storefront read them back as map[string]string.
+func bindProductRequest(c *gin.Context, req *UpsertProductRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && strings.Contains(typeErr.Field, "options") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "variant options must map each name to a text value"})
+			return false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return false
+	}
+	if msg := variantOptionsError(req.Variants); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return false
+	}
+	return true
+}
+
+func variantOptionsError(variants []ProductVariant) string {
+	for _, v := range variants {
+		for name, value := range v.Options {
+			if strings.TrimSpace(name) == "" || strings.TrimSpace(value) == "" {
+				return fmt.Sprintf("variant %s has an option without a name or value", v.SKU)
+			}
+		}
+	}
+	return ""
+}
+
 // GET /api/admin/products
 func (h *ProductsHandler) List(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -139,8 +175,7 @@ func (h *ProductsHandler) List(c *gin.Context) {
 func (h *ProductsHandler) Create(c *gin.Context) {
 	ctx := c.Request.Context()
 	var req UpsertProductRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+	if !bindProductRequest(c, &req) {
 		return
 	}
 
@@ -159,9 +194,9 @@ func (h *ProductsHandler) Create(c *gin.Context) {
 
 	// Variants
 	for _, v := range req.Variants {
-		_, err := h.DB.Exec(ctx, `INSERT INTO product_variants (product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity)
-			VALUES ($1,$2,$3,$4,$5,$6,$7)
-			ON CONFLICT (sku) DO NOTHING`, uuidID, v.SKU, v.Title, v.PriceCents, v.CompareAtPriceCents, v.Currency, v.StockQuantity)
+		_, err := h.DB.Exec(ctx, `INSERT INTO product_variants (product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity, options)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,COALESCE($8::jsonb, '{}'))
+			ON CONFLICT (sku) DO NOTHING`, uuidID, v.SKU, v.Title, v.PriceCents, v.CompareAtPriceCents, v.Currency, v.StockQuantity, v.Options)
 		if err != nil {
 			if err == pgx.ErrNoRows {
 				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
@@ -207,13 +242,13 @@ func (h *ProductsHandler) Get(c *gin.Context) {
 		return
 	}
 	// variants
-	vrows, err := h.DB.Query(ctx, `SELECT id, product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity FROM product_variants WHERE product_id=$1 ORDER BY id`, uuidID)
+	vrows, err := h.DB.Query(ctx, `SELECT id, product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity, options FROM product_variants WHERE product_id=$1 ORDER BY id`, uuidID)
 	defer vrows.Close()
 	var variants []ProductVariant
 	for vrows.Next() {
 		var v ProductVariant
 		var cmp *int
-		if err := vrows.Scan(&v.ID, &v.ProductID, &v.SKU, &v.Title, &v.PriceCents, &cmp, &v.Currency, &v.StockQuantity); err == nil {
+		if err := vrows.Scan(&v.ID, &v.ProductID, &v.SKU, &v.Title, &v.PriceCents, &cmp, &v.Currency, &v.StockQuantity, &v.Options); err == nil {
 			v.CompareAtPriceCents = cmp
 			variants = append(variants, v)
 		}
@@ -242,8 +277,7 @@ func (h *ProductsHandler) Update(c *gin.Context) {
 		return
 	}
 	var req UpsertProductRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+	if !bindProductRequest(c, &req) {
 		return
 	}
 
@@ -267,31 +301,50 @@ func (h *ProductsHandler) Update(c *gin.Context) {
 		return
 	}
 
-	_, _ = h.DB.Exec(ctx, `DELETE FROM product_variants WHERE product_id=$1`, uuidID)
+	// Variants are updated in place, matched by id or SKU, so carts, wishlists and gift
+	// registries pointing at them survive an edit. Only variants left out are deleted.
 	var newTotalStock int
+	keep := make([]int, 0, len(req.Variants))
 	for _, v := range req.Variants {
-		_, err := h.DB.Exec(ctx, `INSERT INTO product_variants (product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity)
-			VALUES ($1,$2,$3,$4,$5,$6,$7)`, uuidID, v.SKU, v.Title, v.PriceCents, v.CompareAtPriceCents, v.Currency, v.StockQuantity)
+		var id int
+		err := pgx.ErrNoRows
+		if v.ID != 0 {
+			err = h.DB.QueryRow(ctx, `UPDATE product_variants SET sku=$3, title=$4, price_cents=$5, compare_at_price_cents=$6, currency=$7,
+				stock_quantity=$8, options=COALESCE($9::jsonb, '{}'), updated_at=NOW()
+				WHERE id=$1 AND product_id=$2 RETURNING id`,
+				v.ID, uuidID, v.SKU, v.Title, v.PriceCents, v.CompareAtPriceCents, v.Currency, v.StockQuantity, v.Options).Scan(&id)
+		}
+		if err == pgx.ErrNoRows {
+			err = h.DB.QueryRow(ctx, `INSERT INTO product_variants (product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity, options)
+				VALUES ($1,$2,$3,$4,$5,$6,$7,COALESCE($8::jsonb, '{}'))
+				ON CONFLICT (sku) DO UPDATE SET title=EXCLUDED.title, price_cents=EXCLUDED.price_cents,
+					compare_at_price_cents=EXCLUDED.compare_at_price_cents, currency=EXCLUDED.currency,
+					stock_quantity=EXCLUDED.stock_quantity, options=EXCLUDED.options, updated_at=NOW()
+				WHERE product_variants.product_id = EXCLUDED.product_id
+				RETURNING id`, uuidID, v.SKU, v.Title, v.PriceCents, v.CompareAtPriceCents, v.Currency, v.StockQuantity, v.Options).Scan(&id)
+			if err == pgx.ErrNoRows {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("sku %s belongs to another product", v.SKU)})
+				return
+			}
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "variant upsert failed"})
 			return
 		}
+		keep = append(keep, id)
 		newTotalStock += v.StockQuantity
 	}
+	if _, err := h.DB.Exec(ctx, `DELETE FROM product_variants WHERE product_id=$1 AND id <> ALL($2)`, uuidID, keep); err != nil {
+		log.Printf("products: failed to delete variants removed from %s: %v", uuidID, err)
+	}
 	_, _ = h.DB.Exec(ctx, `DELETE FROM product_images WHERE product_id=$1`, uuidID)
 	for _, img := range req.Images {
 		_, _ = h.DB.Exec(ctx, `INSERT INTO product_images (product_id, media_id, sort_order) VALUES ($1,$2,$3)`, uuidID, img.MediaID, img.SortOrder)
 	}
 
-	// Check if stock changed from 0 to >0 and send notifications
-	if currentStock == 0 && newTotalStock > 0 && h.StockNotifications != nil {
-		// Send stock notifications asynchronously using UUID
-		go func() {
-			err := h.StockNotifications.SendStockNotifications(uuidID.String(), req.Slug)
-			if err != nil {
-				log.Printf("Failed to send stock notifications for product %s: %v", uuidID.String(), err)
-			}
-		}()
+	// Notify on restocks (stock going from 0 to >0) and on wishlist price drops
+	if h.StockNotifications != nil {
+		go h.StockNotifications.ProductChanged(uuidID.String(), req.Slug, currentStock == 0 && newTotalStock > 0)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"ok": true})
@@ -754,7 +807,7 @@ func (h *ProductsHandler) PublicGet(c *gin.Context) {
 	if requested["variants"] {
 		// Fetch variants for this product
 		variantRows, err := h.DB.Query(ctx, `
-			SELECT id, product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity
+			SELECT id, product_id, sku, title, price_cents, compare_at_price_cents, currency, stock_quantity, options
 			FROM product_variants
 			WHERE product_id = $1
 			ORDER BY id
@@ -775,8 +828,9 @@ func (h *ProductsHandler) PublicGet(c *gin.Context) {
 			var compareAtPriceCents *int
 			var currency string
 			var stockQuantity int
+			var options map[string]string
 
-			if err := variantRows.Scan(&id, &productID, &sku, &title, &priceCents, &compareAtPriceCents, &currency, &stockQuantity); err == nil {
+			if err := variantRows.Scan(&id, &productID, &sku, &title, &priceCents, &compareAtPriceCents, &currency, &stockQuantity, &options); err == nil {
 				variants = append(variants, gin.H{
 					"id":                     id,
 					"product_id":             productID,
@@ -786,6 +840,7 @@ func (h *ProductsHandler) PublicGet(c *gin.Context) {
 					"compare_at_price_cents": compareAtPriceCents,
 					"currency":               currency,
 					"stock_quantity":         stockQuantity,
+					"options":                options,
 				})
 			}
 		}
@@ -1046,15 +1101,9 @@ func (h *ProductsHandler) UpdateVariantStock(c *gin.Context) {
 		return
 	}
 
-	// Check if stock changed from 0 to >0 and send notifications
-	if currentStock == 0 && req.StockQuantity > 0 && h.StockNotifications != nil {
-		// Send stock notifications asynchronously using UUID
-		go func() {
-			err := h.StockNotifications.SendStockNotifications(productID, currentSlug)
-			if err != nil {
-				log.Printf("Failed to send stock notifications for product %s: %v", productID, err)
-			}
-		}()
+	// Notify on restocks (stock going from 0 to >0) and on wishlist changes
+	if h.StockNotifications != nil {
+		go h.StockNotifications.ProductChanged(productID, currentSlug, currentStock == 0 && req.StockQuantity > 0)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "stock updated successfully", "stock_quantity": req.StockQuantity})
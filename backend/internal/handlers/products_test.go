@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindProductRequestOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name    string
+		options string
+		want    int
+	}{
+		{"text options", `{"size": "M", "colour": "Maroon"}`, http.StatusOK},
+		{"no options", `null`, http.StatusOK},
+		{"number value", `{"size": 42}`, http.StatusBadRequest},
+		{"nested value", `{"size": {"eu": "38"}}`, http.StatusBadRequest},
+		{"blank value", `{"size": " "}`, http.StatusBadRequest},
+		{"blank name", `{"": "M"}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		body := `{"slug": "saree", "title": "Saree", "variants": [{"sku": "SAR-1", "options": ` + tc.options + `}]}`
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/admin/products", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		var req UpsertProductRequest
+		ok := bindProductRequest(c, &req)
+		got := http.StatusOK
+		if !ok {
+			got = w.Code
+		}
+		if got != tc.want {
+			t.Errorf("%s: status %d, want %d (%s)", tc.name, got, tc.want, w.Body.String())
+		}
+		if tc.want == http.StatusBadRequest && !strings.Contains(w.Body.String(), "option") {
+			t.Errorf("%s: error does not mention options: %s", tc.name, w.Body.String())
+		}
+	}
+}
@@ -10,10 +10,11 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/etreasure/backend/internal/config"
+	"github.com/etreasure/backend/internal/pricing"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -72,63 +73,21 @@ func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 		log.Printf("CreatePayment: No user_id found in context - user may not be logged in")
 	}
 
-	// Compute amount from the server-side cart for this session.
-	// This keeps UI cart total and Razorpay amount consistent and avoids client-side manipulation.
-	sessionID, err := c.Cookie("session_id")
-	if err != nil || sessionID == "" {
-		sessionID = strings.TrimSpace(c.GetHeader("X-Session-Id"))
-		if sessionID == "" {
-			sessionID = fmt.Sprintf("session_%d", len(body)+len(c.Request.RemoteAddr))
-		}
-		// Set secure flag based on whether request is HTTPS
-		// Check both TLS and X-Forwarded-Proto header (for proxy setups)
-		isSecure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
-
-		// For production, set cookie domain to work across ethnictreasures.co.in
-		cookieDomain := ""
-		// Only set domain for production/remote environments, not localhost
-		isLocalhost := c.Request.Host == "localhost:8080" ||
-			c.GetHeader("Host") == "localhost:8080" ||
-			c.Request.Host == "127.0.0.1:8080" ||
-			c.GetHeader("Host") == "127.0.0.1:8080"
-
-		if !isLocalhost {
-			cookieDomain = os.Getenv("COOKIE_DOMAIN")
-			if cookieDomain == "" {
-				cookieDomain = "ethnictreasures.co.in"
-			}
-		}
-
-		// Industry standard: Set single cookie with proper attributes for cross-domain
-		// For cross-domain scenarios, we need Domain=ethnictreasures.co.in with SameSite=None; Secure
-		// For localhost scenarios, we need no domain with SameSite=Lax
-
-		var cookieString string
-		if cookieDomain != "" && isSecure {
-			// Production: Cross-domain cookie
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Domain=%s; Max-Age=%d; HttpOnly=false; SameSite=None; Secure",
-				sessionID, cookieDomain, 86400*30)
-		} else {
-			// Localhost: Simple cookie
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Max-Age=%d; HttpOnly=false; SameSite=Lax%s",
-				sessionID, 86400*30, func() string {
-					if isSecure {
-						return "; Secure"
-					}
-					return ""
-				}())
+	// Checkout always runs against the signed-in user's cart. Lines still sitting in this
+	// browser's guest cart (added before signing in) are folded in first.
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign in to check out"})
+		return
+	}
+	if sessionID, errCookie := c.Cookie("session_id"); errCookie == nil && sessionID != "" {
+		if _, err := mergeGuestCart(ctx, h.DB, sessionID, *userID); err != nil {
+			log.Printf("CreatePayment: failed to merge guest cart for user %d: %v", *userID, err)
 		}
-
-		c.Header("Set-Cookie", cookieString)
 	}
 
-	var subtotal int
-	err = h.DB.QueryRow(ctx, `
-		SELECT COALESCE(SUM(pv.price_cents * c.quantity), 0)
-		FROM cart c
-		JOIN product_variants pv ON c.variant_id = pv.id
-		WHERE c.session_id = $1
-	`, sessionID).Scan(&subtotal)
+	// Price the server-side cart with the same function GetCart uses, so the total the
+	// customer saw in the cart is exactly the amount charged.
+	cart, err := priceCart(ctx, h.DB, cartOwner{UserID: *userID})
 	if err != nil {
 		if strings.Contains(err.Error(), "does not exist") || strings.Contains(err.Error(), "relation") {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "cart is empty"})
@@ -137,7 +96,7 @@ func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load cart", "details": err.Error()})
 		return
 	}
-	if subtotal <= 0 {
+	if len(cart.Lines) == 0 || cart.TotalCents <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "cart is empty"})
 		return
 	}
@@ -145,7 +104,20 @@ func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 	// Keep tax and shipping at 0 to match the cart UI.
 	tax := 0
 	shipping := 0
-	total := subtotal
+	subtotal := cart.SubtotalCents
+	discount := cart.DiscountCents
+	total := cart.TotalCents + tax + shipping
+	var offerID *string
+	var offerCodeID *int64
+	var offerDiscount *float64
+	if cart.Offer != nil {
+		offerID = &cart.Offer.ID
+		amount := float64(cart.OfferDiscountCents) / 100.0
+		offerDiscount = &amount
+		if cart.Coupon != nil && cart.Coupon.Applied {
+			offerCodeID = &cart.Coupon.CodeID
+		}
+	}
 
 	// Extract shipping address details
 	shippingAddr := req.ShippingAddress
@@ -167,27 +139,73 @@ func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 		shippingPinCode = fmt.Sprintf("%v", val)
 	}
 
+	// The order, its lines and the stock reservations are written together: either the
+	// customer gets a hold on every item or no order is created
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// The customer's earlier unpaid checkouts keep their stock until they lapse, since
+	// their payment window may still be open, but give back their offer claims so the
+	// same code can be used on this attempt
+	if err := releaseOfferClaims(ctx, tx, `o.user_id = $1`, *userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order", "details": err.Error()})
+		return
+	}
+
+	// Gifts from a registry are checked against what the registry still wants, and ship
+	// to the registrant when they gave an address
+	gift, err := prepareGiftCheckout(ctx, tx, *userID, cart.Lines)
+	if err != nil {
+		if short, ok := err.(*insufficientStockError); ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "some gifts have already been bought by someone else", "items": short.Lines})
+			return
+		}
+		if err == errMixedGiftCart {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "registry gifts must be checked out on their own, one registry at a time"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order", "details": err.Error()})
+		return
+	}
+	var giftRegistryID *int64
+	shipName, shipPhone := req.Customer.Name, req.Customer.Phone
+	shipLine1, shipLine2, shipCity, shipState, shipPinCode := shippingAddrLine1, "", shippingCity, shippingState, shippingPinCode
+	if gift != nil {
+		giftRegistryID = &gift.RegistryID
+		if a := gift.Address; a != nil {
+			shipName, shipPhone = a.Name, a.Phone
+			shipLine1, shipLine2, shipCity, shipState, shipPinCode = a.AddressLine1, a.AddressLine2, a.City, a.State, a.PinCode
+		}
+	}
+
 	// Insert complete order with customer details
 	var orderID string
-	err = h.DB.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
     INSERT INTO orders (
         order_number, status, currency, total_price, subtotal, tax_amount, shipping_amount,
         customer_name, customer_email, customer_phone,
-        shipping_name, shipping_email, shipping_phone, shipping_address_line1,
+        shipping_name, shipping_email, shipping_phone, shipping_address_line1, shipping_address_line2,
         shipping_city, shipping_state, shipping_country, shipping_pin_code,
         billing_name, billing_email, billing_phone, billing_address_line1,
         billing_city, billing_state, billing_country, billing_pin_code,
-        payment_method, user_id
+        payment_method, user_id, discount_amount, gift_registry_id, offer_id,
+        offer_code_id, offer_discount_amount
     ) VALUES (
         gen_random_uuid()::text, 'pending_payment', 'INR', $1, $2, $3, $4,
-        $5, $6, $7, $8, $9, $10, $11, $12, $13, 'India', $14,
-        $5, $6, $7, $11, $12, $13, 'India', $14, 'razorpay', $15
+        $5, $6, $7, $8, $6, $9, $10, NULLIF($11, ''), $12, $13, 'India', $14,
+        $5, $6, $7, $15, $16, $17, 'India', $18, 'razorpay', $19, $20, $21, $22::uuid,
+        $23, $24
     ) RETURNING id
   `,
 		float64(total)/100.0, float64(subtotal)/100.0, float64(tax)/100.0, float64(shipping)/100.0,
 		req.Customer.Name, req.Customer.Email, req.Customer.Phone,
-		req.Customer.Name, req.Customer.Email, req.Customer.Phone, shippingAddrLine1,
-		shippingCity, shippingState, shippingPinCode, userID).Scan(&orderID)
+		shipName, shipPhone, shipLine1, shipLine2, shipCity, shipState, shipPinCode,
+		shippingAddrLine1, shippingCity, shippingState, shippingPinCode,
+		userID, float64(discount)/100.0, giftRegistryID, offerID, offerCodeID, offerDiscount).Scan(&orderID)
 
 	if userID != nil {
 		log.Printf("CreatePayment: Storing order with user_id: %d", *userID)
@@ -199,53 +217,58 @@ func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 		return
 	}
 
-	// Insert order line items from cart
-	rows, err := h.DB.Query(ctx, `
-		SELECT c.product_id::text, c.variant_id, c.quantity
-		FROM cart c
-		WHERE c.session_id = $1
-	`, sessionID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load cart items", "details": err.Error()})
+	// The offer's use is claimed now, not when the payment lands, so two checkouts cannot
+	// both spend the last use of a code or limit
+	if err := claimOrderOffer(ctx, tx, orderID); err != nil {
+		if claimed, ok := err.(*errOfferClaimed); ok {
+			c.JSON(http.StatusConflict, gin.H{"error": couponMessage(claimed.Reason, pricing.Offer{}), "reason": claimed.Reason})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order", "details": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var productID string
-		var variantID int
-		var qty int
-		if err := rows.Scan(&productID, &variantID, &qty); err != nil {
-			continue
+	// Insert order line items at the prices just charged, with the part of the offer's
+	// discount that fell on each
+	for _, l := range cart.Lines {
+		imagePath := ""
+		if l.ImagePath != nil {
+			imagePath = *l.ImagePath
 		}
-
-		var productTitle, productSKU, productImageURL string
-		var itemPriceCents int
-		err := h.DB.QueryRow(ctx, `
-			SELECT p.title, pv.sku,
-				   (SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1),
-				   pv.price_cents
-			FROM products p
-			JOIN product_variants pv ON p.uuid_id = pv.product_id
-			WHERE pv.id = $1
-		`, variantID).Scan(&productTitle, &productSKU, &productImageURL, &itemPriceCents)
-		if err != nil {
-			continue
-		}
-
-		_, err = h.DB.Exec(ctx, `
+		_, err = tx.Exec(ctx, `
 			INSERT INTO order_line_items (
 				order_id, product_id, variant_id, product_title, product_sku,
-				product_image_url, quantity, price, total
-			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		`, orderID, productID, variantID, productTitle, productSKU, productImageURL,
-			qty, float64(itemPriceCents)/100.0, float64(itemPriceCents*qty)/100.0)
+				product_image_url, quantity, price, total, registry_item_id, discount_amount
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, orderID, l.ProductID, l.VariantID, l.Title, l.SKU, imagePath,
+			l.Quantity, float64(l.UnitPriceCents)/100.0, float64(l.TotalCents)/100.0, l.RegistryItemID,
+			float64(l.OfferDiscountCents)/100.0)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order line item", "details": err.Error()})
 			return
 		}
 	}
 
+	if err := reserveStock(ctx, tx, orderID, *userID, cart.Lines, reservationTTL); err != nil {
+		if short, ok := err.(*insufficientStockError); ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "some items are no longer available in the requested quantity", "items": short.Lines})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reserve stock", "details": err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
+		return
+	}
+
+	// If the payment cannot be started the hold is given back straight away
+	releaseHold := func() {
+		if err := releaseOrderReservations(ctx, h.DB, orderID); err != nil {
+			log.Printf("CreatePayment: failed to release stock for order %s: %v", orderID, err)
+		}
+	}
+
 	// Create Razorpay order via REST
 	client := &http.Client{}
 	payload := map[string]any{
@@ -257,6 +280,7 @@ func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 	body, _ = json.Marshal(payload)
 	reqHTTP, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.razorpay.com/v1/orders", bytes.NewReader(body))
 	if err != nil {
+		releaseHold()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to init payment"})
 		return
 	}
@@ -265,28 +289,42 @@ func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 
 	resp, err := client.Do(reqHTTP)
 	if err != nil {
+		releaseHold()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create payment"})
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		releaseHold()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "payment gateway error"})
 		return
 	}
 
 	var rpResp razorpayOrderResponse
 	if err := json.NewDecoder(resp.Body).Decode(&rpResp); err != nil {
+		releaseHold()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid payment response"})
 		return
 	}
 
+	// VerifyPayment only accepts a signature for the Razorpay order created here, so a
+	// payment for a cheaper checkout cannot be replayed against this one
+	if _, err := h.DB.Exec(ctx, `
+		UPDATE orders SET razorpay_order_id = $2, updated_at = NOW() WHERE id = $1
+	`, orderID, rpResp.ID); err != nil {
+		releaseHold()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create payment"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"order_id":          orderID,
 		"razorpay_order_id": rpResp.ID,
 		"amount":            total,
 		"currency":          "INR",
 		"key":               h.Cfg.RazorpayKeyID,
+		"reserved_until":    time.Now().Add(reservationTTL),
 	})
 }
 
@@ -344,7 +382,7 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
 		log.Printf("VerifyPayment: No user_id found in context - user may not be logged in")
 	}
 
-	// Start transaction for stock management
+	// Start transaction for the order and its stock
 	tx, err := h.DB.Begin(ctx)
 	if err != nil {
 		log.Printf("VerifyPayment: Failed to start transaction - %v", err)
@@ -355,22 +393,28 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
 
 	log.Printf("VerifyPayment: Transaction started successfully")
 
-	// Mark order as paid and store payment details
-	_, err = tx.Exec(ctx, `
+	// Mark order as paid and store payment details. The signature only vouches for the
+	// Razorpay order, so it must be the one created for this order, and an order is
+	// marked paid once.
+	tag, err := tx.Exec(ctx, `
     UPDATE orders SET 
         status = 'paid', 
         updated_at = NOW(),
-        razorpay_order_id = $2,
         razorpay_payment_id = $3,
         razorpay_signature = $4,
         user_id = $5
-    WHERE id = $1
+    WHERE id = $1 AND razorpay_order_id = $2 AND status = 'pending_payment'
   `, req.OrderID, req.RazorpayOrderID, req.RazorpayPaymentID, req.RazorpaySignature, userID)
 	if err != nil {
 		log.Printf("VerifyPayment: Failed to update order - %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
 		return
 	}
+	if tag.RowsAffected() == 0 {
+		log.Printf("VerifyPayment: Order %s is not awaiting payment for razorpay_order_id %s", req.OrderID, req.RazorpayOrderID)
+		c.JSON(http.StatusConflict, gin.H{"error": "order is not awaiting this payment"})
+		return
+	}
 
 	if userID != nil {
 		log.Printf("VerifyPayment: Updated order %s with user_id: %d", req.OrderID, *userID)
@@ -380,9 +424,27 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
 
 	log.Printf("VerifyPayment: Order %s updated to paid status", req.OrderID)
 
-	// Stock management removed from payment verification
-	// Stock is now only managed when adding items to cart
-	log.Printf("VerifyPayment: Skipping stock deduction - stock managed at cart level")
+	// The stock held at checkout becomes a sale
+	if err := convertReservations(ctx, tx, req.OrderID); err != nil {
+		log.Printf("VerifyPayment: Failed to deduct stock for order %s - %v", req.OrderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update stock"})
+		return
+	}
+
+	// The order keeps the offer use it claimed at checkout, or claims it again if its hold
+	// lapsed before the payment landed
+	if err := redeemOrderOffer(ctx, tx, req.OrderID); err != nil {
+		log.Printf("VerifyPayment: Failed to count offer usage for order %s - %v", req.OrderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
+		return
+	}
+
+	// Credit the payment to a cart reminder that brought the customer back
+	if err := attributeCartRecovery(ctx, tx, req.OrderID); err != nil {
+		log.Printf("VerifyPayment: Failed to attribute cart recovery for order %s - %v", req.OrderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
+		return
+	}
 
 	// Commit transaction
 	if err := tx.Commit(ctx); err != nil {
@@ -393,10 +455,14 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
 
 	log.Printf("VerifyPayment: Transaction committed successfully for order %s", req.OrderID)
 
-	// Clear cart after successful payment (session-based cart)
+	// Clear cart after successful payment
+	if userID != nil {
+		_, _ = h.DB.Exec(ctx, `DELETE FROM cart WHERE user_id = $1 AND saved_for_later = FALSE`, *userID)
+		_, _ = h.DB.Exec(ctx, `DELETE FROM cart_coupons WHERE user_id = $1`, *userID)
+	}
 	if sessionID, errCookie := c.Cookie("session_id"); errCookie == nil && sessionID != "" {
 		log.Printf("VerifyPayment: Clearing cart for session %s", sessionID)
-		_, _ = h.DB.Exec(ctx, `DELETE FROM cart WHERE session_id = $1`, sessionID)
+		_, _ = h.DB.Exec(ctx, `DELETE FROM cart WHERE session_id = $1 AND saved_for_later = FALSE`, sessionID)
 	}
 
 	log.Printf("VerifyPayment: Payment verification completed successfully for order %s", req.OrderID)
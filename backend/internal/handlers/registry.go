@@ -0,0 +1,767 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/config"
+	"github.com/etreasure/backend/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Gift registries are wishlists a customer publishes under a name. Anyone with the share
+// link can see the list and buy from it; the owner manages it from their account. An
+// item's bought quantity is counted from paid orders, and quantities on unpaid checkouts
+// still holding stock count as claimed too, so two visitors cannot buy the last one.
+
+// registryClaimsSQL selects purchased and held quantities for the registry item aliased
+// gi. Holds on the unpaid orders of exceptUser, a SQL expression for a user id, are not
+// counted, so a buyer retrying checkout does not compete with their own earlier attempt
+// as lockVariants does; "0" counts every hold.
+func registryClaimsSQL(exceptUser string) string {
+	return `
+	COALESCE((SELECT SUM(oli.quantity) FROM order_line_items oli JOIN orders o ON o.id = oli.order_id
+	          WHERE oli.registry_item_id = gi.id AND o.status IN ('paid', 'processing', 'shipped', 'delivered')), 0),
+	COALESCE((SELECT SUM(oli.quantity) FROM order_line_items oli JOIN orders o ON o.id = oli.order_id
+	          WHERE oli.registry_item_id = gi.id AND o.status = 'pending_payment'
+	            AND COALESCE(o.user_id, 0) <> ` + exceptUser + `
+	            AND EXISTS (SELECT 1 FROM stock_reservations r
+	                        WHERE r.order_id = o.id AND r.status = 'active' AND r.expires_at > NOW())), 0)`
+}
+
+var errMixedGiftCart = errors.New("registry gifts must be checked out on their own")
+
+type RegistryHandler struct {
+	DB          *pgxpool.Pool
+	ImageHelper *storage.ImageURLHelper
+	Cfg         config.Config
+}
+
+// registryAddress is where gifts bought from the registry are shipped
+type registryAddress struct {
+	Name         string `json:"name" binding:"required"`
+	Phone        string `json:"phone"`
+	AddressLine1 string `json:"address_line1" binding:"required"`
+	AddressLine2 string `json:"address_line2"`
+	City         string `json:"city" binding:"required"`
+	State        string `json:"state" binding:"required"`
+	PinCode      string `json:"pin_code" binding:"required"`
+}
+
+type registryRequest struct {
+	Name            string           `json:"name" binding:"required,max=120"`
+	Occasion        string           `json:"occasion" binding:"omitempty,oneof=wishlist wedding festival birthday baby_shower other"`
+	EventDate       string           `json:"event_date"` // YYYY-MM-DD
+	Message         string           `json:"message" binding:"max=1000"`
+	ShippingAddress *registryAddress `json:"shipping_address"`
+	IsActive        *bool            `json:"is_active"`
+	// FromWishlist fills a new registry with the owner's wishlist
+	FromWishlist bool `json:"from_wishlist"`
+}
+
+type registryItemRequest struct {
+	ProductID string `json:"product_id" binding:"required"`
+	VariantID int    `json:"variant_id"`
+	Quantity  int    `json:"quantity" binding:"omitempty,min=1,max=100"`
+	Note      string `json:"note" binding:"max=500"`
+}
+
+type updateRegistryItemRequest struct {
+	Quantity int     `json:"quantity" binding:"required,min=1,max=100"`
+	Note     *string `json:"note" binding:"omitempty,max=500"`
+}
+
+type addGiftToCartRequest struct {
+	Quantity int `json:"quantity" binding:"omitempty,min=1"`
+}
+
+type GiftRegistry struct {
+	ID              int64              `json:"id"`
+	Name            string             `json:"name"`
+	Occasion        string             `json:"occasion"`
+	EventDate       *string            `json:"event_date"`
+	Message         string             `json:"message"`
+	ShippingAddress *registryAddress   `json:"shipping_address"`
+	IsActive        bool               `json:"is_active"`
+	ShareURL        string             `json:"share_url"`
+	ItemCount       int                `json:"item_count"`
+	CreatedAt       time.Time          `json:"created_at"`
+	Items           []GiftRegistryItem `json:"items,omitempty"`
+}
+
+// PublicGiftRegistry is what visitors with the share link see
+type PublicGiftRegistry struct {
+	Name              string             `json:"name"`
+	Occasion          string             `json:"occasion"`
+	EventDate         *string            `json:"event_date"`
+	Message           string             `json:"message"`
+	OwnerName         string             `json:"owner_name"`
+	ShipsToRegistrant bool               `json:"ships_to_registrant"`
+	Items             []GiftRegistryItem `json:"items"`
+}
+
+type GiftRegistryItem struct {
+	ID           int64             `json:"id"`
+	ProductID    string            `json:"product_id"`
+	VariantID    int               `json:"variant_id"`
+	Title        string            `json:"title"`
+	VariantTitle string            `json:"variant_title"`
+	Options      map[string]string `json:"options"`
+	Price        float64           `json:"price"`
+	ImageURL     string            `json:"image_url"`
+	Quantity     int               `json:"quantity"`
+	Purchased    int               `json:"purchased"`
+	Remaining    int               `json:"remaining"`
+	InStock      bool              `json:"in_stock"`
+	Note         string            `json:"note"`
+}
+
+// giftQuantity is how many of a registry item a visitor can still get: what they asked
+// for, cut to what the registry still wants and to the stock left
+func giftQuantity(requested, wanted, claimed, stock int) int {
+	qty := requested
+	if left := wanted - claimed; qty > left {
+		qty = left
+	}
+	if qty > stock {
+		qty = stock
+	}
+	if qty < 0 {
+		return 0
+	}
+	return qty
+}
+
+func parseEventDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	d, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func formatEventDate(d *time.Time) *string {
+	if d == nil {
+		return nil
+	}
+	s := d.Format("2006-01-02")
+	return &s
+}
+
+func (h *RegistryHandler) shareURL(token string) string {
+	return strings.TrimRight(h.Cfg.WebBaseURL, "/") + "/registry?token=" + token
+}
+
+func (h *RegistryHandler) imageURL(path *string) string {
+	return (&CartHandler{ImageHelper: h.ImageHelper}).imageURL(path)
+}
+
+// loadRegistryItems lists a registry's items with what has been bought and what is left
+func (h *RegistryHandler) loadRegistryItems(ctx context.Context, registryID int64) ([]GiftRegistryItem, error) {
+	rows, err := h.DB.Query(ctx, `
+		SELECT gi.id, gi.product_id::text, pv.id, p.title, COALESCE(pv.title, ''), pv.options,
+		       pv.price_cents, COALESCE(pv.stock_quantity, 0), COALESCE(p.published, FALSE),
+		       (SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1),
+		       gi.quantity, COALESCE(gi.note, ''),`+registryClaimsSQL("0")+`
+		FROM gift_registry_items gi
+		JOIN products p ON p.uuid_id = gi.product_id
+		JOIN product_variants pv ON pv.id = gi.variant_id
+		WHERE gi.registry_id = $1
+		ORDER BY gi.created_at, gi.id
+	`, registryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []GiftRegistryItem{}
+	for rows.Next() {
+		var item GiftRegistryItem
+		var priceCents, stock, held int
+		var published bool
+		var imagePath *string
+		if err := rows.Scan(&item.ID, &item.ProductID, &item.VariantID, &item.Title, &item.VariantTitle, &item.Options,
+			&priceCents, &stock, &published, &imagePath, &item.Quantity, &item.Note, &item.Purchased, &held); err != nil {
+			return nil, err
+		}
+		item.Price = float64(priceCents) / 100.0
+		item.ImageURL = h.imageURL(imagePath)
+		item.Remaining = item.Quantity - item.Purchased - held
+		if item.Remaining < 0 {
+			item.Remaining = 0
+		}
+		item.InStock = published && stock > 0
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ownedRegistryID resolves :id to a registry of the signed-in user; false means a
+// response was sent
+func (h *RegistryHandler) ownedRegistryID(c *gin.Context) (int64, bool) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return 0, false
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid registry id"})
+		return 0, false
+	}
+	var exists bool
+	if err := h.DB.QueryRow(c.Request.Context(), `
+		SELECT EXISTS (SELECT 1 FROM gift_registries WHERE id = $1 AND user_id = $2)
+	`, id, userID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "registry not found"})
+		return 0, false
+	}
+	return id, true
+}
+
+const registrySelectSQL = `
+	SELECT r.id, r.name, r.occasion, r.event_date, COALESCE(r.message, ''),
+	       r.shipping_name, COALESCE(r.shipping_phone, ''), COALESCE(r.shipping_address_line1, ''),
+	       COALESCE(r.shipping_address_line2, ''), COALESCE(r.shipping_city, ''),
+	       COALESCE(r.shipping_state, ''), COALESCE(r.shipping_pin_code, ''),
+	       r.is_active, r.share_token, r.created_at,
+	       (SELECT COUNT(*) FROM gift_registry_items gi WHERE gi.registry_id = r.id)
+	FROM gift_registries r`
+
+func (h *RegistryHandler) scanRegistry(row pgx.Row) (GiftRegistry, error) {
+	var reg GiftRegistry
+	var eventDate *time.Time
+	var shipName *string
+	var addr registryAddress
+	var token string
+	if err := row.Scan(&reg.ID, &reg.Name, &reg.Occasion, &eventDate, &reg.Message,
+		&shipName, &addr.Phone, &addr.AddressLine1, &addr.AddressLine2, &addr.City,
+		&addr.State, &addr.PinCode, &reg.IsActive, &token, &reg.CreatedAt, &reg.ItemCount); err != nil {
+		return reg, err
+	}
+	reg.EventDate = formatEventDate(eventDate)
+	if shipName != nil {
+		addr.Name = *shipName
+		reg.ShippingAddress = &addr
+	}
+	reg.ShareURL = h.shareURL(token)
+	return reg, nil
+}
+
+// ListMyRegistries - GET /api/account/registries
+func (h *RegistryHandler) ListMyRegistries(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	rows, err := h.DB.Query(c.Request.Context(), registrySelectSQL+` WHERE r.user_id = $1 ORDER BY r.created_at DESC`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	defer rows.Close()
+
+	registries := []GiftRegistry{}
+	for rows.Next() {
+		reg, err := h.scanRegistry(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		registries = append(registries, reg)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": registries})
+}
+
+// GetMyRegistry - GET /api/account/registries/:id
+func (h *RegistryHandler) GetMyRegistry(c *gin.Context) {
+	id, ok := h.ownedRegistryID(c)
+	if !ok {
+		return
+	}
+	h.respondWithRegistry(c, http.StatusOK, id)
+}
+
+func (h *RegistryHandler) respondWithRegistry(c *gin.Context, status int, id int64) {
+	ctx := c.Request.Context()
+	reg, err := h.scanRegistry(h.DB.QueryRow(ctx, registrySelectSQL+` WHERE r.id = $1`, id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if reg.Items, err = h.loadRegistryItems(ctx, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	c.JSON(status, reg)
+}
+
+// CreateRegistry - POST /api/account/registries
+// With from_wishlist the registry starts with everything on the owner's wishlist.
+func (h *RegistryHandler) CreateRegistry(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req registryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	eventDate, err := parseEventDate(req.EventDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "event_date must be YYYY-MM-DD"})
+		return
+	}
+	if req.Occasion == "" {
+		req.Occasion = "wishlist"
+	}
+	addr := req.ShippingAddress
+	if addr == nil {
+		addr = &registryAddress{}
+	}
+	token, err := randomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create registry"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create registry"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO gift_registries (
+			user_id, share_token, name, occasion, event_date, message,
+			shipping_name, shipping_phone, shipping_address_line1, shipping_address_line2,
+			shipping_city, shipping_state, shipping_pin_code, is_active
+		) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''),
+		          NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), COALESCE($14, TRUE))
+		RETURNING id
+	`, userID, token, strings.TrimSpace(req.Name), req.Occasion, eventDate, req.Message,
+		addr.Name, addr.Phone, addr.AddressLine1, addr.AddressLine2, addr.City, addr.State, addr.PinCode,
+		req.IsActive).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create registry"})
+		return
+	}
+
+	if req.FromWishlist {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO gift_registry_items (registry_id, product_id, variant_id)
+			SELECT $1, w.product_id,
+			       COALESCE(w.variant_id, (SELECT MIN(v.id) FROM product_variants v WHERE v.product_id = w.product_id))
+			FROM wishlist w
+			WHERE w.user_id = $2
+			  AND COALESCE(w.variant_id, (SELECT MIN(v.id) FROM product_variants v WHERE v.product_id = w.product_id)) IS NOT NULL
+			ORDER BY w.created_at
+			ON CONFLICT (registry_id, variant_id) DO NOTHING
+		`, id, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to copy wishlist"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create registry"})
+		return
+	}
+	h.respondWithRegistry(c, http.StatusCreated, id)
+}
+
+// UpdateRegistry - PUT /api/account/registries/:id
+func (h *RegistryHandler) UpdateRegistry(c *gin.Context) {
+	id, ok := h.ownedRegistryID(c)
+	if !ok {
+		return
+	}
+	var req registryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	eventDate, err := parseEventDate(req.EventDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "event_date must be YYYY-MM-DD"})
+		return
+	}
+	if req.Occasion == "" {
+		req.Occasion = "wishlist"
+	}
+	addr := req.ShippingAddress
+	if addr == nil {
+		addr = &registryAddress{}
+	}
+
+	_, err = h.DB.Exec(c.Request.Context(), `
+		UPDATE gift_registries SET
+			name = $2, occasion = $3, event_date = $4, message = NULLIF($5, ''),
+			shipping_name = NULLIF($6, ''), shipping_phone = NULLIF($7, ''),
+			shipping_address_line1 = NULLIF($8, ''), shipping_address_line2 = NULLIF($9, ''),
+			shipping_city = NULLIF($10, ''), shipping_state = NULLIF($11, ''), shipping_pin_code = NULLIF($12, ''),
+			is_active = COALESCE($13, is_active), updated_at = NOW()
+		WHERE id = $1
+	`, id, strings.TrimSpace(req.Name), req.Occasion, eventDate, req.Message,
+		addr.Name, addr.Phone, addr.AddressLine1, addr.AddressLine2, addr.City, addr.State, addr.PinCode,
+		req.IsActive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update registry"})
+		return
+	}
+	h.respondWithRegistry(c, http.StatusOK, id)
+}
+
+// DeleteRegistry - DELETE /api/account/registries/:id
+func (h *RegistryHandler) DeleteRegistry(c *gin.Context) {
+	id, ok := h.ownedRegistryID(c)
+	if !ok {
+		return
+	}
+	if _, err := h.DB.Exec(c.Request.Context(), `DELETE FROM gift_registries WHERE id = $1`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete registry"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "registry deleted"})
+}
+
+// AddRegistryItem - POST /api/account/registries/:id/items
+// Adding a variant that is already listed sets its quantity and note.
+func (h *RegistryHandler) AddRegistryItem(c *gin.Context) {
+	id, ok := h.ownedRegistryID(c)
+	if !ok {
+		return
+	}
+	var req registryItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	ctx := c.Request.Context()
+	var variantID int
+	err := h.DB.QueryRow(ctx, `
+		SELECT pv.id FROM product_variants pv
+		WHERE pv.product_id = $1::uuid AND ($2 = 0 OR pv.id = $2)
+		ORDER BY pv.id
+		LIMIT 1
+	`, req.ProductID, req.VariantID).Scan(&variantID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found for this product"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+
+	_, err = h.DB.Exec(ctx, `
+		INSERT INTO gift_registry_items (registry_id, product_id, variant_id, quantity, note)
+		VALUES ($1, $2::uuid, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (registry_id, variant_id)
+		DO UPDATE SET quantity = EXCLUDED.quantity, note = EXCLUDED.note, updated_at = NOW()
+	`, id, req.ProductID, variantID, req.Quantity, req.Note)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add item"})
+		return
+	}
+	h.respondWithRegistry(c, http.StatusOK, id)
+}
+
+// UpdateRegistryItem - PATCH /api/account/registries/:id/items/:itemId
+func (h *RegistryHandler) UpdateRegistryItem(c *gin.Context) {
+	id, ok := h.ownedRegistryID(c)
+	if !ok {
+		return
+	}
+	var req updateRegistryItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be between 1 and 100"})
+		return
+	}
+	tag, err := h.DB.Exec(c.Request.Context(), `
+		UPDATE gift_registry_items
+		SET quantity = $3, note = CASE WHEN $4::boolean THEN NULLIF($5, '') ELSE note END, updated_at = NOW()
+		WHERE id = $2 AND registry_id = $1
+	`, id, c.Param("itemId"), req.Quantity, req.Note != nil, derefString(req.Note))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update item"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+		return
+	}
+	h.respondWithRegistry(c, http.StatusOK, id)
+}
+
+// RemoveRegistryItem - DELETE /api/account/registries/:id/items/:itemId
+func (h *RegistryHandler) RemoveRegistryItem(c *gin.Context) {
+	id, ok := h.ownedRegistryID(c)
+	if !ok {
+		return
+	}
+	if _, err := h.DB.Exec(c.Request.Context(), `
+		DELETE FROM gift_registry_items WHERE id = $2 AND registry_id = $1
+	`, id, c.Param("itemId")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove item"})
+		return
+	}
+	h.respondWithRegistry(c, http.StatusOK, id)
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// ViewRegistry - GET /api/registries/:token
+// The public view behind the share link. The shipping address is never included.
+func (h *RegistryHandler) ViewRegistry(c *gin.Context) {
+	ctx := c.Request.Context()
+	var id int64
+	var reg PublicGiftRegistry
+	var eventDate *time.Time
+	var ownerName string
+	err := h.DB.QueryRow(ctx, `
+		SELECT r.id, r.name, r.occasion, r.event_date, COALESCE(r.message, ''),
+		       COALESCE(u.full_name, ''), r.shipping_name IS NOT NULL
+		FROM gift_registries r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.share_token = $1 AND r.is_active = TRUE AND u.deleted_at IS NULL
+	`, c.Param("token")).Scan(&id, &reg.Name, &reg.Occasion, &eventDate, &reg.Message, &ownerName, &reg.ShipsToRegistrant)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "registry not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	reg.EventDate = formatEventDate(eventDate)
+	// First name only: the link may travel further than the owner intended
+	if fields := strings.Fields(ownerName); len(fields) > 0 {
+		reg.OwnerName = fields[0]
+	}
+	if reg.Items, err = h.loadRegistryItems(ctx, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	c.JSON(http.StatusOK, reg)
+}
+
+// AddGiftToCart - POST /api/registries/:token/items/:itemId/cart
+// Puts a registry item in the visitor's cart as a gift, cut to what the registry still
+// wants and to the stock left.
+func (h *RegistryHandler) AddGiftToCart(c *gin.Context) {
+	var req addGiftToCartRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var itemID int64
+	var productID string
+	var variantID, wanted, purchased, held, stock int
+	var published bool
+	err := h.DB.QueryRow(ctx, `
+		SELECT gi.id, gi.product_id::text, gi.variant_id, gi.quantity,`+registryClaimsSQL("0")+`,
+		       COALESCE(pv.stock_quantity, 0), COALESCE(p.published, FALSE)
+		FROM gift_registry_items gi
+		JOIN gift_registries r ON r.id = gi.registry_id
+		JOIN product_variants pv ON pv.id = gi.variant_id
+		JOIN products p ON p.uuid_id = gi.product_id
+		WHERE r.share_token = $1 AND r.is_active = TRUE AND gi.id = $2
+	`, c.Param("token"), c.Param("itemId")).Scan(&itemID, &productID, &variantID, &wanted, &purchased, &held, &stock, &published)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "registry item not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if !published {
+		stock = 0
+	}
+
+	if owner.empty() {
+		owner.SessionID = newSessionCookie(c)
+	}
+	ownerColumn, ownerValue := owner.key()
+
+	// A variant is one cart line, so a gift cannot share it with the visitor's own purchase
+	var inCart int
+	var lineRegistryItem *int64
+	err = h.DB.QueryRow(ctx, `
+		SELECT quantity, registry_item_id FROM cart WHERE `+ownerColumn+` = $1 AND variant_id = $2
+	`, ownerValue, variantID).Scan(&inCart, &lineRegistryItem)
+	if err != nil && err != pgx.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if err == nil && (lineRegistryItem == nil || *lineRegistryItem != itemID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "this item is already in your cart for yourself; check it out or remove it first"})
+		return
+	}
+
+	quantity := giftQuantity(inCart+req.Quantity, wanted, purchased+held, stock)
+	if quantity <= inCart {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "nothing left to buy for this item",
+			"remaining": giftQuantity(wanted, wanted, purchased+held, stock),
+			"in_cart":   inCart,
+		})
+		return
+	}
+
+	var cartItemID int
+	err = h.DB.QueryRow(ctx, `
+		INSERT INTO cart (`+ownerColumn+`, product_id, variant_id, quantity, registry_item_id, updated_at)
+		VALUES ($1, $2::uuid, $3, $4, $5, NOW())
+		ON CONFLICT (`+ownerColumn+`, variant_id) WHERE `+ownerColumn+` IS NOT NULL
+		DO UPDATE SET quantity = EXCLUDED.quantity, saved_for_later = FALSE, updated_at = NOW()
+		RETURNING id
+	`, ownerValue, productID, variantID, quantity, itemID).Scan(&cartItemID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Gift added to cart",
+		"item_id":    cartItemID,
+		"variant_id": variantID,
+		"quantity":   quantity,
+		"capped":     quantity < inCart+req.Quantity,
+	})
+}
+
+// giftCheckout is the registry an order's gifts are for
+type giftCheckout struct {
+	RegistryID int64
+	Address    *registryAddress
+}
+
+// prepareGiftCheckout checks the registry lines of a cart inside the checkout
+// transaction. Registry items are locked so concurrent checkouts of the same gift queue
+// up, and a line asking for more than the registry still wants is reported as a
+// shortage. A cart holding gifts must hold nothing else, since it ships to the
+// registrant. It returns nil when the cart has no gifts. userID is the buyer.
+func prepareGiftCheckout(ctx context.Context, tx pgx.Tx, userID int, lines []pricedCartLine) (*giftCheckout, error) {
+	var itemIDs []int64
+	for _, l := range lines {
+		if l.RegistryItemID != nil {
+			itemIDs = append(itemIDs, *l.RegistryItemID)
+		}
+	}
+	if len(itemIDs) == 0 {
+		return nil, nil
+	}
+	if len(itemIDs) != len(lines) {
+		return nil, errMixedGiftCart
+	}
+	sort.Slice(itemIDs, func(i, j int) bool { return itemIDs[i] < itemIDs[j] })
+
+	rows, err := tx.Query(ctx, `
+		SELECT gi.id, gi.registry_id, gi.quantity,`+registryClaimsSQL("$2")+`
+		FROM gift_registry_items gi
+		WHERE gi.id = ANY($1)
+		ORDER BY gi.id
+		FOR UPDATE OF gi
+	`, itemIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	type claim struct {
+		registryID      int64
+		wanted, claimed int
+	}
+	claims := map[int64]claim{}
+	for rows.Next() {
+		var id int64
+		var cl claim
+		var purchased, held int
+		if err := rows.Scan(&id, &cl.registryID, &cl.wanted, &purchased, &held); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cl.claimed = purchased + held
+		claims[id] = cl
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	gift := &giftCheckout{}
+	var short []stockShortage
+	for _, l := range lines {
+		cl, ok := claims[*l.RegistryItemID]
+		if gift.RegistryID == 0 {
+			gift.RegistryID = cl.registryID
+		}
+		if !ok || cl.registryID != gift.RegistryID {
+			return nil, errMixedGiftCart
+		}
+		if left := giftQuantity(l.Quantity, cl.wanted, cl.claimed, l.Quantity); left < l.Quantity {
+			short = append(short, stockShortage{VariantID: l.VariantID, Title: l.Title, Requested: l.Quantity, Available: left})
+		}
+	}
+	if len(short) > 0 {
+		return nil, &insufficientStockError{Lines: short}
+	}
+
+	var name *string
+	addr := &registryAddress{}
+	err = tx.QueryRow(ctx, `
+		SELECT shipping_name, COALESCE(shipping_phone, ''), COALESCE(shipping_address_line1, ''),
+		       COALESCE(shipping_address_line2, ''), COALESCE(shipping_city, ''),
+		       COALESCE(shipping_state, ''), COALESCE(shipping_pin_code, '')
+		FROM gift_registries WHERE id = $1
+	`, gift.RegistryID).Scan(&name, &addr.Phone, &addr.AddressLine1, &addr.AddressLine2, &addr.City, &addr.State, &addr.PinCode)
+	if err != nil {
+		return nil, err
+	}
+	if name != nil {
+		addr.Name = *name
+		gift.Address = addr
+	}
+	return gift, nil
+}
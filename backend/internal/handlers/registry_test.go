@@ -0,0 +1,23 @@
+package handlers
+
+import "testing"
+
+func TestGiftQuantity(t *testing.T) {
+	cases := []struct {
+		name                              string
+		requested, wanted, claimed, stock int
+		want                              int
+	}{
+		{"all available", 2, 3, 0, 10, 2},
+		{"cut to what the registry still wants", 3, 3, 2, 10, 1},
+		{"cut to stock", 3, 5, 0, 2, 2},
+		{"already bought", 1, 2, 2, 10, 0},
+		{"over-claimed never goes negative", 1, 2, 3, 10, 0},
+		{"out of stock", 1, 2, 0, 0, 0},
+	}
+	for _, tc := range cases {
+		if got := giftQuantity(tc.requested, tc.wanted, tc.claimed, tc.stock); got != tc.want {
+			t.Errorf("%s: giftQuantity = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
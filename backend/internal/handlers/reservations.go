@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// reservationTTL is how long checkout holds stock while the customer is on the payment
+// screen. A payment that completes after it still converts; if the stock went to someone
+// else meanwhile the order is flagged as oversold for the team to resolve.
+const reservationTTL = 15 * time.Minute
+
+// stockShortage is a cart line that cannot be reserved in full
+type stockShortage struct {
+	VariantID int    `json:"variant_id"`
+	Title     string `json:"title"`
+	Requested int    `json:"requested"`
+	Available int    `json:"available"`
+}
+
+type insufficientStockError struct {
+	Lines []stockShortage
+}
+
+func (e *insufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for %d item(s)", len(e.Lines))
+}
+
+type StockReservation struct {
+	ID           int64     `json:"id"`
+	OrderID      string    `json:"order_id"`
+	OrderNumber  string    `json:"order_number"`
+	VariantID    int       `json:"variant_id"`
+	SKU          string    `json:"sku"`
+	ProductTitle string    `json:"product_title"`
+	Quantity     int       `json:"quantity"`
+	Status       string    `json:"status"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// lockVariants takes row locks on the variants in id order, so concurrent checkouts
+// touching the same items queue up instead of deadlocking, and returns what is still
+// sellable for each: stock minus active, unexpired reservations. Holds on exceptUserID's
+// unpaid orders are not subtracted, so a customer retrying checkout does not compete
+// with their own earlier attempt; pass 0 to count every hold.
+func lockVariants(ctx context.Context, tx pgx.Tx, variantIDs []int, exceptUserID int) (map[int]int, error) {
+	ids := append([]int(nil), variantIDs...)
+	sort.Ints(ids)
+	rows, err := tx.Query(ctx, `
+		SELECT pv.id,
+		       COALESCE(pv.stock_quantity, 0) - COALESCE((
+		           SELECT SUM(r.quantity) FROM stock_reservations r
+		           WHERE r.variant_id = pv.id AND r.status = 'active' AND r.expires_at > NOW()
+		             AND NOT EXISTS (
+		                 SELECT 1 FROM orders o
+		                 WHERE o.id = r.order_id AND o.user_id = $2 AND o.status = 'pending_payment'
+		             )
+		       ), 0)
+		FROM product_variants pv
+		WHERE pv.id = ANY($1)
+		ORDER BY pv.id
+		FOR UPDATE OF pv
+	`, ids, exceptUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	available := make(map[int]int, len(ids))
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		available[id] = n
+	}
+	return available, rows.Err()
+}
+
+// reserveStock holds every cart line for the order. It fails with insufficientStockError,
+// reserving nothing, when any line is short. userID is the customer checking out, 0 for
+// a guest.
+func reserveStock(ctx context.Context, tx pgx.Tx, orderID string, userID int, lines []pricedCartLine, ttl time.Duration) error {
+	variantIDs := make([]int, 0, len(lines))
+	for _, l := range lines {
+		variantIDs = append(variantIDs, l.VariantID)
+	}
+	available, err := lockVariants(ctx, tx, variantIDs, userID)
+	if err != nil {
+		return err
+	}
+
+	var short []stockShortage
+	for _, l := range lines {
+		if n := available[l.VariantID]; n < l.Quantity {
+			short = append(short, stockShortage{VariantID: l.VariantID, Title: l.Title, Requested: l.Quantity, Available: max(n, 0)})
+		}
+	}
+	if len(short) > 0 {
+		return &insufficientStockError{Lines: short}
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	for _, l := range lines {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO stock_reservations (order_id, variant_id, quantity, expires_at)
+			VALUES ($1, $2, $3, $4)
+		`, orderID, l.VariantID, l.Quantity, expiresAt); err != nil {
+			return err
+		}
+	}
+	return syncInventoryReserved(ctx, tx, variantIDs)
+}
+
+// holdsNoStock is a releaseOfferClaims filter for orders with no active hold left
+const holdsNoStock = `NOT EXISTS (SELECT 1 FROM stock_reservations r WHERE r.order_id = o.id AND r.status = 'active')`
+
+// releaseOrderReservations frees an order's stock and offer claim when its payment could
+// not be started
+func releaseOrderReservations(ctx context.Context, db *pgxpool.Pool, orderID string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := releaseReservations(ctx, tx, `
+		UPDATE stock_reservations SET status = 'released', updated_at = NOW()
+		WHERE status = 'active' AND order_id = $1
+		RETURNING variant_id
+	`, orderID); err != nil {
+		return err
+	}
+	if err := releaseOfferClaims(ctx, tx, `o.id = $1::uuid AND `+holdsNoStock, orderID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// releaseReservations runs an update that returns the variant id of every reservation it
+// ended, brings the inventory counts for those variants back in line and returns how
+// many reservations were ended
+func releaseReservations(ctx context.Context, tx pgx.Tx, query string, args ...any) (int, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var variantIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		variantIDs = append(variantIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(variantIDs) == 0 {
+		return 0, nil
+	}
+	return len(variantIDs), syncInventoryReserved(ctx, tx, variantIDs)
+}
+
+// convertReservations turns a paid order's reservations into stock deductions. A
+// reservation that lapsed before the payment landed is still converted, but if the
+// stock went to someone else meanwhile the order is marked oversold_at for the team to
+// resolve. So is any order whose items are no longer all in stock, which happens when
+// a customer's retried checkout and the earlier attempt it left holding are both paid.
+func convertReservations(ctx context.Context, tx pgx.Tx, orderID string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT id, variant_id, quantity, status = 'active' AND expires_at > NOW()
+		FROM stock_reservations
+		WHERE order_id = $1 AND status <> 'converted'
+		ORDER BY variant_id
+		FOR UPDATE
+	`, orderID)
+	if err != nil {
+		return err
+	}
+	type held struct {
+		id        int64
+		variantID int
+		quantity  int
+		live      bool
+	}
+	var reservations []held
+	var variantIDs []int
+	for rows.Next() {
+		var r held
+		if err := rows.Scan(&r.id, &r.variantID, &r.quantity, &r.live); err != nil {
+			rows.Close()
+			return err
+		}
+		reservations = append(reservations, r)
+		variantIDs = append(variantIDs, r.variantID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(reservations) == 0 {
+		return nil
+	}
+
+	available, err := lockVariants(ctx, tx, variantIDs, 0)
+	if err != nil {
+		return err
+	}
+	oversold := false
+	for _, r := range reservations {
+		var stock int
+		if err := tx.QueryRow(ctx, `
+			SELECT COALESCE(stock_quantity, 0) FROM product_variants WHERE id = $1
+		`, r.variantID).Scan(&stock); err != nil {
+			return err
+		}
+		// A live reservation is already counted against what is available
+		if !r.live && available[r.variantID] < r.quantity {
+			log.Printf("reservations: order %s paid after its hold lapsed; variant %d oversold by %d",
+				orderID, r.variantID, r.quantity-max(available[r.variantID], 0))
+			oversold = true
+		} else if stock < r.quantity {
+			log.Printf("reservations: order %s paid for %d of variant %d with %d in stock",
+				orderID, r.quantity, r.variantID, stock)
+			oversold = true
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE product_variants SET stock_quantity = GREATEST(COALESCE(stock_quantity, 0) - $2, 0)
+			WHERE id = $1
+		`, r.variantID, r.quantity); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE inventory_items SET quantity = GREATEST(quantity - $2, 0)
+			WHERE id = (SELECT id FROM inventory_items WHERE variant_id = $1 ORDER BY created_at, id LIMIT 1)
+		`, r.variantID, r.quantity); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE stock_reservations SET status = 'converted', updated_at = NOW() WHERE id = $1
+		`, r.id); err != nil {
+			return err
+		}
+	}
+	if oversold {
+		if _, err := tx.Exec(ctx, `
+			UPDATE orders SET oversold_at = NOW() WHERE id = $1 AND oversold_at IS NULL
+		`, orderID); err != nil {
+			return err
+		}
+	}
+	return syncInventoryReserved(ctx, tx, variantIDs)
+}
+
+// syncInventoryReserved recomputes inventory_items.reserved and available for the
+// variants. When a variant is stocked at several locations the hold is shown against
+// its first inventory row, which is also the row a sale is deducted from.
+func syncInventoryReserved(ctx context.Context, tx pgx.Tx, variantIDs []int) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE inventory_items i
+		SET reserved = s.qty, available = i.quantity - s.qty, updated_at = NOW()
+		FROM (
+			SELECT ii.id,
+			       CASE WHEN ii.id = (
+			           SELECT p.id FROM inventory_items p WHERE p.variant_id = ii.variant_id ORDER BY p.created_at, p.id LIMIT 1
+			       ) THEN COALESCE((
+			           SELECT SUM(r.quantity) FROM stock_reservations r
+			           WHERE r.variant_id = ii.variant_id AND r.status = 'active'
+			       ), 0) ELSE 0 END AS qty
+			FROM inventory_items ii
+			WHERE ii.variant_id = ANY($1)
+		) s
+		WHERE i.id = s.id
+	`, variantIDs)
+	return err
+}
+
+// ReleaseExpiredReservations marks lapsed holds as expired, gives back the offer claims of
+// the orders left holding nothing and returns how many holds it released
+func ReleaseExpiredReservations(ctx context.Context, db *pgxpool.Pool) (int, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	n, err := releaseExpired(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+// releaseExpired does the work of ReleaseExpiredReservations inside the caller's transaction
+func releaseExpired(ctx context.Context, tx pgx.Tx) (int, error) {
+	n, err := releaseReservations(ctx, tx, `
+		UPDATE stock_reservations SET status = 'expired', updated_at = NOW()
+		WHERE status = 'active' AND expires_at <= NOW()
+		RETURNING variant_id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	if err := releaseOfferClaims(ctx, tx, holdsNoStock); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RunReservationSweeper calls ReleaseExpiredReservations every interval until ctx is cancelled
+func RunReservationSweeper(ctx context.Context, db *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := ReleaseExpiredReservations(ctx, db)
+			if err != nil {
+				log.Printf("reservations: sweep failed: %v", err)
+			} else if n > 0 {
+				log.Printf("reservations: released %d expired holds", n)
+			}
+		}
+	}
+}
+
+// ListReservations - GET /api/admin/inventory/reservations
+// Active holds by default; ?status= selects converted, released or expired ones.
+func (h *Handler) ListReservations(c *gin.Context) {
+	status := c.DefaultQuery("status", "active")
+	switch status {
+	case "active", "converted", "released", "expired":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active, converted, released or expired"})
+		return
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+
+	query := `
+		SELECT r.id, r.order_id::text, COALESCE(o.order_number, ''), r.variant_id, COALESCE(pv.sku, ''),
+		       COALESCE(p.title, ''), r.quantity, r.status, r.expires_at, r.created_at
+		FROM stock_reservations r
+		JOIN orders o ON o.id = r.order_id
+		JOIN product_variants pv ON pv.id = r.variant_id
+		LEFT JOIN products p ON p.uuid_id = pv.product_id
+		WHERE r.status = $1`
+	args := []any{status}
+	if v := c.Query("variant_id"); v != "" {
+		variantID, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid variant_id"})
+			return
+		}
+		query += ` AND r.variant_id = $3`
+		args = append(args, limit, variantID)
+	} else {
+		args = append(args, limit)
+	}
+	query += ` ORDER BY r.created_at DESC LIMIT $2`
+
+	rows, err := h.DB.Query(c, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	reservations := []StockReservation{}
+	for rows.Next() {
+		var r StockReservation
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.OrderNumber, &r.VariantID, &r.SKU,
+			&r.ProductTitle, &r.Quantity, &r.Status, &r.ExpiresAt, &r.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		reservations = append(reservations, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": reservations})
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// savedCartLine is a cart line set aside for later, with the variant's current price and stock
+type savedCartLine struct {
+	ID           int
+	ProductID    string
+	VariantID    int
+	Title        string
+	VariantTitle string
+	Options      map[string]string
+	SKU          string
+	Quantity     int
+	PriceCents   int
+	Stock        int
+	Available    bool
+	ImagePath    *string
+}
+
+// loadSavedForLater returns the owner's saved lines, most recently saved first
+func loadSavedForLater(ctx context.Context, db *pgxpool.Pool, owner cartOwner) ([]savedCartLine, error) {
+	ownerColumn, ownerValue := owner.key()
+	rows, err := db.Query(ctx, `
+		SELECT c.id, c.product_id::text, pv.id, p.title, COALESCE(pv.title, ''), pv.options,
+		       COALESCE(pv.sku, ''), c.quantity, pv.price_cents, COALESCE(pv.stock_quantity, 0),
+		       COALESCE(p.published, FALSE),
+		       (SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1)
+		FROM cart c
+		JOIN products p ON c.product_id = p.uuid_id
+		JOIN product_variants pv ON c.variant_id = pv.id
+		WHERE c.`+ownerColumn+` = $1 AND c.saved_for_later = TRUE
+		ORDER BY c.updated_at DESC, c.id
+	`, ownerValue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []savedCartLine{}
+	for rows.Next() {
+		var l savedCartLine
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.VariantID, &l.Title, &l.VariantTitle, &l.Options,
+			&l.SKU, &l.Quantity, &l.PriceCents, &l.Stock, &l.Available, &l.ImagePath); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// SaveForLater - POST /api/cart/:id/save-for-later
+// Takes a line out of the cart total without losing it.
+func (h *CartHandler) SaveForLater(c *gin.Context) {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		return
+	}
+	ownerColumn, ownerValue := owner.key()
+
+	itemID := c.Param("id")
+	tag, err := h.DB.Exec(c.Request.Context(), `
+		UPDATE cart SET saved_for_later = TRUE, updated_at = NOW()
+		WHERE id = $1 AND `+ownerColumn+` = $2 AND saved_for_later = FALSE
+	`, itemID, ownerValue)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save item for later"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Item saved for later",
+		"item_id":         itemID,
+		"saved_for_later": true,
+	})
+}
+
+// MoveToCart - POST /api/cart/:id/move-to-cart
+// Brings a saved line back into the cart. Stock is checked again: the quantity is cut to
+// what is left, and a line that is no longer available stays saved.
+func (h *CartHandler) MoveToCart(c *gin.Context) {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		return
+	}
+	ownerColumn, ownerValue := owner.key()
+
+	ctx := c.Request.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	itemID := c.Param("id")
+	var variantID, requested, stock int
+	var available bool
+	err = tx.QueryRow(ctx, `
+		SELECT pv.id, c.quantity, COALESCE(pv.stock_quantity, 0), COALESCE(p.published, FALSE)
+		FROM cart c
+		JOIN product_variants pv ON pv.id = c.variant_id
+		JOIN products p ON p.uuid_id = c.product_id
+		WHERE c.id = $1 AND c.`+ownerColumn+` = $2 AND c.saved_for_later = TRUE
+		FOR UPDATE OF c
+	`, itemID, ownerValue).Scan(&variantID, &requested, &stock, &available)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved item not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+
+	quantity, outcome := mergeCartLine(0, requested, stock, available)
+	if outcome == cartMergeDropped {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "insufficient stock",
+			"current_stock": stock,
+			"requested":     requested,
+			"variant_id":    variantID,
+		})
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE cart SET saved_for_later = FALSE, quantity = $1, updated_at = NOW()
+		WHERE id = $2
+	`, quantity, itemID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Item moved to cart",
+		"item_id":    itemID,
+		"variant_id": variantID,
+		"quantity":   quantity,
+		"requested":  requested,
+		"capped":     outcome == cartMergeCapped,
+	})
+}
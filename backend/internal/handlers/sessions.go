@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Session is one signed-in device. It maps to a refresh token family: the ID
+// stays the same across rotations while the device info follows the latest token.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  *string   `json:"user_agent,omitempty"`
+	IPAddress  *string   `json:"ip_address,omitempty"`
+	RememberMe bool      `json:"remember_me"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type revokeOtherSessionsRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// ListMySessions - GET /api/auth/sessions
+// Pass the refresh token in X-Refresh-Token to have the current session flagged.
+func (h *AuthHandler) ListMySessions(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx := context.Background()
+	sessions, err := h.loadSessions(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sessions"})
+		return
+	}
+
+	if rt := c.GetHeader("X-Refresh-Token"); rt != "" {
+		if current, err := h.sessionIDForToken(ctx, userID, rt); err == nil {
+			for i := range sessions {
+				sessions[i].Current = sessions[i].ID == current.String()
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeMySession - DELETE /api/auth/sessions/:id
+func (h *AuthHandler) RevokeMySession(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	h.revokeSession(c, userID)
+}
+
+// RevokeOtherSessions - POST /api/auth/sessions/revoke-others
+// Signs out every device except the one holding the given refresh token.
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req revokeOtherSessionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	current, err := h.sessionIDForToken(ctx, userID, req.RefreshToken)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh token does not belong to an active session"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+
+	tag, err := h.DB.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+	`, userID, current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked", "revoked": tag.RowsAffected()})
+}
+
+// ListUserSessions - GET /api/admin/users/:id/sessions
+func (h *AuthHandler) ListUserSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	sessions, err := h.loadSessions(context.Background(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeUserSession - DELETE /api/admin/users/:id/sessions/:sessionId
+func (h *AuthHandler) RevokeUserSession(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	h.revokeSession(c, userID)
+}
+
+// RevokeAllUserSessions - DELETE /api/admin/users/:id/sessions
+// Force-logout: no refresh token of the user can be exchanged afterwards.
+// Access tokens already issued stay valid until they expire (15 minutes).
+func (h *AuthHandler) RevokeAllUserSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	tag, err := h.DB.Exec(context.Background(), `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked", "revoked": tag.RowsAffected()})
+}
+
+// revokeSession revokes the session named by the :sessionId (or :id for self-service) route param
+func (h *AuthHandler) revokeSession(c *gin.Context, userID int) {
+	param := c.Param("sessionId")
+	if param == "" {
+		param = c.Param("id")
+	}
+	familyID, err := uuid.Parse(param)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+
+	tag, err := h.DB.Exec(context.Background(), `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+	`, userID, familyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked", "id": familyID})
+}
+
+// loadSessions returns the active sessions of a user, most recently used first
+func (h *AuthHandler) loadSessions(ctx context.Context, userID int) ([]Session, error) {
+	rows, err := h.DB.Query(ctx, `
+		SELECT rt.family_id, rt.user_agent, rt.ip_address, rt.remember_me,
+		       (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id) AS started_at,
+		       rt.created_at, rt.expires_at
+		FROM refresh_tokens rt
+		WHERE rt.user_id = $1 AND rt.revoked_at IS NULL AND rt.expires_at > NOW()
+		ORDER BY rt.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var familyID uuid.UUID
+		if err := rows.Scan(&familyID, &s.UserAgent, &s.IPAddress, &s.RememberMe, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		s.ID = familyID.String()
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// sessionIDForToken resolves an active refresh token of the user to its session (family) ID
+func (h *AuthHandler) sessionIDForToken(ctx context.Context, userID int, refreshToken string) (uuid.UUID, error) {
+	var familyID uuid.UUID
+	err := h.DB.QueryRow(ctx, `
+		SELECT family_id FROM refresh_tokens
+		WHERE token_hash = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	`, hashToken(refreshToken), userID).Scan(&familyID)
+	return familyID, err
+}
+
+// contextUserID reads the user ID set by middleware.AuthRequired
+func contextUserID(c *gin.Context) (int, bool) {
+	val, ok := c.Get("user_id")
+	if !ok {
+		return 0, false
+	}
+	userID, ok := val.(int)
+	return userID, ok
+}
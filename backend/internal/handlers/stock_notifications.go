@@ -3,8 +3,12 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/etreasure/backend/internal/config"
 	"github.com/etreasure/backend/internal/email"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
@@ -16,6 +20,7 @@ type StockNotificationsHandler struct {
 	DB    *pgxpool.Pool
 	Rd    *redis.Client
 	Email *email.EmailService
+	Cfg   config.Config
 }
 
 type createStockNotificationRequest struct {
@@ -46,6 +51,20 @@ func (h *StockNotificationsHandler) CreateStockNotification(c *gin.Context) {
 
 	ctx := context.Background()
 
+	// An email sign-up is only active straight away for a signed-in customer's own
+	// verified address; any other address has to confirm it from the email sent to it
+	confirmed := req.NotificationType != "email"
+	if val, ok := c.Get("user_id"); ok && !confirmed {
+		if userID, ok := val.(int); ok {
+			accountEmail, verified, err := userEmailStatus(ctx, h.DB, userID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			confirmed = verified && strings.EqualFold(strings.TrimSpace(req.Email), accountEmail)
+		}
+	}
+
 	// If productId is not provided, fetch it from the slug
 	var productUUID string
 	if req.ProductID != nil {
@@ -86,6 +105,27 @@ func (h *StockNotificationsHandler) CreateStockNotification(c *gin.Context) {
 		return
 	}
 
+	pending := gin.H{
+		"message":              "Please check your email and confirm to get notified when this product is back in stock",
+		"confirmationRequired": true,
+	}
+	if !confirmed {
+		// One confirmation email per address and product until it is used
+		var waiting bool
+		err := h.DB.QueryRow(ctx, `
+			SELECT EXISTS(SELECT 1 FROM stock_notifications
+			WHERE product_id = $1 AND email = $2 AND is_active = FALSE AND confirm_token_hash IS NOT NULL)
+		`, productUUID, req.Email).Scan(&waiting)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if waiting {
+			c.JSON(http.StatusAccepted, pending)
+			return
+		}
+	}
+
 	// Create new notification
 	var contactField, contactValue string
 	if req.NotificationType == "email" {
@@ -95,25 +135,81 @@ func (h *StockNotificationsHandler) CreateStockNotification(c *gin.Context) {
 		contactField = "mobile_number"
 		contactValue = req.MobileNumber
 	}
+	var confirmToken string
+	var confirmHash *string
+	if !confirmed {
+		confirmToken, err = randomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create notification request"})
+			return
+		}
+		hash := hashToken(confirmToken)
+		confirmHash = &hash
+	}
 
 	query = fmt.Sprintf(`
-		INSERT INTO stock_notifications (product_id, product_slug, %s, notification_type, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		INSERT INTO stock_notifications (product_id, product_slug, %s, notification_type, is_active, confirm_token_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
 		RETURNING id
 	`, contactField)
 
-	err = h.DB.QueryRow(ctx, query, productUUID, req.ProductSlug, contactValue, req.NotificationType).Scan(&existingID)
+	err = h.DB.QueryRow(ctx, query, productUUID, req.ProductSlug, contactValue, req.NotificationType, confirmed, confirmHash).Scan(&existingID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create notification request"})
 		return
 	}
 
+	if !confirmed {
+		var productTitle string
+		if err := h.DB.QueryRow(ctx, `SELECT title FROM products WHERE uuid_id = $1`, productUUID).Scan(&productTitle); err != nil {
+			productTitle = req.ProductSlug
+		}
+		link := strings.TrimRight(h.Cfg.WebBaseURL, "/") + "/confirm-notification?token=" + url.QueryEscape(confirmToken)
+		if h.Email == nil {
+			log.Printf("Stock notification %d: email service not configured, confirmation not sent", existingID)
+		} else if err := h.Email.SendStockNotificationConfirmEmail(req.Email, productTitle, link); err != nil {
+			log.Printf("Failed to send stock notification confirmation to %s: %v", req.Email, err)
+		}
+		c.JSON(http.StatusAccepted, pending)
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Notification request created successfully",
 		"id":      existingID,
 	})
 }
 
+type confirmStockNotificationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ConfirmStockNotification - POST /api/stock-notifications/confirm
+// The storefront posts the token from the confirmation email.
+func (h *StockNotificationsHandler) ConfirmStockNotification(c *gin.Context) {
+	var req confirmStockNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	var productSlug string
+	err := h.DB.QueryRow(c.Request.Context(), `
+		UPDATE stock_notifications SET is_active = TRUE, confirm_token_hash = NULL, updated_at = NOW()
+		WHERE confirm_token_hash = $1
+		RETURNING product_slug
+	`, hashToken(req.Token)).Scan(&productSlug)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link is invalid or already used"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "You will be notified when this product is back in stock", "productSlug": productSlug})
+}
+
 // SendStockNotifications - sends notifications when product is back in stock
 func (h *StockNotificationsHandler) SendStockNotifications(productUUID string, productSlug string) error {
 	ctx := context.Background()
@@ -199,3 +295,17 @@ func (h *StockNotificationsHandler) sendStockNotificationEmail(email, productSlu
 
 	return h.Email.SendStockNotificationEmail(email, productSlug, productTitle, productImage, minPriceCents)
 }
+
+// ProductChanged runs after an admin edits a product's variants or stock: it sends the
+// back-in-stock emails when the product was restocked and queues wishlist alerts for
+// price drops and restocks. Errors are logged, as it runs after the response is sent.
+func (h *StockNotificationsHandler) ProductChanged(productUUID, productSlug string, restocked bool) {
+	if restocked {
+		if err := h.SendStockNotifications(productUUID, productSlug); err != nil {
+			log.Printf("Failed to send stock notifications for product %s: %v", productUUID, err)
+		}
+	}
+	if err := queueWishlistAlerts(context.Background(), h.DB, productUUID, restocked); err != nil {
+		log.Printf("Failed to queue wishlist alerts for product %s: %v", productUUID, err)
+	}
+}
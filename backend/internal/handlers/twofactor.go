@@ -0,0 +1,686 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/auth"
+	"github.com/etreasure/backend/internal/kv"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	twoFactorChallengeTTL      = 5 * time.Minute
+	twoFactorMaxAttempts       = 5
+	twoFactorRecoveryCodeCount = 10
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type enrollTwoFactorRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	EmailCode      string `json:"emailCode" binding:"required"`
+}
+
+type verifyTwoFactorRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// twoFactorLoginResponse carries recovery codes when 2FA was enabled during sign-in
+type twoFactorLoginResponse struct {
+	tokenResponse
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// startTwoFactorChallenge returns the challenge body for Login when the user has 2FA enabled
+// or one of their roles requires it, and nil when tokens can be issued right away.
+// Users that must use 2FA but have not enrolled are emailed a code to start enrolling with.
+func (h *AuthHandler) startTwoFactorChallenge(ctx context.Context, userID int, email string) (gin.H, error) {
+	var enabledAt *time.Time
+	if err := h.DB.QueryRow(ctx, `SELECT totp_enabled_at FROM users WHERE id = $1`, userID).Scan(&enabledAt); err != nil {
+		return nil, err
+	}
+	required, err := h.twoFactorRequired(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt == nil && !required {
+		return nil, nil
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.KV.Set(ctx, twoFactorChallengeKey(token), strconv.Itoa(userID), twoFactorChallengeTTL); err != nil {
+		return nil, err
+	}
+
+	resp := gin.H{
+		"twoFactorRequired": true,
+		"challengeToken":    token,
+		"expiresIn":         int(twoFactorChallengeTTL.Seconds()),
+	}
+	if enabledAt == nil {
+		// The password alone is not enough to enroll: the secret is only handed out by
+		// EnrollTwoFactor once the code emailed here comes back
+		code, err := randomDigits(6)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.KV.Set(ctx, twoFactorEnrollCodeKey(token), hashToken(code), twoFactorChallengeTTL); err != nil {
+			return nil, err
+		}
+		if err := h.Email.SendTwoFactorSetupCodeEmail(email, code); err != nil {
+			log.Printf("Failed to send two-factor setup email: %v", err)
+		}
+		resp["setupRequired"] = true
+	}
+	return resp, nil
+}
+
+// EnrollTwoFactor - POST /api/auth/2fa/enroll
+// Trades the code emailed by an enrollment challenge for a fresh secret. VerifyTwoFactor
+// then enables 2FA with a code from it and finishes signing in.
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	var req enrollTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "challengeToken and emailCode are required"})
+		return
+	}
+	ctx := context.Background()
+	key := twoFactorChallengeKey(req.ChallengeToken)
+	stored, err := h.KV.Get(ctx, key)
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "challenge expired, please log in again"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load challenge"})
+		return
+	}
+	userID, err := strconv.Atoi(stored)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "challenge expired, please log in again"})
+		return
+	}
+	if h.twoFactorLocked(c, ctx, userID) {
+		return
+	}
+	codeKey := twoFactorEnrollCodeKey(req.ChallengeToken)
+	codeHash, err := h.KV.Get(ctx, codeKey)
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no setup code is pending for this challenge"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load challenge"})
+		return
+	}
+
+	attemptsKey := key + ":attempts"
+	attempts, err := h.KV.Incr(ctx, attemptsKey, twoFactorChallengeTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if attempts > twoFactorMaxAttempts {
+		h.KV.Del(ctx, key, codeKey, attemptsKey)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, please log in again"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(hashToken(strings.TrimSpace(req.EmailCode)))) != 1 {
+		h.failTwoFactor(c, ctx, userID, key, codeKey, attemptsKey)
+		return
+	}
+	if n, err := h.KV.Del(ctx, codeKey); err != nil || n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no setup code is pending for this challenge"})
+		return
+	}
+
+	var (
+		email     string
+		enabledAt *time.Time
+	)
+	err = h.DB.QueryRow(ctx, `SELECT email, totp_enabled_at FROM users WHERE id = $1 AND is_active = TRUE`, userID).Scan(&email, &enabledAt)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"})
+		return
+	}
+	if enabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+	if _, err := h.DB.Exec(ctx, `UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE id = $1`, userID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store secret"})
+		return
+	}
+	if err := h.KV.Set(ctx, twoFactorEnrolledKey(req.ChallengeToken), "1", twoFactorChallengeTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": auth.TOTPProvisioningURI(h.Cfg.TOTPIssuer, email, secret),
+	})
+}
+
+// VerifyTwoFactor - POST /api/auth/2fa/verify
+// Completes a login challenge with an authenticator code or a recovery code.
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	var req verifyTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "challengeToken and code or recoveryCode are required"})
+		return
+	}
+	ctx := context.Background()
+	key := twoFactorChallengeKey(req.ChallengeToken)
+	stored, err := h.KV.Get(ctx, key)
+	if err == kv.ErrNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "challenge expired, please log in again"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load challenge"})
+		return
+	}
+	userID, err := strconv.Atoi(stored)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "challenge expired, please log in again"})
+		return
+	}
+
+	if h.twoFactorLocked(c, ctx, userID) {
+		return
+	}
+
+	attemptsKey := key + ":attempts"
+	attempts, err := h.KV.Incr(ctx, attemptsKey, twoFactorChallengeTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if attempts > twoFactorMaxAttempts {
+		h.KV.Del(ctx, key, attemptsKey)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, please log in again"})
+		return
+	}
+
+	var (
+		secret    *string
+		enabledAt *time.Time
+	)
+	err = h.DB.QueryRow(ctx, `SELECT totp_secret, totp_enabled_at FROM users WHERE id = $1 AND is_active = TRUE`, userID).
+		Scan(&secret, &enabledAt)
+	if err != nil || secret == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"})
+		return
+	}
+	enrolling := enabledAt == nil
+	if enrolling {
+		// A secret left over from an earlier challenge or from /2fa/setup does not count:
+		// this challenge must have gone through EnrollTwoFactor
+		if _, err := h.KV.Get(ctx, twoFactorEnrolledKey(req.ChallengeToken)); err == kv.ErrNotFound {
+			c.JSON(http.StatusForbidden, gin.H{"error": "confirm the code emailed to you before setting up two-factor authentication"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load challenge"})
+			return
+		}
+	}
+
+	var ok bool
+	if req.RecoveryCode != "" {
+		if enrolling {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "recovery codes can only be used once two-factor authentication is enabled"})
+			return
+		}
+		ok, err = h.useRecoveryCode(ctx, userID, req.RecoveryCode)
+	} else {
+		ok, err = h.checkTOTP(ctx, userID, *secret, req.Code)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if !ok {
+		h.failTwoFactor(c, ctx, userID, key, attemptsKey, twoFactorEnrolledKey(req.ChallengeToken))
+		return
+	}
+	h.KV.Del(ctx, key, attemptsKey, twoFactorEnrolledKey(req.ChallengeToken))
+	h.resetLoginFailures(ctx, userID)
+
+	var recoveryCodes []string
+	if enrolling {
+		if _, err := h.DB.Exec(ctx, `UPDATE users SET totp_enabled_at = NOW() WHERE id = $1`, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+			return
+		}
+		recoveryCodes, err = h.replaceRecoveryCodes(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+			return
+		}
+	}
+
+	resp, err := h.issueTokens(ctx, c, userID, false, 30*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	c.JSON(http.StatusOK, twoFactorLoginResponse{tokenResponse: resp, RecoveryCodes: recoveryCodes})
+}
+
+// twoFactorLocked answers 423 and returns true while the account is locked, so a
+// challenge started before the lock cannot be used to keep guessing
+func (h *AuthHandler) twoFactorLocked(c *gin.Context, ctx context.Context, userID int) bool {
+	var lockedUntil *time.Time
+	if err := h.DB.QueryRow(ctx, `SELECT locked_until FROM users WHERE id = $1`, userID).Scan(&lockedUntil); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid challenge"})
+		return true
+	}
+	if lockedUntil != nil && time.Now().Before(*lockedUntil) {
+		c.Header("Retry-After", retryAfterSeconds(time.Until(*lockedUntil)))
+		c.JSON(http.StatusLocked, gin.H{"error": "account temporarily locked after too many failed attempts", "lockedUntil": lockedUntil})
+		return true
+	}
+	return false
+}
+
+// failTwoFactor counts a wrong second factor against the account's login lockout like
+// a wrong password, so signing in again for a fresh challenge gives no fresh guesses.
+// The counter is only reset once the second factor passes. Once the account locks,
+// the challenge, named by keys, is dropped.
+func (h *AuthHandler) failTwoFactor(c *gin.Context, ctx context.Context, userID int, keys ...string) {
+	var email string
+	if err := h.DB.QueryRow(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	lockedUntil, err := h.recordLoginFailure(ctx, userID, email, c.ClientIP())
+	if err != nil {
+		log.Printf("2FA: failed to record failure for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if lockedUntil != nil {
+		h.KV.Del(ctx, keys...)
+		c.Header("Retry-After", retryAfterSeconds(time.Until(*lockedUntil)))
+		c.JSON(http.StatusLocked, gin.H{"error": "account temporarily locked after too many failed attempts", "lockedUntil": lockedUntil})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+}
+
+// TwoFactorStatus - GET /api/auth/2fa
+func (h *AuthHandler) TwoFactorStatus(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx := context.Background()
+	var (
+		enabledAt *time.Time
+		remaining int
+	)
+	err := h.DB.QueryRow(ctx, `
+		SELECT u.totp_enabled_at,
+		       (SELECT COUNT(*) FROM user_recovery_codes rc WHERE rc.user_id = u.id AND rc.used_at IS NULL)
+		FROM users u WHERE u.id = $1
+	`, userID).Scan(&enabledAt, &remaining)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	required, err := h.twoFactorRequired(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                enabledAt != nil,
+		"enabledAt":              enabledAt,
+		"required":               required,
+		"recoveryCodesRemaining": remaining,
+	})
+}
+
+// SetupTwoFactor - POST /api/auth/2fa/setup
+// Generates a new secret; 2FA stays off until EnableTwoFactor confirms a code from it.
+func (h *AuthHandler) SetupTwoFactor(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ctx := context.Background()
+	var (
+		email     string
+		enabledAt *time.Time
+	)
+	if err := h.DB.QueryRow(ctx, `SELECT email, totp_enabled_at FROM users WHERE id = $1`, userID).Scan(&email, &enabledAt); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if enabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+	if _, err := h.DB.Exec(ctx, `UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE id = $1`, userID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": auth.TOTPProvisioningURI(h.Cfg.TOTPIssuer, email, secret),
+	})
+}
+
+// EnableTwoFactor - POST /api/auth/2fa/enable
+// Returns the recovery codes; they are only shown this once.
+func (h *AuthHandler) EnableTwoFactor(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	var (
+		secret    *string
+		enabledAt *time.Time
+	)
+	if err := h.DB.QueryRow(ctx, `SELECT totp_secret, totp_enabled_at FROM users WHERE id = $1`, userID).Scan(&secret, &enabledAt); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if enabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+	if secret == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start setup first"})
+		return
+	}
+
+	valid, err := h.checkTOTP(ctx, userID, *secret, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+
+	if _, err := h.DB.Exec(ctx, `UPDATE users SET totp_enabled_at = NOW() WHERE id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+		return
+	}
+	codes, err := h.replaceRecoveryCodes(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "recoveryCodes": codes})
+}
+
+// DisableTwoFactor - POST /api/auth/2fa/disable
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	required, err := h.twoFactorRequired(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if required {
+		c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is mandatory for your role"})
+		return
+	}
+
+	if !h.verifyEnabledTOTP(c, ctx, userID, req.Code) {
+		return
+	}
+
+	if err := h.clearTwoFactor(ctx, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": false})
+}
+
+// RegenerateRecoveryCodes - POST /api/auth/2fa/recovery-codes
+// Invalidates the previous codes.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+
+	ctx := context.Background()
+	if !h.verifyEnabledTOTP(c, ctx, userID, req.Code) {
+		return
+	}
+
+	codes, err := h.replaceRecoveryCodes(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// ResetUserTwoFactor - DELETE /api/admin/users/:id/two-factor
+// For lost devices: the user enrolls again on next login if their role requires it.
+func (h *AuthHandler) ResetUserTwoFactor(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if err := h.clearTwoFactor(context.Background(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset two-factor authentication"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// twoFactorRequired reports whether any role of the user is listed in the two_factor_required_roles setting
+func (h *AuthHandler) twoFactorRequired(ctx context.Context, userID int) (bool, error) {
+	var required bool
+	err := h.DB.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_roles ur
+			JOIN roles r ON r.id = ur.role_id
+			JOIN settings s ON s.key = 'two_factor_required_roles'
+			WHERE ur.user_id = $1
+			  AND r.name IN (SELECT jsonb_array_elements_text(s.value::jsonb))
+		)
+	`, userID).Scan(&required)
+	return required, err
+}
+
+// checkTOTP validates a code and records its time step so the same code cannot be used twice
+func (h *AuthHandler) checkTOTP(ctx context.Context, userID int, secret, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	tag, err := h.DB.Exec(ctx, `
+		UPDATE users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// verifyEnabledTOTP checks a code for a user with 2FA enabled and writes the error response if it fails
+func (h *AuthHandler) verifyEnabledTOTP(c *gin.Context, ctx context.Context, userID int, code string) bool {
+	var (
+		secret    *string
+		enabledAt *time.Time
+	)
+	if err := h.DB.QueryRow(ctx, `SELECT totp_secret, totp_enabled_at FROM users WHERE id = $1`, userID).Scan(&secret, &enabledAt); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return false
+	}
+	if enabledAt == nil || secret == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+		return false
+	}
+	valid, err := h.checkTOTP(ctx, userID, *secret, code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return false
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return false
+	}
+	return true
+}
+
+// clearTwoFactor removes the secret and recovery codes of a user
+func (h *AuthHandler) clearTwoFactor(ctx context.Context, userID int) error {
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = $1
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes, storing only their hashes
+func (h *AuthHandler) replaceRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes := make([]string, 0, twoFactorRecoveryCodeCount)
+	for i := 0; i < twoFactorRecoveryCodeCount; i++ {
+		raw, err := randomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	batch := &pgx.Batch{}
+	for _, code := range codes {
+		batch.Queue(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hashToken(normalizeRecoveryCode(code)))
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useRecoveryCode consumes an unused recovery code of the user
+func (h *AuthHandler) useRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	tag, err := h.DB.Exec(ctx, `
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func twoFactorChallengeKey(token string) string {
+	return fmt.Sprintf("2fa:challenge:%s", hashToken(token))
+}
+
+// twoFactorEnrollCodeKey holds the hash of the code emailed for an enrollment challenge
+func twoFactorEnrollCodeKey(token string) string {
+	return twoFactorChallengeKey(token) + ":enroll_code"
+}
+
+// twoFactorEnrolledKey marks an enrollment challenge whose emailed code was confirmed
+func twoFactorEnrolledKey(token string) string {
+	return twoFactorChallengeKey(token) + ":enrolled"
+}
+
+// randomToken returns n random bytes, hex encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
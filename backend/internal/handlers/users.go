@@ -56,6 +56,7 @@ type Role struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
 	Description *string   `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -350,6 +351,15 @@ func (h *Handler) ListRoles(c *gin.Context) {
 		}
 		roles = append(roles, r)
 	}
+	rows.Close()
+
+	for i := range roles {
+		roles[i].Permissions, err = h.loadRolePermissions(c, roles[i].ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"data": roles})
 }
 
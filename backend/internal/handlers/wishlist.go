@@ -4,32 +4,18 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/etreasure/backend/internal/storage"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// Helper function to check if user is authenticated
-func isAuthenticated(c *gin.Context) bool {
-	token := c.GetHeader("Authorization")
-	return token != "" && strings.HasPrefix(token, "Bearer ")
-}
-
-// Helper function to get user ID from token
-func getUserID(c *gin.Context) string {
-	token := c.GetHeader("Authorization")
-	if token != "" && strings.HasPrefix(token, "Bearer ") {
-		// Extract user ID from token (simplified - in real app you'd decode JWT)
-		// For now, return empty string to force session-based approach
-		return ""
-	}
-	return ""
-}
+// Wishlists belong to the signed-in user when OptionalAuth verified a token, and to the
+// guest session cookie otherwise, the same way carts do (see cartOwner). The guest list
+// is merged into the user's on sign-in.
 
 type WishlistHandler struct {
 	DB          *pgxpool.Pool
@@ -37,17 +23,21 @@ type WishlistHandler struct {
 }
 
 type ToggleWishlistRequest struct {
-	ProductID string  `json:"product_id" binding:"required"`
-	Price     float64 `json:"price,omitempty"` // Allow price override for discounts
+	ProductID string `json:"product_id" binding:"required"`
+	// VariantID remembers the size/colour being looked at, for moving the item to the cart later
+	VariantID int `json:"variant_id,omitempty"`
 }
 
 type WishlistItem struct {
 	ID        string  `json:"id"`
 	ProductID string  `json:"product_id"`
+	VariantID *int    `json:"variant_id"`
 	Title     string  `json:"title"`
 	Price     float64 `json:"price"`
 	ImageURL  string  `json:"image_url"`
 	AddedAt   string  `json:"added_at"`
+	// AlertsEnabled is set when the owner asked for price-drop and back-in-stock emails for this item
+	AlertsEnabled bool `json:"alerts_enabled"`
 }
 
 type WishlistResponse struct {
@@ -63,13 +53,18 @@ func (h *WishlistHandler) ToggleWishlist(c *gin.Context) {
 		return
 	}
 
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+
 	ctx := context.Background()
 
 	// Check if product exists
 	var title string
 	var priceCents int
 	var currency string
-	var imageURL string
+	var imageURL *string
 	err := h.DB.QueryRow(ctx, `
 		SELECT p.title, COALESCE(pv.price_cents, 0) as price_cents, COALESCE(pv.currency, 'INR') as currency, 
 		       (SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1) as image_url
@@ -85,87 +80,25 @@ func (h *WishlistHandler) ToggleWishlist(c *gin.Context) {
 		return
 	}
 
-	// Get session ID from cookie or create new one
-	sessionID, err := c.Cookie("session_id")
-
-	if err != nil || sessionID == "" {
-		// Generate new session ID using UUID
-		sessionID = fmt.Sprintf("session_%s", uuid.New().String())
-		// Set secure flag based on whether request is HTTPS
-		// Check both TLS and X-Forwarded-Proto header (for proxy setups)
-		isSecure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
-
-		// For production, set cookie domain to work across ethnictreasures.co.in
-		cookieDomain := ""
-		// Only set domain for production/remote environments, not localhost
-		requestHost := c.Request.Host
-		headerHost := c.GetHeader("Host")
-		origin := c.GetHeader("Origin")
-		referer := c.GetHeader("Referer")
-
-		// Check if this is a production request from ethnictreasures.co.in
-		isProduction := (origin == "https://ethnictreasures.co.in") ||
-			(referer != "" && strings.Contains(referer, "ethnictreasures.co.in")) ||
-			(headerHost != "" && strings.Contains(headerHost, "ethnictreasures.co.in")) ||
-			(requestHost != "" && strings.Contains(requestHost, "ethnictreasures.co.in"))
-
-		// Check if this is localhost
-		isLocalhost := requestHost == "localhost:8080" ||
-			headerHost == "localhost:8080" ||
-			requestHost == "127.0.0.1:8080" ||
-			headerHost == "127.0.0.1:8080" ||
-			requestHost == "localhost:4321" ||
-			headerHost == "localhost:4321" ||
-			origin == "http://localhost:4321" ||
-			origin == "http://localhost:3000"
-
-		if isProduction && !isLocalhost {
-			// For cross-origin requests, don't set domain to let browser handle cookies naturally
-			cookieDomain = os.Getenv("COOKIE_DOMAIN")
-			if cookieDomain == "" {
-				// Don't set domain for cross-origin - let browser handle it
-				cookieDomain = ""
-			}
-		}
-
-		// Industry standard: Set single cookie with proper attributes for cross-domain
-		// For cross-domain scenarios, we need Domain=ethnictreasures.co.in with SameSite=None; Secure
-		// For localhost scenarios, we need no domain with SameSite=Lax
-
-		var cookieString string
-		if cookieDomain != "" && isSecure {
-			// Production: Cross-domain cookie with explicit domain
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Domain=%s; Max-Age=%d; HttpOnly=false; SameSite=None; Secure",
-				sessionID, cookieDomain, 86400*30)
-		} else if isSecure {
-			// Cross-origin or localhost: Secure cookie without domain
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Max-Age=%d; HttpOnly=false; SameSite=None; Secure",
-				sessionID, 86400*30)
-		} else {
-			// Localhost: Simple cookie
-			cookieString = fmt.Sprintf("session_id=%s; Path=/; Max-Age=%d; HttpOnly=false; SameSite=Lax",
-				sessionID, 86400*30)
-		}
-
-		c.Header("Set-Cookie", cookieString)
-
-	} else {
-		// Use existing session
+	// Guests without a session cookie get a new one
+	if owner.empty() {
+		owner.SessionID = newSessionCookie(c)
 	}
+	ownerColumn, ownerValue := owner.key()
 
-	// Check if item is already in wishlist (using session_id for guest users)
+	// Check if item is already in wishlist
 	var existingID any
 	err = h.DB.QueryRow(ctx, `
 		SELECT id FROM wishlist 
-		WHERE session_id = $1 AND product_id = $2::uuid
-	`, sessionID, req.ProductID).Scan(&existingID)
+		WHERE `+ownerColumn+` = $1 AND product_id = $2::uuid
+	`, ownerValue, req.ProductID).Scan(&existingID)
 
 	if err == nil {
 		// Remove from wishlist
 		_, err = h.DB.Exec(ctx, `
 			DELETE FROM wishlist 
-			WHERE session_id = $1 AND product_id = $2::uuid
-		`, sessionID, req.ProductID)
+			WHERE `+ownerColumn+` = $1 AND product_id = $2::uuid
+		`, ownerValue, req.ProductID)
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from wishlist"})
@@ -180,30 +113,24 @@ func (h *WishlistHandler) ToggleWishlist(c *gin.Context) {
 	} else {
 		// Add to wishlist
 		_, err = h.DB.Exec(ctx, `
-			INSERT INTO wishlist (session_id, product_id, created_at)
-			VALUES ($1, $2::uuid, NOW())
-		`, sessionID, req.ProductID)
+			INSERT INTO wishlist (`+ownerColumn+`, product_id, variant_id, created_at)
+			VALUES ($1, $2::uuid, NULLIF($3, 0), NOW())
+			ON CONFLICT (`+ownerColumn+`, product_id) WHERE `+ownerColumn+` IS NOT NULL DO NOTHING
+		`, ownerValue, req.ProductID, req.VariantID)
+		if err == nil {
+			_, err = h.DB.Exec(ctx, wishlistBaselineSQL(ownerColumn), ownerValue, req.ProductID)
+		}
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to wishlist", "details": err.Error()})
 			return
 		}
 
-		// Use provided price or get from database
-		var finalPrice float64
-		if req.Price > 0 {
-			// Use the provided price (discounted price)
-			finalPrice = req.Price
-		} else {
-			// Use regular price from database
-			finalPrice = float64(priceCents) / 100.0
-		}
-
 		c.JSON(http.StatusOK, gin.H{
 			"message":     "Product added to wishlist successfully",
 			"product_id":  req.ProductID,
 			"in_wishlist": true,
-			"price":       finalPrice,
+			"price":       float64(priceCents) / 100.0,
 			"currency":    currency,
 		})
 	}
@@ -211,10 +138,11 @@ func (h *WishlistHandler) ToggleWishlist(c *gin.Context) {
 
 // GetWishlist retrieves the user's wishlist
 func (h *WishlistHandler) GetWishlist(c *gin.Context) {
-	// Get session ID from cookie (for consistency with toggle)
-	sessionID, err := c.Cookie("session_id")
-
-	if err != nil || sessionID == "" {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
 		// Return empty wishlist for new users
 		c.JSON(http.StatusOK, WishlistResponse{
 			Items: []WishlistItem{},
@@ -225,24 +153,28 @@ func (h *WishlistHandler) GetWishlist(c *gin.Context) {
 
 	ctx := context.Background()
 
-	// Query with proper JOIN to get wishlist items with product details (using session_id)
+	// Query with proper JOIN to get wishlist items with product details
+	ownerColumn, ownerValue := owner.key()
 	query := `
 		SELECT 
 			w.id,
 			w.product_id::text,
+			w.variant_id,
 			p.title,
 			COALESCE(pv.price_cents, 0) as price_cents,
 			COALESCE(pv.currency, 'INR') as currency,
 			w.created_at,
-			(SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1) as image_url
+			(SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1) as image_url,
+			w.alerts_enabled
 		FROM wishlist w
 		JOIN products p ON w.product_id = p.uuid_id
-		LEFT JOIN product_variants pv ON p.uuid_id = pv.product_id
-		WHERE w.session_id = $1
+		LEFT JOIN product_variants pv ON pv.id = COALESCE(w.variant_id,
+			(SELECT MIN(v.id) FROM product_variants v WHERE v.product_id = p.uuid_id))
+		WHERE w.` + ownerColumn + ` = $1
 		ORDER BY w.created_at DESC
 	`
 
-	rows, err := h.DB.Query(ctx, query, sessionID)
+	rows, err := h.DB.Query(ctx, query, ownerValue)
 	if err != nil {
 		// Check if it's a table doesn't exist error
 		if strings.Contains(err.Error(), "does not exist") {
@@ -265,7 +197,7 @@ func (h *WishlistHandler) GetWishlist(c *gin.Context) {
 		var imagePath *string
 		var productID string
 
-		err := rows.Scan(&id, &productID, &item.Title, &priceCents, &currency, &createdAt, &imagePath)
+		err := rows.Scan(&id, &productID, &item.VariantID, &item.Title, &priceCents, &currency, &createdAt, &imagePath, &item.AlertsEnabled)
 		if err != nil {
 			continue
 		}
@@ -321,18 +253,21 @@ func (h *WishlistHandler) RemoveFromWishlist(c *gin.Context) {
 
 	ctx := context.Background()
 
-	// Get session ID from cookie
-	sessionID, err := c.Cookie("session_id")
-	if err != nil || sessionID == "" {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
 		return
 	}
+	ownerColumn, ownerValue := owner.key()
 
 	// Delete item from wishlist
-	_, err = h.DB.Exec(ctx, `
+	_, err := h.DB.Exec(ctx, `
 		DELETE FROM wishlist 
-		WHERE session_id = $1 AND product_id = $2::uuid
-	`, sessionID, productID)
+		WHERE `+ownerColumn+` = $1 AND product_id = $2::uuid
+	`, ownerValue, productID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item from wishlist"})
@@ -344,3 +279,216 @@ func (h *WishlistHandler) RemoveFromWishlist(c *gin.Context) {
 		"product_id": productID,
 	})
 }
+
+type moveWishlistToCartRequest struct {
+	// VariantID overrides the variant saved with the wishlist entry
+	VariantID int `json:"variant_id,omitempty"`
+	Quantity  int `json:"quantity,omitempty"`
+}
+
+// MoveFromCart - POST /api/cart/:id/move-to-wishlist
+// Takes a cart line, in the cart or saved for later, off the cart and onto the wishlist,
+// keeping its variant.
+func (h *WishlistHandler) MoveFromCart(c *gin.Context) {
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		return
+	}
+	ownerColumn, ownerValue := owner.key()
+
+	ctx := c.Request.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to wishlist"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	itemID := c.Param("id")
+	var productID string
+	var variantID *int
+	err = tx.QueryRow(ctx, `
+		DELETE FROM cart WHERE id = $1 AND `+ownerColumn+` = $2
+		RETURNING product_id::text, variant_id
+	`, itemID, ownerValue).Scan(&productID, &variantID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to wishlist"})
+		return
+	}
+
+	// Already wishlisted: keep the entry and remember the variant from the cart
+	_, err = tx.Exec(ctx, `
+		INSERT INTO wishlist (`+ownerColumn+`, product_id, variant_id, created_at)
+		VALUES ($1, $2::uuid, $3, NOW())
+		ON CONFLICT (`+ownerColumn+`, product_id) WHERE `+ownerColumn+` IS NOT NULL
+		DO UPDATE SET variant_id = EXCLUDED.variant_id
+	`, ownerValue, productID, variantID)
+	if err == nil {
+		_, err = tx.Exec(ctx, wishlistBaselineSQL(ownerColumn), ownerValue, productID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to wishlist"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Item moved to wishlist",
+		"item_id":     itemID,
+		"product_id":  productID,
+		"variant_id":  variantID,
+		"in_wishlist": true,
+	})
+}
+
+// cartTarget is the variant a wishlist item moves into and the owner's cart line for it
+type cartTarget struct {
+	VariantID int
+	Stock     int
+	Available bool
+	InCart    int
+	// Gift is set when the cart line holds a registry gift
+	Gift bool
+}
+
+// lookupCartTarget finds the product's variant, variantID or its first one when 0, with
+// the owner's cart line for it
+func lookupCartTarget(ctx context.Context, tx pgx.Tx, owner cartOwner, productID string, variantID int) (cartTarget, error) {
+	ownerColumn, ownerValue := owner.key()
+	var t cartTarget
+	err := tx.QueryRow(ctx, `
+		SELECT pv.id, COALESCE(pv.stock_quantity, 0), COALESCE(p.published, FALSE),
+		       COALESCE(c.quantity, 0), c.registry_item_id IS NOT NULL
+		FROM products p
+		JOIN product_variants pv ON p.uuid_id = pv.product_id
+		LEFT JOIN cart c ON c.`+ownerColumn+` = $3 AND c.variant_id = pv.id
+		WHERE p.uuid_id = $1::uuid AND ($2 = 0 OR pv.id = $2)
+		ORDER BY pv.id
+		LIMIT 1
+	`, productID, variantID, ownerValue).Scan(&t.VariantID, &t.Stock, &t.Available, &t.InCart, &t.Gift)
+	return t, err
+}
+
+// MoveToCart - POST /api/wishlist/:id/move-to-cart
+// Adds a wishlisted product to the cart in its saved variant and takes it off the
+// wishlist. The quantity is cut to the stock left; an unavailable item stays wishlisted.
+func (h *WishlistHandler) MoveToCart(c *gin.Context) {
+	var req moveWishlistToCartRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+	}
+	if req.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be positive"})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	owner, ok := cartOwnerFromRequest(c)
+	if !ok {
+		return
+	}
+	if owner.empty() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No session found"})
+		return
+	}
+	ownerColumn, ownerValue := owner.key()
+
+	ctx := c.Request.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	productID := c.Param("id")
+	var savedVariant *int
+	err = tx.QueryRow(ctx, `
+		SELECT variant_id FROM wishlist WHERE `+ownerColumn+` = $1 AND product_id = $2::uuid
+		FOR UPDATE
+	`, ownerValue, productID).Scan(&savedVariant)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+	variantID := req.VariantID
+	if variantID == 0 && savedVariant != nil {
+		variantID = *savedVariant
+	}
+
+	target, err := lookupCartTarget(ctx, tx, owner, productID, variantID)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found for this product"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+	// As in AddToCart, the shopper's own item must not merge into a gift bound for a registry
+	if target.Gift {
+		c.JSON(http.StatusConflict, gin.H{"error": "this item is already in your cart as a registry gift; check it out or remove it first"})
+		return
+	}
+	variantID, stock, inCart, available := target.VariantID, target.Stock, target.InCart, target.Available
+
+	quantity, outcome := mergeCartLine(inCart, req.Quantity, stock, available)
+	if outcome == cartMergeDropped || quantity <= inCart {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "insufficient stock",
+			"current_stock": stock,
+			"existing_cart": inCart,
+			"requested":     req.Quantity,
+			"variant_id":    variantID,
+		})
+		return
+	}
+
+	var itemID int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO cart (`+ownerColumn+`, product_id, variant_id, quantity, updated_at)
+		VALUES ($1, $2::uuid, $3, $4, NOW())
+		ON CONFLICT (`+ownerColumn+`, variant_id) WHERE `+ownerColumn+` IS NOT NULL
+		DO UPDATE SET quantity = EXCLUDED.quantity,
+		              saved_for_later = FALSE,
+		              updated_at = NOW()
+		RETURNING id
+	`, ownerValue, productID, variantID, quantity).Scan(&itemID)
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM wishlist WHERE `+ownerColumn+` = $1 AND product_id = $2::uuid`, ownerValue, productID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Item moved to cart",
+		"item_id":    itemID,
+		"product_id": productID,
+		"variant_id": variantID,
+		"quantity":   quantity,
+		"capped":     outcome == cartMergeCapped,
+	})
+}
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/etreasure/backend/internal/config"
+	"github.com/etreasure/backend/internal/email"
+	"github.com/etreasure/backend/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Signed-in customers can ask to hear when something on their wishlist gets cheaper or
+// comes back in stock, for the whole wishlist or entry by entry. Changes are recorded
+// when a product is updated and go out together in one digest email, at most once a day.
+
+const (
+	wishlistAlertList      = "wishlist_alerts"
+	wishlistAlertPriceDrop = "price_drop"
+	wishlistAlertRestock   = "back_in_stock"
+	wishlistDigestInterval = 24 * time.Hour
+)
+
+// wishlistItemStateSQL is what a wishlist entry w is watched at: the variant it was saved
+// in, or else the cheapest variant in stock
+const wishlistItemStateSQL = `
+	SELECT v.price_cents, COALESCE(p.published, FALSE) AND COALESCE(v.stock_quantity, 0) > 0 AS in_stock
+	FROM product_variants v JOIN products p ON p.uuid_id = v.product_id
+	WHERE v.product_id = w.product_id AND (w.variant_id IS NULL OR v.id = w.variant_id)
+	ORDER BY COALESCE(v.stock_quantity, 0) > 0 DESC, v.price_cents, v.id
+	LIMIT 1`
+
+// wishlistBaselineSQL records the current price and availability of the entry for the
+// owner and product in $1 and $2, so later changes are measured from when it was saved
+func wishlistBaselineSQL(ownerColumn string) string {
+	return `
+		UPDATE wishlist w SET (alert_price_cents, alert_in_stock) = (` + wishlistItemStateSQL + `)
+		WHERE w.` + ownerColumn + ` = $1 AND w.product_id = $2::uuid`
+}
+
+// WishlistAlertsHandler sends the digests and serves the alert preferences
+type WishlistAlertsHandler struct {
+	DB          *pgxpool.Pool
+	Email       *email.EmailService
+	Cfg         config.Config
+	ImageHelper *storage.ImageURLHelper
+}
+
+// wishlistAlertKind compares an entry with the price and availability it was last seen at.
+// Only items that can be bought now are reported. restocked is set when the change being
+// recorded is a restock of the product, whatever the entry last saw.
+func wishlistAlertKind(wasPrice *int, wasInStock *bool, price int, inStock, restocked bool) (string, bool) {
+	if !inStock {
+		return "", false
+	}
+	if restocked || (wasInStock != nil && !*wasInStock) {
+		return wishlistAlertRestock, true
+	}
+	if wasPrice != nil && price < *wasPrice {
+		return wishlistAlertPriceDrop, true
+	}
+	return "", false
+}
+
+// queueWishlistAlerts records price drops and restocks of a product for the customers
+// who wishlisted it and asked for alerts, and moves every entry's baseline to the
+// product's current state. Calling it again without a change records nothing.
+func queueWishlistAlerts(ctx context.Context, db *pgxpool.Pool, productID string, restocked bool) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT w.id, w.user_id, s.price_cents, s.in_stock, w.alert_price_cents, w.alert_in_stock,
+		       w.alerts_enabled OR u.wishlist_alerts_enabled
+		FROM wishlist w
+		JOIN users u ON u.id = w.user_id
+		CROSS JOIN LATERAL (`+wishlistItemStateSQL+`) s
+		WHERE w.product_id = $1::uuid
+		ORDER BY w.id
+		FOR UPDATE OF w
+	`, productID)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		id, userID, price int
+		inStock, enabled  bool
+		wasPrice          *int
+		wasInStock        *bool
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.userID, &e.price, &e.inStock, &e.wasPrice, &e.wasInStock, &e.enabled); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if kind, ok := wishlistAlertKind(e.wasPrice, e.wasInStock, e.price, e.inStock, restocked); ok && e.enabled {
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO wishlist_alerts (user_id, product_id, kind, old_price_cents, new_price_cents)
+				VALUES ($1, $2::uuid, $3, $4, $5)
+			`, e.userID, productID, kind, e.wasPrice, e.price); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE wishlist SET alert_price_cents = $2, alert_in_stock = $3 WHERE id = $1
+		`, e.id, e.price, e.inStock); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Run sends due digests every interval until ctx is cancelled
+func (h *WishlistAlertsHandler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := h.sendDueDigests(ctx)
+			if err != nil {
+				log.Printf("wishlist alerts: run failed: %v", err)
+			} else if n > 0 {
+				log.Printf("wishlist alerts: sent %d digests", n)
+			}
+		}
+	}
+}
+
+type wishlistDigestCandidate struct {
+	userID int
+	email  string
+}
+
+func (h *WishlistAlertsHandler) sendDueDigests(ctx context.Context) (int, error) {
+	// Alerts nobody will receive, because the customer opted out or the digest kept
+	// failing, are not kept forever
+	if _, err := h.DB.Exec(ctx, `
+		DELETE FROM wishlist_alerts WHERE digest_id IS NULL AND created_at < NOW() - INTERVAL '7 days'
+	`); err != nil {
+		return 0, err
+	}
+
+	rows, err := h.DB.Query(ctx, `
+		SELECT u.id, u.email
+		FROM users u
+		WHERE EXISTS (SELECT 1 FROM wishlist_alerts a WHERE a.user_id = u.id AND a.digest_id IS NULL)
+		  AND u.is_active = TRUE AND u.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM wishlist_alert_digests d
+		                  WHERE d.user_id = u.id AND d.sent_at > NOW() - make_interval(secs => $1))
+		  AND NOT EXISTS (SELECT 1 FROM email_opt_outs x WHERE x.email = LOWER(u.email) AND x.list = $2)
+	`, wishlistDigestInterval.Seconds(), wishlistAlertList)
+	if err != nil {
+		return 0, err
+	}
+	var candidates []wishlistDigestCandidate
+	for rows.Next() {
+		var cand wishlistDigestCandidate
+		if err := rows.Scan(&cand.userID, &cand.email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		candidates = append(candidates, cand)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, cand := range candidates {
+		ok, err := h.sendDigest(ctx, cand)
+		if err != nil {
+			log.Printf("wishlist alerts: digest to user %d failed: %v", cand.userID, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// digestContent lists the alerts claimed by a digest, one line per product, checked
+// against the wishlist as it is now: entries that were removed, have alerts turned
+// off, sold out again or went back up in price are left out
+func (h *WishlistAlertsHandler) digestContent(ctx context.Context, digestID int64) (email.WishlistDigest, error) {
+	var d email.WishlistDigest
+	rows, err := h.DB.Query(ctx, `
+		SELECT p.title, p.slug,
+		       (SELECT m.path FROM product_images pi JOIN media m ON pi.media_id = m.id WHERE pi.product_id = p.uuid_id ORDER BY pi.sort_order LIMIT 1),
+		       s.price_cents, MAX(a.old_price_cents), BOOL_OR(a.kind = 'back_in_stock')
+		FROM wishlist_alerts a
+		JOIN wishlist w ON w.user_id = a.user_id AND w.product_id = a.product_id
+		JOIN users u ON u.id = w.user_id
+		JOIN products p ON p.uuid_id = a.product_id
+		CROSS JOIN LATERAL (`+wishlistItemStateSQL+`) s
+		WHERE a.digest_id = $1
+		  AND (w.alerts_enabled OR u.wishlist_alerts_enabled)
+		  AND s.in_stock
+		GROUP BY p.uuid_id, p.title, p.slug, s.price_cents
+		ORDER BY MAX(a.created_at) DESC
+	`, digestID)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+
+	base := strings.TrimRight(h.Cfg.WebBaseURL, "/")
+	for rows.Next() {
+		var title, slug string
+		var imagePath *string
+		var priceCents int
+		var oldPriceCents *int
+		var restocked bool
+		if err := rows.Scan(&title, &slug, &imagePath, &priceCents, &oldPriceCents, &restocked); err != nil {
+			return d, err
+		}
+		item := email.WishlistDigestItem{
+			Title:       title,
+			ProductURL:  base + "/product/" + url.PathEscape(slug),
+			Price:       float64(priceCents) / 100,
+			BackInStock: restocked,
+		}
+		if oldPriceCents != nil && *oldPriceCents > priceCents {
+			item.OldPrice = float64(*oldPriceCents) / 100
+		} else if !restocked {
+			continue
+		}
+		item.ImageURL = (&CartHandler{ImageHelper: h.ImageHelper}).imageURL(imagePath)
+		d.Items = append(d.Items, item)
+	}
+	return d, rows.Err()
+}
+
+// sendDigest records the digest, claims the customer's pending alerts for it and emails
+// them. When the email cannot be sent the record is removed, which hands the alerts back
+// to the next run. Alerts that no longer apply are dropped without an email.
+func (h *WishlistAlertsHandler) sendDigest(ctx context.Context, cand wishlistDigestCandidate) (bool, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return false, err
+	}
+	var id int64
+	err = h.DB.QueryRow(ctx, `
+		INSERT INTO wishlist_alert_digests (user_id, email, token_hash) VALUES ($1, $2, $3)
+		RETURNING id
+	`, cand.userID, cand.email, hashToken(token)).Scan(&id)
+	if err != nil {
+		return false, err
+	}
+	discard := func() {
+		_, _ = h.DB.Exec(ctx, `DELETE FROM wishlist_alert_digests WHERE id = $1`, id)
+	}
+
+	if _, err := h.DB.Exec(ctx, `
+		UPDATE wishlist_alerts SET digest_id = $2 WHERE user_id = $1 AND digest_id IS NULL
+	`, cand.userID, id); err != nil {
+		discard()
+		return false, err
+	}
+	digest, err := h.digestContent(ctx, id)
+	if err != nil {
+		discard()
+		return false, err
+	}
+	if len(digest.Items) == 0 {
+		_, err := h.DB.Exec(ctx, `DELETE FROM wishlist_alerts WHERE digest_id = $1`, id)
+		discard()
+		return false, err
+	}
+
+	base := strings.TrimRight(h.Cfg.WebBaseURL, "/")
+	digest.WishlistURL = base + "/wishlist"
+	digest.UnsubscribeURL = base + "/unsubscribe?token=" + url.QueryEscape(token)
+	if err := h.Email.SendWishlistDigestEmail(cand.email, digest); err != nil {
+		discard()
+		return false, err
+	}
+	return true, nil
+}
+
+type wishlistAlertsRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// GetAlertSettings - GET /api/account/wishlist-alerts
+func (h *WishlistAlertsHandler) GetAlertSettings(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var enabled, optedOut bool
+	err := h.DB.QueryRow(c.Request.Context(), `
+		SELECT u.wishlist_alerts_enabled,
+		       EXISTS (SELECT 1 FROM email_opt_outs x WHERE x.email = LOWER(u.email) AND x.list = $2)
+		FROM users u WHERE u.id = $1
+	`, userID, wishlistAlertList).Scan(&enabled, &optedOut)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled && !optedOut})
+}
+
+// UpdateAlertSettings - PUT /api/account/wishlist-alerts
+// Turns alerts on or off for the whole wishlist. Turning them on also lifts an earlier
+// unsubscribe from the digest email.
+func (h *WishlistAlertsHandler) UpdateAlertSettings(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req wishlistAlertsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := h.DB.Exec(ctx, `
+		UPDATE users SET wishlist_alerts_enabled = $2, updated_at = NOW() WHERE id = $1
+	`, userID, *req.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if *req.Enabled {
+		if err := h.clearOptOut(ctx, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": *req.Enabled})
+}
+
+// UpdateItemAlerts - PUT /api/wishlist/:id/alerts
+// Turns alerts on or off for one wishlisted product (:id is the product ID).
+func (h *WishlistAlertsHandler) UpdateItemAlerts(c *gin.Context) {
+	userID, ok := contextUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "sign in to get wishlist alerts"})
+		return
+	}
+	var req wishlistAlertsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	ctx := c.Request.Context()
+	tag, err := h.DB.Exec(ctx, `
+		UPDATE wishlist SET alerts_enabled = $3 WHERE user_id = $1 AND product_id = $2::uuid
+	`, userID, c.Param("id"), *req.Enabled)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in wishlist"})
+		return
+	}
+	if *req.Enabled {
+		if err := h.clearOptOut(ctx, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"product_id": c.Param("id"), "alerts_enabled": *req.Enabled})
+}
+
+func (h *WishlistAlertsHandler) clearOptOut(ctx context.Context, userID int) error {
+	_, err := h.DB.Exec(ctx, `
+		DELETE FROM email_opt_outs x USING users u
+		WHERE u.id = $1 AND x.email = LOWER(u.email) AND x.list = $2
+	`, userID, wishlistAlertList)
+	return err
+}
+
+// wishlistDigestAddress resolves the unsubscribe token of a digest to the address it was
+// sent to
+func wishlistDigestAddress(ctx context.Context, db *pgxpool.Pool, token string) (string, error) {
+	var address string
+	err := db.QueryRow(ctx, `SELECT email FROM wishlist_alert_digests WHERE token_hash = $1`, hashToken(token)).Scan(&address)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return address, err
+}
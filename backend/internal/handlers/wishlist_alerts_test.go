@@ -0,0 +1,33 @@
+package handlers
+
+import "testing"
+
+func TestWishlistAlertKind(t *testing.T) {
+	intp := func(v int) *int { return &v }
+	boolp := func(v bool) *bool { return &v }
+	cases := []struct {
+		name       string
+		wasPrice   *int
+		wasInStock *bool
+		price      int
+		inStock    bool
+		restocked  bool
+		wantKind   string
+		wantOK     bool
+	}{
+		{"price dropped", intp(5000), boolp(true), 4000, true, false, wishlistAlertPriceDrop, true},
+		{"price unchanged", intp(5000), boolp(true), 5000, true, false, "", false},
+		{"price rose", intp(5000), boolp(true), 6000, true, false, "", false},
+		{"back in stock", intp(5000), boolp(false), 5000, true, false, wishlistAlertRestock, true},
+		{"restock wins over a drop", intp(5000), boolp(false), 4000, true, false, wishlistAlertRestock, true},
+		{"product restocked", intp(5000), boolp(true), 5000, true, true, wishlistAlertRestock, true},
+		{"cheaper but sold out", intp(5000), boolp(true), 4000, false, false, "", false},
+		{"no baseline", nil, nil, 4000, true, false, "", false},
+	}
+	for _, tc := range cases {
+		kind, ok := wishlistAlertKind(tc.wasPrice, tc.wasInStock, tc.price, tc.inStock, tc.restocked)
+		if kind != tc.wantKind || ok != tc.wantOK {
+			t.Errorf("%s: wishlistAlertKind = %q, %v, want %q, %v", tc.name, kind, ok, tc.wantKind, tc.wantOK)
+		}
+	}
+}
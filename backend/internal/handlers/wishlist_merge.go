@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// mergeGuestWishlist moves the guest session's wishlist onto the user's. Products already
+// on the user's list keep their entry, picking up the guest's variant if they had none.
+// It returns how many guest entries were merged.
+func mergeGuestWishlist(ctx context.Context, db *pgxpool.Pool, sessionID string, userID int) (int, error) {
+	tag, err := db.Exec(ctx, `
+		WITH moved AS (
+			DELETE FROM wishlist WHERE session_id = $1
+			RETURNING product_id, variant_id, created_at, alert_price_cents, alert_in_stock
+		)
+		INSERT INTO wishlist (user_id, product_id, variant_id, created_at, alert_price_cents, alert_in_stock)
+		SELECT $2, product_id, variant_id, created_at, alert_price_cents, alert_in_stock FROM moved
+		ON CONFLICT (user_id, product_id) WHERE user_id IS NOT NULL
+		DO UPDATE SET variant_id = COALESCE(wishlist.variant_id, EXCLUDED.variant_id)
+	`, sessionID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
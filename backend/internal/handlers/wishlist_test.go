@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// A wishlist item moved to the cart must not merge into a registry gift of the same variant
+func TestLookupCartTargetSeesRegistryGift(t *testing.T) {
+	ctx, tx := testDBTx(t)
+	variantID := insertTestVariant(t, ctx, tx, 5)
+	var productID string
+	if err := tx.QueryRow(ctx, `SELECT product_id::text FROM product_variants WHERE id = $1`, variantID).Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	var userID int
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO users (email, password_hash) VALUES ($1, 'x') RETURNING id
+	`, fmt.Sprintf("wishlist-%d@example.com", time.Now().UnixNano())).Scan(&userID); err != nil {
+		t.Fatal(err)
+	}
+	owner := cartOwner{UserID: userID}
+
+	target, err := lookupCartTarget(ctx, tx, owner, productID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.VariantID != variantID || target.InCart != 0 || target.Gift {
+		t.Fatalf("empty cart: target = %+v", target)
+	}
+
+	var registryItemID int64
+	if err := tx.QueryRow(ctx, `
+		WITH r AS (
+			INSERT INTO gift_registries (user_id, share_token, name) VALUES ($1, $2, 'Wedding') RETURNING id
+		)
+		INSERT INTO gift_registry_items (registry_id, product_id, variant_id)
+		SELECT r.id, $3::uuid, $4 FROM r RETURNING id
+	`, userID, fmt.Sprint(time.Now().UnixNano()), productID, variantID).Scan(&registryItemID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO cart (user_id, product_id, variant_id, quantity, registry_item_id) VALUES ($1, $2::uuid, $3, 1, $4)
+	`, userID, productID, variantID, registryItemID); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err = lookupCartTarget(ctx, tx, owner, productID, variantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !target.Gift || target.InCart != 1 {
+		t.Errorf("gift in cart: target = %+v, want Gift with 1 in cart", target)
+	}
+}
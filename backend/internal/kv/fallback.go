@@ -0,0 +1,144 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// FallbackStore uses Primary (Redis) and switches to Secondary (Postgres) when
+// Primary returns an error. After a failure Primary is skipped for RetryAfter so
+// every request does not wait on a dead connection.
+//
+// Reads that miss on Primary also check Secondary, so codes issued during an outage
+// stay valid after Redis comes back. Counters and SetNX keys created during an outage
+// stay in Secondary until they expire, so a limit is not reset by Redis coming back;
+// Secondary is only checked for them until the last one written has expired, so a
+// healthy Redis costs no Postgres reads. Deletes go to both stores.
+type FallbackStore struct {
+	Primary    Store
+	Secondary  Store
+	RetryAfter time.Duration
+
+	mu        sync.Mutex
+	downUntil time.Time
+	// outageKeysUntil is when the last counter or SetNX key written to Secondary expires
+	outageKeysUntil time.Time
+}
+
+func NewFallbackStore(primary, secondary Store) *FallbackStore {
+	return &FallbackStore{Primary: primary, Secondary: secondary, RetryAfter: 30 * time.Second}
+}
+
+// primaryUp reports whether Primary should be tried
+func (s *FallbackStore) primaryUp() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().After(s.downUntil)
+}
+
+// failed records a Primary error; ErrNotFound is an answer, not a failure
+func (s *FallbackStore) failed(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().After(s.downUntil) {
+		log.Printf("kv: primary store unavailable, using fallback for %s: %v", s.RetryAfter, err)
+	}
+	s.downUntil = time.Now().Add(s.RetryAfter)
+	return true
+}
+
+func (s *FallbackStore) Get(ctx context.Context, key string) (string, error) {
+	if s.primaryUp() {
+		v, err := s.Primary.Get(ctx, key)
+		if !s.failed(err) && !errors.Is(err, ErrNotFound) {
+			return v, err
+		}
+	}
+	return s.Secondary.Get(ctx, key)
+}
+
+func (s *FallbackStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if s.primaryUp() {
+		if err := s.Primary.Set(ctx, key, value, ttl); !s.failed(err) {
+			return err
+		}
+	}
+	return s.Secondary.Set(ctx, key, value, ttl)
+}
+
+// wroteSecondary records that a counter or SetNX key living for ttl went to Secondary
+func (s *FallbackStore) wroteSecondary(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until := time.Now().Add(ttl); until.After(s.outageKeysUntil) {
+		s.outageKeysUntil = until
+	}
+}
+
+// inSecondary reports whether Secondary holds a live copy of key, written while Primary
+// was down. A Secondary error counts as no: Primary is up and keeps answering.
+func (s *FallbackStore) inSecondary(ctx context.Context, key string) bool {
+	s.mu.Lock()
+	outage := time.Now().Before(s.outageKeysUntil)
+	s.mu.Unlock()
+	if !outage {
+		return false
+	}
+	_, err := s.Secondary.Get(ctx, key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("kv: fallback store unavailable while checking %s: %v", key, err)
+	}
+	return err == nil
+}
+
+func (s *FallbackStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	if s.primaryUp() {
+		if s.inSecondary(ctx, key) {
+			return false, nil
+		}
+		ok, err := s.Primary.SetNX(ctx, key, value, ttl)
+		if !s.failed(err) {
+			return ok, err
+		}
+	}
+	s.wroteSecondary(ttl)
+	return s.Secondary.SetNX(ctx, key, value, ttl)
+}
+
+func (s *FallbackStore) GetDel(ctx context.Context, key string) (string, error) {
+	if s.primaryUp() {
+		v, err := s.Primary.GetDel(ctx, key)
+		if !s.failed(err) && !errors.Is(err, ErrNotFound) {
+			return v, err
+		}
+	}
+	return s.Secondary.GetDel(ctx, key)
+}
+
+func (s *FallbackStore) Del(ctx context.Context, keys ...string) (int64, error) {
+	var n int64
+	if s.primaryUp() {
+		if deleted, err := s.Primary.Del(ctx, keys...); !s.failed(err) {
+			n += deleted
+		}
+	}
+	deleted, err := s.Secondary.Del(ctx, keys...)
+	return n + deleted, err
+}
+
+func (s *FallbackStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if s.primaryUp() && !s.inSecondary(ctx, key) {
+		n, err := s.Primary.Incr(ctx, key, ttl)
+		if !s.failed(err) {
+			return n, err
+		}
+	}
+	s.wroteSecondary(ttl)
+	return s.Secondary.Incr(ctx, key, ttl)
+}
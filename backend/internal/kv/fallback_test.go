@@ -0,0 +1,246 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory Store; down makes every call fail like an unreachable Redis
+type memStore struct {
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+	down    bool
+	gets    int
+}
+
+var errDown = errors.New("connection refused")
+
+func newMemStore() *memStore {
+	return &memStore{values: map[string]string{}, expires: map[string]time.Time{}}
+}
+
+func (m *memStore) live(key string) (string, bool) {
+	v, ok := m.values[key]
+	if !ok || time.Now().After(m.expires[key]) {
+		return "", false
+	}
+	return v, true
+}
+
+func (m *memStore) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gets++
+	if m.down {
+		return "", errDown
+	}
+	if v, ok := m.live(key); ok {
+		return v, nil
+	}
+	return "", ErrNotFound
+}
+
+func (m *memStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return errDown
+	}
+	m.values[key], m.expires[key] = value, time.Now().Add(ttl)
+	return nil
+}
+
+func (m *memStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return false, errDown
+	}
+	if _, ok := m.live(key); ok {
+		return false, nil
+	}
+	m.values[key], m.expires[key] = value, time.Now().Add(ttl)
+	return true, nil
+}
+
+func (m *memStore) GetDel(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return "", errDown
+	}
+	v, ok := m.live(key)
+	delete(m.values, key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+func (m *memStore) Del(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return 0, errDown
+	}
+	var n int64
+	for _, k := range keys {
+		if _, ok := m.live(k); ok {
+			n++
+		}
+		delete(m.values, k)
+	}
+	return n, nil
+}
+
+func (m *memStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return 0, errDown
+	}
+	var n int64
+	if v, ok := m.live(key); ok {
+		n, _ = strconv.ParseInt(v, 10, 64)
+	} else {
+		m.expires[key] = time.Now().Add(ttl)
+	}
+	n++
+	m.values[key] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func TestFallbackStoreSurvivesPrimaryOutage(t *testing.T) {
+	ctx := context.Background()
+	redis, postgres := newMemStore(), newMemStore()
+	s := NewFallbackStore(redis, postgres)
+
+	if err := s.Set(ctx, "otp:a@example.com", "123456", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := redis.values["otp:a@example.com"]; !ok {
+		t.Fatal("healthy primary should receive writes")
+	}
+
+	// Redis goes down: a password reset still works end to end
+	redis.down = true
+	if err := s.Set(ctx, "otp:b@example.com", "654321", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(ctx, "otp:b@example.com"); err != nil || v != "654321" {
+		t.Fatalf("Get during outage = %q, %v", v, err)
+	}
+	if n, err := s.Incr(ctx, "login:ip:1.2.3.4", time.Minute); err != nil || n != 1 {
+		t.Fatalf("Incr during outage = %d, %v", n, err)
+	}
+
+	// Redis is back: codes issued during the outage are still found, once
+	redis.down = false
+	s.downUntil = time.Time{}
+	if v, err := s.GetDel(ctx, "otp:b@example.com"); err != nil || v != "654321" {
+		t.Fatalf("GetDel after recovery = %q, %v", v, err)
+	}
+	if _, err := s.Get(ctx, "otp:b@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("consumed code should be gone, got %v", err)
+	}
+	if v, err := s.Get(ctx, "otp:a@example.com"); err != nil || v != "123456" {
+		t.Fatalf("Get from primary after recovery = %q, %v", v, err)
+	}
+}
+
+func TestFallbackStoreSkipsPrimaryWhileDown(t *testing.T) {
+	ctx := context.Background()
+	redis, postgres := newMemStore(), newMemStore()
+	s := NewFallbackStore(redis, postgres)
+
+	redis.down = true
+	if _, err := s.SetNX(ctx, "cooldown", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// Recovered, but still inside RetryAfter: writes keep going to the fallback
+	redis.down = false
+	if ok, err := s.SetNX(ctx, "cooldown", "1", time.Minute); err != nil || ok {
+		t.Fatalf("SetNX on an existing key = %v, %v", ok, err)
+	}
+	if _, ok := redis.values["cooldown"]; ok {
+		t.Fatal("primary should be skipped until RetryAfter has passed")
+	}
+}
+
+func TestFallbackStoreKeepsOutageCountersAfterRecovery(t *testing.T) {
+	ctx := context.Background()
+	redis, postgres := newMemStore(), newMemStore()
+	s := NewFallbackStore(redis, postgres)
+
+	redis.down = true
+	for i := 0; i < 3; i++ {
+		if _, err := s.Incr(ctx, "2fa:challenge:x:attempts", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.SetNX(ctx, "login_otp_cooldown:a@example.com", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redis is back: the attempts made during the outage still count, and the cooldown holds
+	redis.down = false
+	s.downUntil = time.Time{}
+	if n, err := s.Incr(ctx, "2fa:challenge:x:attempts", time.Minute); err != nil || n != 4 {
+		t.Fatalf("Incr after recovery = %d, %v, want 4", n, err)
+	}
+	if ok, err := s.SetNX(ctx, "login_otp_cooldown:a@example.com", "1", time.Minute); err != nil || ok {
+		t.Fatalf("SetNX on a key set during the outage = %v, %v", ok, err)
+	}
+	if _, ok := redis.values["2fa:challenge:x:attempts"]; ok {
+		t.Fatal("a counter started during the outage should stay in the fallback store")
+	}
+
+	// New keys go to Redis again
+	if n, err := s.Incr(ctx, "login:ip:1.2.3.4", time.Minute); err != nil || n != 1 {
+		t.Fatalf("Incr of a new counter = %d, %v", n, err)
+	}
+	if _, ok := redis.values["login:ip:1.2.3.4"]; !ok {
+		t.Fatal("new counters should be kept in the primary store")
+	}
+}
+
+// While Redis is healthy and nothing is left over from an outage, limits cost no
+// Postgres reads
+func TestFallbackStoreSkipsSecondaryWithoutOutage(t *testing.T) {
+	ctx := context.Background()
+	redis, postgres := newMemStore(), newMemStore()
+	s := NewFallbackStore(redis, postgres)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Incr(ctx, "login:ip:1.2.3.4", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.SetNX(ctx, "login_otp_cooldown:a@example.com", "1", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if postgres.gets != 0 {
+		t.Errorf("secondary read %d times with no outage", postgres.gets)
+	}
+
+	// Once the keys written during an outage have expired, Secondary is left alone again
+	redis.down = true
+	if _, err := s.Incr(ctx, "2fa:challenge:x:attempts", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	redis.down = false
+	s.downUntil = time.Time{}
+	time.Sleep(2 * time.Millisecond)
+	postgres.gets = 0
+	if _, err := s.Incr(ctx, "login:ip:1.2.3.4", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if postgres.gets != 0 {
+		t.Errorf("secondary read %d times after the outage keys expired", postgres.gets)
+	}
+}
@@ -0,0 +1,26 @@
+// Package kv is a small key-value store with per-key expiry, used for OTPs,
+// sign-in challenges and rate-limit counters. Redis is the primary backend;
+// Postgres keeps those flows working while Redis is unavailable.
+package kv
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a key does not exist or has expired
+var ErrNotFound = errors.New("kv: key not found")
+
+type Store interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// SetNX stores the value only when the key is absent and reports whether it did
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// GetDel returns the value and removes the key, so only one caller can consume it
+	GetDel(ctx context.Context, key string) (string, error)
+	// Del removes the keys and returns how many of them existed
+	Del(ctx context.Context, keys ...string) (int64, error)
+	// Incr increments a counter; ttl applies when the counter is created
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
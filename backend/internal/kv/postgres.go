@@ -0,0 +1,120 @@
+package kv
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps entries in the kv_entries table. Expired rows are ignored on
+// read and removed by RunSweeper.
+type PostgresStore struct {
+	DB *pgxpool.Pool
+}
+
+func NewPostgresStore(db *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (string, error) {
+	var v string
+	err := s.DB.QueryRow(ctx, `SELECT value FROM kv_entries WHERE key = $1 AND expires_at > NOW()`, key).Scan(&v)
+	if err == pgx.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+func (s *PostgresStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := s.DB.Exec(ctx, `
+		INSERT INTO kv_entries (key, value, expires_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at
+	`, key, value, ttl.Milliseconds())
+	return err
+}
+
+func (s *PostgresStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	// An expired row counts as absent and is overwritten
+	var stored string
+	err := s.DB.QueryRow(ctx, `
+		INSERT INTO kv_entries (key, value, expires_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at
+		WHERE kv_entries.expires_at <= NOW()
+		RETURNING key
+	`, key, value, ttl.Milliseconds()).Scan(&stored)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *PostgresStore) GetDel(ctx context.Context, key string) (string, error) {
+	var (
+		v     string
+		alive bool
+	)
+	err := s.DB.QueryRow(ctx, `DELETE FROM kv_entries WHERE key = $1 RETURNING value, expires_at > NOW()`, key).Scan(&v, &alive)
+	if err == pgx.ErrNoRows || (err == nil && !alive) {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+func (s *PostgresStore) Del(ctx context.Context, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	var n int64
+	err := s.DB.QueryRow(ctx, `
+		WITH deleted AS (DELETE FROM kv_entries WHERE key = ANY($1) RETURNING expires_at)
+		SELECT COUNT(*) FROM deleted WHERE expires_at > NOW()
+	`, keys).Scan(&n)
+	return n, err
+}
+
+func (s *PostgresStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	// A counter that has expired starts again from 1 with a fresh TTL
+	var n int64
+	err := s.DB.QueryRow(ctx, `
+		INSERT INTO kv_entries (key, value, expires_at)
+		VALUES ($1, '1', NOW() + $2 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET
+			value = CASE WHEN kv_entries.expires_at > NOW() THEN (kv_entries.value::bigint + 1)::text ELSE '1' END,
+			expires_at = CASE WHEN kv_entries.expires_at > NOW() THEN kv_entries.expires_at ELSE EXCLUDED.expires_at END
+		RETURNING value::bigint
+	`, key, ttl.Milliseconds()).Scan(&n)
+	return n, err
+}
+
+// Sweep deletes expired entries and returns how many were removed
+func (s *PostgresStore) Sweep(ctx context.Context) (int64, error) {
+	tag, err := s.DB.Exec(ctx, `DELETE FROM kv_entries WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RunSweeper calls Sweep every interval until ctx is cancelled
+func (s *PostgresStore) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.Sweep(ctx)
+			if err != nil {
+				log.Printf("kv: sweep failed: %v", err)
+			} else if n > 0 {
+				log.Printf("kv: swept %d expired entries", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisStore struct {
+	Client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{Client: client}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	v, err := s.Client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+func (s *RedisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.Client.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *RedisStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return s.Client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (s *RedisStore) GetDel(ctx context.Context, key string) (string, error) {
+	v, err := s.Client.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+func (s *RedisStore) Del(ctx context.Context, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return s.Client.Del(ctx, keys...).Result()
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := s.Client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		if err := s.Client.Expire(ctx, key, ttl).Err(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -9,21 +10,54 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// AuthRequired accepts a Bearer access token or, for integrations, an X-API-Key header.
+// API keys carry their scopes as permissions and no user_id, so routes acting on
+// "the current user" stay closed to them. Impersonation tokens are read-only unless
+// the route opts in with AllowDuringImpersonation.
 func AuthRequired(cfg config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		h := c.GetHeader("Authorization")
+		if key := c.GetHeader("X-API-Key"); key != "" && h == "" {
+			authenticateAPIKey(c, cfg, key)
+			return
+		}
 		if h == "" || !strings.HasPrefix(h, "Bearer ") {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
 			return
 		}
 		token := strings.TrimPrefix(h, "Bearer ")
-		claims, err := auth.ParseToken(cfg.JWTSecret, token)
+		claims, err := cfg.JWTKeys.ParseToken(token)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
+		if claims.ImpersonatorID != 0 {
+			authenticateImpersonation(c, cfg, claims)
+			return
+		}
 		c.Set("user_id", claims.UserID)
 		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
+		c.Next()
+	}
+}
+
+// OptionalAuth identifies the user when a valid Bearer token is sent and lets
+// anonymous requests through, for public endpoints that behave differently for customers
+func OptionalAuth(cfg config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.GetHeader("Authorization")
+		if strings.HasPrefix(h, "Bearer ") {
+			if claims, err := cfg.JWTKeys.ParseToken(strings.TrimPrefix(h, "Bearer ")); err == nil {
+				if claims.ImpersonatorID != 0 {
+					authenticateImpersonation(c, cfg, claims)
+					return
+				}
+				c.Set("user_id", claims.UserID)
+				c.Set("roles", claims.Roles)
+				c.Set("permissions", claims.Permissions)
+			}
+		}
 		c.Next()
 	}
 }
@@ -49,3 +83,45 @@ func RequireRoles(roles ...string) gin.HandlerFunc {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 	}
 }
+
+// RequirePermission allows the request when the token grants any of the given permissions
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	allowed := make(map[string]struct{}, len(permissions))
+	for _, p := range permissions {
+		allowed[p] = struct{}{}
+	}
+	return func(c *gin.Context) {
+		val, ok := c.Get("permissions")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		granted, _ := val.([]string)
+		for _, p := range granted {
+			if _, ok := allowed[p]; ok {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing permission", "required": permissions})
+	}
+}
+
+func authenticateAPIKey(c *gin.Context, cfg config.Config, key string) {
+	if cfg.APIKeys == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		return
+	}
+	apiKey, err := cfg.APIKeys.VerifyAPIKey(c.Request.Context(), key, c.ClientIP())
+	if errors.Is(err, auth.ErrAPIKeyIPNotAllowed) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "api key not allowed from this address"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		return
+	}
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("roles", []string{})
+	c.Set("permissions", apiKey.Scopes)
+	c.Next()
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The admin session routes rely on RequirePermission refusing a request that no
+// authentication middleware ran for, so a route registered outside an authenticated
+// group cannot be reached anonymously
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name        string
+		permissions []string
+		want        int
+	}{
+		{"not authenticated", nil, http.StatusForbidden},
+		{"missing permission", []string{"users:read"}, http.StatusForbidden},
+		{"granted", []string{"users:read", "users:write"}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		r := gin.New()
+		r.DELETE("/users/:id/sessions", func(c *gin.Context) {
+			if tc.permissions != nil {
+				c.Set("permissions", tc.permissions)
+			}
+		}, RequirePermission("users:write"), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/users/7/sessions", nil))
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
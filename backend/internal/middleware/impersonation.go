@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/etreasure/backend/internal/auth"
+	"github.com/etreasure/backend/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	impersonationWritesMu sync.RWMutex
+	impersonationWrites   = map[string]struct{}{}
+)
+
+// AllowDuringImpersonation opts a write route into impersonation tokens, which are
+// read-only everywhere else. path is the route pattern, e.g. "/api/cart/:id".
+// Call it while registering routes.
+func AllowDuringImpersonation(method, path string) {
+	impersonationWritesMu.Lock()
+	defer impersonationWritesMu.Unlock()
+	impersonationWrites[method+" "+path] = struct{}{}
+}
+
+func impersonationWriteAllowed(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	impersonationWritesMu.RLock()
+	defer impersonationWritesMu.RUnlock()
+	_, ok := impersonationWrites[c.Request.Method+" "+c.FullPath()]
+	return ok
+}
+
+// authenticateImpersonation admits a token minted for support staff acting as a customer.
+// The session must still be open, writes need AllowDuringImpersonation, and the request
+// is recorded whatever the outcome.
+func authenticateImpersonation(c *gin.Context, cfg config.Config, claims *auth.Claims) {
+	if cfg.Impersonation == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+	// The audit row must be written even when the client goes away
+	ctx := context.Background()
+	active, err := cfg.Impersonation.ImpersonationActive(ctx, claims.ID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to verify impersonation session"})
+		return
+	}
+	if !active {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "impersonation session has ended"})
+		return
+	}
+
+	path := c.Request.URL.Path
+	if !impersonationWriteAllowed(c) {
+		cfg.Impersonation.RecordImpersonatedRequest(ctx, claims, c.Request.Method, path, http.StatusForbidden, c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed while impersonating a customer"})
+		return
+	}
+
+	c.Set("user_id", claims.UserID)
+	c.Set("roles", claims.Roles)
+	c.Set("permissions", []string{})
+	c.Set("impersonator_id", claims.ImpersonatorID)
+	c.Set("impersonation_session_id", claims.ID)
+	c.Next()
+	cfg.Impersonation.RecordImpersonatedRequest(ctx, claims, c.Request.Method, path, c.Writer.Status(), c.ClientIP())
+}
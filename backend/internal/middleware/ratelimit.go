@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/etreasure/backend/internal/kv"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitByIP allows limit requests per window from one IP to the routes it guards,
+// counted in the KV store under name like the login limit. Requests are refused when
+// the count cannot be taken, so a guessing attack cannot go unchecked.
+func RateLimitByIP(store kv.Store, name string, limit int64, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := fmt.Sprintf("%s:ip:%s", name, c.ClientIP())
+		count, err := store.Incr(c.Request.Context(), key, window)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "please try again shortly"})
+			return
+		}
+		if count > limit {
+			c.Header("Retry-After", fmt.Sprint(int(window.Seconds())))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, please try again later"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/etreasure/backend/internal/kv"
+	"github.com/gin-gonic/gin"
+)
+
+// countingStore only implements the counter the rate limit uses
+type countingStore struct {
+	kv.Store
+	counts map[string]int64
+}
+
+func (s *countingStore) Incr(_ context.Context, key string, _ time.Duration) (int64, error) {
+	s.counts[key]++
+	return s.counts[key], nil
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &countingStore{counts: map[string]int64{}}
+	r := gin.New()
+	r.POST("/api/cart/coupon", RateLimitByIP(store, "coupon", 3, time.Minute), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(ip string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/cart/coupon", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	for i := 1; i <= 3; i++ {
+		if code := send("203.0.113.7"); code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, code)
+		}
+	}
+	if code := send("203.0.113.7"); code != http.StatusTooManyRequests {
+		t.Errorf("request over the limit: status %d, want 429", code)
+	}
+	if code := send("198.51.100.2"); code != http.StatusOK {
+		t.Errorf("another IP: status %d, want 200", code)
+	}
+}
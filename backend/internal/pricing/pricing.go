@@ -0,0 +1,231 @@
+// Package pricing evaluates the store's offers against a cart. It does no I/O: callers
+// load the cart lines and the offers, and get back what each line costs and which offer
+// was applied. The cart and checkout both price through Evaluate, so the total shown in
+// the cart is the amount charged.
+package pricing
+
+import (
+	"strings"
+	"time"
+)
+
+// Offer is a row of the offers table
+type Offer struct {
+	ID            string
+	Title         string
+	DiscountType  string // percentage | fixed | bogo | tiered | bundle
+	DiscountValue float64
+	Rules         Rules
+	AppliesTo     string // all | products | categories | collections
+	AppliesToIDs  []string
+	MinOrderCents int
+	StartsAt      *time.Time
+	EndsAt        *time.Time
+	UsageLimit    *int
+	UsageCount    int
+}
+
+// Line is a cart line as far as offers are concerned
+type Line struct {
+	ProductID  string
+	CategoryID string
+	SKU        string
+	// Collections are the product's tags; a collection offer lists tags
+	Collections         []string
+	PriceCents          int
+	CompareAtPriceCents int
+	Quantity            int
+}
+
+// LinePrice is the price breakdown of a line. TotalCents is what the line is charged;
+// UnitPriceCents spreads it over the units, rounded down when the offer discounts some
+// units of the line and not others, as "buy 2 get 1 free" does.
+type LinePrice struct {
+	ListPriceCents int
+	UnitPriceCents int
+	DiscountCents  int
+	Reason         string
+	TotalCents     int
+	// OfferDiscountCents is the part of the applied offer's discount that fell on this
+	// line, for all of its units
+	OfferDiscountCents int
+}
+
+// Result is a priced cart. SubtotalCents is at list price; TotalCents is what is charged.
+type Result struct {
+	Lines         []LinePrice
+	SubtotalCents int
+	DiscountCents int
+	TotalCents    int
+	// Offer is the offer applied to the cart, nil when none was eligible
+	Offer *Offer
+	// OfferDiscountCents is the part of DiscountCents that comes from Offer
+	OfferDiscountCents int
+}
+
+// Reasons an offer does not apply to a cart, as reported to a customer who entered a code
+const (
+	ReasonNotStarted        = "not_started"
+	ReasonExpired           = "expired"
+	ReasonUsageLimitReached = "usage_limit_reached"
+	ReasonMinOrderNotMet    = "min_order_not_met"
+	ReasonNotApplicable     = "not_applicable"
+)
+
+// Running reports whether the offer is inside its date window and has uses left
+func (o Offer) Running(now time.Time) bool {
+	switch o.check(now) {
+	case ReasonNotStarted, ReasonExpired, ReasonUsageLimitReached:
+		return false
+	}
+	return true
+}
+
+func (o Offer) check(now time.Time) string {
+	if o.StartsAt != nil && now.Before(*o.StartsAt) {
+		return ReasonNotStarted
+	}
+	if o.EndsAt != nil && !now.Before(*o.EndsAt) {
+		return ReasonExpired
+	}
+	if o.UsageLimit != nil && o.UsageCount >= *o.UsageLimit {
+		return ReasonUsageLimitReached
+	}
+	return ""
+}
+
+// Check returns why the offer gives nothing on the lines at now, or "" when it applies
+func (o Offer) Check(lines []Line, now time.Time) string {
+	if reason := o.check(now); reason != "" {
+		return reason
+	}
+	if subtotalCents(lines) < o.MinimumOrderCents() {
+		return ReasonMinOrderNotMet
+	}
+	if o.savingCents(lines) == 0 {
+		return ReasonNotApplicable
+	}
+	return ""
+}
+
+// Matches reports whether the offer covers the line. Product offers are entered with
+// variant SKUs in the admin; product IDs are accepted too.
+func (o Offer) Matches(l Line) bool {
+	switch o.AppliesTo {
+	case "all":
+		return true
+	case "categories":
+		return l.CategoryID != "" && containsTrimmed(o.AppliesToIDs, l.CategoryID)
+	case "products":
+		return (l.SKU != "" && containsTrimmed(o.AppliesToIDs, l.SKU)) || containsTrimmed(o.AppliesToIDs, l.ProductID)
+	case "collections":
+		for _, tag := range l.Collections {
+			for _, id := range o.AppliesToIDs {
+				if tag != "" && strings.EqualFold(strings.TrimSpace(id), strings.TrimSpace(tag)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// UnitDiscountCents is the per-unit discount a percentage or fixed offer gives on a
+// price, never more than the price
+func (o Offer) UnitDiscountCents(priceCents int) int {
+	var off int
+	switch o.DiscountType {
+	case TypePercentage:
+		off = percentOf(priceCents, o.DiscountValue)
+	case TypeFixed:
+		off = rupeesToCents(o.DiscountValue)
+	}
+	if off < 0 {
+		return 0
+	}
+	if off > priceCents {
+		return priceCents
+	}
+	return off
+}
+
+// Evaluate prices the lines and applies the one eligible offer that saves the customer
+// the most. An offer is eligible when Check finds nothing against it: it is running at
+// now and the cart, at selling prices, reaches its minimum order. On a tie the earlier
+// offer in the list wins. The offer's discount is allocated to the lines it fell on.
+//
+// The compare-at price, when higher, is a line's list price, so a variant already marked
+// down shows that saving as well.
+func Evaluate(lines []Line, offers []Offer, now time.Time) Result {
+	var best *Offer
+	var bestAlloc []int
+	bestSaving := 0
+	for i := range offers {
+		o := &offers[i]
+		if o.Check(lines, now) != "" {
+			continue
+		}
+		alloc := o.allocate(lines)
+		if saving := sum(alloc); saving > bestSaving {
+			best, bestAlloc, bestSaving = o, alloc, saving
+		}
+	}
+
+	res := Result{Lines: make([]LinePrice, len(lines))}
+	for i, l := range lines {
+		p := LinePrice{ListPriceCents: l.PriceCents, UnitPriceCents: l.PriceCents}
+		if l.CompareAtPriceCents > l.PriceCents {
+			p.ListPriceCents = l.CompareAtPriceCents
+			p.Reason = "Sale"
+		}
+		p.TotalCents = l.PriceCents * l.Quantity
+		if best != nil && bestAlloc[i] > 0 {
+			p.OfferDiscountCents = bestAlloc[i]
+			p.TotalCents -= bestAlloc[i]
+			p.Reason = best.Title
+		}
+		if l.Quantity > 0 {
+			p.UnitPriceCents = p.TotalCents / l.Quantity
+		}
+		p.DiscountCents = p.ListPriceCents - p.UnitPriceCents
+		res.Lines[i] = p
+		res.SubtotalCents += p.ListPriceCents * l.Quantity
+		res.TotalCents += p.TotalCents
+	}
+	res.DiscountCents = res.SubtotalCents - res.TotalCents
+	if best != nil {
+		res.Offer, res.OfferDiscountCents = best, bestSaving
+	}
+	return res
+}
+
+// savingCents is what the offer takes off the lines, minimum order aside
+func (o Offer) savingCents(lines []Line) int {
+	return sum(o.allocate(lines))
+}
+
+func sum(cents []int) int {
+	total := 0
+	for _, c := range cents {
+		total += c
+	}
+	return total
+}
+
+// subtotalCents is the lines at selling price, which minimum order amounts are checked against
+func subtotalCents(lines []Line) int {
+	total := 0
+	for _, l := range lines {
+		total += l.PriceCents * l.Quantity
+	}
+	return total
+}
+
+func containsTrimmed(ids []string, want string) bool {
+	for _, id := range ids {
+		if strings.TrimSpace(id) == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluateLine(t *testing.T) {
+	shirt := Line{ProductID: "p-1", CategoryID: "c-1", SKU: "SHIRT-M", PriceCents: 100000, Quantity: 1}
+	offers := []Offer{
+		{Title: "Festive 10%", DiscountType: "percentage", DiscountValue: 10, AppliesTo: "all"},
+		{Title: "Shirts Rs 150 off", DiscountType: "fixed", DiscountValue: 150, AppliesTo: "products", AppliesToIDs: []string{" SHIRT-M", "SHIRT-L"}},
+		{Title: "Big basket 30%", DiscountType: "percentage", DiscountValue: 30, AppliesTo: "categories", AppliesToIDs: []string{"c-1"}, MinOrderCents: 500000},
+	}
+	fiveShirts := shirt
+	fiveShirts.Quantity = 5
+
+	cases := []struct {
+		name   string
+		in     Line
+		offers []Offer
+		want   LinePrice
+	}{
+		{"no offers", shirt, nil, LinePrice{100000, 100000, 0, "", 100000, 0}},
+		{"best offer wins", shirt, offers, LinePrice{100000, 85000, 15000, "Shirts Rs 150 off", 85000, 15000}},
+		{"min order reached", fiveShirts, offers, LinePrice{100000, 70000, 30000, "Big basket 30%", 350000, 150000}},
+		{"sku not covered", Line{ProductID: "p-2", SKU: "SAREE", PriceCents: 100000, Quantity: 1}, offers[1:2], LinePrice{100000, 100000, 0, "", 100000, 0}},
+		{"product id covered", Line{ProductID: "p-2", PriceCents: 100000, Quantity: 1}, []Offer{{Title: "P2", DiscountType: "percentage", DiscountValue: 5, AppliesTo: "products", AppliesToIDs: []string{"p-2"}}}, LinePrice{100000, 95000, 5000, "P2", 95000, 5000}},
+		{"collection covered", Line{Collections: []string{"Wedding Edit"}, PriceCents: 100000, Quantity: 1}, []Offer{{Title: "Wedding", DiscountType: "percentage", DiscountValue: 20, AppliesTo: "collections", AppliesToIDs: []string{"wedding edit"}}}, LinePrice{100000, 80000, 20000, "Wedding", 80000, 20000}},
+		{"compare-at sale", Line{PriceCents: 80000, CompareAtPriceCents: 100000, Quantity: 1}, nil, LinePrice{100000, 80000, 20000, "Sale", 80000, 0}},
+		{"offer on top of sale", Line{PriceCents: 80000, CompareAtPriceCents: 100000, Quantity: 1}, offers[:1], LinePrice{100000, 72000, 28000, "Festive 10%", 72000, 8000}},
+		{"fixed offer capped at price", Line{SKU: "SHIRT-L", PriceCents: 10000, Quantity: 1}, offers[1:2], LinePrice{10000, 0, 10000, "Shirts Rs 150 off", 0, 10000}},
+	}
+	for _, tc := range cases {
+		res := Evaluate([]Line{tc.in}, tc.offers, time.Now())
+		if got := res.Lines[0]; got != tc.want {
+			t.Errorf("%s: line = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEvaluateCart(t *testing.T) {
+	now := time.Date(2024, 10, 20, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	one := 1
+	saree := Line{ProductID: "saree", CategoryID: "sarees", PriceCents: 500000, Quantity: 1}
+	stole := Line{ProductID: "stole", CategoryID: "stoles", PriceCents: 100000, Quantity: 2}
+
+	sarees := Offer{ID: "sarees", Title: "Sarees 10%", DiscountType: "percentage", DiscountValue: 10, AppliesTo: "categories", AppliesToIDs: []string{"sarees"}}
+	stoles := Offer{ID: "stoles", Title: "Stoles Rs 300 off", DiscountType: "fixed", DiscountValue: 300, AppliesTo: "categories", AppliesToIDs: []string{"stoles"}}
+
+	cases := []struct {
+		name      string
+		offers    []Offer
+		wantOffer string
+		wantTotal int
+	}{
+		{"largest saving across the cart wins", []Offer{sarees, stoles}, "stoles", 640000},
+		{"only one offer applies", []Offer{sarees, {ID: "all", Title: "All 5%", DiscountType: "percentage", DiscountValue: 5, AppliesTo: "all"}}, "sarees", 650000},
+		{"not started", []Offer{{ID: "later", DiscountType: "percentage", DiscountValue: 50, AppliesTo: "all", StartsAt: &after}}, "", 700000},
+		{"ended", []Offer{{ID: "over", DiscountType: "percentage", DiscountValue: 50, AppliesTo: "all", EndsAt: &before}}, "", 700000},
+		{"usage limit reached", []Offer{{ID: "used", DiscountType: "percentage", DiscountValue: 50, AppliesTo: "all", UsageLimit: &one, UsageCount: 1}}, "", 700000},
+		{"min order not met", []Offer{{ID: "big", DiscountType: "percentage", DiscountValue: 50, AppliesTo: "all", MinOrderCents: 800000}}, "", 700000},
+	}
+	for _, tc := range cases {
+		res := Evaluate([]Line{saree, stole}, tc.offers, now)
+		gotOffer := ""
+		if res.Offer != nil {
+			gotOffer = res.Offer.ID
+		}
+		if gotOffer != tc.wantOffer || res.TotalCents != tc.wantTotal {
+			t.Errorf("%s: offer %q total %d, want %q total %d", tc.name, gotOffer, res.TotalCents, tc.wantOffer, tc.wantTotal)
+		}
+		if res.SubtotalCents != 700000 || res.DiscountCents != res.SubtotalCents-res.TotalCents || res.OfferDiscountCents != res.DiscountCents {
+			t.Errorf("%s: subtotal %d discount %d offer discount %d", tc.name, res.SubtotalCents, res.DiscountCents, res.OfferDiscountCents)
+		}
+	}
+}
+
+func TestOfferCheck(t *testing.T) {
+	now := time.Date(2024, 10, 20, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	limit := 10
+	lines := []Line{{ProductID: "saree", CategoryID: "sarees", PriceCents: 300000, Quantity: 1}}
+	diwali := Offer{Title: "DIWALI20", DiscountType: "percentage", DiscountValue: 20, AppliesTo: "all"}
+
+	with := func(change func(*Offer)) Offer {
+		o := diwali
+		change(&o)
+		return o
+	}
+	cases := []struct {
+		name  string
+		offer Offer
+		want  string
+	}{
+		{"applies", diwali, ""},
+		{"not started", with(func(o *Offer) { o.StartsAt = &after }), ReasonNotStarted},
+		{"expired", with(func(o *Offer) { o.EndsAt = &before }), ReasonExpired},
+		{"ends now", with(func(o *Offer) { o.EndsAt = &now }), ReasonExpired},
+		{"used up", with(func(o *Offer) { o.UsageLimit, o.UsageCount = &limit, 10 }), ReasonUsageLimitReached},
+		{"min order not met", with(func(o *Offer) { o.MinOrderCents = 500000 }), ReasonMinOrderNotMet},
+		{"nothing in scope", with(func(o *Offer) { o.AppliesTo, o.AppliesToIDs = "categories", []string{"stoles"} }), ReasonNotApplicable},
+	}
+	for _, tc := range cases {
+		if got := tc.offer.Check(lines, now); got != tc.want {
+			t.Errorf("%s: Check = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
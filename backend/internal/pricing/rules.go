@@ -0,0 +1,282 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Offer types. Percentage and fixed offers take DiscountValue off every unit they cover;
+// the others are described by the offer's Rules and can discount units of the same line
+// differently, which is why discounts are allocated to lines rather than to units.
+const (
+	TypePercentage = "percentage"
+	TypeFixed      = "fixed"
+	TypeBOGO       = "bogo"
+	TypeTiered     = "tiered"
+	TypeBundle     = "bundle"
+)
+
+// Rules are the settings of the offer types a single discount value cannot describe. They
+// are stored as JSON on the offer; amounts are in rupees like the rest of the offer.
+type Rules struct {
+	// bogo: for every Buy units of the offer's items, Get more are GetPercent off, or free
+	// when GetPercent is left out. The cheapest units are the ones discounted.
+	Buy        int     `json:"buy,omitempty"`
+	Get        int     `json:"get,omitempty"`
+	GetPercent float64 `json:"get_percent,omitempty"`
+	// tiered: the highest tier the cart reaches sets the percentage off the offer's items
+	Tiers []Tier `json:"tiers,omitempty"`
+	// bundle: every complete set of Items costs BundlePrice together. The offer's
+	// applies_to is not used; each item says what fills it.
+	Items       []BundleItem `json:"items,omitempty"`
+	BundlePrice float64      `json:"bundle_price,omitempty"`
+}
+
+// Tier is one step of a tiered offer: Percent off once the cart reaches MinOrder
+type Tier struct {
+	MinOrder float64 `json:"min_order"`
+	Percent  float64 `json:"percent"`
+}
+
+// BundleItem is one part of a bundle: Quantity units, 1 when left out, of the products,
+// categories or collections in IDs
+type BundleItem struct {
+	AppliesTo string   `json:"applies_to"`
+	IDs       []string `json:"ids"`
+	Quantity  int      `json:"quantity,omitempty"`
+}
+
+// Validate reports what is wrong with the offer's discount settings, or nil
+func (o Offer) Validate() error {
+	r := o.Rules
+	switch o.DiscountType {
+	case TypePercentage:
+		if o.DiscountValue <= 0 || o.DiscountValue > 100 {
+			return errors.New("discount_value must be a percentage between 0 and 100")
+		}
+	case TypeFixed:
+		if o.DiscountValue <= 0 {
+			return errors.New("discount_value must be more than 0")
+		}
+	case TypeBOGO:
+		if r.Buy < 1 || r.Get < 1 {
+			return errors.New("rules.buy and rules.get must be at least 1")
+		}
+		if r.GetPercent < 0 || r.GetPercent > 100 {
+			return errors.New("rules.get_percent must be between 0 and 100")
+		}
+	case TypeTiered:
+		if len(r.Tiers) == 0 {
+			return errors.New("rules.tiers needs at least one tier")
+		}
+		for i, t := range r.Tiers {
+			if t.Percent <= 0 || t.Percent > 100 {
+				return fmt.Errorf("rules.tiers[%d].percent must be between 0 and 100", i)
+			}
+			if t.MinOrder < 0 || (i > 0 && t.MinOrder <= r.Tiers[i-1].MinOrder) {
+				return errors.New("rules.tiers must be in increasing order of min_order")
+			}
+			if i > 0 && t.Percent <= r.Tiers[i-1].Percent {
+				return errors.New("a higher tier must give a larger percent")
+			}
+		}
+	case TypeBundle:
+		if len(r.Items) == 0 {
+			return errors.New("rules.items needs at least one item")
+		}
+		units := 0
+		for i, it := range r.Items {
+			switch it.AppliesTo {
+			case "products", "categories", "collections":
+			default:
+				return fmt.Errorf("rules.items[%d].applies_to must be products, categories or collections", i)
+			}
+			if len(it.IDs) == 0 {
+				return fmt.Errorf("rules.items[%d].ids is empty", i)
+			}
+			if it.Quantity < 0 {
+				return fmt.Errorf("rules.items[%d].quantity must be at least 1", i)
+			}
+			units += it.quantity()
+		}
+		if units < 2 {
+			return errors.New("a bundle needs at least two units")
+		}
+		if r.BundlePrice <= 0 {
+			return errors.New("rules.bundle_price must be more than 0")
+		}
+	default:
+		return fmt.Errorf("unknown discount_type %q", o.DiscountType)
+	}
+	return nil
+}
+
+// MinimumOrderCents is the smallest cart, at selling prices, the offer gives anything on
+func (o Offer) MinimumOrderCents() int {
+	min := o.MinOrderCents
+	if o.DiscountType == TypeTiered && len(o.Rules.Tiers) > 0 {
+		if t := rupeesToCents(o.Rules.Tiers[0].MinOrder); t > min {
+			min = t
+		}
+	}
+	return min
+}
+
+func (it BundleItem) quantity() int {
+	if it.Quantity < 1 {
+		return 1
+	}
+	return it.Quantity
+}
+
+// tier returns the highest tier a cart of subtotal reaches, or nil
+func (r Rules) tier(subtotal int) *Tier {
+	var reached *Tier
+	for i := range r.Tiers {
+		if subtotal >= rupeesToCents(r.Tiers[i].MinOrder) {
+			reached = &r.Tiers[i]
+		}
+	}
+	return reached
+}
+
+// unit is one unit of a cart line, the granularity bogo and bundle offers work at
+type unit struct {
+	line  int
+	price int
+}
+
+// units expands the lines the match function accepts into units, most expensive first.
+// Units of the same price keep cart order.
+func units(lines []Line, match func(Line) bool) []unit {
+	var us []unit
+	for i, l := range lines {
+		if !match(l) {
+			continue
+		}
+		for q := 0; q < l.Quantity; q++ {
+			us = append(us, unit{line: i, price: l.PriceCents})
+		}
+	}
+	sort.SliceStable(us, func(a, b int) bool { return us[a].price > us[b].price })
+	return us
+}
+
+// allocate returns what the offer takes off each line, for all of the line's units
+// together, minimum order aside
+func (o Offer) allocate(lines []Line) []int {
+	alloc := make([]int, len(lines))
+	switch o.DiscountType {
+	case TypePercentage, TypeFixed:
+		for i, l := range lines {
+			if o.Matches(l) {
+				alloc[i] = o.UnitDiscountCents(l.PriceCents) * l.Quantity
+			}
+		}
+	case TypeTiered:
+		t := o.Rules.tier(subtotalCents(lines))
+		if t == nil {
+			break
+		}
+		for i, l := range lines {
+			if o.Matches(l) {
+				alloc[i] = percentOf(l.PriceCents, t.Percent) * l.Quantity
+			}
+		}
+	case TypeBOGO:
+		o.allocateBOGO(lines, alloc)
+	case TypeBundle:
+		o.allocateBundle(lines, alloc)
+	}
+	return alloc
+}
+
+// allocateBOGO walks the matching units from the most expensive down in groups of
+// Buy+Get; the last Get units of each full group, the cheapest, are discounted
+func (o Offer) allocateBOGO(lines []Line, alloc []int) {
+	r := o.Rules
+	if r.Buy < 1 || r.Get < 1 {
+		return
+	}
+	pct := r.GetPercent
+	if pct == 0 {
+		pct = 100
+	}
+	us := units(lines, o.Matches)
+	group := r.Buy + r.Get
+	for start := 0; start+group <= len(us); start += group {
+		for _, u := range us[start+r.Buy : start+group] {
+			alloc[u.line] += percentOf(u.price, pct)
+		}
+	}
+}
+
+// allocateBundle makes as many complete sets as the cart holds, filling each item with
+// the most expensive units that match it and no earlier item. A set's saving is shared
+// among its units in proportion to their prices, so each line shows its part of it.
+func (o Offer) allocateBundle(lines []Line, alloc []int) {
+	items := o.Rules.Items
+	price := rupeesToCents(o.Rules.BundlePrice)
+	if len(items) == 0 || price <= 0 {
+		return
+	}
+	used := make([]int, len(lines))
+	candidates := make([][]unit, len(items))
+	for i, it := range items {
+		slot := Offer{AppliesTo: it.AppliesTo, AppliesToIDs: it.IDs}
+		candidates[i] = units(lines, slot.Matches)
+	}
+	next := make([]int, len(items))
+	for {
+		var set []unit
+		taken := map[int]int{}
+		for i, it := range items {
+			for n := 0; n < it.quantity(); n++ {
+				for next[i] < len(candidates[i]) {
+					u := candidates[i][next[i]]
+					next[i]++
+					if used[u.line]+taken[u.line] < lines[u.line].Quantity {
+						set = append(set, u)
+						taken[u.line]++
+						break
+					}
+				}
+			}
+		}
+		full := 0
+		for _, it := range items {
+			full += it.quantity()
+		}
+		if len(set) < full {
+			return
+		}
+		total := 0
+		for _, u := range set {
+			total += u.price
+		}
+		saving := total - price
+		if saving <= 0 {
+			return
+		}
+		shared := 0
+		for _, u := range set[1:] {
+			share := saving * u.price / total
+			alloc[u.line] += share
+			shared += share
+		}
+		alloc[set[0].line] += saving - shared
+		for line, n := range taken {
+			used[line] += n
+		}
+	}
+}
+
+func percentOf(priceCents int, percent float64) int {
+	return int(math.Round(float64(priceCents) * percent / 100))
+}
+
+func rupeesToCents(rupees float64) int {
+	return int(math.Round(rupees * 100))
+}
@@ -0,0 +1,110 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluateRules(t *testing.T) {
+	now := time.Now()
+	buy2get1 := Offer{ID: "bogo", Title: "Buy 2 get 1 free", DiscountType: TypeBOGO, AppliesTo: "all", Rules: Rules{Buy: 2, Get: 1}}
+	tiered := Offer{ID: "tiered", Title: "Spend more, save more", DiscountType: TypeTiered, AppliesTo: "all",
+		Rules: Rules{Tiers: []Tier{{MinOrder: 5000, Percent: 15}, {MinOrder: 10000, Percent: 20}}}}
+	bundle := Offer{ID: "bundle", Title: "Saree + blouse for Rs 4000", DiscountType: TypeBundle, AppliesTo: "all",
+		Rules: Rules{BundlePrice: 4000, Items: []BundleItem{
+			{AppliesTo: "categories", IDs: []string{"sarees"}},
+			{AppliesTo: "categories", IDs: []string{"blouses"}, Quantity: 1},
+		}}}
+
+	cases := []struct {
+		name          string
+		lines         []Line
+		offer         Offer
+		wantAlloc     []int
+		wantUnitPrice []int
+	}{
+		{"cheapest of each three is free", []Line{
+			{ProductID: "a", PriceCents: 300000, Quantity: 2},
+			{ProductID: "b", PriceCents: 100000, Quantity: 1},
+			{ProductID: "c", PriceCents: 200000, Quantity: 1},
+		}, buy2get1, []int{0, 0, 200000}, []int{300000, 100000, 0}},
+		{"free unit within one line", []Line{{ProductID: "a", PriceCents: 100000, Quantity: 3}}, buy2get1, []int{100000}, []int{66666}},
+		{"half price third", []Line{{ProductID: "a", PriceCents: 100000, Quantity: 3}},
+			Offer{DiscountType: TypeBOGO, AppliesTo: "all", Rules: Rules{Buy: 2, Get: 1, GetPercent: 50}}, []int{50000}, []int{83333}},
+		{"first tier", []Line{{ProductID: "a", PriceCents: 600000, Quantity: 1}}, tiered, []int{90000}, []int{510000}},
+		{"top tier", []Line{{ProductID: "a", PriceCents: 600000, Quantity: 2}}, tiered, []int{240000}, []int{480000}},
+		{"bundle saving shared by price", []Line{
+			{ProductID: "saree", CategoryID: "sarees", PriceCents: 400000, Quantity: 1},
+			{ProductID: "blouse", CategoryID: "blouses", PriceCents: 100000, Quantity: 2},
+		}, bundle, []int{80000, 20000}, []int{400000 - 80000, 90000}},
+		{"two bundles", []Line{
+			{ProductID: "saree", CategoryID: "sarees", PriceCents: 400000, Quantity: 2},
+			{ProductID: "blouse", CategoryID: "blouses", PriceCents: 100000, Quantity: 2},
+		}, bundle, []int{160000, 40000}, []int{320000, 80000}},
+	}
+	for _, tc := range cases {
+		res := Evaluate(tc.lines, []Offer{tc.offer}, now)
+		total := 0
+		for i, l := range res.Lines {
+			if l.OfferDiscountCents != tc.wantAlloc[i] || l.UnitPriceCents != tc.wantUnitPrice[i] {
+				t.Errorf("%s: line %d discount %d unit price %d, want %d and %d", tc.name, i, l.OfferDiscountCents, l.UnitPriceCents, tc.wantAlloc[i], tc.wantUnitPrice[i])
+			}
+			if l.TotalCents != tc.lines[i].PriceCents*tc.lines[i].Quantity-l.OfferDiscountCents {
+				t.Errorf("%s: line %d total %d", tc.name, i, l.TotalCents)
+			}
+			total += l.OfferDiscountCents
+		}
+		if res.OfferDiscountCents != total || res.DiscountCents != total {
+			t.Errorf("%s: cart discount %d offer discount %d, lines add up to %d", tc.name, res.DiscountCents, res.OfferDiscountCents, total)
+		}
+	}
+}
+
+func TestRulesCheck(t *testing.T) {
+	now := time.Now()
+	tiered := Offer{DiscountType: TypeTiered, AppliesTo: "all", Rules: Rules{Tiers: []Tier{{MinOrder: 5000, Percent: 15}}}}
+	if got := tiered.Check([]Line{{PriceCents: 400000, Quantity: 1}}, now); got != ReasonMinOrderNotMet {
+		t.Errorf("tiered under the first tier: Check = %q, want %q", got, ReasonMinOrderNotMet)
+	}
+	if got := tiered.MinimumOrderCents(); got != 500000 {
+		t.Errorf("MinimumOrderCents = %d, want 500000", got)
+	}
+	bundle := Offer{DiscountType: TypeBundle, Rules: Rules{BundlePrice: 4000, Items: []BundleItem{
+		{AppliesTo: "categories", IDs: []string{"sarees"}}, {AppliesTo: "categories", IDs: []string{"blouses"}},
+	}}}
+	if got := bundle.Check([]Line{{CategoryID: "sarees", PriceCents: 400000, Quantity: 3}}, now); got != ReasonNotApplicable {
+		t.Errorf("bundle without a blouse: Check = %q, want %q", got, ReasonNotApplicable)
+	}
+	if got := bundle.Check([]Line{{CategoryID: "sarees", PriceCents: 300000, Quantity: 1}, {CategoryID: "blouses", PriceCents: 50000, Quantity: 1}}, now); got != ReasonNotApplicable {
+		t.Errorf("bundle dearer than its parts: Check = %q, want %q", got, ReasonNotApplicable)
+	}
+}
+
+func TestOfferValidate(t *testing.T) {
+	item := BundleItem{AppliesTo: "categories", IDs: []string{"sarees"}}
+	cases := []struct {
+		name  string
+		offer Offer
+		ok    bool
+	}{
+		{"percentage", Offer{DiscountType: TypePercentage, DiscountValue: 20}, true},
+		{"percentage over 100", Offer{DiscountType: TypePercentage, DiscountValue: 120}, false},
+		{"fixed without value", Offer{DiscountType: TypeFixed}, false},
+		{"bogo", Offer{DiscountType: TypeBOGO, Rules: Rules{Buy: 2, Get: 1}}, true},
+		{"bogo without get", Offer{DiscountType: TypeBOGO, Rules: Rules{Buy: 2}}, false},
+		{"tiers", Offer{DiscountType: TypeTiered, Rules: Rules{Tiers: []Tier{{5000, 15}, {10000, 20}}}}, true},
+		{"tiers out of order", Offer{DiscountType: TypeTiered, Rules: Rules{Tiers: []Tier{{10000, 20}, {5000, 15}}}}, false},
+		{"higher tier gives less", Offer{DiscountType: TypeTiered, Rules: Rules{Tiers: []Tier{{5000, 20}, {10000, 15}}}}, false},
+		{"bundle", Offer{DiscountType: TypeBundle, Rules: Rules{BundlePrice: 4000, Items: []BundleItem{item, {AppliesTo: "products", IDs: []string{"BLOUSE-1"}}}}}, true},
+		{"bundle of one unit", Offer{DiscountType: TypeBundle, Rules: Rules{BundlePrice: 4000, Items: []BundleItem{item}}}, false},
+		{"bundle of two of one item", Offer{DiscountType: TypeBundle, Rules: Rules{BundlePrice: 4000, Items: []BundleItem{{AppliesTo: "categories", IDs: []string{"sarees"}, Quantity: 2}}}}, true},
+		{"bundle without price", Offer{DiscountType: TypeBundle, Rules: Rules{Items: []BundleItem{item, item}}}, false},
+		{"bundle item for all", Offer{DiscountType: TypeBundle, Rules: Rules{BundlePrice: 4000, Items: []BundleItem{item, {AppliesTo: "all", IDs: []string{"x"}}}}}, false},
+		{"unknown type", Offer{DiscountType: "cashback", DiscountValue: 10}, false},
+	}
+	for _, tc := range cases {
+		if err := tc.offer.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: Validate = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_refresh_tokens_family;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN IF EXISTS last_used_at,
+DROP COLUMN IF EXISTS replaced_by,
+DROP COLUMN IF EXISTS family_id;
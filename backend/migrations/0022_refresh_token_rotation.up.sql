@@ -0,0 +1,11 @@
+-- Refresh token rotation and reuse detection
+-- Every refresh issues a new token in the same family and revokes the old one.
+-- Presenting a revoked token revokes the whole family.
+
+ALTER TABLE refresh_tokens
+ADD COLUMN IF NOT EXISTS family_id UUID NOT NULL DEFAULT gen_random_uuid(),
+ADD COLUMN IF NOT EXISTS replaced_by BIGINT REFERENCES refresh_tokens(id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family
+    ON refresh_tokens (family_id);
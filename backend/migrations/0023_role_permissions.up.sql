@@ -0,0 +1,74 @@
+-- Permission-based access control for the admin API
+-- Roles are granted permissions; access tokens carry the union of them.
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id SERIAL PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_permissions_permission ON role_permissions(permission_id);
+
+INSERT INTO permissions (name, description) VALUES
+('products:read', 'View products and stock levels'),
+('products:write', 'Create, edit and delete products, prices and variants'),
+('media:read', 'View the media library'),
+('media:write', 'Upload and delete media'),
+('categories:read', 'View categories'),
+('categories:write', 'Create, edit and delete categories'),
+('banners:read', 'View banners'),
+('banners:write', 'Create, edit and delete banners'),
+('offers:read', 'View offers'),
+('offers:write', 'Create, edit and delete offers'),
+('orders:read', 'View orders'),
+('orders:write', 'Create, edit, cancel and delete orders'),
+('orders:fulfil', 'Update shipping status and tracking of orders'),
+('customers:read', 'View customers and their orders'),
+('inventory:read', 'View inventory'),
+('inventory:write', 'Create, edit and adjust inventory'),
+('settings:read', 'View settings'),
+('settings:write', 'Create, edit and delete settings'),
+('users:read', 'View staff users, roles and sessions'),
+('users:write', 'Manage staff users, role permissions and sessions'),
+('content:read', 'View content pages and FAQs'),
+('content:write', 'Create, edit and delete content pages and FAQs'),
+('system:maintenance', 'Run schema fixes, debug endpoints and search reindexing')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name) VALUES
+('SuperAdmin'), ('Admin'), ('Manager'), ('Staff'), ('Warehouse')
+ON CONFLICT (name) DO NOTHING;
+
+-- SuperAdmin and Admin: everything
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name IN ('SuperAdmin', 'Admin')
+ON CONFLICT DO NOTHING;
+
+-- Manager: runs the store but cannot manage staff, settings or maintenance
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'Manager'
+  AND p.name NOT IN ('users:write', 'settings:write', 'system:maintenance')
+ON CONFLICT DO NOTHING;
+
+-- Staff: read-only catalogue and orders, can fulfil orders
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'Staff'
+  AND p.name IN ('products:read', 'media:read', 'categories:read', 'banners:read', 'offers:read',
+                 'orders:read', 'orders:fulfil', 'customers:read', 'inventory:read', 'content:read')
+ON CONFLICT DO NOTHING;
+
+-- Warehouse: shipping and stock, no prices, customers or users
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'Warehouse'
+  AND p.name IN ('products:read', 'orders:read', 'orders:fulfil', 'inventory:read', 'inventory:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,23 @@
+-- TOTP two-factor authentication
+-- totp_secret is set when enrollment starts; 2FA is active once totp_enabled_at is set.
+-- totp_last_step stores the last accepted time step so a code cannot be replayed.
+
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user ON user_recovery_codes(user_id);
+
+-- Roles listed here must complete 2FA to sign in, e.g. ["SuperAdmin", "Admin"]
+INSERT INTO settings (key, value, type, description) VALUES
+('two_factor_required_roles', '[]', 'json', 'Roles that must use two-factor authentication')
+ON CONFLICT (key) DO NOTHING;
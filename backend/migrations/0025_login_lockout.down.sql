@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS login_attempts;
+ALTER TABLE users
+DROP COLUMN IF EXISTS locked_until,
+DROP COLUMN IF EXISTS last_failed_login_at,
+DROP COLUMN IF EXISTS failed_login_count;
@@ -0,0 +1,20 @@
+-- Durable failed-login tracking
+-- Per-account counters live on users; every attempt is logged so per-IP and
+-- global failure rates can be measured without Redis.
+
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS failed_login_count INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    ip_address TEXT NOT NULL,
+    success BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip_created ON login_attempts(ip_address, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_created ON login_attempts(created_at);
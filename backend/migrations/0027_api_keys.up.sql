@@ -0,0 +1,28 @@
+-- API keys for integrations (ERP sync, fulfilment partners)
+-- Only a SHA-256 hash of the key is stored; key_prefix identifies it in the admin list.
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+INSERT INTO permissions (name, description) VALUES
+('api_keys:read', 'View integration API keys'),
+('api_keys:write', 'Create, edit and revoke integration API keys')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name IN ('SuperAdmin', 'Admin')
+  AND p.name IN ('api_keys:read', 'api_keys:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- Email verification state
+-- Accounts that existed before verification was introduced are treated as verified so
+-- current customers are not suddenly restricted.
+
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
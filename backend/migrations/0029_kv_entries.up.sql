@@ -0,0 +1,10 @@
+-- Postgres fallback for short-lived keys (OTPs, sign-in challenges, rate-limit counters)
+-- used while Redis is unavailable. Expired rows are removed by the API's sweeper.
+
+CREATE TABLE IF NOT EXISTS kv_entries (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_kv_entries_expires_at ON kv_entries(expires_at);
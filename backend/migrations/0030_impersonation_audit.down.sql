@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE name IN ('customers:impersonate', 'audit:read');
+DROP TABLE IF EXISTS impersonation_sessions;
+DROP INDEX IF EXISTS idx_audit_logs_created;
+DROP INDEX IF EXISTS idx_audit_logs_object;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS details;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS impersonated_user_id;
@@ -0,0 +1,50 @@
+-- Audit trail read by GET /api/admin/audit, and support staff "view as customer" sessions.
+-- Every impersonation start, stop and request is written to audit_logs with the customer
+-- in impersonated_user_id and the session as the object, so one filter shows the whole trail.
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    actor_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    object_type TEXT NOT NULL DEFAULT '',
+    object_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS impersonated_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS details JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS ip_address TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_object ON audit_logs(object_type, object_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created ON audit_logs(created_at DESC);
+
+-- The id is the jti of the impersonation token
+CREATE TABLE IF NOT EXISTS impersonation_sessions (
+    id TEXT PRIMARY KEY,
+    impersonator_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    ip_address TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_user ON impersonation_sessions(user_id, started_at DESC);
+
+INSERT INTO permissions (name, description) VALUES
+('customers:impersonate', 'View the storefront as a customer with a short-lived read-only token'),
+('audit:read', 'View the audit log')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name IN ('SuperAdmin', 'Admin', 'Manager')
+  AND p.name = 'customers:impersonate'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name IN ('SuperAdmin', 'Admin')
+  AND p.name = 'audit:read'
+ON CONFLICT DO NOTHING;
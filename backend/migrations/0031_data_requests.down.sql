@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE name = 'customers:privacy';
+DROP TABLE IF EXISTS data_requests;
@@ -0,0 +1,27 @@
+-- Data subject requests (DPDP Act / GDPR): exports and erasures, with their outcome.
+-- No email is stored here; after an erasure the user row itself is anonymized.
+
+CREATE TABLE IF NOT EXISTS data_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    request_type TEXT NOT NULL CHECK (request_type IN ('export', 'erasure')),
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'completed', 'rejected', 'failed')),
+    details JSONB NOT NULL DEFAULT '{}',
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_requests_user ON data_requests(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_data_requests_created ON data_requests(created_at DESC);
+
+INSERT INTO permissions (name, description) VALUES
+('customers:privacy', 'Export and erase customer personal data')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name IN ('SuperAdmin', 'Admin')
+  AND p.name = 'customers:privacy'
+ON CONFLICT DO NOTHING;
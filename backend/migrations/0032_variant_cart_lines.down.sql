@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_cart_session_variant;
+ALTER TABLE product_variants DROP COLUMN IF EXISTS options;
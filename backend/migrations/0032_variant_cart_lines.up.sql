@@ -0,0 +1,22 @@
+-- Selectable variants: options describe what the customer picks (e.g. {"size": "M", "colour": "Maroon"})
+-- and a guest cart holds one line per variant, so adding the same variant again adds to that line.
+
+ALTER TABLE product_variants ADD COLUMN IF NOT EXISTS options JSONB NOT NULL DEFAULT '{}';
+
+-- Merge duplicate lines left by earlier inserts before the unique index is created
+UPDATE cart c SET quantity = d.total_quantity
+FROM (
+    SELECT MIN(id) AS id, SUM(quantity) AS total_quantity
+    FROM cart
+    WHERE session_id IS NOT NULL
+    GROUP BY session_id, variant_id
+    HAVING COUNT(*) > 1
+) d
+WHERE c.id = d.id;
+
+DELETE FROM cart c USING cart keep
+WHERE c.session_id = keep.session_id
+  AND c.variant_id = keep.variant_id
+  AND c.id > keep.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_session_variant ON cart(session_id, variant_id) WHERE session_id IS NOT NULL;
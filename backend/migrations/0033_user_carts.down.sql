@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_cart_user_variant;
+-- user_id is left in place: older deployments created it with the cart table
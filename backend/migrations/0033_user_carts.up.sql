@@ -0,0 +1,7 @@
+-- Carts of signed-in customers are keyed by user so they follow them across devices;
+-- guest carts stay keyed by the session cookie and are merged in on sign-in.
+
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_cart_user ON cart(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_user_variant ON cart(user_id, variant_id) WHERE user_id IS NOT NULL;
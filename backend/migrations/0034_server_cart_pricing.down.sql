@@ -0,0 +1,4 @@
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS price_cents INT;
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS discounted_price VARCHAR(255);
+CREATE INDEX IF NOT EXISTS idx_cart_price ON cart(price_cents);
+CREATE INDEX IF NOT EXISTS idx_cart_discounted_price ON cart(discounted_price);
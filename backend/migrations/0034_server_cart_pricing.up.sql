@@ -0,0 +1,7 @@
+-- Cart prices are computed from product_variants and offers on every read; the columns
+-- that stored a client-supplied price are dropped so nothing can read them again.
+
+DROP INDEX IF EXISTS idx_cart_discounted_price;
+DROP INDEX IF EXISTS idx_cart_price;
+ALTER TABLE cart DROP COLUMN IF EXISTS discounted_price;
+ALTER TABLE cart DROP COLUMN IF EXISTS price_cents;
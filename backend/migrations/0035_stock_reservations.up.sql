@@ -0,0 +1,16 @@
+-- Checkout holds stock for a short time so two customers cannot pay for the same last piece.
+-- A variant's sellable stock is stock_quantity minus its active, unexpired reservations.
+
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id BIGSERIAL PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    variant_id INTEGER NOT NULL REFERENCES product_variants(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'converted', 'released', 'expired')),
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_order ON stock_reservations(order_id);
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_active ON stock_reservations(variant_id, expires_at) WHERE status = 'active';
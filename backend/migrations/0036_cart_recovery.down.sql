@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS email_opt_outs;
+DROP TABLE IF EXISTS cart_recovery_emails;
@@ -0,0 +1,31 @@
+-- Reminder emails for carts and unpaid checkouts left idle, and what they brought back.
+
+CREATE TABLE IF NOT EXISTS cart_recovery_emails (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('cart', 'order')),
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    email TEXT NOT NULL,
+    -- anchor_at is the last cart activity (or the order's creation) the reminder is about;
+    -- step is the index into the configured cadence
+    anchor_at TIMESTAMPTZ NOT NULL,
+    step INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    clicked_at TIMESTAMPTZ,
+    recovered_order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    recovered_amount NUMERIC(10,2),
+    recovered_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_cart_recovery_user ON cart_recovery_emails(user_id, kind, anchor_at);
+CREATE INDEX IF NOT EXISTS idx_cart_recovery_order ON cart_recovery_emails(order_id);
+CREATE INDEX IF NOT EXISTS idx_cart_recovery_sent ON cart_recovery_emails(sent_at);
+
+-- Addresses that asked not to receive a kind of non-transactional email
+CREATE TABLE IF NOT EXISTS email_opt_outs (
+    email TEXT NOT NULL,
+    list TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (email, list)
+);
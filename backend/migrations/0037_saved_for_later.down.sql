@@ -0,0 +1,4 @@
+ALTER TABLE wishlist DROP COLUMN IF EXISTS variant_id;
+-- Saved lines would otherwise be charged at the next checkout
+DELETE FROM cart WHERE saved_for_later = TRUE;
+ALTER TABLE cart DROP COLUMN IF EXISTS saved_for_later;
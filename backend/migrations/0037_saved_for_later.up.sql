@@ -0,0 +1,7 @@
+-- Cart lines can be set aside as "saved for later": they stay with the shopper but are
+-- not priced, charged or reserved. A variant is either in the cart or saved, never both,
+-- so the per-owner variant indexes still hold.
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS saved_for_later BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Wishlist entries remember the variant they came from so moving them back keeps the size
+ALTER TABLE wishlist ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES product_variants(id) ON DELETE SET NULL;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_wishlist_user_product;
+DROP INDEX IF EXISTS idx_wishlist_session_product;
+DELETE FROM wishlist WHERE user_id IS NOT NULL;
+ALTER TABLE wishlist DROP CONSTRAINT IF EXISTS wishlist_user_or_session;
+ALTER TABLE wishlist ADD CONSTRAINT wishlist_user_or_session CHECK (
+    (session_id IS NOT NULL AND customer_id IS NULL) OR
+    (session_id IS NULL AND customer_id IS NOT NULL)
+);
+ALTER TABLE wishlist DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,14 @@
+-- Wishlists of signed-in customers are keyed by user so they follow them across devices;
+-- guest wishlists stay keyed by the session cookie and are merged in on sign-in.
+ALTER TABLE wishlist ADD COLUMN IF NOT EXISTS user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE wishlist DROP CONSTRAINT IF EXISTS wishlist_user_or_session;
+ALTER TABLE wishlist ADD CONSTRAINT wishlist_user_or_session CHECK (num_nonnulls(session_id, customer_id, user_id) = 1);
+
+-- One entry per product per owner; toggling used to be the only guard
+DELETE FROM wishlist w
+USING wishlist d
+WHERE w.session_id = d.session_id AND w.product_id = d.product_id AND w.id > d.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wishlist_session_product ON wishlist(session_id, product_id) WHERE session_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wishlist_user_product ON wishlist(user_id, product_id) WHERE user_id IS NOT NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_order_line_items_registry_item;
+ALTER TABLE orders DROP COLUMN IF EXISTS gift_registry_id;
+ALTER TABLE order_line_items DROP COLUMN IF EXISTS registry_item_id;
+ALTER TABLE cart DROP COLUMN IF EXISTS registry_item_id;
+DROP TABLE IF EXISTS gift_registry_items;
+DROP TABLE IF EXISTS gift_registries;
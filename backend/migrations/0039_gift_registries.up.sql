@@ -0,0 +1,45 @@
+-- Shareable wishlists and gift registries. A customer publishes a named list that anyone
+-- holding its link can buy from. What has been bought is counted from paid order lines,
+-- so cancelled orders give the item back to the list.
+CREATE TABLE IF NOT EXISTS gift_registries (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    share_token TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    occasion TEXT NOT NULL DEFAULT 'wishlist'
+        CHECK (occasion IN ('wishlist', 'wedding', 'festival', 'birthday', 'baby_shower', 'other')),
+    event_date DATE,
+    message TEXT,
+    -- Where gifts are sent; never shown to visitors
+    shipping_name TEXT,
+    shipping_phone TEXT,
+    shipping_address_line1 TEXT,
+    shipping_address_line2 TEXT,
+    shipping_city TEXT,
+    shipping_state TEXT,
+    shipping_pin_code TEXT,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_gift_registries_user ON gift_registries(user_id);
+
+CREATE TABLE IF NOT EXISTS gift_registry_items (
+    id BIGSERIAL PRIMARY KEY,
+    registry_id BIGINT NOT NULL REFERENCES gift_registries(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(uuid_id) ON DELETE CASCADE,
+    variant_id INTEGER NOT NULL REFERENCES product_variants(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0),
+    note TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (registry_id, variant_id)
+);
+
+-- A gift travels from the visitor's cart line to the order line it was bought on
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS registry_item_id BIGINT REFERENCES gift_registry_items(id) ON DELETE SET NULL;
+ALTER TABLE order_line_items ADD COLUMN IF NOT EXISTS registry_item_id BIGINT REFERENCES gift_registry_items(id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gift_registry_id BIGINT REFERENCES gift_registries(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_order_line_items_registry_item ON order_line_items(registry_item_id) WHERE registry_item_id IS NOT NULL;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS wishlist_alerts;
+DROP TABLE IF EXISTS wishlist_alert_digests;
+
+ALTER TABLE wishlist DROP COLUMN IF EXISTS alert_in_stock;
+ALTER TABLE wishlist DROP COLUMN IF EXISTS alert_price_cents;
+ALTER TABLE wishlist DROP COLUMN IF EXISTS alerts_enabled;
+
+ALTER TABLE users DROP COLUMN IF EXISTS wishlist_alerts_enabled;
@@ -0,0 +1,41 @@
+-- Price-drop and back-in-stock alerts for wishlisted products, sent as at most one digest
+-- email a day. Customers opt in for their whole wishlist or for single entries.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS wishlist_alerts_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Each entry remembers the price and availability it was last seen at; a change is
+-- measured against it, so one drop is reported once
+ALTER TABLE wishlist ADD COLUMN IF NOT EXISTS alerts_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE wishlist ADD COLUMN IF NOT EXISTS alert_price_cents INTEGER;
+ALTER TABLE wishlist ADD COLUMN IF NOT EXISTS alert_in_stock BOOLEAN;
+
+UPDATE wishlist w SET (alert_price_cents, alert_in_stock) = (
+    SELECT v.price_cents, COALESCE(p.published, FALSE) AND COALESCE(v.stock_quantity, 0) > 0
+    FROM product_variants v JOIN products p ON p.uuid_id = v.product_id
+    WHERE v.product_id = w.product_id AND (w.variant_id IS NULL OR v.id = w.variant_id)
+    ORDER BY COALESCE(v.stock_quantity, 0) > 0 DESC, v.price_cents, v.id
+    LIMIT 1
+);
+
+CREATE TABLE IF NOT EXISTS wishlist_alert_digests (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_wishlist_alert_digests_user ON wishlist_alert_digests(user_id, sent_at);
+
+-- Changes waiting for the owner's next digest
+CREATE TABLE IF NOT EXISTS wishlist_alerts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(uuid_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('price_drop', 'back_in_stock')),
+    old_price_cents INTEGER,
+    new_price_cents INTEGER NOT NULL,
+    digest_id BIGINT REFERENCES wishlist_alert_digests(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_wishlist_alerts_pending ON wishlist_alerts(user_id) WHERE digest_id IS NULL;
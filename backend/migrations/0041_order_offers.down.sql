@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_orders_offer;
+ALTER TABLE orders DROP COLUMN IF EXISTS offer_redeemed_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS offer_id;
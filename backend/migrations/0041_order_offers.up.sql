@@ -0,0 +1,6 @@
+-- Orders remember the offer the cart was priced with. The offer's usage_count goes up once
+-- per order, when the payment is verified, and never past usage_limit.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS offer_id UUID REFERENCES offers(id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS offer_redeemed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_orders_offer ON orders(offer_id) WHERE offer_id IS NOT NULL;
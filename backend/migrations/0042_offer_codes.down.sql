@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS offer_redemptions;
+ALTER TABLE orders DROP COLUMN IF EXISTS offer_discount_amount;
+ALTER TABLE orders DROP COLUMN IF EXISTS offer_code_id;
+DROP TABLE IF EXISTS cart_coupons;
+DROP TABLE IF EXISTS offer_codes;
+
+ALTER TABLE offers DROP COLUMN IF EXISTS first_order_only;
+ALTER TABLE offers DROP COLUMN IF EXISTS per_customer_limit;
+ALTER TABLE offers DROP COLUMN IF EXISTS requires_code;
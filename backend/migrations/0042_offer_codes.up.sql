@@ -0,0 +1,55 @@
+-- Offers redeemed with a code. Once an offer has been given codes it only applies to carts
+-- the customer entered one of them on; offers without codes keep applying automatically.
+-- A code is either shared ("DIWALI20") or single use, generated in named batches.
+ALTER TABLE offers ADD COLUMN IF NOT EXISTS requires_code BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE offers ADD COLUMN IF NOT EXISTS per_customer_limit INTEGER CHECK (per_customer_limit > 0);
+ALTER TABLE offers ADD COLUMN IF NOT EXISTS first_order_only BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS offer_codes (
+    id BIGSERIAL PRIMARY KEY,
+    offer_id UUID NOT NULL REFERENCES offers(id) ON DELETE CASCADE,
+    code TEXT NOT NULL,
+    single_use BOOLEAN NOT NULL DEFAULT FALSE,
+    batch TEXT,
+    redeemed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_offer_codes_code ON offer_codes(UPPER(code));
+CREATE INDEX IF NOT EXISTS idx_offer_codes_offer ON offer_codes(offer_id, batch);
+
+-- The code a customer entered on their cart, at most one per cart
+CREATE TABLE IF NOT EXISTS cart_coupons (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    session_id TEXT,
+    code_id BIGINT NOT NULL REFERENCES offer_codes(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT cart_coupons_user_or_session CHECK (num_nonnulls(user_id, session_id) = 1)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_coupons_user ON cart_coupons(user_id) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_coupons_session ON cart_coupons(session_id) WHERE session_id IS NOT NULL;
+
+-- One row per paid order that used an offer, with the code it was entered with
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS offer_code_id BIGINT REFERENCES offer_codes(id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS offer_discount_amount NUMERIC(10,2);
+
+CREATE TABLE IF NOT EXISTS offer_redemptions (
+    id BIGSERIAL PRIMARY KEY,
+    offer_id UUID NOT NULL REFERENCES offers(id) ON DELETE CASCADE,
+    code_id BIGINT REFERENCES offer_codes(id) ON DELETE SET NULL,
+    order_id UUID NOT NULL UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    discount_amount NUMERIC(10,2) NOT NULL DEFAULT 0,
+    redeemed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_offer_redemptions_offer_user ON offer_redemptions(offer_id, user_id);
+
+-- Orders paid before this migration count against per-customer limits too
+INSERT INTO offer_redemptions (offer_id, order_id, user_id, discount_amount, redeemed_at)
+SELECT o.offer_id, o.id, o.user_id, COALESCE(o.offer_discount_amount, o.discount_amount, 0), o.offer_redeemed_at
+FROM orders o
+WHERE o.offer_id IS NOT NULL AND o.offer_redeemed_at IS NOT NULL
+ON CONFLICT (order_id) DO NOTHING;
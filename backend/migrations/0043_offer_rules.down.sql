@@ -0,0 +1,2 @@
+ALTER TABLE order_line_items DROP COLUMN IF EXISTS discount_amount;
+ALTER TABLE offers DROP COLUMN IF EXISTS rules;
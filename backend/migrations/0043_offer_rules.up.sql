@@ -0,0 +1,5 @@
+-- Offers of the bogo, tiered and bundle types keep their settings in rules; percentage and
+-- fixed offers leave it empty. Order lines record the part of the offer's discount that
+-- fell on them, so refunds and invoices can show what each item was sold for.
+ALTER TABLE offers ADD COLUMN IF NOT EXISTS rules JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE order_line_items ADD COLUMN IF NOT EXISTS discount_amount NUMERIC(10,2) NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+-- Failed sign-ins for addresses that have no account, counted like the columns on users
+-- so Login answers with the same delays and locks whether or not the account exists.
+-- Rows are pruned along with old login_attempts.
+CREATE TABLE IF NOT EXISTS unknown_login_failures (
+    email TEXT PRIMARY KEY,
+    failed_login_count INTEGER NOT NULL DEFAULT 0,
+    last_failed_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_unknown_login_failures_last ON unknown_login_failures(last_failed_login_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_stock_notifications_confirm_token;
+ALTER TABLE stock_notifications DROP COLUMN IF EXISTS confirm_token_hash;
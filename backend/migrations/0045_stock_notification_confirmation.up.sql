@@ -0,0 +1,7 @@
+-- Email sign-ups for back-in-stock alerts stay inactive until the address confirms them,
+-- unless a signed-in customer subscribes their own verified address. Existing sign-ups
+-- are left as they are.
+ALTER TABLE stock_notifications ADD COLUMN IF NOT EXISTS confirm_token_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_notifications_confirm_token
+    ON stock_notifications(confirm_token_hash) WHERE confirm_token_hash IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_orders_oversold;
+ALTER TABLE orders DROP COLUMN IF EXISTS oversold_at;
@@ -0,0 +1,5 @@
+-- Orders paid after their stock hold lapsed still convert, and are stamped here when the
+-- stock had gone to someone else meanwhile, so the team can find and resolve them.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS oversold_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_orders_oversold ON orders(oversold_at) WHERE oversold_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_cart_recovery_order_step;
+DROP INDEX IF EXISTS idx_cart_recovery_cart_step;
@@ -0,0 +1,19 @@
+-- Each API process runs the reminder job, so a reminder (the same step for the same cart
+-- activity or unpaid order) is claimed by inserting its row, which only one can do.
+-- Duplicates sent before this are dropped, keeping the one that brought an order back or
+-- was clicked, else the first.
+DELETE FROM cart_recovery_emails WHERE id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (
+            PARTITION BY kind, user_id, order_id, anchor_at, step
+            ORDER BY recovered_order_id IS NULL, clicked_at IS NULL, id
+        ) AS n
+        FROM cart_recovery_emails
+    ) ranked
+    WHERE n > 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_recovery_cart_step
+    ON cart_recovery_emails(user_id, anchor_at, step) WHERE kind = 'cart';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_recovery_order_step
+    ON cart_recovery_emails(order_id, anchor_at, step) WHERE kind = 'order';
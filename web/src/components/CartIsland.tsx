@@ -1,15 +1,28 @@
 import React, { useState, useEffect } from 'react';
-import { getCart, removeFromCart, addToCart } from '../lib/api';
+import { getCart, removeFromCart, updateCartItem } from '../lib/api';
 
 interface CartItem {
   id: string;
   product_id: string;
+  variant_id: number;
   title: string;
+  variant_title?: string;
+  options?: Record<string, string>;
   price: number;
+  list_price: number;
+  discount: number;
+  discount_reason?: string;
   quantity: number;
   image_url: string;
 }
 
+// Totals are computed by the server with the same pricing used at checkout
+interface CartTotals {
+  subtotal: number;
+  discount: number;
+  total: number;
+}
+
 interface CartIslandProps {
   isOpen: boolean;
   onClose: () => void;
@@ -17,6 +30,7 @@ interface CartIslandProps {
 
 const CartIsland: React.FC<CartIslandProps> = ({ isOpen, onClose }) => {
   const [cartItems, setCartItems] = useState<CartItem[]>([]);
+  const [totals, setTotals] = useState<CartTotals>({ subtotal: 0, discount: 0, total: 0 });
   const [loading, setLoading] = useState(false);
 
   useEffect(() => {
@@ -30,17 +44,28 @@ const CartIsland: React.FC<CartIslandProps> = ({ isOpen, onClose }) => {
     try {
       const cartData = await getCart();
       setCartItems(cartData.items || []);
+      setTotals({
+        subtotal: cartData.subtotal ?? cartData.total ?? 0,
+        discount: cartData.discount ?? 0,
+        total: cartData.total ?? 0,
+      });
     } catch (error) {
       console.error('Failed to load cart:', error);
       setCartItems([]);
+      setTotals({ subtotal: 0, discount: 0, total: 0 });
     } finally {
       setLoading(false);
     }
   };
 
-  const updateQuantity = async (productId: string, change: number) => {
+  const updateQuantity = async (item: CartItem, change: number) => {
     try {
-      await addToCart(productId, change);
+      const quantity = item.quantity + change;
+      if (quantity < 1) {
+        await removeFromCart(item.id);
+      } else {
+        await updateCartItem(item.id, quantity);
+      }
       await loadCart(); // Reload cart to get updated data
     } catch (error) {
       console.error('Failed to update quantity:', error);
@@ -56,24 +81,16 @@ const CartIsland: React.FC<CartIslandProps> = ({ isOpen, onClose }) => {
     }
   };
 
-  const calculateSubtotal = () => {
-    return cartItems.reduce((total, item) => {
-      return total + (item.price * item.quantity);
-    }, 0);
-  };
-
-  const calculateShipping = () => {
-    return cartItems.length > 0 ? (calculateSubtotal() > 5000 ? 0 : 250) : 0;
-  };
-
-  const calculateTotal = () => {
-    return calculateSubtotal() + calculateShipping();
-  };
 
   const getTotalItems = () => {
     return cartItems.reduce((sum, item) => sum + item.quantity, 0);
   };
 
+  const variantLabel = (item: CartItem) => {
+    const options = Object.entries(item.options || {}).map(([name, value]) => `${name}: ${value}`);
+    return options.length > 0 ? options.join(' | ') : item.variant_title || '';
+  };
+
   if (!isOpen) return null;
 
   return (
@@ -128,24 +145,32 @@ const CartIsland: React.FC<CartIslandProps> = ({ isOpen, onClose }) => {
                     />
                     <div className="flex-1">
                       <h3 className="font-semibold text-dark mb-1">{item.title}</h3>
-                      <p className="text-sm text-dark/60 mb-2">SKU: {item.product_id}</p>
+                      <p className="text-sm text-dark/60 mb-2">{variantLabel(item) || `SKU: ${item.product_id}`}</p>
                       <div className="flex items-center justify-between">
                         <div className="flex items-center space-x-2">
                           <button
-                            onClick={() => updateQuantity(item.product_id, -1)}
+                            onClick={() => updateQuantity(item, -1)}
                             className="w-8 h-8 bg-white border border-gold/30 rounded-full flex items-center justify-center hover:bg-gold hover:text-white transition-colors duration-300"
                           >
                             -
                           </button>
                           <span className="font-semibold w-8 text-center">{item.quantity}</span>
                           <button
-                            onClick={() => updateQuantity(item.product_id, 1)}
+                            onClick={() => updateQuantity(item, 1)}
                             className="w-8 h-8 bg-white border border-gold/30 rounded-full flex items-center justify-center hover:bg-gold hover:text-white transition-colors duration-300"
                           >
                             +
                           </button>
                         </div>
-                        <span className="font-semibold text-maroon">₹{item.price}</span>
+                        <span className="text-right">
+                          {item.discount > 0 && (
+                            <span className="block text-xs text-dark/50 line-through">₹{item.list_price}</span>
+                          )}
+                          <span className="font-semibold text-maroon">₹{item.price}</span>
+                          {item.discount_reason && (
+                            <span className="block text-xs text-green-600">{item.discount_reason}</span>
+                          )}
+                        </span>
                       </div>
                     </div>
                     <button
@@ -169,19 +194,22 @@ const CartIsland: React.FC<CartIslandProps> = ({ isOpen, onClose }) => {
                 <div className="space-y-3">
                   <div className="flex justify-between text-dark/80">
                     <span>Subtotal ({getTotalItems()} items)</span>
-                    <span>₹{calculateSubtotal().toLocaleString()}</span>
+                    <span>₹{totals.subtotal.toLocaleString()}</span>
                   </div>
+                  {totals.discount > 0 && (
+                    <div className="flex justify-between text-green-600">
+                      <span>Discount</span>
+                      <span>-₹{totals.discount.toLocaleString()}</span>
+                    </div>
+                  )}
                   <div className="flex justify-between text-dark/80">
                     <span>Shipping</span>
-                    <span>{calculateShipping() === 0 ? 'FREE' : `₹${calculateShipping()}`}</span>
+                    <span>FREE</span>
                   </div>
-                  {calculateShipping() === 0 && (
-                    <p className="text-sm text-green-600">You have qualified for free shipping!</p>
-                  )}
                   <div className="border-t border-gold/20 pt-3">
                     <div className="flex justify-between font-semibold text-lg text-maroon">
                       <span>Total</span>
-                      <span>₹{calculateTotal().toLocaleString()}</span>
+                      <span>₹{totals.total.toLocaleString()}</span>
                     </div>
                   </div>
                 </div>
@@ -51,9 +51,14 @@ export default function StockNotificationModal({ isOpen, onClose, product }: Sto
       setSubmitting(true);
       const API_URL = 'https://etreasure-1.onrender.com';
       
+      // Signed-in customers subscribing their own verified address skip the confirmation email
+      const headers: Record<string, string> = { 'Content-Type': 'application/json' };
+      const token = localStorage.getItem('accessToken');
+      if (token) headers['Authorization'] = `Bearer ${token}`;
+
       const res = await fetch(`${API_URL}/api/stock-notifications`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers,
         body: JSON.stringify({
           productId: product.id,
           productSlug: product.slug,
@@ -69,7 +74,10 @@ export default function StockNotificationModal({ isOpen, onClose, product }: Sto
         return;
       }
 
-      setSuccess('We will notify you when this product is back in stock!');
+      // Addresses other than the customer's own verified one confirm from their inbox first
+      setSuccess(data?.confirmationRequired
+        ? 'Please check your email and confirm to get notified.'
+        : 'We will notify you when this product is back in stock!');
       
       // Close modal after 2 seconds
       setTimeout(() => {
@@ -1,4 +1,5 @@
 import { useState } from 'react';
+import { rememberCartMerge } from '../../lib/api';
 
 const API_URL = 'https://etreasure-1.onrender.com';
 
@@ -37,6 +38,7 @@ export default function LoginForm() {
       if (data.accessToken) {
         sessionStorage.setItem('accessToken', data.accessToken);
       }
+      rememberCartMerge(data);
 
       window.location.href = '/account/dashboard';
     } catch (err) {
@@ -1,4 +1,5 @@
 import { useState } from 'react';
+import { rememberCartMerge } from '../../lib/api';
 
 interface OTPVerificationProps {
   email: string;
@@ -53,6 +54,7 @@ export default function OTPVerification({ email, password, fullName, onSuccess,
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({ email, otp: otpValue }),
+        credentials: 'include', // Send the guest cart cookie so it is merged into the new account
       });
 
       const data = await res.json();
@@ -61,6 +63,7 @@ export default function OTPVerification({ email, password, fullName, onSuccess,
         return;
       }
 
+      rememberCartMerge(data);
       onSuccess();
     } catch (err) {
       setError('Verification failed. Please try again.');
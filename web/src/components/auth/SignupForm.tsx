@@ -1,6 +1,7 @@
 import { useState } from 'react';
 import PasswordStrengthIndicator from './PasswordStrengthIndicator';
 import OTPVerification from './OTPVerification';
+import { rememberCartMerge } from '../../lib/api';
 
 // Temporarily hardcoded for debugging
 const API_URL = 'https://etreasure-1.onrender.com';
@@ -62,11 +63,13 @@ export default function SignupForm() {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({ email, password }),
+        credentials: 'include',
       });
       
       const data = await res.json();
       
       if (res.ok && data.accessToken) {
+        rememberCartMerge(data);
         // Store tokens
         localStorage.setItem('accessToken', data.accessToken);
         if (data.refreshToken) {
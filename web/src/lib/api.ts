@@ -72,10 +72,25 @@ export interface User {
   roles: string[];
 }
 
+export interface CartMergeLine {
+  variantId: number;
+  title: string;
+  requested: number;
+  quantity: number;
+}
+
+// What happened to the guest cart when it was merged into the account on sign-in
+export interface CartMerge {
+  merged: number;
+  capped: CartMergeLine[];
+  dropped: CartMergeLine[];
+}
+
 export interface AuthResponse {
   accessToken: string;
   refreshToken: string;
   user: User;
+  cartMerge?: CartMerge;
 }
 
 export interface LoginRequest {
@@ -210,28 +225,72 @@ async function apiRequest(url: string, options: RequestInit = {}): Promise<Respo
   }
 }
 
-// Session-only API wrapper for cart operations (no authentication)
+// API wrapper for cart and wishlist operations: guests are identified by the session
+// cookie, signed-in customers also send their token so the server uses their own cart.
+// Unlike apiRequest it never redirects to login; an expired sign-in falls back to the guest cart.
 async function apiRequestSessionOnly(url: string, options: RequestInit = {}): Promise<Response> {
-  const headers: HeadersInit = {
-    'Content-Type': 'application/json',
-    ...options.headers,
+  const send = () => {
+    const token = getAuthToken();
+    const headers: HeadersInit = {
+      'Content-Type': 'application/json',
+      ...(token && { Authorization: `Bearer ${token}` }),
+      ...options.headers,
+    };
+    return fetch(`${API_BASE_URL}${url}`, {
+      ...options,
+      headers,
+      credentials: 'include', // Include cookies for session management
+    });
   };
 
-  const response = await fetch(`${API_BASE_URL}${url}`, {
-    ...options,
-    headers,
-    credentials: 'include', // Include cookies for session management only
-  });
+  let response = await send();
+  if (response.status === 401 && getAuthToken()) {
+    if (!(await refreshAccessToken())) {
+      clearAuth();
+    }
+    response = await send();
+  }
 
   return response;
 }
 
+// Keep the cart merge outcome of a sign-in so the cart page can explain it
+export function rememberCartMerge(data: { cartMerge?: CartMerge }) {
+  if (typeof sessionStorage !== 'undefined' && data?.cartMerge) {
+    sessionStorage.setItem('cartMergeNotice', JSON.stringify(data.cartMerge));
+  }
+}
+
+// Returns the pending cart merge outcome once, then forgets it
+export function takeCartMergeNotice(): CartMerge | null {
+  if (typeof sessionStorage === 'undefined') {
+    return null;
+  }
+  const stored = sessionStorage.getItem('cartMergeNotice');
+  sessionStorage.removeItem('cartMergeNotice');
+  return stored ? JSON.parse(stored) : null;
+}
+
+// Records that a cart reminder email brought the customer back; failures are ignored
+export async function trackCartReminderClick(token: string): Promise<void> {
+  try {
+    await fetch(`${API_BASE_URL}/api/cart-reminders/click`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ token }),
+    });
+  } catch {
+    // Tracking must never get in the way of the cart
+  }
+}
+
 // Authentication API functions
 export async function login(credentials: LoginRequest): Promise<AuthResponse> {
   const response = await fetch(`${API_BASE_URL}/api/auth/login`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(credentials),
+    credentials: 'include', // Send the guest cart cookie so it is merged into the account
   });
 
   if (!response.ok) {
@@ -239,7 +298,9 @@ export async function login(credentials: LoginRequest): Promise<AuthResponse> {
     throw new Error(error.error || 'Login failed');
   }
 
-  return response.json();
+  const data = await response.json();
+  rememberCartMerge(data);
+  return data;
 }
 
 export async function signup(userData: SignupRequest): Promise<AuthResponse> {
@@ -247,6 +308,7 @@ export async function signup(userData: SignupRequest): Promise<AuthResponse> {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(userData),
+    credentials: 'include', // Send the guest cart cookie so it is merged into the account
   });
 
   if (!response.ok) {
@@ -254,7 +316,9 @@ export async function signup(userData: SignupRequest): Promise<AuthResponse> {
     throw new Error(error.error || 'Signup failed');
   }
 
-  return response.json();
+  const data = await response.json();
+  rememberCartMerge(data);
+  return data;
 }
 
 export async function logout(): Promise<void> {
@@ -382,11 +446,12 @@ function dispatchShopEvent(eventName: string) {
 }
 
 // Cart and Wishlist functions with session management (no authentication required)
-export const addToCart = async (productId: string, quantity = 1, price?: number) => {
+// Prices are always computed by the server; getCart returns the breakdown
+export const addToCart = async (productId: string, quantity = 1, variantId?: number) => {
   try {
     const requestBody: any = { product_id: productId, quantity };
-    if (price !== undefined && price > 0) {
-      requestBody.price = price;
+    if (variantId) {
+      requestBody.variant_id = variantId;
     }
     
     const response = await apiRequestSessionOnly('/api/cart/add', {
@@ -416,8 +481,22 @@ export const getCart = async () => {
   } catch (error) {
     console.error('Get cart error:', error);
     // Return empty cart for better UX instead of throwing
-    return { items: [], total: 0 };
+    return { items: [], saved_for_later: [], total: 0 };
+  }
+};
+
+export const updateCartItem = async (itemId: string, quantity: number) => {
+  const response = await apiRequestSessionOnly(`/api/cart/${itemId}`, {
+    method: 'PATCH',
+    body: JSON.stringify({ quantity }),
+  });
+
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || 'Failed to update cart');
   }
+  dispatchShopEvent('cart-updated');
+  return data;
 };
 
 export const removeFromCart = async (itemId: string) => {
@@ -450,11 +529,62 @@ export const clearCart = async () => {
   }
 };
 
-export const toggleWishlist = async (productId: string, price?: number) => {
+// A code the server refuses comes back with a `reason` (expired, min_order_not_met,
+// already_used, ...) and a message meant for the shopper
+export const applyCartCoupon = async (code: string) => {
+  const response = await apiRequestSessionOnly('/api/cart/coupon', {
+    method: 'POST',
+    body: JSON.stringify({ code }),
+  });
+  const data = await response.json();
+  if (!response.ok) {
+    const error: any = new Error(data.error || 'Failed to apply code');
+    error.reason = data.reason;
+    throw error;
+  }
+  dispatchShopEvent('cart-updated');
+  return data;
+};
+
+export const removeCartCoupon = async () => {
+  const response = await apiRequestSessionOnly('/api/cart/coupon', { method: 'DELETE' });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || 'Failed to remove code');
+  }
+  dispatchShopEvent('cart-updated');
+  return data;
+};
+
+// Moves keep the variant; the server re-checks stock on the way back into the cart and
+// answers with `capped: true` when it had to lower the quantity
+const moveCartItem = async (path: string, events: string[], fallbackError: string) => {
+  const response = await apiRequestSessionOnly(path, { method: 'POST' });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || fallbackError);
+  }
+  events.forEach((event) => dispatchShopEvent(event));
+  return data;
+};
+
+export const saveForLater = (itemId: string) =>
+  moveCartItem(`/api/cart/${itemId}/save-for-later`, ['cart-updated'], 'Failed to save item for later');
+
+export const moveSavedToCart = (itemId: string) =>
+  moveCartItem(`/api/cart/${itemId}/move-to-cart`, ['cart-updated'], 'Failed to move item to cart');
+
+export const moveCartItemToWishlist = (itemId: string) =>
+  moveCartItem(`/api/cart/${itemId}/move-to-wishlist`, ['cart-updated', 'wishlist-updated'], 'Failed to move item to wishlist');
+
+export const moveWishlistItemToCart = (productId: string) =>
+  moveCartItem(`/api/wishlist/${productId}/move-to-cart`, ['cart-updated', 'wishlist-updated'], 'Failed to move item to cart');
+
+export const toggleWishlist = async (productId: string, variantId?: number) => {
   try {
     const requestBody: any = { product_id: productId };
-    if (price !== undefined && price > 0) {
-      requestBody.price = price;
+    if (variantId) {
+      requestBody.variant_id = variantId;
     }
     
     const response = await apiRequestSessionOnly('/api/wishlist/toggle', {
@@ -497,6 +627,151 @@ export const removeFromWishlist = async (productId: string) => {
   }
 };
 
+// Price-drop and back-in-stock alerts for wishlisted products, sent as a daily digest.
+// Signed-in customers turn them on for single items or for the whole wishlist.
+export const setWishlistItemAlerts = async (productId: string, enabled: boolean) => {
+  const response = await apiRequestSessionOnly(`/api/wishlist/${productId}/alerts`, {
+    method: 'PUT',
+    body: JSON.stringify({ enabled }),
+  });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || 'Failed to update wishlist alerts');
+  }
+  return data;
+};
+
+export const getWishlistAlertSettings = async (): Promise<{ enabled: boolean }> => {
+  const response = await apiRequest('/api/account/wishlist-alerts', { method: 'GET' });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || 'Failed to load wishlist alerts');
+  }
+  return data;
+};
+
+export const setWishlistAlertSettings = async (enabled: boolean): Promise<{ enabled: boolean }> => {
+  const response = await apiRequest('/api/account/wishlist-alerts', {
+    method: 'PUT',
+    body: JSON.stringify({ enabled }),
+  });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || 'Failed to update wishlist alerts');
+  }
+  return data;
+};
+
+// Gift registries. Owners manage theirs from their account; visitors open one by its
+// share token and buy from it as gifts.
+export interface GiftRegistryItem {
+  id: number;
+  product_id: string;
+  variant_id: number;
+  title: string;
+  variant_title: string;
+  options: Record<string, string>;
+  price: number;
+  image_url: string;
+  quantity: number;
+  purchased: number;
+  remaining: number;
+  in_stock: boolean;
+  note: string;
+}
+
+export interface GiftRegistryAddress {
+  name: string;
+  phone: string;
+  address_line1: string;
+  address_line2: string;
+  city: string;
+  state: string;
+  pin_code: string;
+}
+
+export interface GiftRegistry {
+  id: number;
+  name: string;
+  occasion: string;
+  event_date: string | null;
+  message: string;
+  shipping_address: GiftRegistryAddress | null;
+  is_active: boolean;
+  share_url: string;
+  item_count: number;
+  created_at: string;
+  items?: GiftRegistryItem[];
+}
+
+export interface PublicGiftRegistry {
+  name: string;
+  occasion: string;
+  event_date: string | null;
+  message: string;
+  owner_name: string;
+  ships_to_registrant: boolean;
+  items: GiftRegistryItem[];
+}
+
+const registryRequest = async (path: string, options: RequestInit, fallbackError: string) => {
+  const response = await apiRequest(path, options);
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || fallbackError);
+  }
+  return data;
+};
+
+export const getMyRegistries = async (): Promise<GiftRegistry[]> => {
+  const data = await registryRequest('/api/account/registries', { method: 'GET' }, 'Failed to load registries');
+  return data.data || [];
+};
+
+export const getMyRegistry = (id: number): Promise<GiftRegistry> =>
+  registryRequest(`/api/account/registries/${id}`, { method: 'GET' }, 'Failed to load registry');
+
+export const createRegistry = (registry: Partial<GiftRegistry> & { from_wishlist?: boolean }): Promise<GiftRegistry> =>
+  registryRequest('/api/account/registries', { method: 'POST', body: JSON.stringify(registry) }, 'Failed to create registry');
+
+export const updateRegistry = (id: number, registry: Partial<GiftRegistry>): Promise<GiftRegistry> =>
+  registryRequest(`/api/account/registries/${id}`, { method: 'PUT', body: JSON.stringify(registry) }, 'Failed to update registry');
+
+export const deleteRegistry = (id: number) =>
+  registryRequest(`/api/account/registries/${id}`, { method: 'DELETE' }, 'Failed to delete registry');
+
+export const updateRegistryItem = (id: number, itemId: number, quantity: number): Promise<GiftRegistry> =>
+  registryRequest(`/api/account/registries/${id}/items/${itemId}`, { method: 'PATCH', body: JSON.stringify({ quantity }) }, 'Failed to update item');
+
+export const removeRegistryItem = (id: number, itemId: number): Promise<GiftRegistry> =>
+  registryRequest(`/api/account/registries/${id}/items/${itemId}`, { method: 'DELETE' }, 'Failed to remove item');
+
+export const getPublicRegistry = async (token: string): Promise<PublicGiftRegistry | null> => {
+  const response = await fetch(`${API_BASE_URL}/api/registries/${encodeURIComponent(token)}`);
+  if (response.status === 404) {
+    return null;
+  }
+  if (!response.ok) {
+    throw new Error(`Failed to load registry: ${response.status}`);
+  }
+  return response.json();
+};
+
+// Puts a registry item in the cart as a gift; the server cuts the quantity to what is
+// still wanted and answers with `capped: true` when it did
+export const addGiftToCart = async (token: string, itemId: number, quantity = 1) => {
+  const response = await apiRequestSessionOnly(`/api/registries/${encodeURIComponent(token)}/items/${itemId}/cart`, {
+    method: 'POST',
+    body: JSON.stringify({ quantity }),
+  });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || 'Failed to add gift to cart');
+  }
+  dispatchShopEvent('cart-updated');
+  return data;
+};
+
 // Content management types
 export interface ContentPage {
   id: string;
@@ -518,6 +793,15 @@ export interface Sale {
   description: string;
   discount_type: string;
   discount_value: number;
+  // Settings of bogo, tiered and bundle sales
+  rules?: {
+    buy?: number;
+    get?: number;
+    get_percent?: number;
+    tiers?: { min_order: number; percent: number }[];
+    items?: { applies_to: string; ids: string[]; quantity?: number }[];
+    bundle_price?: number;
+  };
   applies_to: string;
   applies_to_ids: string;
   min_order_amount: number;